@@ -0,0 +1,325 @@
+package main
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// archiveFormats maps file suffixes to the archive formats served as roots
+var archiveFormats = []struct{ suffix, format string }{
+	{".tar.gz", "tar.gz"},
+	{".tgz", "tar.gz"},
+	{".tar.zst", "tar.zst"},
+	{".tzst", "tar.zst"},
+	{".tar", "tar"},
+	{".zip", "zip"},
+}
+
+// archiveFormat returns the archive format of name, or "" if it is not one
+func archiveFormat(name string) string {
+	lower := strings.ToLower(name)
+	for _, f := range archiveFormats {
+		if strings.HasSuffix(lower, f.suffix) {
+			return f.format
+		}
+	}
+	return ""
+}
+
+// archiveBackend serves the contents of an archive through io/fs. Every
+// mutating method fails with ErrReadOnlyFS.
+type archiveBackend struct {
+	fsys   fs.FS
+	source string    // archive path, for messages
+	src    io.Closer // zip file read in place; nil when nothing stays open
+}
+
+// Close releases the archive file a zip backend reads from
+func (a *archiveBackend) Close() error {
+	if a.src == nil {
+		return nil
+	}
+	err := a.src.Close()
+	a.src = nil
+	return err
+}
+
+// openArchive builds a backend for the archive src of the given size.
+// Zip files are read in place when src supports ReadAt, which keeps src open
+// until the backend is closed; tar streams are decompressed and loaded into
+// memory up to the --max-size limit.
+func openArchive(source string, src File, size int64) (*archiveBackend, error) {
+	switch format := archiveFormat(source); format {
+	case "zip":
+		zr, err := zipReader(source, src, size)
+		if err != nil {
+			return nil, err
+		}
+		a := &archiveBackend{fsys: zr, source: source}
+		if _, inPlace := src.(io.ReaderAt); inPlace {
+			a.src = src
+		}
+		return a, nil
+	case "tar", "tar.gz", "tar.zst":
+		defer src.Close()
+		r, done, err := tarStream(source, format, src)
+		if err != nil {
+			return nil, err
+		}
+		defer done()
+		mem, err := loadTar(source, r)
+		if err != nil {
+			return nil, err
+		}
+		return &archiveBackend{fsys: backendFS{mem}, source: source}, nil
+	default:
+		src.Close()
+		return nil, fmt.Errorf("unsupported archive type: %s (want .zip, .tar, .tar.gz or .tar.zst)", source)
+	}
+}
+
+// zipReader opens src as a zip file. When src lacks ReadAt it is read into
+// memory and closed; otherwise it must stay open while the reader is used.
+func zipReader(source string, src File, size int64) (*zip.Reader, error) {
+	ra, ok := src.(io.ReaderAt)
+	if !ok {
+		defer src.Close()
+		if size > currentConfig().MaxFileSize {
+			return nil, newOpError("archive", source, ErrFileTooLarge)
+		}
+		data, err := io.ReadAll(src)
+		if err != nil {
+			return nil, err
+		}
+		ra, size = bytes.NewReader(data), int64(len(data))
+	}
+	zr, err := zip.NewReader(ra, size)
+	if err != nil {
+		if ok {
+			src.Close()
+		}
+		return nil, newOpError("archive", source, err, "invalid zip file")
+	}
+	return zr, nil
+}
+
+// tarStream wraps src with the decompressor for format; done releases it
+func tarStream(source, format string, src io.Reader) (r io.Reader, done func(), err error) {
+	switch format {
+	case "tar.gz":
+		gz, err := gzip.NewReader(src)
+		if err != nil {
+			return nil, nil, newOpError("archive", source, err, "invalid gzip stream")
+		}
+		return gz, func() { gz.Close() }, nil
+	case "tar.zst":
+		zr, err := zstd.NewReader(src)
+		if err != nil {
+			return nil, nil, newOpError("archive", source, err, "invalid zstd stream")
+		}
+		return zr, zr.Close, nil
+	default:
+		return src, func() {}, nil
+	}
+}
+
+// openRootArchive opens a --root that names an archive file
+func openRootArchive(root string) (*archiveBackend, error) {
+	f, err := os.Open(root)
+	if err != nil {
+		return nil, err
+	}
+	fi, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	return openArchive(root, f, fi.Size())
+}
+
+// openSessionArchive opens the archive at reqPath in state as a new root
+func openSessionArchive(ctx context.Context, state *SessionState, reqPath string) (*archiveBackend, error) {
+	if state.FS == nil {
+		return nil, fmt.Errorf("archive requires a parent session")
+	}
+	name, err := state.FS.Resolve(reqPath, true)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkAccess(ctx, state, "archive", capRead, reqPath, name); err != nil {
+		return nil, err
+	}
+	f, err := state.FS.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	fi, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	if !fi.Mode().IsRegular() {
+		f.Close()
+		return nil, newOpError("archive", reqPath, ErrPathNotRegular)
+	}
+	return openArchive(name, f, fi.Size())
+}
+
+// loadTar reads regular files and directories of a tar stream into memory.
+// Links, devices and entries escaping the root are skipped.
+func loadTar(source string, r io.Reader) (*memBackend, error) {
+	mem := newMemBackend()
+	tr := tar.NewReader(r)
+	var total int64
+	for {
+		hdr, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			return mem, nil
+		}
+		if err != nil {
+			return nil, newOpError("archive", source, err, "invalid tar stream")
+		}
+		name, err := cleanName(hdr.Name)
+		if err != nil || name == "" {
+			dprintf("archive %s: skip entry %q", source, hdr.Name)
+			continue
+		}
+		switch hdr.Typeflag {
+		case tar.TypeDir:
+			mem.put(name, &memNode{mode: fs.ModeDir | fs.FileMode(hdr.Mode)&fs.ModePerm, modTime: hdr.ModTime})
+		case tar.TypeReg:
+			total += hdr.Size
+			if total > currentConfig().MaxFileSize {
+				return nil, newOpError("archive", source, ErrFileTooLarge, "uncompressed contents exceed --max-size")
+			}
+			data, err := io.ReadAll(io.LimitReader(tr, hdr.Size))
+			if err != nil {
+				return nil, newOpError("archive", source, err, "invalid tar stream")
+			}
+			mem.put(name, &memNode{mode: fs.FileMode(hdr.Mode) & fs.ModePerm, data: data, modTime: hdr.ModTime})
+		default:
+			dprintf("archive %s: skip %q of type %q", source, hdr.Name, hdr.Typeflag)
+		}
+	}
+}
+
+// put stores n at name, creating missing parent directories
+func (b *memBackend) put(name string, n *memNode) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for dir := parentName(name); dir != ""; dir = parentName(dir) {
+		if p, ok := b.nodes[dir]; ok && p.mode.IsDir() {
+			break
+		}
+		b.nodes[dir] = &memNode{mode: fs.ModeDir | 0o755, modTime: n.modTime}
+	}
+	if old, ok := b.nodes[name]; ok && old.mode.IsDir() && n.mode.IsDir() {
+		old.mode, old.modTime = n.mode, n.modTime
+		return
+	}
+	b.nodes[name] = n
+}
+
+func (a *archiveBackend) readOnly(op, name string) error {
+	return newOpError(op, name, ErrReadOnlyFS, "archive "+a.source+" is read-only")
+}
+
+func (a *archiveBackend) Resolve(reqPath string, followFinal bool) (string, error) {
+	return cleanName(reqPath)
+}
+
+func (a *archiveBackend) Stat(name string) (fs.FileInfo, error) {
+	return fs.Stat(a.fsys, fsPath(name))
+}
+
+func (a *archiveBackend) Lstat(name string) (fs.FileInfo, error) {
+	return a.Stat(name)
+}
+
+// Open returns a seekable file; zip entries are buffered to provide Seek
+func (a *archiveBackend) Open(name string) (File, error) {
+	f, err := a.fsys.Open(fsPath(name))
+	if err != nil {
+		return nil, err
+	}
+	if sf, ok := f.(File); ok {
+		return sf, nil
+	}
+	defer f.Close()
+	fi, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	if fi.Size() > currentConfig().MaxFileSize {
+		return nil, newOpError("open", name, ErrFileTooLarge)
+	}
+	var data []byte
+	if !fi.IsDir() {
+		if data, err = io.ReadAll(f); err != nil {
+			return nil, err
+		}
+	}
+	info := memFileInfo{name: fi.Name(), size: fi.Size(), mode: fi.Mode(), mod: fi.ModTime()}
+	return &memFile{Reader: bytes.NewReader(data), info: info}, nil
+}
+
+func (a *archiveBackend) ReadDir(name string) ([]fs.DirEntry, error) {
+	return fs.ReadDir(a.fsys, fsPath(name))
+}
+
+func (a *archiveBackend) WriteFile(name string, data []byte, perm fs.FileMode) error {
+	return a.readOnly("write", name)
+}
+
+func (a *archiveBackend) AppendFile(name string, data []byte, perm fs.FileMode) error {
+	return a.readOnly("write", name)
+}
+
+func (a *archiveBackend) MkdirAll(name string, perm fs.FileMode) error {
+	return a.readOnly("mkdir", name)
+}
+
+func (a *archiveBackend) Chmod(name string, perm fs.FileMode) error {
+	return a.readOnly("chmod", name)
+}
+
+func (a *archiveBackend) Rename(oldName, newName string) error {
+	return a.readOnly("rename", oldName)
+}
+
+func (a *archiveBackend) Remove(name string) error {
+	return a.readOnly("remove", name)
+}
+
+func (a *archiveBackend) RemoveAll(name string) error {
+	return a.readOnly("remove", name)
+}
+
+// Lock grants shared locks freely, since nothing can change the archive
+func (a *archiveBackend) Lock(name string, mode lockMode, timeout time.Duration) (func(), error) {
+	if mode == lockShared {
+		return func() {}, nil
+	}
+	return nil, a.readOnly("lock", name)
+}
+
+// fsPath converts a backend name to an io/fs path
+func fsPath(name string) string {
+	if name == "" {
+		return "."
+	}
+	return path.Clean(name)
+}
@@ -0,0 +1,239 @@
+package main
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/mark3labs/mcp-go/mcp"
+)
+
+// archiveFiles is the tree packed into every test archive
+var archiveFiles = map[string]string{
+	"README.md":       "release notes\n",
+	"lib/util.go":     "package lib\n\nfunc Needle() {}\n",
+	"lib/data/a.json": "{}\n",
+}
+
+func writeZip(t *testing.T, p string) {
+	t.Helper()
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	for name, body := range archiveFiles {
+		w, err := zw.Create(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		io.WriteString(w, body)
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	mustWrite(t, p, buf.Bytes(), 0o644)
+}
+
+func writeTar(t *testing.T, p string, compress func(io.Writer) io.WriteCloser) {
+	t.Helper()
+	var buf bytes.Buffer
+	var w io.Writer = &buf
+	var c io.WriteCloser
+	if compress != nil {
+		c = compress(&buf)
+		w = c
+	}
+	tw := tar.NewWriter(w)
+	hdrs := []*tar.Header{
+		{Name: "lib/", Typeflag: tar.TypeDir, Mode: 0o750},
+		{Name: "../evil.txt", Typeflag: tar.TypeReg, Mode: 0o644, Size: 4},
+		{Name: "link", Typeflag: tar.TypeSymlink, Linkname: "/etc/passwd"},
+	}
+	for _, h := range hdrs {
+		h.ModTime = time.Unix(1700000000, 0)
+		if err := tw.WriteHeader(h); err != nil {
+			t.Fatal(err)
+		}
+		if h.Size > 0 {
+			io.WriteString(tw, "evil")
+		}
+	}
+	for name, body := range archiveFiles {
+		h := &tar.Header{Name: name, Typeflag: tar.TypeReg, Mode: 0o640, Size: int64(len(body)), ModTime: time.Unix(1700000000, 0)}
+		if err := tw.WriteHeader(h); err != nil {
+			t.Fatal(err)
+		}
+		io.WriteString(tw, body)
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if c != nil {
+		if err := c.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	mustWrite(t, p, buf.Bytes(), 0o644)
+}
+
+func TestArchiveSessions(t *testing.T) {
+	root := t.TempDir()
+	writeZip(t, filepath.Join(root, "bundle.zip"))
+	writeTar(t, filepath.Join(root, "deps.tar"), nil)
+	writeTar(t, filepath.Join(root, "deps.tar.gz"), func(w io.Writer) io.WriteCloser { return gzip.NewWriter(w) })
+	writeTar(t, filepath.Join(root, "deps.tar.zst"), func(w io.Writer) io.WriteCloser {
+		zw, err := zstd.NewWriter(w)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return zw
+	})
+	req := mcp.CallToolRequest{}
+
+	for _, name := range []string{"bundle.zip", "deps.tar", "deps.tar.gz", "deps.tar.zst"} {
+		t.Run(name, func(t *testing.T) {
+			ctx, sessions, mu := testSession(root)
+			res, err := handleCreateSession(sessions, mu)(ctx, req, CreateSessionArgs{ID: "a", Archive: name})
+			if err != nil {
+				t.Fatalf("createsession: %v", err)
+			}
+			if res.Archive != name {
+				t.Fatalf("archive = %q", res.Archive)
+			}
+			setSessionID(ctx, "a")
+
+			rr, err := handleRead(sessions, mu)(ctx, req, ReadArgs{Path: "lib/util.go"})
+			if err != nil || rr.Content != archiveFiles["lib/util.go"] {
+				t.Fatalf("read: %+v %v", rr, err)
+			}
+			pr, err := handlePeek(sessions, mu)(ctx, req, PeekArgs{Path: "README.md", Offset: 8, MaxBytes: 5})
+			if err != nil || pr.Content != "notes" {
+				t.Fatalf("peek: %+v %v", pr, err)
+			}
+			lr, err := handleList(sessions, mu)(ctx, req, ListArgs{Path: "", Recursive: true})
+			if err != nil {
+				t.Fatalf("list: %v", err)
+			}
+			got := map[string]string{}
+			for _, e := range lr.Entries {
+				got[e.Path] = e.Kind
+			}
+			for _, want := range []string{"README.md", "lib", "lib/util.go", "lib/data", "lib/data/a.json"} {
+				if got[want] == "" {
+					t.Fatalf("list missing %s: %v", want, got)
+				}
+			}
+			if _, ok := got["link"]; ok {
+				t.Fatalf("symlink entry served: %v", got)
+			}
+			gr, err := handleGlob(sessions, mu)(ctx, req, GlobArgs{Pattern: "**/*.json"})
+			if err != nil || len(gr.Matches) != 1 || gr.Matches[0] != "lib/data/a.json" {
+				t.Fatalf("glob: %+v %v", gr, err)
+			}
+			sr, err := handleSearch(sessions, mu)(ctx, req, SearchArgs{Pattern: "Needle"})
+			if err != nil || len(sr.Matches) != 1 || sr.Matches[0].Path != "lib/util.go" {
+				t.Fatalf("search: %+v %v", sr, err)
+			}
+			st, err := handleStat(sessions, mu)(ctx, req, StatArgs{Path: "lib"})
+			if err != nil || st.Kind != "dir" {
+				t.Fatalf("stat: %+v %v", st, err)
+			}
+
+			_, err = handleWrite(sessions, mu)(ctx, req, WriteArgs{Path: "new.txt", Content: "x"})
+			if !errors.Is(err, ErrReadOnlyFS) || toErrorResponse(err).Code != "READ_ONLY" {
+				t.Fatalf("write err = %v", err)
+			}
+			if _, err := handleMkdir(sessions, mu)(ctx, req, MkdirArgs{Path: "d"}); !errors.Is(err, ErrReadOnlyFS) {
+				t.Fatalf("mkdir err = %v", err)
+			}
+			if _, err := handleRmdir(sessions, mu)(ctx, req, RmdirArgs{Path: "lib", Recursive: true}); !errors.Is(err, ErrReadOnlyFS) {
+				t.Fatalf("rmdir err = %v", err)
+			}
+		})
+	}
+}
+
+func TestTarEntriesKeepModeAndTime(t *testing.T) {
+	root := t.TempDir()
+	writeTar(t, filepath.Join(root, "deps.tar"), nil)
+	b, err := openRootArchive(filepath.Join(root, "deps.tar"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	fi, err := b.Stat("lib")
+	if err != nil || !fi.IsDir() || fi.Mode().Perm() != 0o750 {
+		t.Fatalf("lib: %v %v", fi, err)
+	}
+	fi, err = b.Stat("lib/util.go")
+	if err != nil || fi.Mode().Perm() != 0o640 || !fi.ModTime().Equal(time.Unix(1700000000, 0)) {
+		t.Fatalf("util.go: %v %v", fi, err)
+	}
+	if _, err := b.Stat("evil.txt"); err == nil {
+		t.Fatal("escaping entry served")
+	}
+}
+
+func TestArchiveRejectsUnknownFormat(t *testing.T) {
+	root := t.TempDir()
+	mustWrite(t, filepath.Join(root, "notes.txt"), []byte("x"), 0o644)
+	ctx, sessions, mu := testSession(root)
+	if _, err := handleCreateSession(sessions, mu)(ctx, mcp.CallToolRequest{}, CreateSessionArgs{Archive: "notes.txt"}); err == nil {
+		t.Fatal("expected error for non-archive")
+	}
+	if len(sessions) != 1 {
+		t.Fatalf("session created on failure: %v", sessions)
+	}
+}
+
+// openTrackBackend remembers the files it opens
+type openTrackBackend struct {
+	*localBackend
+	opened []*os.File
+}
+
+func (b *openTrackBackend) Open(name string) (File, error) {
+	f, err := b.localBackend.Open(name)
+	if err == nil {
+		b.opened = append(b.opened, f.(*os.File))
+	}
+	return f, err
+}
+
+func TestCreateSessionClosesArchiveOnError(t *testing.T) {
+	root := t.TempDir()
+	writeZip(t, filepath.Join(root, "bundle.zip"))
+	b := &openTrackBackend{localBackend: newLocalBackend(root)}
+	ctx, sessions, mu := newTestSession(b)
+	req := mcp.CallToolRequest{}
+
+	if _, err := handleCreateSession(sessions, mu)(ctx, req, CreateSessionArgs{ID: "s1", Archive: "bundle.zip"}); err == nil {
+		t.Fatal("duplicate session id accepted")
+	}
+	if len(b.opened) != 1 {
+		t.Fatalf("opened %d files", len(b.opened))
+	}
+	if _, err := b.opened[0].Stat(); !errors.Is(err, os.ErrClosed) {
+		t.Fatalf("archive left open after a failed createsession: %v", err)
+	}
+
+	a, err := openSessionArchive(context.Background(), sessions["s1"], "bundle.zip")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := b.opened[1].Stat(); err != nil {
+		t.Fatalf("zip closed while in use: %v", err)
+	}
+	if err := a.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := b.opened[1].Stat(); !errors.Is(err, os.ErrClosed) {
+		t.Fatalf("Close left the zip open: %v", err)
+	}
+}
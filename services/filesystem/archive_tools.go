@@ -0,0 +1,566 @@
+package main
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bmatcuk/doublestar/v4"
+	"github.com/mark3labs/mcp-go/mcp"
+)
+
+const (
+	defaultExtractMaxRatio   = 100 // uncompressed bytes per archive byte
+	defaultExtractMaxEntries = 100000
+)
+
+// archiveEpoch is stored as the modification time of every entry unless
+// preserve_times is set, so packing the same tree twice gives the same bytes
+var archiveEpoch = time.Date(1980, 1, 1, 0, 0, 0, 0, time.UTC)
+
+func archiveCreateScope(a ArchiveCreateArgs) ([]string, bool)   { return []string{a.Path}, false }
+func archiveExtractScope(a ArchiveExtractArgs) ([]string, bool) { return []string{a.Dest}, true }
+
+func formatArchiveResult(r ArchiveResult) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "path=%s format=%s entries=%d bytes=%d", r.Path, r.Format, len(r.Entries), r.Bytes)
+	if r.Dest != "" {
+		fmt.Fprintf(&b, " dest=%s", r.Dest)
+	}
+	if r.SHA256 != "" {
+		fmt.Fprintf(&b, " sha=%s", r.SHA256)
+	}
+	for _, e := range r.Entries {
+		fmt.Fprintf(&b, "\n%s %s %d %s", e.Path, e.Kind, e.Size, e.Mode)
+	}
+	for _, s := range r.Skipped {
+		fmt.Fprintf(&b, "\nskipped %s: %s", s.Path, s.Reason)
+	}
+	return b.String()
+}
+
+func archiveEntryOf(name string, fi fs.FileInfo) ArchiveEntry {
+	e := ArchiveEntry{Path: name, Kind: kindOf(fi), Mode: fmt.Sprintf("%#o", fi.Mode()&os.ModePerm)}
+	if !fi.IsDir() {
+		e.Size = fi.Size()
+	}
+	return e
+}
+
+// matchAny reports whether name matches one of the doublestar patterns
+func matchAny(patterns []string, name string) bool {
+	for _, p := range patterns {
+		if ok, _ := doublestar.Match(p, name); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// collectArchiveInputs resolves include paths and globs to the files and
+// directories to pack, keyed by name. The archive itself, excluded and
+// unreadable paths are left out; links and special files are reported.
+func collectArchiveInputs(ctx context.Context, state *SessionState, include, exclude []string, self string) (map[string]fs.FileInfo, []ArchiveSkipped, error) {
+	found := map[string]fs.FileInfo{}
+	var skipped []ArchiveSkipped
+	visit := func(name string, fi fs.FileInfo) error {
+		if name == self || name == "" {
+			return nil
+		}
+		if matchAny(exclude, name) || !state.readable(name) {
+			if fi.IsDir() {
+				return fs.SkipDir
+			}
+			return nil
+		}
+		if !fi.IsDir() && !fi.Mode().IsRegular() {
+			skipped = append(skipped, ArchiveSkipped{Path: name, Reason: kindOf(fi) + " entries are not archived"})
+			return nil
+		}
+		found[name] = fi
+		return nil
+	}
+	// walk visits start and everything below it; with match set only matching
+	// entries and the contents of matching directories are kept
+	walk := func(start string, match func(string) bool) error {
+		matchedDir := ""
+		return walkBackend(state.FS, start, func(name string, d fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+			fi, err := d.Info()
+			if err != nil {
+				return err
+			}
+			if match != nil && name != "" {
+				inside := matchedDir != "" && strings.HasPrefix(name, matchedDir+"/")
+				if !inside && !match(name) {
+					if d.IsDir() && (!state.readable(name) || matchAny(exclude, name)) {
+						return fs.SkipDir
+					}
+					return nil
+				}
+				if !inside && d.IsDir() {
+					matchedDir = name
+				}
+			}
+			return visit(name, fi)
+		})
+	}
+	for _, inc := range include {
+		if strings.ContainsAny(inc, "*?[{") {
+			pat := path.Clean(strings.TrimPrefix(inc, "/"))
+			if !doublestar.ValidatePattern(pat) || strings.HasPrefix(pat, "../") {
+				return nil, nil, newOpError("archive_create", inc, ErrInvalidGlob)
+			}
+			if err := walk("", func(name string) bool {
+				ok, _ := doublestar.Match(pat, name)
+				return ok
+			}); err != nil {
+				return nil, nil, err
+			}
+			continue
+		}
+		name, err := state.FS.Resolve(inc, false)
+		if err != nil {
+			return nil, nil, err
+		}
+		if err := checkAccess(ctx, state, "archive_create", capRead, inc, name); err != nil {
+			return nil, nil, err
+		}
+		fi, err := state.FS.Lstat(name)
+		if err != nil {
+			return nil, nil, err
+		}
+		if !fi.IsDir() {
+			if err := visit(name, fi); err != nil && !errors.Is(err, fs.SkipDir) {
+				return nil, nil, err
+			}
+			continue
+		}
+		if err := walk(name, nil); err != nil {
+			return nil, nil, err
+		}
+	}
+	return found, skipped, nil
+}
+
+// writeArchive packs names from b into w in the given format
+func writeArchive(w io.Writer, format string, b Backend, names []string, infos map[string]fs.FileInfo, preserveTimes bool) error {
+	mtime := func(fi fs.FileInfo) time.Time {
+		if preserveTimes {
+			return fi.ModTime().UTC().Truncate(time.Second)
+		}
+		return archiveEpoch
+	}
+	copyFile := func(dst io.Writer, name string) error {
+		f, err := b.Open(name)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		_, err = io.Copy(dst, f)
+		return err
+	}
+	switch format {
+	case "zip":
+		zw := zip.NewWriter(w)
+		for _, name := range names {
+			fi := infos[name]
+			hdr := &zip.FileHeader{Name: name, Method: zip.Deflate, Modified: mtime(fi)}
+			hdr.SetMode(fi.Mode())
+			if fi.IsDir() {
+				hdr.Name += "/"
+				hdr.Method = zip.Store
+			}
+			fw, err := zw.CreateHeader(hdr)
+			if err != nil {
+				return err
+			}
+			if !fi.IsDir() {
+				if err := copyFile(fw, name); err != nil {
+					return err
+				}
+			}
+		}
+		return zw.Close()
+	case "tar.gz":
+		gz := gzip.NewWriter(w)
+		tw := tar.NewWriter(gz)
+		for _, name := range names {
+			fi := infos[name]
+			hdr := &tar.Header{Name: name, Mode: int64(fi.Mode() & os.ModePerm), ModTime: mtime(fi), Typeflag: tar.TypeReg, Size: fi.Size()}
+			if fi.IsDir() {
+				hdr.Name += "/"
+				hdr.Typeflag = tar.TypeDir
+				hdr.Size = 0
+			}
+			if err := tw.WriteHeader(hdr); err != nil {
+				return err
+			}
+			if !fi.IsDir() {
+				if err := copyFile(tw, name); err != nil {
+					return err
+				}
+			}
+		}
+		if err := tw.Close(); err != nil {
+			return err
+		}
+		return gz.Close()
+	}
+	return fmt.Errorf("unsupported archive format: %s", format)
+}
+
+func handleArchiveCreate(sessions map[string]*SessionState, mu *sync.RWMutex) mcp.StructuredToolHandlerFunc[ArchiveCreateArgs, ArchiveResult] {
+	return func(ctx context.Context, req mcp.CallToolRequest, args ArchiveCreateArgs) (ArchiveResult, error) {
+		state, err := getSessionState(ctx, sessions, mu)
+		if err != nil {
+			return ArchiveResult{}, err
+		}
+		start := time.Now()
+		dprintf("%s -> fs_archive_create path=%q include=%q exclude=%q format=%q", sessionContext(ctx), args.Path, args.Include, args.Exclude, args.Format)
+		var out ArchiveResult
+		format := args.Format
+		if format == "" {
+			format = archiveFormat(args.Path)
+		}
+		if format != "zip" && format != "tar.gz" {
+			return out, &ValidationError{Field: "format", Value: format, Message: "expected zip or tar.gz"}
+		}
+		if len(args.Include) == 0 {
+			return out, &ValidationError{Field: "include", Value: args.Include, Message: "at least one path or glob is required"}
+		}
+		for _, p := range args.Exclude {
+			if !doublestar.ValidatePattern(p) {
+				return out, newOpError("archive_create", p, ErrInvalidGlob)
+			}
+		}
+		name, err := state.FS.Resolve(args.Path, false)
+		if err != nil {
+			dprintf("fs_archive_create error: %v", err)
+			return out, err
+		}
+		if err := checkAccess(ctx, state, "archive_create", capWrite, args.Path, name); err != nil {
+			dprintf("fs_archive_create error: %v", err)
+			return out, err
+		}
+		if _, err := state.FS.Lstat(name); err == nil && !args.Overwrite {
+			return out, newOpError("archive_create", args.Path, ErrFileExists, "set overwrite to replace it")
+		}
+		infos, skipped, err := collectArchiveInputs(ctx, state, args.Include, args.Exclude, name)
+		if err != nil {
+			dprintf("fs_archive_create error: %v", err)
+			return out, err
+		}
+		names := make([]string, 0, len(infos))
+		var total int64
+		for n, fi := range infos {
+			names = append(names, n)
+			if !fi.IsDir() {
+				total += fi.Size()
+			}
+		}
+		if total > currentConfig().MaxFileSize {
+			return out, newOpError("archive_create", args.Path, ErrFileTooLarge, fmt.Sprintf("inputs total %d bytes", total))
+		}
+		sort.Strings(names)
+		var buf bytes.Buffer
+		if err := writeArchive(&buf, format, state.FS, names, infos, args.PreserveTimes); err != nil {
+			dprintf("fs_archive_create write error: %v", err)
+			return out, err
+		}
+		unlock, err := state.FS.Lock(name, lockExclusive, currentConfig().lockTimeout())
+		if err != nil {
+			return out, err
+		}
+		defer unlock()
+		if err := checkLease(ctx, state, "archive_create", args.Path, name); err != nil {
+			return out, err
+		}
+		defer snapshotHistory(ctx)()
+		defer digestAudit(ctx)()
+		if err := ensureParentDir(state.FS, name); err != nil {
+			return out, err
+		}
+		if err := state.FS.WriteFile(name, buf.Bytes(), 0o644); err != nil {
+			dprintf("fs_archive_create write error: %v", err)
+			return out, err
+		}
+		out = ArchiveResult{Path: args.Path, Format: format, Entries: []ArchiveEntry{}, Skipped: skipped, Bytes: total, SHA256: sha256sum(buf.Bytes())}
+		for _, n := range names {
+			out.Entries = append(out.Entries, archiveEntryOf(n, infos[n]))
+		}
+		dprintf("<- fs_archive_create ok entries=%d bytes=%d dur=%s", len(names), buf.Len(), time.Since(start))
+		return out, nil
+	}
+}
+
+// archiveItem is one entry of an archive being extracted
+type archiveItem struct {
+	name string // as stored in the archive
+	kind string // file, dir, symlink, hardlink or another kindOf value
+	mode fs.FileMode
+	size int64 // declared uncompressed size
+	open func() (io.ReadCloser, error)
+}
+
+// walkArchiveItems calls fn for each entry of the archive src in order
+func walkArchiveItems(source, format string, src File, size int64, fn func(archiveItem) error) error {
+	switch format {
+	case "zip":
+		zr, err := zipReader(source, src, size)
+		if err != nil {
+			return err
+		}
+		defer src.Close()
+		for _, f := range zr.File {
+			kind := kindOf(memFileInfo{mode: f.Mode()})
+			if err := fn(archiveItem{name: f.Name, kind: kind, mode: f.Mode(), size: int64(f.UncompressedSize64), open: f.Open}); err != nil {
+				return err
+			}
+		}
+		return nil
+	case "tar", "tar.gz", "tar.zst":
+		defer src.Close()
+		r, done, err := tarStream(source, format, src)
+		if err != nil {
+			return err
+		}
+		defer done()
+		tr := tar.NewReader(r)
+		for {
+			hdr, err := tr.Next()
+			if errors.Is(err, io.EOF) {
+				return nil
+			}
+			if err != nil {
+				return newOpError("archive_extract", source, err, "invalid tar stream")
+			}
+			mode := hdr.FileInfo().Mode()
+			kind := kindOf(memFileInfo{mode: mode})
+			switch hdr.Typeflag {
+			case tar.TypeXGlobalHeader:
+				continue
+			case tar.TypeLink:
+				kind = "hardlink"
+			case tar.TypeReg, tar.TypeDir:
+			default:
+				if kind == "file" {
+					kind = "other"
+				}
+			}
+			open := func() (io.ReadCloser, error) { return io.NopCloser(tr), nil }
+			if err := fn(archiveItem{name: hdr.Name, kind: kind, mode: mode, size: hdr.Size, open: open}); err != nil {
+				return err
+			}
+		}
+	}
+	src.Close()
+	return fmt.Errorf("unsupported archive type: %s (want .zip, .tar, .tar.gz or .tar.zst)", source)
+}
+
+// extractTarget maps an entry name to its path relative to dest and to a
+// backend name, rejecting absolute names and names that climb out of dest.
+// Resolving through the backend also catches symlinked directories in dest.
+func extractTarget(b Backend, dest, entry string) (rel, name string, err error) {
+	clean := strings.ReplaceAll(entry, `\`, "/")
+	if strings.HasPrefix(clean, "/") {
+		return "", "", newOpError("archive_extract", entry, ErrPathOutsideRoot, "absolute entry name")
+	}
+	rel = path.Clean(clean)
+	if rel == ".." || strings.HasPrefix(rel, "../") {
+		return "", "", newOpError("archive_extract", entry, ErrPathOutsideRoot, "entry climbs out of the destination")
+	}
+	if rel == "." {
+		return "", dest, nil
+	}
+	name, err = b.Resolve(path.Join(dest, rel), false)
+	if err != nil {
+		return "", "", newOpError("archive_extract", entry, ErrPathOutsideRoot, err.Error())
+	}
+	return rel, name, nil
+}
+
+// extractMode keeps permission bits only, substituting a default for
+// archives that store none
+func extractMode(m fs.FileMode) fs.FileMode {
+	perm := m & fs.ModePerm
+	if perm != 0 {
+		return perm
+	}
+	if m.IsDir() {
+		return 0o755
+	}
+	return 0o644
+}
+
+func handleArchiveExtract(sessions map[string]*SessionState, mu *sync.RWMutex) mcp.StructuredToolHandlerFunc[ArchiveExtractArgs, ArchiveResult] {
+	return func(ctx context.Context, req mcp.CallToolRequest, args ArchiveExtractArgs) (ArchiveResult, error) {
+		state, err := getSessionState(ctx, sessions, mu)
+		if err != nil {
+			return ArchiveResult{}, err
+		}
+		start := time.Now()
+		dprintf("%s -> fs_archive_extract path=%q dest=%q overwrite=%v", sessionContext(ctx), args.Path, args.Dest, args.Overwrite)
+		var out ArchiveResult
+		format := archiveFormat(args.Path)
+		if format == "" {
+			return out, &ValidationError{Field: "path", Value: args.Path, Message: "expected .zip, .tar, .tar.gz or .tar.zst"}
+		}
+		src, err := state.FS.Resolve(args.Path, true)
+		if err != nil {
+			dprintf("fs_archive_extract error: %v", err)
+			return out, err
+		}
+		if err := checkAccess(ctx, state, "archive_extract", capRead, args.Path, src); err != nil {
+			dprintf("fs_archive_extract error: %v", err)
+			return out, err
+		}
+		dest, err := state.FS.Resolve(args.Dest, false)
+		if err != nil {
+			dprintf("fs_archive_extract error: %v", err)
+			return out, err
+		}
+		if err := checkAccess(ctx, state, "archive_extract", capWrite, args.Dest, dest); err != nil {
+			dprintf("fs_archive_extract error: %v", err)
+			return out, err
+		}
+		maxBytes := args.MaxBytes
+		if maxBytes <= 0 {
+			maxBytes = currentConfig().MaxFileSize
+		}
+		maxRatio := args.MaxRatio
+		if maxRatio <= 0 {
+			maxRatio = defaultExtractMaxRatio
+		}
+		walk := func(fn func(it archiveItem, rel, target string) error) (int64, error) {
+			f, err := state.FS.Open(src)
+			if err != nil {
+				return 0, err
+			}
+			fi, err := f.Stat()
+			if err != nil {
+				f.Close()
+				return 0, err
+			}
+			return fi.Size(), walkArchiveItems(args.Path, format, f, fi.Size(), func(it archiveItem) error {
+				if it.kind != "file" && it.kind != "dir" {
+					return fn(it, "", "")
+				}
+				rel, target, err := extractTarget(state.FS, dest, it.name)
+				if err != nil {
+					return err
+				}
+				return fn(it, rel, target)
+			})
+		}
+
+		// Validate every entry against the declared sizes before writing anything
+		var declared int64
+		count := 0
+		archiveSize, err := walk(func(it archiveItem, rel, target string) error {
+			if it.kind != "file" && it.kind != "dir" {
+				out.Skipped = append(out.Skipped, ArchiveSkipped{Path: it.name, Reason: it.kind + " entries are not extracted"})
+				return nil
+			}
+			if count++; count > defaultExtractMaxEntries {
+				return newOpError("archive_extract", args.Path, ErrFileTooLarge, fmt.Sprintf("more than %d entries", defaultExtractMaxEntries))
+			}
+			if err := checkAccess(ctx, state, "archive_extract", capWrite, it.name, target); err != nil {
+				return err
+			}
+			if it.mode.IsDir() {
+				if fi, err := state.FS.Lstat(target); err == nil && !fi.IsDir() {
+					return newOpError("archive_extract", it.name, ErrFileExists, "a file is in the way of a directory")
+				}
+				return nil
+			}
+			if declared += it.size; declared > maxBytes {
+				return newOpError("archive_extract", args.Path, ErrFileTooLarge, fmt.Sprintf("uncompressed size exceeds %d bytes", maxBytes))
+			}
+			if fi, err := state.FS.Lstat(target); err == nil && (fi.IsDir() || !args.Overwrite) {
+				return newOpError("archive_extract", it.name, ErrFileExists, "set overwrite to replace existing files")
+			}
+			return nil
+		})
+		if err == nil && archiveSize > 0 && float64(declared)/float64(archiveSize) > maxRatio {
+			err = newOpError("archive_extract", args.Path, ErrFileTooLarge, fmt.Sprintf("compression ratio %.0f exceeds %.0f", float64(declared)/float64(archiveSize), maxRatio))
+		}
+		if err != nil {
+			dprintf("fs_archive_extract error: %v", err)
+			return out, err
+		}
+
+		// Write entries, enforcing the limits again on the actual bytes
+		defer snapshotHistory(ctx)()
+		var written int64
+		out.Entries = []ArchiveEntry{}
+		_, err = walk(func(it archiveItem, rel, target string) error {
+			if it.kind != "file" && it.kind != "dir" {
+				return nil
+			}
+			perm := extractMode(it.mode)
+			if it.mode.IsDir() {
+				if err := state.FS.MkdirAll(target, perm); err != nil {
+					return err
+				}
+				if err := state.FS.Chmod(target, perm); err != nil {
+					return err
+				}
+				out.Entries = append(out.Entries, ArchiveEntry{Path: rel, Kind: "dir", Mode: fmt.Sprintf("%#o", perm)})
+				return nil
+			}
+			rc, err := it.open()
+			if err != nil {
+				return err
+			}
+			data, err := io.ReadAll(io.LimitReader(rc, it.size+1))
+			rc.Close()
+			if err != nil {
+				return newOpError("archive_extract", it.name, err)
+			}
+			if int64(len(data)) > it.size {
+				return newOpError("archive_extract", it.name, ErrFileTooLarge, "entry is larger than its declared size")
+			}
+			if written += int64(len(data)); written > maxBytes {
+				return newOpError("archive_extract", args.Path, ErrFileTooLarge, fmt.Sprintf("uncompressed size exceeds %d bytes", maxBytes))
+			}
+			release, err := state.FS.Lock(target, lockExclusive, currentConfig().lockTimeout())
+			if err != nil {
+				return newOpError("archive_extract", rel, err)
+			}
+			defer release()
+			if err := checkLease(ctx, state, "archive_extract", rel, target); err != nil {
+				return err
+			}
+			if err := ensureParentDir(state.FS, target); err != nil {
+				return err
+			}
+			if err := state.FS.WriteFile(target, data, perm); err != nil {
+				return err
+			}
+			out.Entries = append(out.Entries, ArchiveEntry{Path: rel, Kind: "file", Size: int64(len(data)), Mode: fmt.Sprintf("%#o", perm)})
+			return nil
+		})
+		if err != nil {
+			dprintf("fs_archive_extract error: %v", err)
+			return out, err
+		}
+		out.Path, out.Format, out.Dest, out.Bytes = args.Path, format, args.Dest, written
+		dprintf("<- fs_archive_extract ok entries=%d bytes=%d dur=%s", len(out.Entries), written, time.Since(start))
+		return out, nil
+	}
+}
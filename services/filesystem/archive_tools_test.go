@@ -0,0 +1,175 @@
+package main
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/mark3labs/mcp-go/mcp"
+)
+
+func archiveTree(t *testing.T, b Backend) {
+	t.Helper()
+	memWrite(t, b, "src/main.go", []byte("package main\n"), 0o644)
+	memWrite(t, b, "src/run.sh", []byte("#!/bin/sh\n"), 0o755)
+	memWrite(t, b, "src/tmp/cache.bin", []byte("cache"), 0o644)
+	memWrite(t, b, "docs/a.md", []byte("# a\n"), 0o644)
+	memWrite(t, b, "docs/b.txt", []byte("b\n"), 0o644)
+}
+
+func entryPaths(r ArchiveResult) string {
+	var out []string
+	for _, e := range r.Entries {
+		out = append(out, e.Path)
+	}
+	return strings.Join(out, ",")
+}
+
+func TestArchiveCreateAndExtractRoundTrip(t *testing.T) {
+	for _, name := range []string{"out.zip", "out.tar.gz"} {
+		t.Run(name, func(t *testing.T) {
+			ctx, sessions, mu, mem := memSession()
+			archiveTree(t, mem)
+			req := mcp.CallToolRequest{}
+			create := handleArchiveCreate(sessions, mu)
+
+			args := ArchiveCreateArgs{Path: name, Include: []string{"src", "docs/*.md"}, Exclude: []string{"src/tmp"}}
+			res, err := create(ctx, req, args)
+			if err != nil {
+				t.Fatalf("create: %v", err)
+			}
+			if got := entryPaths(res); got != "docs/a.md,src,src/main.go,src/run.sh" {
+				t.Fatalf("entries = %s", got)
+			}
+			if _, err := create(ctx, req, args); !errors.Is(err, ErrFileExists) {
+				t.Fatalf("second create err = %v", err)
+			}
+			args.Overwrite = true
+			again, err := create(ctx, req, args)
+			if err != nil || again.SHA256 != res.SHA256 {
+				t.Fatalf("archive not deterministic: %s vs %s (%v)", again.SHA256, res.SHA256, err)
+			}
+
+			ext, err := handleArchiveExtract(sessions, mu)(ctx, req, ArchiveExtractArgs{Path: name, Dest: "unpacked"})
+			if err != nil {
+				t.Fatalf("extract: %v", err)
+			}
+			if got := entryPaths(ext); got != "docs/a.md,src,src/main.go,src/run.sh" {
+				t.Fatalf("extracted = %s", got)
+			}
+			if memRead(t, mem, "unpacked/src/main.go") != "package main\n" {
+				t.Fatal("content mismatch")
+			}
+			fi, err := mem.Stat("unpacked/src/run.sh")
+			if err != nil || fi.Mode().Perm() != 0o755 {
+				t.Fatalf("mode not preserved: %v %v", fi, err)
+			}
+			if _, err := mem.Stat("unpacked/src/tmp"); !os.IsNotExist(err) {
+				t.Fatal("excluded directory extracted")
+			}
+
+			if _, err := handleArchiveExtract(sessions, mu)(ctx, req, ArchiveExtractArgs{Path: name, Dest: "unpacked"}); !errors.Is(err, ErrFileExists) {
+				t.Fatalf("extract over existing files err = %v", err)
+			}
+			if _, err := handleArchiveExtract(sessions, mu)(ctx, req, ArchiveExtractArgs{Path: name, Dest: "unpacked", Overwrite: true}); err != nil {
+				t.Fatalf("extract with overwrite: %v", err)
+			}
+		})
+	}
+}
+
+func zipWith(t *testing.T, b Backend, name string, files map[string][]byte) {
+	t.Helper()
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	for name, body := range files {
+		w, err := zw.Create(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		w.Write(body)
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	memWrite(t, b, name, buf.Bytes(), 0o644)
+}
+
+func TestArchiveExtractRejectsZipSlip(t *testing.T) {
+	ctx, sessions, mu, mem := memSession()
+	for _, entry := range []string{"../evil.txt", "/etc/evil.txt", "a/../../evil.txt"} {
+		zipWith(t, mem, "bad.zip", map[string][]byte{"ok.txt": []byte("ok"), entry: []byte("evil")})
+		_, err := handleArchiveExtract(sessions, mu)(ctx, mcp.CallToolRequest{}, ArchiveExtractArgs{Path: "bad.zip", Dest: "out"})
+		if !errors.Is(err, ErrPathOutsideRoot) {
+			t.Fatalf("%s: err = %v", entry, err)
+		}
+		if _, err := mem.Stat("out"); !os.IsNotExist(err) {
+			t.Fatalf("%s: files written before validation failed", entry)
+		}
+	}
+}
+
+func TestArchiveExtractThroughSymlinkedDir(t *testing.T) {
+	root := t.TempDir()
+	outside := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(root, "out"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := makeSymlink(t, outside, filepath.Join(root, "out", "link")); err != nil {
+		t.Skip("symlinks unsupported")
+	}
+	zipWith(t, newLocalBackend(root), "a.zip", map[string][]byte{"link/x.txt": []byte("x")})
+	ctx, sessions, mu := testSession(root)
+	if _, err := handleArchiveExtract(sessions, mu)(ctx, mcp.CallToolRequest{}, ArchiveExtractArgs{Path: "a.zip", Dest: "out"}); err == nil {
+		t.Fatal("extract followed a symlink out of the root")
+	}
+	if _, err := os.Stat(filepath.Join(outside, "x.txt")); !os.IsNotExist(err) {
+		t.Fatal("file written outside root")
+	}
+}
+
+func TestArchiveExtractSkipsLinks(t *testing.T) {
+	ctx, sessions, mu, mem := memSession()
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	tw.WriteHeader(&tar.Header{Name: "a.txt", Typeflag: tar.TypeReg, Mode: 0o600, Size: 1})
+	tw.Write([]byte("a"))
+	tw.WriteHeader(&tar.Header{Name: "sym", Typeflag: tar.TypeSymlink, Linkname: "/etc/passwd"})
+	tw.WriteHeader(&tar.Header{Name: "hard", Typeflag: tar.TypeLink, Linkname: "a.txt"})
+	tw.Close()
+	memWrite(t, mem, "l.tar", buf.Bytes(), 0o644)
+	res, err := handleArchiveExtract(sessions, mu)(ctx, mcp.CallToolRequest{}, ArchiveExtractArgs{Path: "l.tar", Dest: "out"})
+	if err != nil {
+		t.Fatalf("extract: %v", err)
+	}
+	if entryPaths(res) != "a.txt" || len(res.Skipped) != 2 {
+		t.Fatalf("manifest = %+v", res)
+	}
+	if _, err := mem.Lstat("out/sym"); !os.IsNotExist(err) {
+		t.Fatal("symlink extracted")
+	}
+	if fi, err := mem.Stat("out/a.txt"); err != nil || fi.Mode().Perm() != 0o600 {
+		t.Fatalf("a.txt: %v %v", fi, err)
+	}
+}
+
+func TestArchiveExtractBombLimits(t *testing.T) {
+	ctx, sessions, mu, mem := memSession()
+	zipWith(t, mem, "bomb.zip", map[string][]byte{"zeros": make([]byte, 4<<20)})
+	req := mcp.CallToolRequest{}
+	extract := handleArchiveExtract(sessions, mu)
+	if _, err := extract(ctx, req, ArchiveExtractArgs{Path: "bomb.zip", Dest: "out"}); !errors.Is(err, ErrFileTooLarge) {
+		t.Fatalf("ratio err = %v", err)
+	}
+	if _, err := extract(ctx, req, ArchiveExtractArgs{Path: "bomb.zip", Dest: "out", MaxRatio: 1e6, MaxBytes: 1 << 20}); !errors.Is(err, ErrFileTooLarge) {
+		t.Fatalf("size err = %v", err)
+	}
+	if _, err := extract(ctx, req, ArchiveExtractArgs{Path: "bomb.zip", Dest: "out", MaxRatio: 1e6}); err != nil {
+		t.Fatalf("extract within limits: %v", err)
+	}
+}
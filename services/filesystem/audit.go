@@ -0,0 +1,455 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bmatcuk/doublestar/v4"
+	"github.com/mark3labs/mcp-go/mcp"
+	"github.com/mark3labs/mcp-go/server"
+)
+
+const (
+	defaultAuditMaxSize    = 10 << 20 // 10 MiB per log file
+	defaultAuditMaxBackups = 5
+	defaultAuditQueryLimit = 100
+)
+
+// auditElidedFields lists argument fields replaced by their hash and size
+var auditElidedFields = []string{"content"}
+
+// AuditEntry is a single line of the audit log
+type AuditEntry struct {
+	Time    string         `json:"time" description:"Completion time (RFC3339)"`
+	Session string         `json:"session" description:"Session id that issued the call"`
+	Client  string         `json:"client,omitempty" description:"Client name and version"`
+	Tool    string         `json:"tool" description:"Tool name"`
+	Path    string         `json:"path,omitempty" description:"Target path as requested"`
+	Args    map[string]any `json:"args,omitempty" description:"Tool arguments with content elided"`
+	Before  string         `json:"before_sha256,omitempty" description:"SHA256 of the target before the call"`
+	After   string         `json:"after_sha256,omitempty" description:"SHA256 of the target after the call"`
+	Bytes   int64          `json:"bytes" description:"Size of the target after the call"`
+	Result  string         `json:"result" description:"ok or error"`
+	Code    string         `json:"code,omitempty" description:"Error code when result is error"`
+	Error   string         `json:"error,omitempty" description:"Error message when result is error"`
+}
+
+// auditLogger appends entries to a JSONL file with size-based rotation
+type auditLogger struct {
+	mu         sync.Mutex
+	path       string
+	maxSize    int64
+	maxBackups int
+	f          *os.File
+	size       int64
+}
+
+var auditLog *auditLogger
+
+func initAudit(c AuditConfig) error {
+	if c.Log == "" {
+		return nil
+	}
+	l, err := openAuditLog(c.Log, c.MaxSize, c.MaxBackups)
+	if err != nil {
+		return err
+	}
+	auditLog = l
+	return nil
+}
+
+func openAuditLog(path string, maxSize int64, maxBackups int) (*auditLogger, error) {
+	if maxSize <= 0 {
+		maxSize = defaultAuditMaxSize
+	}
+	if maxBackups < 0 {
+		maxBackups = 0
+	}
+	l := &auditLogger{path: path, maxSize: maxSize, maxBackups: maxBackups}
+	if err := l.open(); err != nil {
+		return nil, err
+	}
+	return l, nil
+}
+
+func (l *auditLogger) open() error {
+	if err := ensureParent(l.path); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(l.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o600)
+	if err != nil {
+		return fmt.Errorf("failed to open audit log: %w", err)
+	}
+	fi, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	l.f = f
+	l.size = fi.Size()
+	return nil
+}
+
+func (l *auditLogger) backupName(n int) string {
+	return fmt.Sprintf("%s.%d", l.path, n)
+}
+
+// rotate shifts path.N to path.N+1, drops the oldest backup and reopens path
+func (l *auditLogger) rotate() error {
+	if err := l.f.Close(); err != nil {
+		return err
+	}
+	if l.maxBackups == 0 {
+		if err := os.Remove(l.path); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		return l.open()
+	}
+	_ = os.Remove(l.backupName(l.maxBackups))
+	for i := l.maxBackups - 1; i >= 1; i-- {
+		if err := os.Rename(l.backupName(i), l.backupName(i+1)); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	if err := os.Rename(l.path, l.backupName(1)); err != nil {
+		return err
+	}
+	return l.open()
+}
+
+func (l *auditLogger) record(e AuditEntry) error {
+	line, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	line = append(line, '\n')
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.size > 0 && l.size+int64(len(line)) > l.maxSize {
+		if err := l.rotate(); err != nil {
+			return fmt.Errorf("failed to rotate audit log: %w", err)
+		}
+	}
+	n, err := l.f.Write(line)
+	l.size += int64(n)
+	return err
+}
+
+// files returns the log files from oldest to newest
+func (l *auditLogger) files() []string {
+	var out []string
+	for i := l.maxBackups; i >= 1; i-- {
+		out = append(out, l.backupName(i))
+	}
+	return append(out, l.path)
+}
+
+// snapshot opens the log files from oldest to newest along with the bytes
+// written to each so far. Open files stay readable when a later rotation
+// renames or removes them, so they can be scanned without holding l.mu.
+func (l *auditLogger) snapshot() (files []*os.File, sizes []int64, err error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	defer func() {
+		if err != nil {
+			for _, f := range files {
+				f.Close()
+			}
+		}
+	}()
+	for _, name := range l.files() {
+		f, err := os.Open(name)
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return files, nil, err
+		}
+		files = append(files, f)
+		size := l.size
+		if name != l.path {
+			fi, err := f.Stat()
+			if err != nil {
+				return files, nil, err
+			}
+			size = fi.Size()
+		}
+		sizes = append(sizes, size)
+	}
+	return files, sizes, nil
+}
+
+// query scans all log files and returns the most recent entries accepted by
+// match. Entries recorded while it scans are not included.
+func (l *auditLogger) query(match func(AuditEntry) bool, limit int) ([]AuditEntry, bool, error) {
+	files, sizes, err := l.snapshot()
+	if err != nil {
+		return nil, false, err
+	}
+	defer func() {
+		for _, f := range files {
+			f.Close()
+		}
+	}()
+	var out []AuditEntry
+	truncated := false
+	for i, f := range files {
+		sc := bufio.NewScanner(io.LimitReader(f, sizes[i]))
+		sc.Buffer(make([]byte, 64*1024), 16<<20)
+		for sc.Scan() {
+			var e AuditEntry
+			if err := json.Unmarshal(sc.Bytes(), &e); err != nil {
+				continue
+			}
+			if !match(e) {
+				continue
+			}
+			out = append(out, e)
+			if len(out) > limit {
+				out = out[1:]
+				truncated = true
+			}
+		}
+		if err := sc.Err(); err != nil {
+			return nil, false, err
+		}
+	}
+	return out, truncated, nil
+}
+
+// auditArgs converts tool arguments to a map with large fields elided
+func auditArgs(args any) map[string]any {
+	b, err := json.Marshal(args)
+	if err != nil {
+		return nil
+	}
+	var m map[string]any
+	if err := json.Unmarshal(b, &m); err != nil {
+		return nil
+	}
+	for _, k := range auditElidedFields {
+		if s, ok := m[k].(string); ok {
+			delete(m, k)
+			m[k+"_sha256"] = sha256sum([]byte(s))
+			m[k+"_bytes"] = len(s)
+		}
+	}
+	return m
+}
+
+// fileDigest hashes a regular file, returning empty values when it is absent
+func fileDigest(b Backend, name string) (string, int64) {
+	fi, err := b.Lstat(name)
+	if err != nil || !fi.Mode().IsRegular() {
+		return "", 0
+	}
+	if fi.Size() > maxHashBytes {
+		return "", fi.Size()
+	}
+	sha, err := hashBackendFile(b, name)
+	if err != nil {
+		return "", fi.Size()
+	}
+	return sha, fi.Size()
+}
+
+// lockedDigest is fileDigest under a shared lock, so it never sees a write
+// half done
+func lockedDigest(b Backend, name string) (string, int64) {
+	release, err := b.Lock(name, lockShared, currentConfig().lockTimeout())
+	if err != nil {
+		return "", 0
+	}
+	defer release()
+	return fileDigest(b, name)
+}
+
+// auditDigestKey carries the digests withAudit asks its handler to take
+type auditDigestKey struct{}
+
+// auditDigests holds the hashes of the audited path before and after a call
+type auditDigests struct {
+	fs            Backend
+	name          string
+	before, after string
+	size          int64
+	taken         bool
+}
+
+// digestAudit hashes the audited path of the call in ctx, if there is one,
+// and returns a func that hashes it again. Handlers that change the path
+// call it while they hold its lock, so the audit entry records what the
+// call replaced and what it wrote rather than a concurrent writer's content.
+func digestAudit(ctx context.Context) (done func()) {
+	d, ok := ctx.Value(auditDigestKey{}).(*auditDigests)
+	if !ok || d.taken {
+		return func() {}
+	}
+	d.taken = true
+	d.before, _ = fileDigest(d.fs, d.name)
+	return func() { d.after, d.size = fileDigest(d.fs, d.name) }
+}
+
+// clientInfo describes the MCP client bound to ctx
+func clientInfo(ctx context.Context) string {
+	if cs, ok := server.ClientSessionFromContext(ctx).(server.SessionWithClientInfo); ok {
+		info := cs.GetClientInfo()
+		if info.Name == "" {
+			return ""
+		}
+		if info.Version == "" {
+			return info.Name
+		}
+		return info.Name + "/" + info.Version
+	}
+	return ""
+}
+
+// withAudit records every call of a mutating tool in the audit log
+func withAudit[TArgs any, TResult any](tool string, sessions map[string]*SessionState, mu *sync.RWMutex, h mcp.StructuredToolHandlerFunc[TArgs, TResult]) mcp.StructuredToolHandlerFunc[TArgs, TResult] {
+	return func(ctx context.Context, req mcp.CallToolRequest, args TArgs) (TResult, error) {
+		if auditLog == nil {
+			return h(ctx, req, args)
+		}
+		fields := auditArgs(args)
+		path, _ := fields["path"].(string)
+		// Handlers that lock the path take the digests themselves; the rest,
+		// and calls failing before the lock, get them under a shared lock
+		var before, after string
+		var size int64
+		settle := func() {}
+		if state, err := getSessionState(ctx, sessions, mu); err == nil && path != "" {
+			if name, err := state.FS.Resolve(path, false); err == nil {
+				d := &auditDigests{fs: state.FS, name: name}
+				ctx = context.WithValue(ctx, auditDigestKey{}, d)
+				before, _ = lockedDigest(state.FS, name)
+				settle = func() {
+					if d.taken {
+						before, after, size = d.before, d.after, d.size
+					} else {
+						after, size = lockedDigest(state.FS, name)
+					}
+				}
+			}
+		}
+		res, err := h(ctx, req, args)
+		settle()
+		entry := AuditEntry{
+			Time:    time.Now().UTC().Format(time.RFC3339Nano),
+			Session: getSessionID(ctx),
+			Client:  clientInfo(ctx),
+			Tool:    tool,
+			Path:    path,
+			Args:    fields,
+			Before:  before,
+			After:   after,
+			Bytes:   size,
+			Result:  "ok",
+		}
+		if err != nil {
+			resp := toErrorResponse(err)
+			entry.Result = "error"
+			entry.Code = resp.Code
+			entry.Error = err.Error()
+		}
+		if werr := auditLog.record(entry); werr != nil {
+			dprintf("audit write error: %v", werr)
+		}
+		return res, err
+	}
+}
+
+func formatAuditQueryResult(r AuditQueryResult) string {
+	var b strings.Builder
+	for i, e := range r.Entries {
+		if i > 0 {
+			b.WriteByte('\n')
+		}
+		line, _ := json.Marshal(e)
+		b.Write(line)
+	}
+	return b.String()
+}
+
+// handleAuditQuery searches the audit log. Callers need the read capability
+// and only see entries for paths they may read; entries without a path are
+// shown to the session that made them only.
+func handleAuditQuery(sessions map[string]*SessionState, mu *sync.RWMutex) mcp.StructuredToolHandlerFunc[AuditQueryArgs, AuditQueryResult] {
+	return func(ctx context.Context, req mcp.CallToolRequest, args AuditQueryArgs) (AuditQueryResult, error) {
+		start := time.Now()
+		var out AuditQueryResult
+		state, err := getSessionState(ctx, sessions, mu)
+		if err != nil {
+			return out, err
+		}
+		dprintf("%s -> fs_audit_query tool=%q path=%q session=%q", sessionContext(ctx), args.Tool, args.Path, args.Session)
+		if !state.Caps.has(capRead) {
+			return out, newOpError("audit_query", args.Path, ErrPermissionDenied, fmt.Sprintf("session lacks %s capability", capRead))
+		}
+		if auditLog == nil {
+			return out, errors.New("audit log is disabled; start the server with --audit-log")
+		}
+		self := getSessionID(ctx)
+		visible := func(e AuditEntry) bool {
+			if e.Path == "" {
+				return e.Session == self
+			}
+			name, err := state.FS.Resolve(e.Path, false)
+			return err == nil && state.readable(name)
+		}
+		var since, until time.Time
+		if args.Since != "" {
+			if since, err = time.Parse(time.RFC3339, args.Since); err != nil {
+				return out, &ValidationError{Field: "since", Value: args.Since, Message: "expected RFC3339 timestamp"}
+			}
+		}
+		if args.Until != "" {
+			if until, err = time.Parse(time.RFC3339, args.Until); err != nil {
+				return out, &ValidationError{Field: "until", Value: args.Until, Message: "expected RFC3339 timestamp"}
+			}
+		}
+		if args.Path != "" && !doublestar.ValidatePattern(args.Path) {
+			return out, newOpError("audit_query", args.Path, ErrInvalidGlob)
+		}
+		limit := currentConfig().toolLimit("fs_audit_query", args.Limit)
+		match := func(e AuditEntry) bool {
+			if args.Session != "" && e.Session != args.Session {
+				return false
+			}
+			if args.Tool != "" && e.Tool != args.Tool {
+				return false
+			}
+			if args.Result != "" && e.Result != args.Result {
+				return false
+			}
+			if args.Path != "" {
+				if ok, _ := doublestar.Match(args.Path, e.Path); !ok {
+					return false
+				}
+			}
+			if !since.IsZero() || !until.IsZero() {
+				t, err := time.Parse(time.RFC3339Nano, e.Time)
+				if err != nil || (!since.IsZero() && t.Before(since)) || (!until.IsZero() && t.After(until)) {
+					return false
+				}
+			}
+			return visible(e)
+		}
+		entries, truncated, err := auditLog.query(match, limit)
+		if err != nil {
+			dprintf("fs_audit_query error: %v", err)
+			return out, err
+		}
+		out = AuditQueryResult{Entries: entries, Truncated: truncated}
+		dprintf("<- fs_audit_query ok entries=%d dur=%s", len(entries), time.Since(start))
+		return out, nil
+	}
+}
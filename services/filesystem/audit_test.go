@@ -0,0 +1,203 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mark3labs/mcp-go/mcp"
+)
+
+func withTestAuditLog(t *testing.T, maxSize int64, backups int) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "audit", "audit.jsonl")
+	l, err := openAuditLog(path, maxSize, backups)
+	if err != nil {
+		t.Fatal(err)
+	}
+	orig := auditLog
+	auditLog = l
+	t.Cleanup(func() {
+		l.f.Close()
+		auditLog = orig
+	})
+	return path
+}
+
+func TestAuditRecordsMutations(t *testing.T) {
+	withTestAuditLog(t, 0, 0)
+	ctx, sessions, mu, mem := memSession()
+	memWrite(t, mem, "f.txt", []byte("v1"), 0o644)
+	wr := withAudit("fs_write", sessions, mu, handleWrite(sessions, mu))
+
+	if _, err := wr(ctx, mcp.CallToolRequest{}, WriteArgs{Path: "f.txt", Content: "v2"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := wr(ctx, mcp.CallToolRequest{}, WriteArgs{Path: "f.txt", Content: "x", Strategy: "bogus"}); err == nil {
+		t.Fatal("expected error")
+	}
+
+	res, err := handleAuditQuery(sessions, mu)(ctx, mcp.CallToolRequest{}, AuditQueryArgs{Tool: "fs_write"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(res.Entries) != 2 {
+		t.Fatalf("expected 2 entries, got %d", len(res.Entries))
+	}
+	ok := res.Entries[0]
+	if ok.Session != "s1" || ok.Result != "ok" || ok.Path != "f.txt" || ok.Bytes != 2 {
+		t.Fatalf("unexpected entry: %+v", ok)
+	}
+	if ok.Before != sha256sum([]byte("v1")) || ok.After != sha256sum([]byte("v2")) {
+		t.Fatalf("unexpected hashes: %+v", ok)
+	}
+	if _, found := ok.Args["content"]; found {
+		t.Fatalf("content should be elided: %+v", ok.Args)
+	}
+	if ok.Args["content_sha256"] != sha256sum([]byte("v2")) {
+		t.Fatalf("content hash missing: %+v", ok.Args)
+	}
+	if bad := res.Entries[1]; bad.Result != "error" || bad.Code == "" || bad.Before != bad.After {
+		t.Fatalf("unexpected error entry: %+v", bad)
+	}
+
+	res, err = handleAuditQuery(sessions, mu)(ctx, mcp.CallToolRequest{}, AuditQueryArgs{Result: "error", Limit: 5})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(res.Entries) != 1 {
+		t.Fatalf("expected 1 error entry, got %d", len(res.Entries))
+	}
+}
+
+func TestAuditRotationAndQueryLimit(t *testing.T) {
+	path := withTestAuditLog(t, 400, 2)
+	ctx, sessions, mu, _ := memSession()
+	mk := withAudit("fs_mkdir", sessions, mu, handleMkdir(sessions, mu))
+	for _, d := range []string{"a", "b", "c", "d", "e", "f"} {
+		if _, err := mk(ctx, mcp.CallToolRequest{}, MkdirArgs{Path: "dirs/" + d}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := os.Stat(path + ".1"); err != nil {
+		t.Fatalf("expected rotated log: %v", err)
+	}
+	if _, err := os.Stat(path + ".3"); !os.IsNotExist(err) {
+		t.Fatalf("expected at most 2 backups, got %v", err)
+	}
+
+	res, err := handleAuditQuery(sessions, mu)(ctx, mcp.CallToolRequest{}, AuditQueryArgs{Path: "dirs/*", Limit: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(res.Entries) != 2 || !res.Truncated {
+		t.Fatalf("expected 2 truncated entries, got %d truncated=%v", len(res.Entries), res.Truncated)
+	}
+	if !strings.HasSuffix(res.Entries[1].Path, "/f") {
+		t.Fatalf("expected most recent entry last, got %+v", res.Entries)
+	}
+}
+
+func TestAuditQueryDoesNotBlockWriters(t *testing.T) {
+	withTestAuditLog(t, 400, 2)
+	for i := 0; i < 10; i++ {
+		if err := auditLog.record(AuditEntry{Tool: "fs_write", Path: fmt.Sprint(i)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// Recording from inside the scan rotates the files being read
+	done := make(chan []AuditEntry)
+	go func() {
+		out, _, err := auditLog.query(func(e AuditEntry) bool {
+			if err := auditLog.record(AuditEntry{Tool: "fs_edit", Path: "during"}); err != nil {
+				t.Error(err)
+			}
+			return e.Tool == "fs_write"
+		}, 100)
+		if err != nil {
+			t.Error(err)
+		}
+		done <- out
+	}()
+	select {
+	case out := <-done:
+		if len(out) == 0 || out[len(out)-1].Path != "9" {
+			t.Fatalf("query during writes = %+v", out)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("record blocked while a query was scanning")
+	}
+}
+
+func TestAuditQueryDisabled(t *testing.T) {
+	orig := auditLog
+	auditLog = nil
+	t.Cleanup(func() { auditLog = orig })
+	ctx, sessions, mu, _ := memSession()
+	if _, err := handleAuditQuery(sessions, mu)(ctx, mcp.CallToolRequest{}, AuditQueryArgs{}); err == nil {
+		t.Fatal("expected error when audit log is disabled")
+	}
+}
+
+func TestAuditQueryHidesUnreadablePaths(t *testing.T) {
+	withTestAuditLog(t, 0, 0)
+	ctx, sessions, mu, mem := memSession()
+	memWrite(t, mem, "secret/key.txt", []byte("k"), 0o600)
+	mk := withAudit("fs_mkdir", sessions, mu, handleMkdir(sessions, mu))
+	wr := withAudit("fs_write", sessions, mu, handleWrite(sessions, mu))
+	if _, err := mk(ctx, mcp.CallToolRequest{}, MkdirArgs{Path: "pub"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := wr(ctx, mcp.CallToolRequest{}, WriteArgs{Path: "secret/key.txt", Content: "k2"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := auditLog.record(AuditEntry{Session: "s9", Tool: "fs_createsession", Result: "ok"}); err != nil {
+		t.Fatal(err)
+	}
+
+	policy, err := newPolicy(false, []string{"deny:read:secret/**"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sessions["s2"] = &SessionState{FS: mem, Policy: policy}
+	other := withSessionManager(context.Background(), &sessionManager{id: "s2"})
+	res, err := handleAuditQuery(sessions, mu)(other, mcp.CallToolRequest{}, AuditQueryArgs{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(res.Entries) != 1 || res.Entries[0].Path != "pub" {
+		t.Fatalf("entries visible to restricted session = %+v", res.Entries)
+	}
+
+	sessions["s3"] = &SessionState{FS: mem, Caps: capabilitySet{capWrite: true}}
+	other = withSessionManager(context.Background(), &sessionManager{id: "s3"})
+	if _, err := handleAuditQuery(sessions, mu)(other, mcp.CallToolRequest{}, AuditQueryArgs{}); !errors.Is(err, ErrPermissionDenied) {
+		t.Fatalf("query without read capability err = %v", err)
+	}
+}
+
+func TestAuditDigestsTakenUnderHandlerLock(t *testing.T) {
+	withTestAuditLog(t, 0, 0)
+	ctx, sessions, mu, mem := memSession()
+	memWrite(t, mem, "f.txt", []byte("v1"), 0o644)
+	// Another writer lands after withAudit starts but before fs_write locks
+	racy := func(ctx context.Context, req mcp.CallToolRequest, args WriteArgs) (WriteResult, error) {
+		memWrite(t, mem, "f.txt", []byte("other"), 0o644)
+		return handleWrite(sessions, mu)(ctx, req, args)
+	}
+	if _, err := withAudit("fs_write", sessions, mu, racy)(ctx, mcp.CallToolRequest{}, WriteArgs{Path: "f.txt", Content: "v2"}); err != nil {
+		t.Fatal(err)
+	}
+	res, err := handleAuditQuery(sessions, mu)(ctx, mcp.CallToolRequest{}, AuditQueryArgs{Tool: "fs_write"})
+	if err != nil || len(res.Entries) != 1 {
+		t.Fatalf("query = %+v, %v", res, err)
+	}
+	if e := res.Entries[0]; e.Before != sha256sum([]byte("other")) || e.After != sha256sum([]byte("v2")) {
+		t.Fatalf("entry hashes content the call did not replace: %+v", e)
+	}
+}
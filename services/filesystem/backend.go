@@ -0,0 +1,162 @@
+package main
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/url"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Backend is the storage behind a session. Names are slash-separated paths
+// relative to the backend's root, with "" naming the root itself; only names
+// returned by Resolve or derived from them with path.Join should be passed in.
+type Backend interface {
+	// Resolve maps a request path (relative, absolute under the root or a
+	// file:// URI) to a name, rejecting anything outside the root. With
+	// followFinal a symlink in the last element is resolved as well.
+	Resolve(reqPath string, followFinal bool) (string, error)
+
+	Stat(name string) (fs.FileInfo, error)
+	Lstat(name string) (fs.FileInfo, error)
+	Open(name string) (File, error)
+	ReadDir(name string) ([]fs.DirEntry, error)
+
+	// WriteFile atomically replaces name with data
+	WriteFile(name string, data []byte, perm fs.FileMode) error
+	AppendFile(name string, data []byte, perm fs.FileMode) error
+	MkdirAll(name string, perm fs.FileMode) error
+	Chmod(name string, perm fs.FileMode) error
+	Rename(oldName, newName string) error
+	Remove(name string) error
+	RemoveAll(name string) error
+
+	// Lock takes an advisory lock on name, shared for readers or exclusive
+	// for writers, waiting up to timeout
+	Lock(name string, mode lockMode, timeout time.Duration) (release func(), err error)
+}
+
+// File is an open file of a backend
+type File interface {
+	fs.File
+	io.Seeker
+}
+
+// symlinkBackend is implemented by backends that can store symbolic links
+type symlinkBackend interface {
+	Readlink(name string) (string, error)
+	Symlink(target, name string) error
+}
+
+// walkerBackend is implemented by backends with a faster tree walk than
+// repeated ReadDir calls
+type walkerBackend interface {
+	Walk(start string, fn fs.WalkDirFunc) error
+}
+
+// walkBackend visits the tree rooted at start in lexical order, passing
+// names to fn with the same semantics as fs.WalkDir.
+func walkBackend(b Backend, start string, fn fs.WalkDirFunc) error {
+	if w, ok := b.(walkerBackend); ok {
+		return w.Walk(start, fn)
+	}
+	root := start
+	if root == "" {
+		root = "."
+	}
+	return fs.WalkDir(backendFS{b}, root, func(p string, d fs.DirEntry, err error) error {
+		if p == "." {
+			p = ""
+		}
+		return fn(p, d, err)
+	})
+}
+
+// backendFS exposes a backend as an fs.FS
+type backendFS struct{ b Backend }
+
+func fsName(name string) string {
+	if name == "." {
+		return ""
+	}
+	return name
+}
+
+func (f backendFS) Open(name string) (fs.File, error) { return f.b.Open(fsName(name)) }
+
+func (f backendFS) Stat(name string) (fs.FileInfo, error) { return f.b.Stat(fsName(name)) }
+
+func (f backendFS) ReadDir(name string) ([]fs.DirEntry, error) { return f.b.ReadDir(fsName(name)) }
+
+// readBackendFile reads the whole file
+func readBackendFile(b Backend, name string) ([]byte, error) {
+	f, err := b.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return io.ReadAll(f)
+}
+
+// hashBackendFile computes the SHA256 of at most maxHashBytes of name,
+// reusing the cached digest while the file is unchanged
+func hashBackendFile(b Backend, name string) (string, error) {
+	f, err := b.Open(name)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	fi, err := f.Stat()
+	if err != nil {
+		return "", err
+	}
+	key := hashKeyOf(b, name, fi)
+	if sha, ok := fileHashes.get(key); ok {
+		return sha, nil
+	}
+	h := sha256.New()
+	if _, err := io.CopyN(h, f, maxHashBytes); err != nil && err != io.EOF {
+		return "", err
+	}
+	sha := fmt.Sprintf("%x", h.Sum(nil))
+	fileHashes.put(key, sha)
+	return sha, nil
+}
+
+// ensureParentDir creates the parent directories of name
+func ensureParentDir(b Backend, name string) error {
+	dir := path.Dir(name)
+	if dir == "." {
+		return nil
+	}
+	if err := b.MkdirAll(dir, 0o755); err != nil {
+		return fmt.Errorf("failed to create parent directories: %w", err)
+	}
+	return nil
+}
+
+// cleanName turns a request path into a name without touching any storage.
+// Backends without a host directory use it to implement Resolve; absolute
+// paths and file:// URIs are taken relative to the root.
+func cleanName(reqPath string) (string, error) {
+	p := reqPath
+	if strings.HasPrefix(p, "file://") {
+		u, err := url.Parse(p)
+		if err != nil {
+			return "", fmt.Errorf("invalid file URI: %w", err)
+		}
+		p = u.Path
+	}
+	p = path.Clean(strings.TrimPrefix(filepath.ToSlash(p), "/"))
+	if p == ".." || strings.HasPrefix(p, "../") {
+		return "", fmt.Errorf("refusing to access outside base folder: %s", reqPath)
+	}
+	if p == "." {
+		return "", nil
+	}
+	return p, nil
+}
@@ -0,0 +1,200 @@
+package main
+
+import (
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// localBackend serves a directory of the host filesystem. Every name is
+// joined with safeJoin, so symlinks cannot lead an operation outside root.
+type localBackend struct {
+	root         string
+	rootResolved string
+}
+
+func newLocalBackend(root string) *localBackend {
+	b := &localBackend{root: mustAbs(root)}
+	b.rootResolved = b.root
+	if r, err := filepath.EvalSymlinks(b.root); err == nil {
+		b.rootResolved = r
+	}
+	return b
+}
+
+// name converts a host path under the root to a backend name
+func (b *localBackend) name(full string) string {
+	for _, r := range []string{b.rootResolved, b.root} {
+		if full == r {
+			return ""
+		}
+		if rel, ok := strings.CutPrefix(full, strings.TrimSuffix(r, string(os.PathSeparator))+string(os.PathSeparator)); ok {
+			return filepath.ToSlash(rel)
+		}
+	}
+	return filepath.ToSlash(trimUnderRoot(b.root, full))
+}
+
+// path maps name to a host path without following a final symlink
+func (b *localBackend) path(name string) (string, error) {
+	return safeJoin(b.root, filepath.FromSlash(name))
+}
+
+// target maps name to a host path, following a final symlink within the root
+func (b *localBackend) target(name string) (string, error) {
+	return safeJoinResolveFinal(b.root, filepath.FromSlash(name))
+}
+
+func (b *localBackend) Resolve(reqPath string, followFinal bool) (string, error) {
+	var full string
+	var err error
+	if followFinal {
+		full, err = safeJoinResolveFinal(b.root, reqPath)
+	} else {
+		full, err = safeJoin(b.root, reqPath)
+	}
+	if err != nil {
+		return "", err
+	}
+	return b.name(full), nil
+}
+
+func (b *localBackend) Stat(name string) (fs.FileInfo, error) {
+	p, err := b.target(name)
+	if err != nil {
+		return nil, err
+	}
+	return os.Stat(p)
+}
+
+func (b *localBackend) Lstat(name string) (fs.FileInfo, error) {
+	p, err := b.path(name)
+	if err != nil {
+		return nil, err
+	}
+	return os.Lstat(p)
+}
+
+func (b *localBackend) Open(name string) (File, error) {
+	p, err := b.target(name)
+	if err != nil {
+		return nil, err
+	}
+	return os.Open(p)
+}
+
+func (b *localBackend) ReadDir(name string) ([]fs.DirEntry, error) {
+	p, err := b.target(name)
+	if err != nil {
+		return nil, err
+	}
+	return os.ReadDir(p)
+}
+
+func (b *localBackend) WriteFile(name string, data []byte, perm fs.FileMode) error {
+	p, err := b.path(name)
+	if err != nil {
+		return err
+	}
+	return atomicWrite(p, data, perm)
+}
+
+func (b *localBackend) AppendFile(name string, data []byte, perm fs.FileMode) error {
+	p, err := b.path(name)
+	if err != nil {
+		return err
+	}
+	if err := checkDiskSpace(p, int64(len(data))); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(p, os.O_CREATE|os.O_WRONLY|os.O_APPEND, perm)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+func (b *localBackend) MkdirAll(name string, perm fs.FileMode) error {
+	p, err := b.path(name)
+	if err != nil {
+		return err
+	}
+	return os.MkdirAll(p, perm)
+}
+
+func (b *localBackend) Chmod(name string, perm fs.FileMode) error {
+	p, err := b.path(name)
+	if err != nil {
+		return err
+	}
+	return os.Chmod(p, perm)
+}
+
+func (b *localBackend) Rename(oldName, newName string) error {
+	op, err := b.path(oldName)
+	if err != nil {
+		return err
+	}
+	np, err := b.path(newName)
+	if err != nil {
+		return err
+	}
+	return os.Rename(op, np)
+}
+
+func (b *localBackend) Remove(name string) error {
+	p, err := b.path(name)
+	if err != nil {
+		return err
+	}
+	return os.Remove(p)
+}
+
+func (b *localBackend) RemoveAll(name string) error {
+	p, err := b.path(name)
+	if err != nil {
+		return err
+	}
+	return os.RemoveAll(p)
+}
+
+func (b *localBackend) Lock(name string, mode lockMode, timeout time.Duration) (func(), error) {
+	p, err := b.path(name)
+	if err != nil {
+		return nil, err
+	}
+	return acquireLock(p, mode, timeout)
+}
+
+func (b *localBackend) Readlink(name string) (string, error) {
+	p, err := b.path(name)
+	if err != nil {
+		return "", err
+	}
+	return os.Readlink(p)
+}
+
+func (b *localBackend) Symlink(target, name string) error {
+	p, err := b.path(name)
+	if err != nil {
+		return err
+	}
+	return os.Symlink(target, p)
+}
+
+// Walk uses filepath.WalkDir, which avoids re-validating every entry
+func (b *localBackend) Walk(start string, fn fs.WalkDirFunc) error {
+	p, err := b.target(start)
+	if err != nil {
+		return fn(start, nil, err)
+	}
+	return filepath.WalkDir(p, func(full string, d fs.DirEntry, err error) error {
+		return fn(b.name(full), d, err)
+	})
+}
@@ -0,0 +1,279 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io/fs"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// memBackend keeps a whole tree in memory. It backs the --memfs demo mode
+// and tests that do not need a real disk. Symlinks are not supported.
+type memBackend struct {
+	mu    sync.RWMutex
+	nodes map[string]*memNode // keyed by name; "" is the root directory
+	locks *lockTable
+}
+
+type memNode struct {
+	mode    fs.FileMode // permission bits plus fs.ModeDir for directories
+	data    []byte
+	modTime time.Time
+	ino     uint64 // changes whenever the content is replaced
+}
+
+// memInodes numbers nodes so callers can tell rewrites apart like inodes
+var memInodes atomic.Uint64
+
+func newMemBackend() *memBackend {
+	return &memBackend{
+		nodes: map[string]*memNode{"": {mode: fs.ModeDir | 0o755, modTime: time.Now()}},
+		locks: newLockTable(),
+	}
+}
+
+// memFileInfo implements fs.FileInfo for a snapshot of a node
+type memFileInfo struct {
+	name string
+	size int64
+	mode fs.FileMode
+	mod  time.Time
+	ino  uint64
+}
+
+func (fi memFileInfo) Name() string       { return fi.name }
+func (fi memFileInfo) Size() int64        { return fi.size }
+func (fi memFileInfo) Mode() fs.FileMode  { return fi.mode }
+func (fi memFileInfo) ModTime() time.Time { return fi.mod }
+func (fi memFileInfo) IsDir() bool        { return fi.mode.IsDir() }
+func (fi memFileInfo) Sys() any           { return nil }
+func (fi memFileInfo) Inode() uint64      { return fi.ino }
+
+func (n *memNode) info(name string) memFileInfo {
+	base := path.Base(name)
+	if name == "" {
+		base = "/"
+	}
+	return memFileInfo{name: base, size: int64(len(n.data)), mode: n.mode, mod: n.modTime, ino: n.ino}
+}
+
+// memFile is an open file; it reads a snapshot taken at Open
+type memFile struct {
+	*bytes.Reader
+	info memFileInfo
+}
+
+func (f *memFile) Stat() (fs.FileInfo, error) { return f.info, nil }
+func (f *memFile) Close() error               { return nil }
+
+func memErr(op, name string, err error) error {
+	return &fs.PathError{Op: op, Path: name, Err: err}
+}
+
+func parentName(name string) string {
+	if dir := path.Dir(name); dir != "." {
+		return dir
+	}
+	return ""
+}
+
+// dirLocked returns the directory node of name or an error
+func (b *memBackend) dirLocked(op, name string) error {
+	n, ok := b.nodes[name]
+	if !ok {
+		return memErr(op, name, fs.ErrNotExist)
+	}
+	if !n.mode.IsDir() {
+		return memErr(op, name, errors.New("not a directory"))
+	}
+	return nil
+}
+
+// childrenLocked lists the names directly under dir
+func (b *memBackend) childrenLocked(dir string) []string {
+	var out []string
+	for k := range b.nodes {
+		if k != "" && parentName(k) == dir {
+			out = append(out, k)
+		}
+	}
+	sort.Strings(out)
+	return out
+}
+
+func (b *memBackend) Resolve(reqPath string, followFinal bool) (string, error) {
+	return cleanName(reqPath)
+}
+
+func (b *memBackend) Stat(name string) (fs.FileInfo, error) {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	n, ok := b.nodes[name]
+	if !ok {
+		return nil, memErr("stat", name, fs.ErrNotExist)
+	}
+	return n.info(name), nil
+}
+
+func (b *memBackend) Lstat(name string) (fs.FileInfo, error) {
+	return b.Stat(name)
+}
+
+func (b *memBackend) Open(name string) (File, error) {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	n, ok := b.nodes[name]
+	if !ok {
+		return nil, memErr("open", name, fs.ErrNotExist)
+	}
+	return &memFile{Reader: bytes.NewReader(n.data), info: n.info(name)}, nil
+}
+
+func (b *memBackend) ReadDir(name string) ([]fs.DirEntry, error) {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	if err := b.dirLocked("readdir", name); err != nil {
+		return nil, err
+	}
+	var out []fs.DirEntry
+	for _, k := range b.childrenLocked(name) {
+		out = append(out, fs.FileInfoToDirEntry(b.nodes[k].info(k)))
+	}
+	return out, nil
+}
+
+// putLocked stores data at name, creating the file if needed
+func (b *memBackend) putLocked(op, name string, data []byte, perm fs.FileMode) error {
+	if name == "" {
+		return memErr(op, name, errors.New("is a directory"))
+	}
+	if err := b.dirLocked(op, parentName(name)); err != nil {
+		return err
+	}
+	if n, ok := b.nodes[name]; ok && n.mode.IsDir() {
+		return memErr(op, name, errors.New("is a directory"))
+	}
+	b.nodes[name] = &memNode{mode: perm & fs.ModePerm, data: data, modTime: time.Now(), ino: memInodes.Add(1)}
+	return nil
+}
+
+func (b *memBackend) WriteFile(name string, data []byte, perm fs.FileMode) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.putLocked("write", name, append([]byte(nil), data...), perm)
+}
+
+func (b *memBackend) AppendFile(name string, data []byte, perm fs.FileMode) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if n, ok := b.nodes[name]; ok && !n.mode.IsDir() {
+		buf := append(append([]byte(nil), n.data...), data...)
+		// Appending keeps the file's identity, as it does on disk
+		n.data, n.modTime = buf, time.Now()
+		return nil
+	}
+	return b.putLocked("append", name, append([]byte(nil), data...), perm)
+}
+
+func (b *memBackend) MkdirAll(name string, perm fs.FileMode) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if name == "" {
+		return nil
+	}
+	parts := strings.Split(name, "/")
+	for i := range parts {
+		p := strings.Join(parts[:i+1], "/")
+		if n, ok := b.nodes[p]; ok {
+			if !n.mode.IsDir() {
+				return memErr("mkdir", p, errors.New("not a directory"))
+			}
+			continue
+		}
+		b.nodes[p] = &memNode{mode: fs.ModeDir | perm&fs.ModePerm, modTime: time.Now()}
+	}
+	return nil
+}
+
+func (b *memBackend) Chmod(name string, perm fs.FileMode) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	n, ok := b.nodes[name]
+	if !ok {
+		return memErr("chmod", name, fs.ErrNotExist)
+	}
+	n.mode = n.mode&fs.ModeType | perm&fs.ModePerm
+	return nil
+}
+
+func (b *memBackend) Rename(oldName, newName string) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	n, ok := b.nodes[oldName]
+	if !ok || oldName == "" {
+		return memErr("rename", oldName, fs.ErrNotExist)
+	}
+	if err := b.dirLocked("rename", parentName(newName)); err != nil {
+		return err
+	}
+	if newName == oldName || strings.HasPrefix(newName, oldName+"/") {
+		return memErr("rename", newName, fs.ErrInvalid)
+	}
+	if dst, ok := b.nodes[newName]; ok {
+		if dst.mode.IsDir() != n.mode.IsDir() || (dst.mode.IsDir() && len(b.childrenLocked(newName)) > 0) {
+			return memErr("rename", newName, fs.ErrExist)
+		}
+	}
+	moved := map[string]*memNode{newName: n}
+	for k, v := range b.nodes {
+		if strings.HasPrefix(k, oldName+"/") {
+			moved[newName+strings.TrimPrefix(k, oldName)] = v
+			delete(b.nodes, k)
+		}
+	}
+	delete(b.nodes, oldName)
+	for k, v := range moved {
+		b.nodes[k] = v
+	}
+	return nil
+}
+
+func (b *memBackend) Remove(name string) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	n, ok := b.nodes[name]
+	if !ok || name == "" {
+		return memErr("remove", name, fs.ErrNotExist)
+	}
+	if n.mode.IsDir() && len(b.childrenLocked(name)) > 0 {
+		return memErr("remove", name, errors.New("directory not empty"))
+	}
+	delete(b.nodes, name)
+	return nil
+}
+
+func (b *memBackend) RemoveAll(name string) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for k := range b.nodes {
+		if k != "" && (name == "" || k == name || strings.HasPrefix(k, name+"/")) {
+			delete(b.nodes, k)
+		}
+	}
+	return nil
+}
+
+// Lock locks name within this process
+func (b *memBackend) Lock(name string, mode lockMode, timeout time.Duration) (func(), error) {
+	release, err := b.locks.acquire(name, mode, time.Now().Add(timeout))
+	if err != nil {
+		return nil, fmt.Errorf("%w after %v: %s", ErrLockTimeout, timeout, name)
+	}
+	return release, nil
+}
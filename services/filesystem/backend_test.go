@@ -0,0 +1,156 @@
+package main
+
+import (
+	"errors"
+	"io/fs"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/mark3labs/mcp-go/mcp"
+)
+
+func TestCleanName(t *testing.T) {
+	cases := map[string]string{
+		"":              "",
+		".":             "",
+		"/":             "",
+		"a/b.txt":       "a/b.txt",
+		"/a/b.txt":      "a/b.txt",
+		"a/../b":        "b",
+		"file:///a/b":   "a/b",
+		"a//b/./c.txt/": "a/b/c.txt",
+	}
+	for in, want := range cases {
+		got, err := cleanName(in)
+		if err != nil || got != want {
+			t.Errorf("cleanName(%q) = %q, %v; want %q", in, got, err, want)
+		}
+	}
+	for _, in := range []string{"..", "../x", "a/../../x"} {
+		if _, err := cleanName(in); err == nil {
+			t.Errorf("cleanName(%q) allowed an escape", in)
+		}
+	}
+}
+
+func TestMemBackendBasics(t *testing.T) {
+	b := newMemBackend()
+	if err := b.WriteFile("a/b.txt", []byte("x"), 0o644); err == nil {
+		t.Fatalf("write without parent should fail")
+	}
+	if err := b.MkdirAll("a/c", 0o755); err != nil {
+		t.Fatalf("mkdir: %v", err)
+	}
+	if err := b.WriteFile("a/b.txt", []byte("hello"), 0o600); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	if err := b.AppendFile("a/b.txt", []byte(" world"), 0o644); err != nil {
+		t.Fatalf("append: %v", err)
+	}
+	data, err := readBackendFile(b, "a/b.txt")
+	if err != nil || string(data) != "hello world" {
+		t.Fatalf("read: %q %v", data, err)
+	}
+	fi, err := b.Stat("a/b.txt")
+	if err != nil || fi.Mode().Perm() != 0o600 || fi.Size() != 11 {
+		t.Fatalf("stat: %v %v", fi, err)
+	}
+	if err := b.Remove("a"); err == nil {
+		t.Fatalf("remove of non-empty dir should fail")
+	}
+	if err := b.Rename("a", "z"); err != nil {
+		t.Fatalf("rename: %v", err)
+	}
+	if _, err := b.Stat("a/b.txt"); !errors.Is(err, fs.ErrNotExist) {
+		t.Fatalf("old name still present: %v", err)
+	}
+	var names []string
+	if err := walkBackend(b, "", func(name string, d fs.DirEntry, err error) error {
+		names = append(names, name)
+		return err
+	}); err != nil {
+		t.Fatalf("walk: %v", err)
+	}
+	sort.Strings(names)
+	want := []string{"", "z", "z/b.txt", "z/c"}
+	if len(names) != len(want) {
+		t.Fatalf("walk = %v; want %v", names, want)
+	}
+	for i := range want {
+		if names[i] != want[i] {
+			t.Fatalf("walk = %v; want %v", names, want)
+		}
+	}
+	if err := b.RemoveAll("z"); err != nil {
+		t.Fatalf("removeall: %v", err)
+	}
+	if ents, _ := b.ReadDir(""); len(ents) != 0 {
+		t.Fatalf("root not empty: %v", ents)
+	}
+}
+
+func TestMemBackendLock(t *testing.T) {
+	b := newMemBackend()
+	unlock, err := b.Lock("f", lockExclusive, time.Second)
+	if err != nil {
+		t.Fatalf("lock: %v", err)
+	}
+	if _, err := b.Lock("f", lockExclusive, 20*time.Millisecond); err == nil {
+		t.Fatalf("second lock should time out")
+	}
+	unlock()
+	unlock2, err := b.Lock("f", lockExclusive, time.Second)
+	if err != nil {
+		t.Fatalf("relock: %v", err)
+	}
+	unlock2()
+}
+
+func TestHandlersOnMemBackend(t *testing.T) {
+	ctx, sessions, mu, b := memSession()
+	req := mcp.CallToolRequest{}
+
+	if _, err := handleMkdir(sessions, mu)(ctx, req, MkdirArgs{Path: "src"}); err != nil {
+		t.Fatalf("mkdir: %v", err)
+	}
+	wr, err := handleWrite(sessions, mu)(ctx, req, WriteArgs{Path: "src/main.go", Content: "package main\n"})
+	if err != nil || !wr.Created {
+		t.Fatalf("write: %+v %v", wr, err)
+	}
+	if _, err := handleWrite(sessions, mu)(ctx, req, WriteArgs{Path: "src/main.go", Content: "func main() {}\n", Strategy: strategyAppend}); err != nil {
+		t.Fatalf("append: %v", err)
+	}
+	if _, err := handleEdit(sessions, mu)(ctx, req, EditArgs{Path: "src/main.go", Pattern: "main()", Replace: "run()"}); err != nil {
+		t.Fatalf("edit: %v", err)
+	}
+	rr, err := handleRead(sessions, mu)(ctx, req, ReadArgs{Path: "src/main.go"})
+	if err != nil || rr.Content != "package main\nfunc run() {}\n" || rr.SHA256 == "" {
+		t.Fatalf("read: %+v %v", rr, err)
+	}
+	pr, err := handlePeek(sessions, mu)(ctx, req, PeekArgs{Path: "src/main.go", Offset: 8, MaxBytes: 4})
+	if err != nil || pr.Content != "main" {
+		t.Fatalf("peek: %+v %v", pr, err)
+	}
+	lr, err := handleList(sessions, mu)(ctx, req, ListArgs{Path: "src"})
+	if err != nil || len(lr.Entries) != 1 || lr.Entries[0].Path != "src/main.go" {
+		t.Fatalf("list: %+v %v", lr, err)
+	}
+	gr, err := handleGlob(sessions, mu)(ctx, req, GlobArgs{Pattern: "**/*.go"})
+	if err != nil || len(gr.Matches) != 1 || gr.Matches[0] != "src/main.go" {
+		t.Fatalf("glob: %+v %v", gr, err)
+	}
+	sr, err := handleSearch(sessions, mu)(ctx, req, SearchArgs{Pattern: "run"})
+	if err != nil || len(sr.Matches) != 1 || sr.Matches[0].Line != 2 {
+		t.Fatalf("search: %+v %v", sr, err)
+	}
+	if _, err := handleRead(sessions, mu)(ctx, req, ReadArgs{Path: "../etc/passwd"}); err == nil {
+		t.Fatalf("read allowed an escape")
+	}
+	if _, err := handleRmdir(sessions, mu)(ctx, req, RmdirArgs{Path: "src", Recursive: true}); err != nil {
+		t.Fatalf("rmdir: %v", err)
+	}
+	if _, err := b.Stat("src"); !errors.Is(err, fs.ErrNotExist) {
+		t.Fatalf("src still present: %v", err)
+	}
+}
@@ -0,0 +1,271 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"strings"
+	"unicode/utf8"
+
+	"golang.org/x/text/encoding"
+	"golang.org/x/text/encoding/charmap"
+	"golang.org/x/text/encoding/unicode"
+)
+
+// Text encodings recognised on read and accepted as a write charset
+const (
+	charsetUTF8        = "utf-8"
+	charsetUTF16LE     = "utf-16le"
+	charsetUTF16BE     = "utf-16be"
+	charsetLatin1      = "latin-1"
+	charsetWindows1252 = "windows-1252"
+)
+
+// Line ending conventions reported for text content
+const (
+	lineEndingsLF    = "lf"
+	lineEndingsCRLF  = "crlf"
+	lineEndingsMixed = "mixed"
+)
+
+// textSniffBytes bounds how much of an existing file is inspected to learn
+// its encoding before a write
+const textSniffBytes = 64 << 10
+
+var (
+	bomUTF8    = []byte{0xef, 0xbb, 0xbf}
+	bomUTF16LE = []byte{0xff, 0xfe}
+	bomUTF16BE = []byte{0xfe, 0xff}
+)
+
+// textFormat describes how a text file is stored on disk
+type textFormat struct {
+	Encoding    string
+	BOM         bool
+	LineEndings string
+}
+
+// plain reports whether the format is BOM-less UTF-8 that needs no transcoding
+func (f textFormat) plain() bool {
+	return f.Encoding == charsetUTF8 && !f.BOM
+}
+
+// verbatim reports whether UTF-8 text can be stored as is in this format
+func (f textFormat) verbatim() bool {
+	return f.plain() && f.LineEndings != lineEndingsLF && f.LineEndings != lineEndingsCRLF
+}
+
+var charsetAliases = map[string]string{
+	"utf-8":        charsetUTF8,
+	"utf8":         charsetUTF8,
+	"utf-16le":     charsetUTF16LE,
+	"utf-16be":     charsetUTF16BE,
+	"latin-1":      charsetLatin1,
+	"latin1":       charsetLatin1,
+	"iso-8859-1":   charsetLatin1,
+	"windows-1252": charsetWindows1252,
+	"cp1252":       charsetWindows1252,
+}
+
+// parseCharset normalises a user supplied charset name
+func parseCharset(name string) (string, error) {
+	if cs, ok := charsetAliases[strings.ToLower(strings.TrimSpace(name))]; ok {
+		return cs, nil
+	}
+	return "", &ValidationError{Field: "charset", Value: name, Message: "expected utf-8, utf-16le, utf-16be, latin-1 or windows-1252"}
+}
+
+func charsetEncoding(cs string) encoding.Encoding {
+	switch cs {
+	case charsetUTF16LE:
+		return unicode.UTF16(unicode.LittleEndian, unicode.IgnoreBOM)
+	case charsetUTF16BE:
+		return unicode.UTF16(unicode.BigEndian, unicode.IgnoreBOM)
+	case charsetLatin1:
+		return charmap.ISO8859_1
+	case charsetWindows1252:
+		return charmap.Windows1252
+	}
+	return encoding.Nop
+}
+
+func charsetBOM(cs string) []byte {
+	switch cs {
+	case charsetUTF8:
+		return bomUTF8
+	case charsetUTF16LE:
+		return bomUTF16LE
+	case charsetUTF16BE:
+		return bomUTF16BE
+	}
+	return nil
+}
+
+// detectTextFormat works out how b is encoded. It returns false when b looks
+// like binary data in every supported encoding.
+func detectTextFormat(b []byte) (textFormat, bool) {
+	f := textFormat{Encoding: charsetUTF8}
+	switch {
+	case bytes.HasPrefix(b, bomUTF8):
+		f.BOM = true
+	case bytes.HasPrefix(b, bomUTF16LE):
+		f.Encoding, f.BOM = charsetUTF16LE, true
+	case bytes.HasPrefix(b, bomUTF16BE):
+		f.Encoding, f.BOM = charsetUTF16BE, true
+	case plainText(trimPartialRune(b)):
+		f.LineEndings = lineEndingsOf(string(b))
+		return f, true
+	default:
+		f.Encoding = guessLegacyCharset(b)
+		if f.Encoding == "" {
+			return f, false
+		}
+	}
+	s, err := decodeText(b, f)
+	if err != nil || !plainText([]byte(s)) {
+		return f, false
+	}
+	f.LineEndings = lineEndingsOf(s)
+	return f, true
+}
+
+// guessLegacyCharset picks a BOM-less encoding for content that is not
+// UTF-8: UTF-16 when NUL bytes line up with ASCII characters, otherwise a
+// single-byte Western encoding
+func guessLegacyCharset(b []byte) string {
+	sample := b[:min(len(b), 4096)]
+	var even, odd int
+	for i, c := range sample {
+		if c != 0 {
+			continue
+		}
+		if i%2 == 0 {
+			even++
+		} else {
+			odd++
+		}
+	}
+	pairs := len(sample) / 2
+	switch {
+	case pairs == 0:
+	case odd*10 >= pairs*4 && even*10 < pairs:
+		return charsetUTF16LE
+	case even*10 >= pairs*4 && odd*10 < pairs:
+		return charsetUTF16BE
+	}
+	if even+odd > 0 {
+		return ""
+	}
+	for _, c := range b {
+		// Bytes left undefined by windows-1252
+		if c == 0x81 || c == 0x8d || c == 0x8f || c == 0x90 || c == 0x9d {
+			return charsetLatin1
+		}
+	}
+	return charsetWindows1252
+}
+
+// trimPartialRune drops an incomplete UTF-8 sequence cut off at the end of a
+// truncated read so it does not disqualify the whole sample
+func trimPartialRune(b []byte) []byte {
+	for i := 1; i <= utf8.UTFMax-1 && i <= len(b); i++ {
+		if utf8.RuneStart(b[len(b)-i]) {
+			if !utf8.FullRune(b[len(b)-i:]) {
+				return b[:len(b)-i]
+			}
+			break
+		}
+	}
+	return b
+}
+
+// lineEndingsOf reports whether s uses LF, CRLF or both; text without line
+// breaks yields an empty string
+func lineEndingsOf(s string) string {
+	crlf := strings.Count(s, "\r\n")
+	lf := strings.Count(s, "\n") - crlf
+	switch {
+	case crlf > 0 && lf > 0:
+		return lineEndingsMixed
+	case crlf > 0:
+		return lineEndingsCRLF
+	case lf > 0:
+		return lineEndingsLF
+	}
+	return ""
+}
+
+// decodeText converts b from format f to UTF-8, dropping any BOM
+func decodeText(b []byte, f textFormat) (string, error) {
+	if f.BOM {
+		b = bytes.TrimPrefix(b, charsetBOM(f.Encoding))
+	}
+	if f.Encoding == charsetUTF16LE || f.Encoding == charsetUTF16BE {
+		// A truncated read can split a code unit
+		b = b[:len(b)&^1]
+	}
+	if f.Encoding == charsetUTF8 {
+		return string(b), nil
+	}
+	out, err := charsetEncoding(f.Encoding).NewDecoder().Bytes(b)
+	if err != nil {
+		return "", fmt.Errorf("decode %s: %w", f.Encoding, err)
+	}
+	return string(out), nil
+}
+
+// encodeText converts UTF-8 text to format f, adding the BOM when f has one
+// and normalising line breaks to the format's convention
+func encodeText(s string, f textFormat) ([]byte, error) {
+	s = normalizeNewlines(s, f.LineEndings)
+	out, err := charsetEncoding(f.Encoding).NewEncoder().Bytes([]byte(s))
+	if err != nil {
+		return nil, fmt.Errorf("content cannot be encoded as %s: %w", f.Encoding, err)
+	}
+	if f.BOM {
+		out = append(append([]byte{}, charsetBOM(f.Encoding)...), out...)
+	}
+	return out, nil
+}
+
+// sniffTextFormat detects the format of an existing file from its first bytes
+func sniffTextFormat(b Backend, name string) (textFormat, bool) {
+	f, err := b.Open(name)
+	if err != nil {
+		return textFormat{}, false
+	}
+	defer f.Close()
+	sample, err := io.ReadAll(io.LimitReader(f, textSniffBytes))
+	if err != nil {
+		return textFormat{}, false
+	}
+	return detectTextFormat(sample)
+}
+
+// targetTextFormat settles the format new text is written in: the detected
+// format of the existing file, overridden by an explicit charset, bom or
+// newline policy
+func targetTextFormat(existing textFormat, found bool, charset string, bom *bool, newline newlinePolicy) (textFormat, error) {
+	f := textFormat{Encoding: charsetUTF8}
+	if found {
+		f = existing
+	}
+	endings, err := lineEndingsFor(newline, f.LineEndings)
+	if err != nil {
+		return f, err
+	}
+	f.LineEndings = endings
+	if charset != "" {
+		cs, err := parseCharset(charset)
+		if err != nil {
+			return f, err
+		}
+		if cs != f.Encoding {
+			f.Encoding, f.BOM = cs, false
+		}
+	}
+	if bom != nil {
+		f.BOM = *bom && charsetBOM(f.Encoding) != nil
+	}
+	return f, nil
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/mark3labs/mcp-go/mcp"
+)
+
+func TestDetectTextFormat(t *testing.T) {
+	cases := []struct {
+		name string
+		in   []byte
+		want textFormat
+		text bool
+	}{
+		{"utf8", []byte("a\nb\n"), textFormat{Encoding: charsetUTF8, LineEndings: lineEndingsLF}, true},
+		{"utf8 bom crlf", []byte("\xef\xbb\xbfa\r\nb\r\n"), textFormat{Encoding: charsetUTF8, BOM: true, LineEndings: lineEndingsCRLF}, true},
+		{"utf16le bom", []byte("\xff\xfeh\x00i\x00\r\x00\n\x00"), textFormat{Encoding: charsetUTF16LE, BOM: true, LineEndings: lineEndingsCRLF}, true},
+		{"utf16be bom", []byte("\xfe\xff\x00h\x00i"), textFormat{Encoding: charsetUTF16BE, BOM: true}, true},
+		{"utf16le bare", []byte("k\x00e\x00y\x00=\x00v\x00\n\x00"), textFormat{Encoding: charsetUTF16LE, LineEndings: lineEndingsLF}, true},
+		{"latin1", []byte("caf\xe9 cr\xe8me\n"), textFormat{Encoding: charsetWindows1252, LineEndings: lineEndingsLF}, true},
+		{"latin1 c1", []byte("x\x81y\xe9\n"), textFormat{Encoding: charsetLatin1, LineEndings: lineEndingsLF}, true},
+		{"mixed", []byte("a\r\nb\n"), textFormat{Encoding: charsetUTF8, LineEndings: lineEndingsMixed}, true},
+		{"truncated rune", []byte("na\xc3"), textFormat{Encoding: charsetUTF8}, true},
+		{"binary", []byte{0x89, 'P', 'N', 'G', 0, 0, 0, 0x0d, 1, 2, 3}, textFormat{}, false},
+	}
+	for _, c := range cases {
+		got, ok := detectTextFormat(c.in)
+		if ok != c.text || (ok && got != c.want) {
+			t.Errorf("%s: got %+v %v; want %+v %v", c.name, got, ok, c.want, c.text)
+		}
+	}
+}
+
+func TestReadTranscodes(t *testing.T) {
+	ctx, sessions, mu, mem := memSession()
+	memWrite(t, mem, "latin.conf", []byte("name=Jos\xe9\r\n"), 0o644)
+	memWrite(t, mem, "export.csv", []byte("\xff\xfea\x00,\x00\xe9\x00\n\x00"), 0o644)
+	req := mcp.CallToolRequest{}
+
+	res, err := handleRead(sessions, mu)(ctx, req, ReadArgs{Path: "latin.conf"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Content != "name=José\r\n" || res.Encoding != charsetWindows1252 || res.LineEndings != lineEndingsCRLF {
+		t.Fatalf("latin read = %+v", res)
+	}
+
+	res, err = handleRead(sessions, mu)(ctx, req, ReadArgs{Path: "export.csv"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Content != "a,é\n" || res.Encoding != charsetUTF16LE || !res.BOM {
+		t.Fatalf("utf16 read = %+v", res)
+	}
+
+	// Raw encodings return the stored bytes untouched
+	res, err = handleRead(sessions, mu)(ctx, req, ReadArgs{Path: "export.csv", Encoding: encodingHex})
+	if err != nil || res.Content != "fffe61002c00e9000a00" || res.Encoding != charsetUTF16LE {
+		t.Fatalf("hex read = %+v %v", res, err)
+	}
+}
+
+func TestWriteAndEditKeepEncoding(t *testing.T) {
+	ctx, sessions, mu, mem := memSession()
+	req := mcp.CallToolRequest{}
+	p := "win.txt"
+	memWrite(t, mem, p, []byte("\xff\xfeo\x00n\x00e\x00\r\x00\n\x00"), 0o644)
+
+	wr, err := handleWrite(sessions, mu)(ctx, req, WriteArgs{Path: "win.txt", Content: "zwei\ndrei\n"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "\xff\xfez\x00w\x00e\x00i\x00\r\x00\n\x00d\x00r\x00e\x00i\x00\r\x00\n\x00"
+	if got := memRead(t, mem, p); got != want || wr.Charset != charsetUTF16LE {
+		t.Fatalf("overwrite = %q (%s)", got, wr.Charset)
+	}
+
+	if _, err := handleWrite(sessions, mu)(ctx, req, WriteArgs{Path: "win.txt", Content: "eins\n", Strategy: strategyPrepend}); err != nil {
+		t.Fatal(err)
+	}
+	if got := memRead(t, mem, p); got != "\xff\xfee\x00i\x00n\x00s\x00\r\x00\n\x00"+want[2:] {
+		t.Fatalf("prepend = %q", got)
+	}
+
+	er, err := handleEdit(sessions, mu)(ctx, req, EditArgs{Path: "win.txt", Pattern: "zwei", Replace: "deux"})
+	if err != nil || er.Replacements != 1 || er.Charset != charsetUTF16LE {
+		t.Fatalf("edit = %+v %v", er, err)
+	}
+	rr, err := handleRead(sessions, mu)(ctx, req, ReadArgs{Path: "win.txt"})
+	if err != nil || rr.Content != "eins\r\ndeux\r\ndrei\r\n" || !rr.BOM {
+		t.Fatalf("read after edit = %+v %v", rr, err)
+	}
+
+	// An explicit charset converts the file
+	bom := false
+	if _, err := handleEdit(sessions, mu)(ctx, req, EditArgs{Path: "win.txt", Pattern: "eins", Replace: "un", Charset: "utf-8", BOM: &bom}); err != nil {
+		t.Fatal(err)
+	}
+	if got := memRead(t, mem, p); got != "un\r\ndeux\r\ndrei\r\n" {
+		t.Fatalf("converted = %q", got)
+	}
+
+	memWrite(t, mem, "l1.txt", []byte("caf\xe9\n"), 0o644)
+	if _, err := handleWrite(sessions, mu)(ctx, req, WriteArgs{Path: "l1.txt", Content: "€ only in utf-8 ☃\n", Charset: "latin-1"}); err == nil {
+		t.Fatal("unencodable content accepted")
+	}
+	if _, err := handleWrite(sessions, mu)(ctx, req, WriteArgs{Path: "l1.txt", Content: "naïve\n"}); err != nil {
+		t.Fatal(err)
+	}
+	if got := memRead(t, mem, "l1.txt"); got != "na\xefve\n" {
+		t.Fatalf("latin overwrite = %q", got)
+	}
+}
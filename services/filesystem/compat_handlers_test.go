@@ -1,8 +1,6 @@
 package main
 
 import (
-	"os"
-	"path/filepath"
 	"testing"
 
 	"github.com/mark3labs/mcp-go/mcp"
@@ -10,12 +8,9 @@ import (
 
 // Test that wrapTextHandler propagates errors correctly when compat mode is enabled.
 func TestCompatWrapTextHandlerPropagatesErrors(t *testing.T) {
-	orig := *compatFlag
-	*compatFlag = true
-	t.Cleanup(func() { *compatFlag = orig })
+	withConfig(t, func(c *ServerConfig) { c.CompatMode = true })
 
-	root := t.TempDir()
-	ctx, sessions, mu := testSession(root)
+	ctx, sessions, mu, _ := memSession()
 	h := wrapTextHandler(handleRead(sessions, mu), formatReadResult)
 
 	// Attempt to read path outside the root to force an error.
@@ -32,8 +27,7 @@ func TestCompatWrapTextHandlerPropagatesErrors(t *testing.T) {
 
 // Test that wrapTextHandler returns an error result when argument binding fails.
 func TestWrapTextHandlerBindingError(t *testing.T) {
-	root := t.TempDir()
-	ctx, sessions, mu := testSession(root)
+	ctx, sessions, mu, _ := memSession()
 	h := wrapTextHandler(handleRead(sessions, mu), formatReadResult)
 
 	// Provide invalid argument type to trigger binding error.
@@ -49,12 +43,11 @@ func TestWrapTextHandlerBindingError(t *testing.T) {
 }
 
 func TestStructuredHandlerOmitsTextContent(t *testing.T) {
-	root := t.TempDir()
-	p := filepath.Join(root, "f.txt")
-	if err := os.WriteFile(p, []byte("hi"), 0o644); err != nil {
+	ctx, sessions, mu, mem := memSession()
+	p := "f.txt"
+	if err := mem.WriteFile(p, []byte("hi"), 0o644); err != nil {
 		t.Fatal(err)
 	}
-	ctx, sessions, mu := testSession(root)
 	h := wrapStructuredHandler(handleRead(sessions, mu))
 	req := mcp.CallToolRequest{Params: mcp.CallToolParams{Arguments: map[string]any{"path": "f.txt"}}}
 	res, err := h(ctx, req)
@@ -71,8 +64,7 @@ func TestStructuredHandlerOmitsTextContent(t *testing.T) {
 
 // Test that wrapStructuredHandler returns an error result when argument binding fails.
 func TestWrapStructuredHandlerBindingError(t *testing.T) {
-	root := t.TempDir()
-	ctx, sessions, mu := testSession(root)
+	ctx, sessions, mu, _ := memSession()
 	h := wrapStructuredHandler(handleRead(sessions, mu))
 	req := mcp.CallToolRequest{Params: mcp.CallToolParams{Arguments: map[string]any{"path": 123}}}
 	res, err := h(ctx, req)
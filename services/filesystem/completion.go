@@ -0,0 +1,171 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"io/fs"
+	"path"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mark3labs/mcp-go/mcp"
+)
+
+const (
+	methodCompletionComplete mcp.MCPMethod = "completion/complete"
+
+	refResource = "ref/resource"
+	refPrompt   = "ref/prompt"
+	// refTool is not part of MCP, which only completes prompt and resource
+	// template arguments; {"type":"ref/tool","name":"fs_list"} names a tool
+	refTool = "ref/tool"
+
+	maxCompletions = 100 // MCP limit on values per completion
+)
+
+// completeKind says what a path argument accepts
+type completeKind int
+
+const (
+	completeAny completeKind = iota
+	completeDir
+)
+
+// pathArgs lists the tool arguments that take paths or globs relative to the
+// base folder. Tools that need an existing or new directory get directories
+// only.
+var pathArgs = map[string]map[string]completeKind{
+	"fs_read":            {"path": completeAny},
+	"fs_peek":            {"path": completeAny},
+	"fs_tail":            {"path": completeAny},
+	"fs_read_many":       {"paths": completeAny, "glob": completeAny},
+	"fs_write":           {"path": completeAny},
+	"fs_write_begin":     {"path": completeAny},
+	"fs_write_commit":    {"path": completeAny},
+	"fs_edit":            {"path": completeAny},
+	"fs_list":            {"path": completeDir},
+	"fs_stat":            {"path": completeAny},
+	"fs_lock":            {"path": completeAny},
+	"fs_search":          {"path": completeDir},
+	"fs_glob":            {"pattern": completeAny},
+	"fs_watch":           {"paths": completeAny, "globs": completeAny, "ignore": completeAny},
+	"fs_mkdir":           {"path": completeDir},
+	"fs_rmdir":           {"path": completeDir},
+	"fs_archive_create":  {"path": completeAny, "include": completeAny, "exclude": completeAny},
+	"fs_archive_extract": {"path": completeAny, "dest": completeDir},
+	"fs_checkpoint":      {"paths": completeAny},
+	"fs_audit_query":     {"path": completeAny},
+	"createsession":      {"archive": completeAny},
+}
+
+// completeParams is mcp.CompleteParams with the reference decoded
+type completeParams struct {
+	Ref struct {
+		Type string `json:"type"`
+		Name string `json:"name"`
+		URI  string `json:"uri"`
+	} `json:"ref"`
+	Argument struct {
+		Name  string `json:"name"`
+		Value string `json:"value"`
+	} `json:"argument"`
+}
+
+// registerCompletions serves completion/complete through router, since
+// mcp-go neither handles it nor declares the capability
+func registerCompletions(router *rpcRouter, sessions map[string]*SessionState, mu *sync.RWMutex) {
+	router.handle(methodCompletionComplete, handleComplete(sessions, mu))
+	router.advertise("completions", struct{}{})
+}
+
+func handleComplete(sessions map[string]*SessionState, mu *sync.RWMutex) rpcHandler {
+	return func(ctx context.Context, params json.RawMessage) (any, error) {
+		state, err := getSessionState(ctx, sessions, mu)
+		if err != nil {
+			return nil, err
+		}
+		start := time.Now()
+		var p completeParams
+		if err := decodeParams(params, &p); err != nil {
+			return nil, err
+		}
+		dprintf("%s -> completion/complete ref=%s%s arg=%s value=%q", sessionContext(ctx), p.Ref.Name, p.Ref.URI, p.Argument.Name, p.Argument.Value)
+		var res mcp.CompleteResult
+		res.Completion.Values = []string{}
+		kind, ok := completeAny, false
+		switch p.Ref.Type {
+		case refTool:
+			kind, ok = pathArgs[p.Ref.Name][p.Argument.Name]
+		case refResource:
+			ok = p.Ref.URI == fileResourceTemplate && p.Argument.Name == "path"
+		case refPrompt:
+		default:
+			return nil, &ValidationError{Field: "ref.type", Value: p.Ref.Type, Message: "expected ref/resource, ref/prompt or ref/tool"}
+		}
+		if !ok {
+			return res, nil
+		}
+		values := completePath(state, p.Argument.Value, kind)
+		res.Completion.Total = len(values)
+		if len(values) > maxCompletions {
+			values = values[:maxCompletions]
+			res.Completion.HasMore = true
+		}
+		res.Completion.Values = values
+		dprintf("<- completion/complete ok values=%d dur=%s", res.Completion.Total, time.Since(start))
+		return res, nil
+	}
+}
+
+// completePath returns the entries of the directory value points into whose
+// names start with the rest of value, in name order. Directories end in a
+// slash so completion can continue below them. Dot entries are only offered
+// once the partial name starts with a dot.
+func completePath(state *SessionState, value string, kind completeKind) []string {
+	dir, prefix := "", value
+	if i := strings.LastIndex(value, "/"); i >= 0 {
+		dir, prefix = value[:i+1], value[i+1:]
+	}
+	if strings.ContainsAny(dir, "*?[{") {
+		return nil
+	}
+	lookup := dir
+	if dir != "/" {
+		lookup = strings.TrimSuffix(dir, "/")
+	}
+	name, err := state.FS.Resolve(lookup, true)
+	if err != nil || (name != "" && !state.readable(name)) {
+		return nil
+	}
+	entries, err := state.FS.ReadDir(name)
+	if err != nil {
+		return nil
+	}
+	var out []string
+	for _, e := range entries {
+		base := e.Name()
+		if !strings.HasPrefix(base, prefix) || (strings.HasPrefix(base, ".") && !strings.HasPrefix(prefix, ".")) {
+			continue
+		}
+		child := path.Join(name, base)
+		if !state.readable(child) || internalArtifact(child) {
+			continue
+		}
+		isDir := e.IsDir()
+		if e.Type()&fs.ModeSymlink != 0 {
+			if fi, err := state.FS.Stat(child); err == nil {
+				isDir = fi.IsDir()
+			}
+		}
+		switch {
+		case isDir:
+			out = append(out, dir+base+"/")
+		case kind == completeAny:
+			out = append(out, dir+base)
+		}
+	}
+	slices.Sort(out)
+	return out
+}
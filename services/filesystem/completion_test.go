@@ -0,0 +1,98 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+
+	"github.com/mark3labs/mcp-go/mcp"
+	"github.com/mark3labs/mcp-go/server"
+)
+
+func TestCompletePath(t *testing.T) {
+	root := t.TempDir()
+	mustWrite(t, filepath.Join(root, "src", "main.go"), []byte("package main\n"), 0o644)
+	mustWrite(t, filepath.Join(root, "src", "server.go"), []byte("package main\n"), 0o644)
+	mustWrite(t, filepath.Join(root, "secrets", "key.pem"), []byte("x"), 0o600)
+	mustWrite(t, filepath.Join(root, "setup.sh"), []byte("x"), 0o644)
+	mustWrite(t, filepath.Join(root, ".env"), []byte("x"), 0o644)
+	mustWrite(t, filepath.Join(root, "src", ".mcpfs-123"), []byte("x"), 0o644)
+	if err := os.Symlink("src", filepath.Join(root, "link")); err != nil {
+		t.Fatal(err)
+	}
+	_, sessions, _ := testSession(root)
+	state := sessions["s1"]
+	state.Policy = mustPolicy(t, false, "deny:read:secrets/**", "deny:read:secrets")
+
+	cases := []struct {
+		value string
+		kind  completeKind
+		want  []string
+	}{
+		{"s", completeAny, []string{"setup.sh", "src/"}},
+		{"s", completeDir, []string{"src/"}},
+		{"src/", completeAny, []string{"src/main.go", "src/server.go"}},
+		{"src/s", completeAny, []string{"src/server.go"}},
+		{"src/", completeDir, nil},
+		{".", completeAny, []string{".env"}},
+		{"l", completeDir, []string{"link/"}},
+		{"src/*/", completeAny, nil},
+		{"../", completeAny, nil},
+	}
+	for _, c := range cases {
+		if got := completePath(state, c.value, c.kind); !slices.Equal(got, c.want) {
+			t.Errorf("completePath(%q, %d) = %v, want %v", c.value, c.kind, got, c.want)
+		}
+	}
+}
+
+func TestCompletionRequests(t *testing.T) {
+	ctx, sessions, mu, mem := memSession()
+	for i := range maxCompletions + 10 {
+		memWrite(t, mem, fmt.Sprintf("logs/%03d.log", i), []byte("x"), 0o644)
+	}
+	srv := server.NewMCPServer("test", "1.0.0")
+	router := newRPCRouter(srv)
+	registerCompletions(router, sessions, mu)
+
+	complete := func(ref, arg, value string) mcp.JSONRPCMessage {
+		msg, ok := router.dispatch(ctx, []byte(fmt.Sprintf(`{"jsonrpc":"2.0","id":1,"method":"completion/complete","params":{"ref":%s,"argument":{"name":%q,"value":%q}}}`, ref, arg, value)))
+		if !ok {
+			t.Fatal("completion/complete not routed")
+		}
+		return msg
+	}
+	res := complete(`{"type":"ref/tool","name":"fs_read"}`, "path", "logs/").(mcp.JSONRPCResponse).Result.(mcp.CompleteResult)
+	if len(res.Completion.Values) != maxCompletions || res.Completion.Total != maxCompletions+10 || !res.Completion.HasMore {
+		t.Fatalf("capped = %d of %d more=%v", len(res.Completion.Values), res.Completion.Total, res.Completion.HasMore)
+	}
+	res = complete(`{"type":"ref/resource","uri":"file:///{+path}"}`, "path", "lo").(mcp.JSONRPCResponse).Result.(mcp.CompleteResult)
+	if !slices.Equal(res.Completion.Values, []string{"logs/"}) {
+		t.Fatalf("resource template = %v", res.Completion.Values)
+	}
+	// Arguments that are not paths complete to nothing
+	res = complete(`{"type":"ref/tool","name":"fs_read"}`, "encoding", "b").(mcp.JSONRPCResponse).Result.(mcp.CompleteResult)
+	if res.Completion.Values == nil || len(res.Completion.Values) != 0 {
+		t.Fatalf("non-path argument = %#v", res.Completion.Values)
+	}
+	if e, ok := complete(`{"type":"ref/bogus"}`, "path", "").(mcp.JSONRPCError); !ok || e.Error.Code != mcp.INVALID_PARAMS {
+		t.Fatal("unknown ref type accepted")
+	}
+
+	// initialize declares the capability alongside mcp-go's own
+	client := &notifySession{ch: make(chan mcp.JSONRPCNotification, 1)}
+	if err := srv.RegisterSession(ctx, client); err != nil {
+		t.Fatal(err)
+	}
+	msg, _ := router.dispatch(srv.WithContext(ctx, client), []byte(`{"jsonrpc":"2.0","id":1,"method":"initialize","params":{"protocolVersion":"2025-06-18","capabilities":{},"clientInfo":{"name":"t","version":"1"}}}`))
+	initRes, ok := msg.(mcp.JSONRPCResponse)
+	if !ok {
+		t.Fatalf("initialize = %+v", msg)
+	}
+	caps := initRes.Result.(map[string]any)["capabilities"].(map[string]any)
+	if _, ok := caps["completions"]; !ok {
+		t.Fatalf("capabilities = %v", caps)
+	}
+}
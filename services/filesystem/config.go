@@ -6,14 +6,19 @@ import (
 	"os"
 	"path/filepath"
 	"runtime"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
 )
 
 // Configuration constants with tunable defaults
 const (
 	// Size limits for operations
-	maxPeekBytesForSniff = 1 << 20  // 1 MiB for MIME/encoding detection
-	maxHashBytes         = 32 << 20 // 32 MiB hashing cap
-	maxFileSize          = 1 << 30  // 1 GiB maximum file size
+	maxPeekBytesForSniff = 1 << 20   // 1 MiB for MIME/encoding detection
+	maxHashBytes         = 32 << 20  // 32 MiB hashing cap
+	maxFileSize          = 1 << 30   // 1 GiB maximum file size
+	maxSearchFileBytes   = 100 << 20 // 100 MiB per searched file, decoded or not
 
 	// Default operation limits
 	defaultReadMaxBytes     = 64 * 1024 // 64 KiB
@@ -21,73 +26,344 @@ const (
 	defaultListMaxEntries   = 1000
 	defaultGlobMaxResults   = 1000
 	defaultSearchMaxResults = 100
+	defaultReadManyFiles    = 100
+	defaultReadManyBytes    = 1 << 20 // 1 MiB across a batch read
+	defaultTailLines        = 10
+	defaultChangesMax       = 1000
+	defaultResourcePageSize = 100 // resources per resources/list page
 
 	// Performance tuning
 	defaultWorkers     = 0 // 0 = auto-detect
 	maxWorkers         = 16
 	fileChannelBuffer  = 64
 	matchChannelBuffer = 128
+	hashCacheEntries   = 4096  // remembered file hashes
+	watchLogEntries    = 10000 // changes kept per session for fs_changes_since
 
 	// Timeouts
-	defaultLockTimeout = 3 // seconds
-	staleLockAge       = 5 // minutes
-)
+	defaultLockTimeout = 3       // seconds
+	maxTailWaitMs      = 60_000  // longest fs_tail follow wait
+	tailPollMs         = 100     // how often a follow checks for new data
+	watchCoalesceMs    = 100     // events on a path within this window merge
+	watchPollMs        = 500     // scan interval when native notifications are unavailable
+	maxChangesWaitMs   = 60_000  // longest fs_changes_since wait
+	defaultLeaseTTLMs  = 60_000  // fs_lock lease duration when none is given
+	maxLeaseTTLMs      = 600_000 // longest fs_lock lease
+	shutdownTimeout    = 10      // seconds HTTP transports wait for requests in flight on SIGTERM
+	readHeaderTimeout  = 10      // seconds allowed for an HTTP client to send request headers
 
-// Command-line flags
-var (
-	rootDirFlag     = flag.String("root", "", "filesystem base folder (defaults to the current working directory or $FS_ROOT)")
-	debugFlag       = flag.String("debug", "", "write debug logs to this file")
-	compatFlag      = flag.Bool("compat", false, "return tool results as plain text instead of JSON")
-	workersFlag     = flag.Int("workers", defaultWorkers, "number of worker threads (0=auto)")
-	maxSizeFlag     = flag.Int64("max-size", maxFileSize, "maximum file size in bytes")
-	lockTimeoutFlag = flag.Int("lock-timeout", defaultLockTimeout, "file lock timeout in seconds")
+	// HTTP transports
+	defaultListenAddr = "127.0.0.1:8080"
 )
 
-// ServerConfig holds server configuration
+// commandFlags holds the command-line flags. Each LoadConfig defines them on
+// a fresh flag set, so a reload starts again from the built-in defaults.
+type commandFlags struct {
+	config      string
+	root        string
+	debug       string
+	compat      bool
+	workers     int
+	maxSize     int64
+	lockTimeout int
+	readOnly    bool
+	memfs       bool
+	watchPoll   bool
+	pathRules   stringList
+
+	transport   string
+	listen      string
+	tlsCert     string
+	tlsKey      string
+	tlsClientCA string
+	authToken   string
+
+	auditLog        string
+	auditMaxSize    int64
+	auditMaxBackups int
+
+	historyMaxBytes int64
+	historyMaxAge   time.Duration
+}
+
+func defineFlags(fs *flag.FlagSet) *commandFlags {
+	f := &commandFlags{}
+	fs.StringVar(&f.config, "config", "", "read settings from this YAML file; FS_* environment variables and flags override it")
+	fs.StringVar(&f.root, "root", "", "filesystem base folder (defaults to the current working directory or $FS_ROOT)")
+	fs.StringVar(&f.debug, "debug", "", "write debug logs to this file")
+	fs.BoolVar(&f.compat, "compat", false, "return tool results as plain text instead of JSON")
+	fs.IntVar(&f.workers, "workers", defaultWorkers, "number of worker threads (0=auto)")
+	fs.Int64Var(&f.maxSize, "max-size", maxFileSize, "maximum file size in bytes")
+	fs.IntVar(&f.lockTimeout, "lock-timeout", defaultLockTimeout, "file lock timeout in seconds")
+	fs.BoolVar(&f.readOnly, "read-only", false, "reject every mutating operation")
+	fs.BoolVar(&f.memfs, "memfs", false, "serve an empty in-memory filesystem instead of the base folder")
+	fs.BoolVar(&f.watchPoll, "watch-poll", false, "detect changes for fs_watch by scanning instead of OS notifications")
+	fs.Var(&f.pathRules, "path-rule", "access rule allow|deny:read|write|any:pattern; repeatable, first match wins")
+
+	fs.StringVar(&f.transport, "transport", transportStdio, "transport to serve: stdio, sse or http (streamable HTTP)")
+	fs.StringVar(&f.listen, "listen", defaultListenAddr, "address the sse and http transports listen on")
+	fs.StringVar(&f.tlsCert, "tls-cert", "", "serve HTTPS with this certificate file")
+	fs.StringVar(&f.tlsKey, "tls-key", "", "private key file for --tls-cert")
+	fs.StringVar(&f.tlsClientCA, "tls-client-ca", "", "require client certificates signed by a CA in this file (mTLS)")
+	fs.StringVar(&f.authToken, "auth-token", "", "require this bearer token on HTTP requests")
+
+	fs.StringVar(&f.auditLog, "audit-log", "", "append a JSONL audit record of every mutating operation to this file")
+	fs.Int64Var(&f.auditMaxSize, "audit-max-size", defaultAuditMaxSize, "rotate the audit log when it exceeds this many bytes")
+	fs.IntVar(&f.auditMaxBackups, "audit-max-backups", defaultAuditMaxBackups, "number of rotated audit logs to keep")
+
+	fs.Int64Var(&f.historyMaxBytes, "history-max-bytes", defaultHistoryMaxBytes, "bytes of pre-images kept per session for fs_undo (0 disables undo)")
+	fs.DurationVar(&f.historyMaxAge, "history-max-age", defaultHistoryMaxAge, "discard undo history older than this")
+	return f
+}
+
+// stringList is a repeatable string flag
+type stringList []string
+
+func (l *stringList) String() string { return strings.Join(*l, ",") }
+
+func (l *stringList) Set(v string) error {
+	*l = append(*l, v)
+	return nil
+}
+
+// ServerConfig holds server configuration. LoadConfig builds it from
+// defaults, the --config file, FS_* environment variables and flags, and
+// handlers read it through currentConfig, so a reload reaches the next call.
 type ServerConfig struct {
+	File        string // config file, watched for changes; empty without one
 	Root        string
 	Debug       string
 	CompatMode  bool
 	Workers     int
 	MaxFileSize int64
 	LockTimeout int
+	Policy      *Policy // server-wide access policy, followed by sessions without their own
+
+	Memfs     bool
+	WatchPoll bool
+	Audit     AuditConfig
+	History   HistoryConfig
+	HTTP      httpOptions
+	Tools     map[string]ToolConfig // keyed by tool name; every tool has an entry
+}
+
+// AuditConfig configures the audit log
+type AuditConfig struct {
+	Log        string // empty disables auditing
+	MaxSize    int64
+	MaxBackups int
+}
+
+// HistoryConfig bounds the undo history of each session
+type HistoryConfig struct {
+	MaxBytes int64 // 0 disables undo
+	MaxAge   time.Duration
+}
+
+// ToolConfig holds the per-tool settings of the config file
+type ToolConfig struct {
+	Disabled bool `yaml:"disabled"` // calls fail with PERMISSION_DENIED
+	Default  int  `yaml:"default"`  // limit used when a call leaves its limit argument out
+	Max      int  `yaml:"max"`      // largest limit a call may ask for; 0 means no cap
+}
+
+// toolLimitArgs names, for every tool, the argument ToolConfig.Default and
+// Max apply to. Tools mapped to "" take no limit and can only be disabled.
+var toolLimitArgs = map[string]string{
+	"fs_read":               "max_bytes",
+	"fs_peek":               "max_bytes",
+	"fs_tail":               "max_bytes",
+	"fs_read_many":          "max_total_bytes",
+	"fs_write":              "",
+	"fs_write_begin":        "",
+	"fs_write_chunk":        "",
+	"fs_write_commit":       "",
+	"fs_write_abort":        "",
+	"fs_edit":               "",
+	"fs_list":               "max_entries",
+	"fs_stat":               "",
+	"fs_search":             "max_results",
+	"fs_glob":               "max_results",
+	"fs_watch":              "",
+	"fs_unwatch":            "",
+	"fs_changes_since":      "max_results",
+	"fs_lock":               "",
+	"fs_lock_renew":         "",
+	"fs_unlock":             "",
+	"fs_mkdir":              "",
+	"fs_rmdir":              "",
+	"fs_archive_create":     "",
+	"fs_archive_extract":    "",
+	"fs_undo":               "",
+	"fs_checkpoint":         "",
+	"fs_restore_checkpoint": "",
+	"fs_overlay_diff":       "",
+	"fs_overlay_commit":     "",
+	"fs_overlay_discard":    "",
+	"fs_audit_query":        "limit",
+	"createsession":         "",
+	"switchsession":         "",
+	"listsessions":          "",
+	"debuggingapproach":     "",
+	"pendingdebug":          "",
+}
+
+// defaultToolLimits are the built-in defaults of the limited tools
+var defaultToolLimits = map[string]int{
+	"fs_read":          defaultReadMaxBytes,
+	"fs_peek":          defaultPeekMaxBytes,
+	"fs_tail":          defaultReadMaxBytes,
+	"fs_read_many":     defaultReadManyBytes,
+	"fs_list":          defaultListMaxEntries,
+	"fs_search":        defaultSearchMaxResults,
+	"fs_glob":          defaultGlobMaxResults,
+	"fs_changes_since": defaultChangesMax,
+	"fs_audit_query":   defaultAuditQueryLimit,
+}
+
+// defaultToolConfigs returns the built-in settings of every tool
+func defaultToolConfigs() map[string]ToolConfig {
+	tools := make(map[string]ToolConfig, len(toolLimitArgs))
+	for name := range toolLimitArgs {
+		tools[name] = ToolConfig{Default: defaultToolLimits[name]}
+	}
+	return tools
+}
+
+// defaultWorkerCount is the worker count used when --workers is 0
+func defaultWorkerCount() int {
+	return min(runtime.NumCPU(), maxWorkers)
+}
+
+// newDefaultConfig returns the configuration used before LoadConfig runs,
+// which is what tests see
+func newDefaultConfig() *ServerConfig {
+	return &ServerConfig{
+		Workers:     defaultWorkerCount(),
+		MaxFileSize: maxFileSize,
+		LockTimeout: defaultLockTimeout,
+		Audit:       AuditConfig{MaxSize: defaultAuditMaxSize, MaxBackups: defaultAuditMaxBackups},
+		History:     HistoryConfig{MaxBytes: defaultHistoryMaxBytes, MaxAge: defaultHistoryMaxAge},
+		HTTP:        httpOptions{Transport: transportStdio, Addr: defaultListenAddr},
+		Tools:       defaultToolConfigs(),
+	}
+}
+
+var (
+	activeConfig  atomic.Pointer[ServerConfig]
+	defaultConfig = sync.OnceValue(newDefaultConfig)
+)
+
+// currentConfig returns the configuration in effect
+func currentConfig() *ServerConfig {
+	if c := activeConfig.Load(); c != nil {
+		return c
+	}
+	return defaultConfig()
+}
+
+// setConfig makes c the configuration in effect
+func setConfig(c *ServerConfig) {
+	activeConfig.Store(c)
 }
 
-// LoadConfig loads configuration from flags and environment
+// LoadConfig loads configuration from flags, environment and the config file
 func LoadConfig() (*ServerConfig, error) {
-	flag.Parse()
+	return loadConfig(os.Args[1:], os.Getenv)
+}
+
+// ReloadConfig loads the configuration again for a running server
+func ReloadConfig() (*ServerConfig, error) {
+	return reloadConfig(os.Args[1:], os.Getenv)
+}
+
+// loadConfig builds the configuration from the command-line arguments, the
+// environment as seen through getenv and the config file they name
+func loadConfig(args []string, getenv func(string) string) (*ServerConfig, error) {
+	return readConfig(args, getenv, true)
+}
+
+// reloadConfig is loadConfig for a running server. Settings read only at
+// startup are taken as given rather than validated: a reload keeps their
+// running values and only reports that they changed, so a root or transport
+// that would be rejected must not fail it.
+func reloadConfig(args []string, getenv func(string) string) (*ServerConfig, error) {
+	return readConfig(args, getenv, false)
+}
+
+func readConfig(args []string, getenv func(string) string, startup bool) (*ServerConfig, error) {
+	fs := flag.NewFlagSet(os.Args[0], flag.ExitOnError)
+	f := defineFlags(fs)
+	_ = fs.Parse(args) // ExitOnError: a bad command line never returns
+	path := f.config
+	if path == "" {
+		path = getenv(envName("config"))
+	}
+	file, err := readConfigFile(path)
+	if err != nil {
+		return nil, err
+	}
+	if err := file.apply(fs, getenv); err != nil {
+		return nil, err
+	}
 
 	// Determine base folder
-	root, err := getRoot()
+	root, err := getRoot(f.root)
 	if err != nil {
-		return nil, fmt.Errorf("failed to determine base folder: %w", err)
+		if startup {
+			return nil, fmt.Errorf("failed to determine base folder: %w", err)
+		}
+		root = f.root
 	}
 
-	// Validate base folder
-	if err := validateRoot(root); err != nil {
-		return nil, err
+	// Validate base folder; archives and the in-memory backend need no folder
+	if startup && !f.memfs && archiveFormat(root) == "" {
+		if err := validateRoot(root); err != nil {
+			return nil, err
+		}
 	}
 
 	// Determine worker count
-	workers := *workersFlag
+	workers := f.workers
 	if workers <= 0 {
-		workers = runtime.NumCPU()
-		if workers > maxWorkers {
-			workers = maxWorkers
-		}
+		workers = defaultWorkerCount()
+	}
+
+	policy, err := newPolicy(f.readOnly, f.pathRules)
+	if err != nil {
+		return nil, err
 	}
 
 	config := &ServerConfig{
+		File:        path,
 		Root:        root,
-		Debug:       *debugFlag,
-		CompatMode:  *compatFlag,
+		Debug:       f.debug,
+		CompatMode:  f.compat,
 		Workers:     workers,
-		MaxFileSize: *maxSizeFlag,
-		LockTimeout: *lockTimeoutFlag,
+		MaxFileSize: f.maxSize,
+		LockTimeout: f.lockTimeout,
+		Policy:      policy,
+		Memfs:       f.memfs,
+		WatchPoll:   f.watchPoll,
+		Audit:       AuditConfig{Log: f.auditLog, MaxSize: f.auditMaxSize, MaxBackups: f.auditMaxBackups},
+		History:     HistoryConfig{MaxBytes: f.historyMaxBytes, MaxAge: f.historyMaxAge},
+		HTTP: httpOptions{
+			Transport:    f.transport,
+			Addr:         f.listen,
+			CertFile:     f.tlsCert,
+			KeyFile:      f.tlsKey,
+			ClientCAFile: f.tlsClientCA,
+			Token:        f.authToken,
+		},
+		Tools: file.Tools,
 	}
 
 	// Validate configuration
-	if err := config.Validate(); err != nil {
+	validate := config.Validate
+	if !startup {
+		validate = config.validateReloadable
+	}
+	if err := validate(); err != nil {
 		return nil, err
 	}
 
@@ -96,10 +372,22 @@ func LoadConfig() (*ServerConfig, error) {
 
 // Validate checks configuration validity
 func (c *ServerConfig) Validate() error {
-	if c.Root == "" {
+	if c.Root == "" && !c.Memfs {
 		return fmt.Errorf("base folder is required")
 	}
 
+	switch c.HTTP.Transport {
+	case transportStdio, transportSSE, transportHTTP:
+	default:
+		return fmt.Errorf("unknown transport %q: expected stdio, sse or http", c.HTTP.Transport)
+	}
+
+	return c.validateReloadable()
+}
+
+// validateReloadable checks the settings a reload applies to the running
+// server
+func (c *ServerConfig) validateReloadable() error {
 	if c.Workers < 1 || c.Workers > maxWorkers {
 		return fmt.Errorf("workers must be between 1 and %d", maxWorkers)
 	}
@@ -112,18 +400,53 @@ func (c *ServerConfig) Validate() error {
 		return fmt.Errorf("lock timeout must be at least 1 second")
 	}
 
+	if c.History.MaxBytes < 0 || c.History.MaxAge < 0 {
+		return fmt.Errorf("history limits must not be negative")
+	}
+
+	for name, tc := range c.Tools {
+		arg, ok := toolLimitArgs[name]
+		switch {
+		case !ok:
+			return fmt.Errorf("tools: unknown tool %q", name)
+		case tc.Default < 0 || tc.Max < 0:
+			return fmt.Errorf("tools.%s: limits must not be negative", name)
+		case arg == "" && (tc.Default != 0 || tc.Max != 0):
+			return fmt.Errorf("tools.%s: tool takes no limit; only disabled applies", name)
+		case tc.Max > 0 && tc.Default > tc.Max:
+			return fmt.Errorf("tools.%s: default %d exceeds max %d", name, tc.Default, tc.Max)
+		}
+	}
+
 	return nil
 }
 
-// getRoot determines the base folder from flags/env/cwd
-func getRoot() (string, error) {
+// toolLimit returns the limit a call to tool gets when it asks for
+// requested, which is 0 when the call leaves the argument out
+func (c *ServerConfig) toolLimit(tool string, requested int) int {
+	tc := c.Tools[tool]
+	n := requested
+	if n <= 0 {
+		n = tc.Default
+	}
+	if tc.Max > 0 && n > tc.Max {
+		n = tc.Max
+	}
+	return n
+}
+
+// lockTimeout returns the configured lock timeout
+func (c *ServerConfig) lockTimeout() time.Duration {
+	return time.Duration(c.LockTimeout) * time.Second
+}
+
+// getRoot determines the base folder from the --root setting, which may
+// come from $FS_ROOT or the config file, or else the working directory
+func getRoot(rootFlag string) (string, error) {
 	var base string
 
-	// Priority: environment variable > flag > current directory
-	if env := os.Getenv("FS_ROOT"); env != "" {
-		base = mustAbs(env)
-	} else if *rootDirFlag != "" {
-		base = mustAbs(*rootDirFlag)
+	if rootFlag != "" {
+		base = mustAbs(rootFlag)
 	} else {
 		cwd, err := os.Getwd()
 		if err != nil {
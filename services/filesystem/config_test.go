@@ -0,0 +1,225 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io/fs"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/mark3labs/mcp-go/mcp"
+)
+
+func TestConfigFileParse(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "fs.yaml")
+	mustWrite(t, path, []byte(`
+max-size: 2048
+read-only: true
+path-rule:
+  - deny:write:.git/**
+  - deny:read:**/.env
+tools:
+  fs_read: {max: 4096}
+  fs_rmdir:
+    disabled: true
+`), 0o644)
+	cf, err := readConfigFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := cf.Settings["max-size"]; len(got) != 1 || got[0] != "2048" {
+		t.Errorf("max-size = %v", got)
+	}
+	if got := cf.Settings["path-rule"]; len(got) != 2 {
+		t.Errorf("path-rule = %v", got)
+	}
+	if got := cf.Tools["fs_read"]; got.Max != 4096 || got.Default != defaultReadMaxBytes {
+		t.Errorf("fs_read = %+v", got)
+	}
+	if !cf.Tools["fs_rmdir"].Disabled || cf.Tools["fs_list"].Default != defaultListMaxEntries {
+		t.Errorf("tools = %+v", cf.Tools)
+	}
+
+	for _, bad := range []string{
+		"tools:\n  fs_read: {maximum: 1}\n",
+		"tools:\n  fs_read: {max: lots}\n",
+		"audit:\n  log: x\n",
+		"max-size: [1: 2]\n",
+		"{",
+	} {
+		if err := (&configFile{Settings: map[string][]string{}, Tools: defaultToolConfigs()}).parse([]byte(bad)); err == nil {
+			t.Errorf("parse(%q) accepted", bad)
+		}
+	}
+}
+
+func TestConfigPrecedence(t *testing.T) {
+	newFlags := func(args ...string) (*flag.FlagSet, *int64, *stringList) {
+		fs := flag.NewFlagSet("test", flag.ContinueOnError)
+		size := fs.Int64("max-size", maxFileSize, "")
+		var rules stringList
+		fs.Var(&rules, "path-rule", "")
+		if err := fs.Parse(args); err != nil {
+			t.Fatal(err)
+		}
+		return fs, size, &rules
+	}
+	file := &configFile{Settings: map[string][]string{
+		"max-size":  {"1000"},
+		"path-rule": {"deny:read:a", "deny:read:b"},
+	}}
+	env := func(vars map[string]string) func(string) string {
+		return func(k string) string { return vars[k] }
+	}
+
+	cases := []struct {
+		args []string
+		env  map[string]string
+		size int64
+	}{
+		{nil, nil, 1000},
+		{nil, map[string]string{"FS_MAX_SIZE": "2000"}, 2000},
+		{[]string{"--max-size", "3000"}, map[string]string{"FS_MAX_SIZE": "2000"}, 3000},
+	}
+	for _, c := range cases {
+		fs, size, rules := newFlags(c.args...)
+		if err := file.apply(fs, env(c.env)); err != nil {
+			t.Fatal(err)
+		}
+		if *size != c.size || len(*rules) != 2 {
+			t.Errorf("args=%v env=%v: max-size=%d rules=%v", c.args, c.env, *size, *rules)
+		}
+	}
+
+	// The environment replaces a repeatable setting instead of adding to it
+	fs, _, rules := newFlags()
+	if err := file.apply(fs, env(map[string]string{"FS_PATH_RULE": "deny:any:c"})); err != nil {
+		t.Fatal(err)
+	}
+	if len(*rules) != 1 || (*rules)[0] != "deny:any:c" {
+		t.Errorf("rules = %v", *rules)
+	}
+
+	for _, settings := range []map[string][]string{{"max-sise": {"1"}}, {"max-size": {"big"}}, {"config": {"other.yaml"}}} {
+		fs, _, _ := newFlags()
+		if err := (&configFile{Settings: settings}).apply(fs, env(nil)); err == nil {
+			t.Errorf("%v accepted", settings)
+		}
+	}
+	fs, _, _ = newFlags()
+	if err := (&configFile{}).apply(fs, env(map[string]string{"FS_MAX_SIZE": "big"})); err == nil || !strings.Contains(err.Error(), "FS_MAX_SIZE") {
+		t.Errorf("bad environment value: %v", err)
+	}
+}
+
+func TestConfigValidate(t *testing.T) {
+	if err := newDefaultConfig().Validate(); err == nil {
+		t.Fatal("config without root accepted")
+	}
+	cases := map[string]func(c *ServerConfig){
+		"valid":          func(c *ServerConfig) {},
+		"workers":        func(c *ServerConfig) { c.Workers = maxWorkers + 1 },
+		"max size":       func(c *ServerConfig) { c.MaxFileSize = 10 },
+		"lock timeout":   func(c *ServerConfig) { c.LockTimeout = 0 },
+		"transport":      func(c *ServerConfig) { c.HTTP.Transport = "grpc" },
+		"unknown tool":   func(c *ServerConfig) { c.Tools["fs_frobnicate"] = ToolConfig{} },
+		"default > max":  func(c *ServerConfig) { c.Tools["fs_read"] = ToolConfig{Default: 10, Max: 5} },
+		"no limit arg":   func(c *ServerConfig) { c.Tools["fs_stat"] = ToolConfig{Max: 5} },
+		"negative limit": func(c *ServerConfig) { c.Tools["fs_list"] = ToolConfig{Default: -1} },
+	}
+	for name, edit := range cases {
+		c := newDefaultConfig()
+		c.Root = t.TempDir()
+		edit(c)
+		if err := c.Validate(); (err == nil) != (name == "valid") {
+			t.Errorf("%s: Validate() = %v", name, err)
+		}
+	}
+}
+
+func TestToolLimitArgsCoverTools(t *testing.T) {
+	tools := listTools(t)
+	if len(tools) != len(toolLimitArgs) {
+		t.Errorf("%d tools registered, %d in toolLimitArgs", len(tools), len(toolLimitArgs))
+	}
+	for _, tool := range tools {
+		arg, ok := toolLimitArgs[tool.Name]
+		if !ok {
+			t.Errorf("%s: missing from toolLimitArgs", tool.Name)
+			continue
+		}
+		if (arg != "") != (defaultToolLimits[tool.Name] > 0) {
+			t.Errorf("%s: limit argument %q without a default, or the reverse", tool.Name, arg)
+		}
+		if arg == "" {
+			continue
+		}
+		var s objectSchema
+		if err := json.Unmarshal(tool.RawInputSchema, &s); err != nil {
+			t.Fatal(err)
+		}
+		if _, ok := s.Properties[arg]; !ok {
+			t.Errorf("%s: no argument %s", tool.Name, arg)
+		}
+	}
+}
+
+func TestToolConfigApplied(t *testing.T) {
+	withConfig(t, func(c *ServerConfig) {
+		c.Tools["fs_list"] = ToolConfig{Default: 2, Max: 3}
+		c.Tools["fs_rmdir"] = ToolConfig{Disabled: true}
+	})
+	backend := newMemBackend()
+	for i := range 5 {
+		if err := backend.WriteFile(fmt.Sprintf("f%d.txt", i), []byte("x"), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := backend.MkdirAll("d", 0o755); err != nil {
+		t.Fatal(err)
+	}
+	srv, _ := setupServer(backend)
+	ctx := withSessionManager(context.Background(), &sessionManager{id: "default"})
+	call := func(name, args string) mcp.CallToolResult {
+		t.Helper()
+		msg := srv.HandleMessage(ctx, []byte(`{"jsonrpc":"2.0","id":1,"method":"tools/call","params":{"name":"`+name+`","arguments":`+args+`}}`))
+		resp, ok := msg.(mcp.JSONRPCResponse)
+		if !ok {
+			t.Fatalf("%s = %+v", name, msg)
+		}
+		return resp.Result.(mcp.CallToolResult)
+	}
+	entries := func(args string) int {
+		t.Helper()
+		b, _ := json.Marshal(call("fs_list", args).StructuredContent)
+		var r ListResult
+		if err := json.Unmarshal(b, &r); err != nil {
+			t.Fatal(err)
+		}
+		return len(r.Entries)
+	}
+	if n := entries(`{"path":""}`); n != 2 {
+		t.Errorf("default limit: %d entries", n)
+	}
+	if n := entries(`{"path":"","max_entries":100}`); n != 3 {
+		t.Errorf("capped limit: %d entries", n)
+	}
+	res := call("fs_rmdir", `{"path":"d"}`)
+	b, _ := json.Marshal(res.StructuredContent)
+	if !res.IsError || !strings.Contains(string(b), "PERMISSION_DENIED") {
+		t.Errorf("disabled tool = %s", b)
+	}
+	if _, err := backend.Stat("d"); err != nil {
+		t.Errorf("disabled fs_rmdir removed d: %v", err)
+	}
+}
+
+func TestLoadConfigFileMissing(t *testing.T) {
+	if _, err := readConfigFile(filepath.Join(t.TempDir(), "none.yaml")); !errors.Is(err, fs.ErrNotExist) {
+		t.Fatalf("missing file: %v", err)
+	}
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// configFile is a parsed --config file. Top-level keys are flag names and
+// give defaults for those flags; the tools key holds per-tool settings:
+//
+//	max-size: 10485760
+//	path-rule: ["deny:write:.git/**"]
+//	tools:
+//	  fs_read: {default: 65536, max: 1048576}
+//	  fs_rmdir: {disabled: true}
+type configFile struct {
+	Settings map[string][]string   // flag name -> values; repeatable flags may have several
+	Tools    map[string]ToolConfig // built-in settings overridden by the file
+}
+
+// readConfigFile parses the config file at path. An empty path yields the
+// built-in settings.
+func readConfigFile(path string) (*configFile, error) {
+	cf := &configFile{Settings: map[string][]string{}, Tools: defaultToolConfigs()}
+	if path == "" {
+		return cf, nil
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("read config: %w", err)
+	}
+	if err := cf.parse(data); err != nil {
+		return nil, fmt.Errorf("config %s: %w", path, err)
+	}
+	return cf, nil
+}
+
+func (cf *configFile) parse(data []byte) error {
+	var doc map[string]yaml.Node
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return err
+	}
+	for key, node := range doc {
+		if key == "tools" {
+			if err := cf.parseTools(&node); err != nil {
+				return err
+			}
+			continue
+		}
+		switch node.Kind {
+		case yaml.ScalarNode:
+			cf.Settings[key] = []string{node.Value}
+		case yaml.SequenceNode:
+			for _, item := range node.Content {
+				if item.Kind != yaml.ScalarNode {
+					return fmt.Errorf("line %d: %s: expected a list of values", item.Line, key)
+				}
+				cf.Settings[key] = append(cf.Settings[key], item.Value)
+			}
+		default:
+			return fmt.Errorf("line %d: %s: expected a value or a list", node.Line, key)
+		}
+	}
+	return nil
+}
+
+// parseTools layers the tools section over the built-in tool settings, so
+// a tool entry only needs the fields it changes
+func (cf *configFile) parseTools(node *yaml.Node) error {
+	var tools map[string]yaml.Node
+	if err := node.Decode(&tools); err != nil {
+		return fmt.Errorf("tools: %w", err)
+	}
+	for name, n := range tools {
+		var keys map[string]any
+		if err := n.Decode(&keys); err != nil {
+			return fmt.Errorf("line %d: tools.%s: %w", n.Line, name, err)
+		}
+		for k := range keys {
+			switch k {
+			case "disabled", "default", "max":
+			default:
+				return fmt.Errorf("line %d: tools.%s: unknown setting %q", n.Line, name, k)
+			}
+		}
+		tc := cf.Tools[name]
+		if err := n.Decode(&tc); err != nil {
+			return fmt.Errorf("line %d: tools.%s: %w", n.Line, name, err)
+		}
+		cf.Tools[name] = tc
+	}
+	return nil
+}
+
+// apply sets the flags of fs that were not given on the command line, from
+// FS_* environment variables first and the file second, so flags override
+// the environment and the environment overrides the file
+func (cf *configFile) apply(fs *flag.FlagSet, getenv func(string) string) error {
+	set := map[string]bool{}
+	fs.Visit(func(f *flag.Flag) { set[f.Name] = true })
+	var errs []error
+	fs.VisitAll(func(f *flag.Flag) {
+		if set[f.Name] {
+			return
+		}
+		if v := getenv(envName(f.Name)); v != "" {
+			if err := fs.Set(f.Name, v); err != nil {
+				errs = append(errs, fmt.Errorf("%s: %w", envName(f.Name), err))
+			}
+			set[f.Name] = true
+		}
+	})
+	for name, values := range cf.Settings {
+		if fs.Lookup(name) == nil || name == "config" {
+			errs = append(errs, fmt.Errorf("config: unknown setting %q", name))
+			continue
+		}
+		if set[name] {
+			continue
+		}
+		for _, v := range values {
+			if err := fs.Set(name, v); err != nil {
+				errs = append(errs, fmt.Errorf("config: %s: %w", name, err))
+			}
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// envName returns the environment variable for the flag name, such as
+// FS_MAX_SIZE for max-size
+func envName(flagName string) string {
+	return "FS_" + strings.ToUpper(strings.ReplaceAll(flagName, "-", "_"))
+}
@@ -13,11 +13,11 @@ var (
 	debugLog     *log.Logger
 )
 
-func initDebug() {
-	if *debugFlag == "" {
+func initDebug(path string) {
+	if path == "" {
 		return
 	}
-	f, err := os.Create(*debugFlag)
+	f, err := os.Create(path)
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "failed to open log file: %v\n", err)
 		return
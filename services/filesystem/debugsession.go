@@ -27,9 +27,9 @@ var (
 
 // DebuggingApproachArgs are inputs for the debuggingapproach tool.
 type DebuggingApproachArgs struct {
-	SessionID  string `json:"session"`
-	Approach   string `json:"approach"`
-	Resolution string `json:"resolution"`
+	SessionID  string `json:"session,omitempty" description:"Existing session identifier"`
+	Approach   string `json:"approach" description:"Debugging steps taken"`
+	Resolution string `json:"resolution,omitempty" description:"Outcome or fix applied"`
 }
 
 // DebuggingApproachResult is returned from the debuggingapproach tool.
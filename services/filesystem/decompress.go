@@ -0,0 +1,120 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"compress/bzip2"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"strings"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/ulikunitz/xz"
+)
+
+// compressionMagic lists the stream signatures recognised by decompressStream
+var compressionMagic = []struct {
+	format string
+	magic  []byte
+}{
+	{"gzip", []byte{0x1f, 0x8b}},
+	{"bzip2", []byte("BZh")},
+	{"xz", []byte{0xfd, '7', 'z', 'X', 'Z', 0x00}},
+	{"zstd", []byte{0x28, 0xb5, 0x2f, 0xfd}},
+}
+
+// compressedExts maps file extensions to the format they usually hold
+var compressedExts = map[string]string{
+	".gz":  "gzip",
+	".tgz": "gzip",
+	".bz2": "bzip2",
+	".xz":  "xz",
+	".zst": "zstd",
+}
+
+// compressionByExt returns the compression format suggested by name
+func compressionByExt(name string) string {
+	return compressedExts[strings.ToLower(path.Ext(name))]
+}
+
+// innerName strips a compression extension, so app.log.gz becomes app.log
+func innerName(name string) string {
+	if compressionByExt(name) == "" {
+		return name
+	}
+	inner := strings.TrimSuffix(name, path.Ext(name))
+	if strings.EqualFold(path.Ext(name), ".tgz") {
+		inner += ".tar"
+	}
+	return inner
+}
+
+// decompressStream sniffs r and, when it starts with a known signature,
+// returns a reader of the decoded content and the format name. Other
+// content is passed through with an empty format.
+func decompressStream(r io.Reader) (io.ReadCloser, string, error) {
+	br := bufio.NewReader(r)
+	head, _ := br.Peek(6)
+	for _, c := range compressionMagic {
+		if !bytes.HasPrefix(head, c.magic) {
+			continue
+		}
+		switch c.format {
+		case "gzip":
+			zr, err := gzip.NewReader(br)
+			if err != nil {
+				return nil, "", fmt.Errorf("invalid gzip stream: %w", err)
+			}
+			return zr, c.format, nil
+		case "bzip2":
+			return io.NopCloser(bzip2.NewReader(br)), c.format, nil
+		case "xz":
+			zr, err := xz.NewReader(br)
+			if err != nil {
+				return nil, "", fmt.Errorf("invalid xz stream: %w", err)
+			}
+			return io.NopCloser(zr), c.format, nil
+		case "zstd":
+			zr, err := zstd.NewReader(br)
+			if err != nil {
+				return nil, "", fmt.Errorf("invalid zstd stream: %w", err)
+			}
+			return zr.IOReadCloser(), c.format, nil
+		}
+	}
+	return io.NopCloser(br), "", nil
+}
+
+// readDecompressed skips offset decoded bytes and returns up to max more,
+// reporting whether the decoded stream ends within the window. Skipping is
+// bounded by --max-size so a crafted stream cannot spin forever.
+func readDecompressed(r io.Reader, offset, max int64) ([]byte, string, bool, error) {
+	dec, format, err := decompressStream(r)
+	if err != nil {
+		return nil, "", false, err
+	}
+	defer dec.Close()
+	if offset > 0 {
+		if offset > currentConfig().MaxFileSize {
+			return nil, format, false, ErrFileTooLarge
+		}
+		n, err := io.CopyN(io.Discard, dec, offset)
+		if err != nil && !errors.Is(err, io.EOF) {
+			return nil, format, false, err
+		}
+		if n < offset {
+			return []byte{}, format, true, nil
+		}
+	}
+	buf, err := io.ReadAll(io.LimitReader(dec, max+1))
+	if err != nil {
+		return nil, format, false, err
+	}
+	if int64(len(buf)) > max {
+		return buf[:max], format, false, nil
+	}
+	return buf, format, true, nil
+}
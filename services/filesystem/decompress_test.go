@@ -0,0 +1,144 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"strings"
+	"testing"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/mark3labs/mcp-go/mcp"
+	"github.com/ulikunitz/xz"
+)
+
+const compressedText = "hello needle\nsecond line\n"
+
+// bzip2Text is compressedText run through bzip2 -9; the standard library
+// only decodes bzip2
+var bzip2Text = []byte{
+	0x42, 0x5a, 0x68, 0x39, 0x31, 0x41, 0x59, 0x26, 0x53, 0x59, 0xb6, 0x84,
+	0x38, 0x07, 0x00, 0x00, 0x06, 0xd1, 0x80, 0x00, 0x10, 0x40, 0x00, 0x0e,
+	0x65, 0x88, 0x00, 0x20, 0x00, 0x22, 0x99, 0x94, 0xda, 0x65, 0x08, 0x06,
+	0x9a, 0x68, 0x3c, 0x2e, 0xdd, 0x53, 0x80, 0x35, 0xe5, 0xb8, 0x36, 0x11,
+	0x17, 0xc5, 0xdc, 0x91, 0x4e, 0x14, 0x24, 0x2d, 0xa1, 0x0e, 0x01, 0xc0,
+}
+
+func compressedFiles(t *testing.T, b Backend) map[string]string {
+	t.Helper()
+	var gz bytes.Buffer
+	gw := gzip.NewWriter(&gz)
+	gw.Write([]byte(compressedText))
+	gw.Close()
+
+	var xzb bytes.Buffer
+	xw, err := xz.NewWriter(&xzb)
+	if err != nil {
+		t.Fatal(err)
+	}
+	xw.Write([]byte(compressedText))
+	xw.Close()
+
+	var zst bytes.Buffer
+	zw, err := zstd.NewWriter(&zst)
+	if err != nil {
+		t.Fatal(err)
+	}
+	zw.Write([]byte(compressedText))
+	zw.Close()
+
+	files := map[string][]byte{
+		"app.txt.gz":  gz.Bytes(),
+		"app.txt.bz2": bzip2Text,
+		"app.txt.xz":  xzb.Bytes(),
+		"app.txt.zst": zst.Bytes(),
+	}
+	formats := map[string]string{}
+	for name, data := range files {
+		memWrite(t, b, "logs/"+name, data, 0o644)
+		formats[name] = compressionByExt(name)
+	}
+	return formats
+}
+
+func TestReadAndPeekDecompress(t *testing.T) {
+	ctx, sessions, mu, mem := memSession()
+	formats := compressedFiles(t, mem)
+	req := mcp.CallToolRequest{}
+	for name, format := range formats {
+		p := "logs/" + name
+		res, err := handleRead(sessions, mu)(ctx, req, ReadArgs{Path: p, Decompress: true})
+		if err != nil {
+			t.Fatalf("%s: read: %v", name, err)
+		}
+		if res.Content != compressedText || res.Compression != format || res.Truncated {
+			t.Fatalf("%s: read = %+v", name, res)
+		}
+		if !strings.HasPrefix(res.MIMEType, "text/plain") {
+			t.Fatalf("%s: mime = %s", name, res.MIMEType)
+		}
+
+		res, err = handleRead(sessions, mu)(ctx, req, ReadArgs{Path: p, Decompress: true, MaxBytes: 5})
+		if err != nil || res.Content != "hello" || !res.Truncated {
+			t.Fatalf("%s: limited read = %+v %v", name, res, err)
+		}
+
+		pr, err := handlePeek(sessions, mu)(ctx, req, PeekArgs{Path: p, Decompress: true, Offset: 6, MaxBytes: 6})
+		if err != nil || pr.Content != "needle" || pr.EOF || pr.Compression != format {
+			t.Fatalf("%s: peek = %+v %v", name, pr, err)
+		}
+		pr, err = handlePeek(sessions, mu)(ctx, req, PeekArgs{Path: p, Decompress: true, Offset: 13, MaxBytes: 100})
+		if err != nil || pr.Content != "second line\n" || !pr.EOF {
+			t.Fatalf("%s: tail peek = %+v %v", name, pr, err)
+		}
+	}
+
+	// Plain files are returned unchanged
+	memWrite(t, mem, "plain.txt", []byte("plain"), 0o644)
+	res, err := handleRead(sessions, mu)(ctx, req, ReadArgs{Path: "plain.txt", Decompress: true})
+	if err != nil || res.Content != "plain" || res.Compression != "" {
+		t.Fatalf("plain read = %+v %v", res, err)
+	}
+}
+
+func TestSearchDecompress(t *testing.T) {
+	ctx, sessions, mu, mem := memSession()
+	formats := compressedFiles(t, mem)
+	req := mcp.CallToolRequest{}
+
+	res, err := handleSearch(sessions, mu)(ctx, req, SearchArgs{Pattern: "needle", Decompress: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(res.Matches) != len(formats) {
+		t.Fatalf("matches = %+v", res.Matches)
+	}
+	for _, m := range res.Matches {
+		if m.Line != 1 || m.Text != "hello needle" {
+			t.Fatalf("match = %+v", m)
+		}
+	}
+
+	res, err = handleSearch(sessions, mu)(ctx, req, SearchArgs{Pattern: "needle"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, m := range res.Matches {
+		if m.Text == "hello needle" {
+			t.Fatalf("decoded match without decompress: %+v", m)
+		}
+	}
+}
+
+func TestInnerName(t *testing.T) {
+	cases := map[string]string{
+		"a.log.gz":   "a.log",
+		"a.json.zst": "a.json",
+		"b.tgz":      "b.tar",
+		"c.txt":      "c.txt",
+	}
+	for in, want := range cases {
+		if got := innerName(in); got != want {
+			t.Errorf("innerName(%q) = %q; want %q", in, got, want)
+		}
+	}
+}
@@ -23,19 +23,22 @@ func handleEdit(sessions map[string]*SessionState, mu *sync.RWMutex) mcp.Structu
 		if err != nil {
 			return EditResult{}, err
 		}
-		root := state.Root
 		start := time.Now()
 		dprintf("%s -> fs_edit path=%q regex=%v count=%d", sessionContext(ctx), args.Path, args.Regex, args.Count)
 		var res EditResult
 		if args.Path == "" || args.Pattern == "" {
 			return res, errors.New("path and pattern required")
 		}
-		full, err := safeJoin(root, args.Path)
+		name, err := state.FS.Resolve(args.Path, false)
 		if err != nil {
 			dprintf("fs_edit error: %v", err)
 			return res, err
 		}
-		fi, err := os.Lstat(full)
+		if err := checkAccess(ctx, state, "edit", capWrite, args.Path, name); err != nil {
+			dprintf("fs_edit error: %v", err)
+			return res, err
+		}
+		fi, err := state.FS.Lstat(name)
 		if err != nil {
 			dprintf("fs_edit error: %v", err)
 			return res, err
@@ -47,18 +50,38 @@ func handleEdit(sessions map[string]*SessionState, mu *sync.RWMutex) mcp.Structu
 			return res, fmt.Errorf("target not a regular file: %s", args.Path)
 		}
 
-		release, err := acquireLock(full, 3*time.Second)
+		release, err := state.FS.Lock(name, lockExclusive, currentConfig().lockTimeout())
 		if err != nil {
 			dprintf("fs_edit lock error: %v", err)
 			return res, err
 		}
 		defer release()
+		if err := checkLease(ctx, state, "edit", args.Path, name); err != nil {
+			dprintf("fs_edit error: %v", err)
+			return res, err
+		}
+		defer snapshotHistory(ctx)()
+		defer digestAudit(ctx)()
 
-		b, err := os.ReadFile(full)
+		b, err := readBackendFile(state.FS, name)
 		if err != nil {
 			dprintf("fs_edit read error: %v", err)
 			return res, err
 		}
+		// Edits work on UTF-8 text and are stored back in the file's own format
+		existing, found := detectTextFormat(b)
+		tf, err := targetTextFormat(existing, found, args.Charset, args.BOM, args.Newline)
+		if err != nil {
+			return res, err
+		}
+		if found && !existing.plain() {
+			text, err := decodeText(b, existing)
+			if err != nil {
+				dprintf("fs_edit decode error: %v", err)
+				return res, err
+			}
+			b = []byte(text)
+		}
 		var re *regexp.Regexp
 		if args.Regex {
 			re, err = regexp.Compile(args.Pattern)
@@ -102,19 +125,34 @@ func handleEdit(sessions map[string]*SessionState, mu *sync.RWMutex) mcp.Structu
 				}
 			}
 		}
+		if args.EnsureFinalNewline {
+			out = []byte(withFinalNewline(string(out), tf.LineEndings))
+		}
+		if !tf.verbatim() {
+			if out, err = encodeText(string(out), tf); err != nil {
+				dprintf("fs_edit error: %v", err)
+				return res, err
+			}
+		}
 		mode := fi.Mode() & os.ModePerm
 		if mode == 0 {
 			mode = 0o644
 		}
-		if err := atomicWrite(full, out, mode); err != nil {
+		if err := state.FS.WriteFile(name, out, mode); err != nil {
 			dprintf("fs_edit write error: %v", err)
 			return res, err
 		}
+		sha := sha256sum(out)
+		if len(out) <= int(maxHashBytes) {
+			rememberHash(state.FS, name, sha)
+		}
 		res = EditResult{
 			Path:         args.Path,
 			Replacements: count,
 			Bytes:        len(out),
-			SHA256:       sha256sum(out),
+			SHA256:       sha,
+			Charset:      tf.Encoding,
+			LineEndings:  storedLineEndings(out),
 			MetaFields: MetaFields{
 				Mode:       fmt.Sprintf("%#o", mode),
 				ModifiedAt: time.Now().UTC().Format(time.RFC3339),
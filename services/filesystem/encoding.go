@@ -0,0 +1,90 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"strings"
+)
+
+// Content encodings define how file bytes travel inside JSON strings
+type contentEncoding string
+
+const (
+	encodingUTF8    contentEncoding = "utf8"    // Bytes as text, unchanged
+	encodingBase64  contentEncoding = "base64"  // Standard base64 with padding
+	encodingHex     contentEncoding = "hex"     // Lowercase hex digits
+	encodingHexdump contentEncoding = "hexdump" // Offset, hex and ASCII columns; output only
+)
+
+// encodeContent renders data for a result's content field
+func encodeContent(enc contentEncoding, data []byte, offset int64) (string, error) {
+	switch enc {
+	case "", encodingUTF8:
+		return string(data), nil
+	case encodingBase64:
+		return base64.StdEncoding.EncodeToString(data), nil
+	case encodingHex:
+		return hex.EncodeToString(data), nil
+	case encodingHexdump:
+		return hexdump(data, offset), nil
+	}
+	return "", &ValidationError{Field: "encoding", Value: enc, Message: "expected utf8, base64, hex or hexdump"}
+}
+
+// decodeContent turns a request's content field back into bytes
+func decodeContent(enc contentEncoding, s string) ([]byte, error) {
+	switch enc {
+	case "", encodingUTF8:
+		return []byte(s), nil
+	case encodingBase64:
+		b, err := base64.StdEncoding.DecodeString(strings.TrimSpace(s))
+		if err != nil {
+			return nil, &ValidationError{Field: "content", Value: enc, Message: "content is not valid base64"}
+		}
+		return b, nil
+	case encodingHex:
+		b, err := hex.DecodeString(strings.TrimSpace(s))
+		if err != nil {
+			return nil, &ValidationError{Field: "content", Value: enc, Message: "content is not valid hex"}
+		}
+		return b, nil
+	}
+	return nil, &ValidationError{Field: "encoding", Value: enc, Message: "expected utf8, base64 or hex"}
+}
+
+// resultEncoding names the encoding in results, leaving plain text unlabelled
+func resultEncoding(enc contentEncoding) string {
+	if enc == encodingUTF8 {
+		return ""
+	}
+	return string(enc)
+}
+
+// hexdump formats data like hexdump -C, numbering lines from offset
+func hexdump(data []byte, offset int64) string {
+	var b strings.Builder
+	for i := 0; i < len(data); i += 16 {
+		line := data[i:min(i+16, len(data))]
+		fmt.Fprintf(&b, "%08x ", offset+int64(i))
+		for j := 0; j < 16; j++ {
+			if j == 8 {
+				b.WriteByte(' ')
+			}
+			if j < len(line) {
+				fmt.Fprintf(&b, " %02x", line[j])
+			} else {
+				b.WriteString("   ")
+			}
+		}
+		b.WriteString("  |")
+		for _, c := range line {
+			if c < 0x20 || c > 0x7e {
+				c = '.'
+			}
+			b.WriteByte(c)
+		}
+		b.WriteString("|\n")
+	}
+	return b.String()
+}
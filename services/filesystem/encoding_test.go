@@ -0,0 +1,105 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/hex"
+	"strings"
+	"testing"
+
+	"github.com/mark3labs/mcp-go/mcp"
+)
+
+// binaryBlob covers every byte value, including invalid UTF-8 sequences
+func binaryBlob() []byte {
+	b := make([]byte, 256)
+	for i := range b {
+		b[i] = byte(i)
+	}
+	return b
+}
+
+func TestBinaryRoundTrip(t *testing.T) {
+	ctx, sessions, mu, mem := memSession()
+	req := mcp.CallToolRequest{}
+	blob := binaryBlob()
+
+	for _, enc := range []contentEncoding{encodingBase64, encodingHex} {
+		name := "blob." + string(enc)
+		content, _ := encodeContent(enc, blob, 0)
+		wr, err := handleWrite(sessions, mu)(ctx, req, WriteArgs{Path: name, Content: content, Encoding: enc})
+		if err != nil {
+			t.Fatalf("%s: write: %v", enc, err)
+		}
+		if got := memRead(t, mem, name); got != string(blob) {
+			t.Fatalf("%s: bytes on disk differ", enc)
+		}
+		if wr.SHA256 != sha256sum(blob) {
+			t.Fatalf("%s: write sha = %s", enc, wr.SHA256)
+		}
+
+		rr, err := handleRead(sessions, mu)(ctx, req, ReadArgs{Path: name, Encoding: enc})
+		if err != nil {
+			t.Fatalf("%s: read: %v", enc, err)
+		}
+		back, err := decodeContent(enc, rr.Content)
+		if err != nil || string(back) != string(blob) {
+			t.Fatalf("%s: read content does not round-trip (%v)", enc, err)
+		}
+		if rr.SHA256 != wr.SHA256 || rr.ContentEncoding != string(enc) {
+			t.Fatalf("%s: read = sha %s encoding %q", enc, rr.SHA256, rr.ContentEncoding)
+		}
+	}
+
+	pr, err := handlePeek(sessions, mu)(ctx, req, PeekArgs{Path: "blob.hex", Offset: 250, MaxBytes: 10, Encoding: encodingBase64})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pr.Content != base64.StdEncoding.EncodeToString(blob[250:]) || !pr.EOF {
+		t.Fatalf("peek = %+v", pr)
+	}
+
+	// replace_range splices decoded bytes, not their text form
+	s, e := 0, 2
+	if _, err := handleWrite(sessions, mu)(ctx, req, WriteArgs{Path: "blob.hex", Content: "ffff", Encoding: encodingHex, Strategy: strategyReplaceRange, Start: &s, End: &e}); err != nil {
+		t.Fatal(err)
+	}
+	if got := memRead(t, mem, "blob.hex"); hex.EncodeToString([]byte(got[:3])) != "ffff02" || len(got) != 256 {
+		t.Fatalf("replace_range result = % x", got[:3])
+	}
+}
+
+func TestWriteRejectsBadEncoding(t *testing.T) {
+	ctx, sessions, mu, _ := memSession()
+	req := mcp.CallToolRequest{}
+	cases := []WriteArgs{
+		{Path: "a", Content: "not base64!", Encoding: encodingBase64},
+		{Path: "a", Content: "abc", Encoding: encodingHex},
+		{Path: "a", Content: "x", Encoding: "rot13"},
+		{Path: "a", Content: "x", Encoding: encodingHexdump},
+	}
+	for _, args := range cases {
+		if _, err := handleWrite(sessions, mu)(ctx, req, args); err == nil {
+			t.Fatalf("%+v: expected error", args)
+		}
+	}
+	if _, err := handleRead(sessions, mu)(ctx, req, ReadArgs{Path: "a", Encoding: "rot13"}); err == nil {
+		t.Fatal("read accepted unknown encoding")
+	}
+}
+
+func TestPeekHexdump(t *testing.T) {
+	ctx, sessions, mu, mem := memSession()
+	memWrite(t, mem, "f.bin", append([]byte("hello, world\n"), 0x00, 0xff, 'z', 'q', 'r'), 0o644)
+	pr, err := handlePeek(sessions, mu)(ctx, mcp.CallToolRequest{}, PeekArgs{Path: "f.bin", Offset: 1, Encoding: encodingHexdump})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "00000001  65 6c 6c 6f 2c 20 77 6f  72 6c 64 0a 00 ff 7a 71  |ello, world...zq|\n" +
+		"00000011  72                                                |r|\n"
+	if pr.Content != want {
+		t.Fatalf("hexdump =\n%s\nwant\n%s", pr.Content, want)
+	}
+	if !strings.Contains(formatPeekResult(pr), "|ello, world") {
+		t.Fatal("compat output lost the dump")
+	}
+}
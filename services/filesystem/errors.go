@@ -3,6 +3,7 @@ package main
 import (
 	"errors"
 	"fmt"
+	"io/fs"
 )
 
 // Error types for better error handling and agent processing
@@ -21,6 +22,9 @@ var (
 	ErrFileTooLarge      = errors.New("file exceeds size limit")
 	ErrLockTimeout       = errors.New("lock acquisition timeout")
 	ErrInvalidStrategy   = errors.New("invalid write strategy")
+	ErrPermissionDenied  = errors.New("permission denied")
+	ErrFileChanged       = errors.New("file changed since operation")
+	ErrReadOnlyFS        = errors.New("filesystem is read-only")
 
 	// Pattern errors
 	ErrPatternRequired = errors.New("pattern is required")
@@ -93,13 +97,20 @@ func toErrorResponse(err error) ErrorResponse {
 		resp.Operation = opErr.Op
 		resp.Path = opErr.Path
 		resp.Error = opErr.Err.Error()
+		if opErr.Details != "" {
+			resp.Details = map[string]string{"reason": opErr.Details}
+		}
+	}
+	var lc *leaseConflict
+	if errors.As(err, &lc) {
+		resp.Details = lc.details()
 	}
 
 	// Set error codes for common errors
 	switch {
 	case errors.Is(err, ErrPathOutsideRoot):
 		resp.Code = "PATH_ESCAPE"
-	case errors.Is(err, ErrPathNotFound):
+	case errors.Is(err, ErrPathNotFound), errors.Is(err, fs.ErrNotExist):
 		resp.Code = "NOT_FOUND"
 	case errors.Is(err, ErrFileExists):
 		resp.Code = "ALREADY_EXISTS"
@@ -109,6 +120,12 @@ func toErrorResponse(err error) ErrorResponse {
 		resp.Code = "FILE_TOO_LARGE"
 	case errors.Is(err, ErrLockTimeout):
 		resp.Code = "LOCK_TIMEOUT"
+	case errors.Is(err, ErrPermissionDenied), errors.Is(err, fs.ErrPermission):
+		resp.Code = "PERMISSION_DENIED"
+	case errors.Is(err, ErrFileChanged):
+		resp.Code = "CONFLICT"
+	case errors.Is(err, ErrReadOnlyFS):
+		resp.Code = "READ_ONLY"
 	default:
 		resp.Code = "UNKNOWN_ERROR"
 	}
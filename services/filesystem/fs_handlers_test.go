@@ -1,16 +1,13 @@
 package main
 
 import (
-	"os"
-	"path/filepath"
 	"testing"
 
 	"github.com/mark3labs/mcp-go/mcp"
 )
 
 func TestWrite_PathAndMode(t *testing.T) {
-	root := t.TempDir()
-	ctx, sessions, mu := testSession(root)
+	ctx, sessions, mu, mem := memSession()
 	wr := handleWrite(sessions, mu)
 	res, err := wr(ctx, mcp.CallToolRequest{}, WriteArgs{Path: "m/sub/file.txt", Content: "hello", Mode: "0640"})
 	if err != nil {
@@ -19,7 +16,7 @@ func TestWrite_PathAndMode(t *testing.T) {
 	if res.Bytes != 5 || res.MIMEType == "" {
 		t.Fatalf("unexpected write result: %+v", res)
 	}
-	st, err := os.Stat(filepath.Join(root, "m/sub/file.txt"))
+	st, err := mem.Stat("m/sub/file.txt")
 	if err != nil {
 		t.Fatal(err)
 	}
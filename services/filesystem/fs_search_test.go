@@ -10,11 +10,10 @@ import (
 )
 
 func TestSearchBasic(t *testing.T) {
-	root := t.TempDir()
-	mustWrite(t, filepath.Join(root, "a.txt"), []byte("hello world\nbye\n"), 0o644)
-	mustWrite(t, filepath.Join(root, "dir", "b.txt"), []byte("world line\nfoo\n"), 0o644)
+	ctx, sessions, mu, mem := memSession()
+	memWrite(t, mem, "a.txt", []byte("hello world\nbye\n"), 0o644)
+	memWrite(t, mem, "dir/b.txt", []byte("world line\nfoo\n"), 0o644)
 
-	ctx, sessions, mu := testSession(root)
 	search := handleSearch(sessions, mu)
 	res, err := search(ctx, mcp.CallToolRequest{}, SearchArgs{Pattern: "world"})
 	if err != nil {
@@ -26,10 +25,9 @@ func TestSearchBasic(t *testing.T) {
 }
 
 func TestSearchRegexAndLimit(t *testing.T) {
-	root := t.TempDir()
-	mustWrite(t, filepath.Join(root, "c.txt"), []byte("cat\ncar\ncap\n"), 0o644)
+	ctx, sessions, mu, mem := memSession()
+	memWrite(t, mem, "c.txt", []byte("cat\ncar\ncap\n"), 0o644)
 
-	ctx, sessions, mu := testSession(root)
 	search := handleSearch(sessions, mu)
 	res, err := search(ctx, mcp.CallToolRequest{}, SearchArgs{Pattern: "ca.", Regex: true, MaxResults: 2})
 	if err != nil {
@@ -40,8 +38,7 @@ func TestSearchRegexAndLimit(t *testing.T) {
 	}
 }
 func TestSearchNoPattern(t *testing.T) {
-	root := t.TempDir()
-	ctx, sessions, mu := testSession(root)
+	ctx, sessions, mu, _ := memSession()
 	search := handleSearch(sessions, mu)
 	_, err := search(ctx, mcp.CallToolRequest{}, SearchArgs{})
 	if err == nil {
@@ -50,8 +47,7 @@ func TestSearchNoPattern(t *testing.T) {
 }
 
 func TestSearchRegexError(t *testing.T) {
-	root := t.TempDir()
-	ctx, sessions, mu := testSession(root)
+	ctx, sessions, mu, _ := memSession()
 	search := handleSearch(sessions, mu)
 	_, err := search(ctx, mcp.CallToolRequest{}, SearchArgs{Pattern: "[", Regex: true})
 	if err == nil {
@@ -91,23 +91,6 @@ func TestSafeJoinResolveFinal(t *testing.T) {
 	}
 }
 
-func TestReadWindow(t *testing.T) {
-	root := t.TempDir()
-	p := filepath.Join(root, "a.txt")
-	mustWrite(t, p, []byte("0123456789"), 0o644)
-	b, sz, eof, err := readWindow(p, 3, 4)
-	if err != nil {
-		t.Fatal(err)
-	}
-	if string(b) != "3456" || sz != 10 || eof {
-		t.Fatalf("got %q sz=%d eof=%v", string(b), sz, eof)
-	}
-	b, _, eof, err = readWindow(p, 9, 10)
-	if err != nil || string(b) != "9" || !eof {
-		t.Fatalf("tail read failed: %q eof=%v err=%v", b, eof, err)
-	}
-}
-
 func TestParseMode(t *testing.T) {
 	m, err := parseMode("")
 	if err != nil || m != 0o644 {
@@ -144,7 +127,7 @@ func TestAtomicWriteAndLock(t *testing.T) {
 		t.Fatalf("overwrite wrong content: %q err=%v", b, err)
 	}
 
-	rel, err := acquireLock(p, time.Second)
+	rel, err := acquireLock(p, lockExclusive, time.Second)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -152,7 +135,7 @@ func TestAtomicWriteAndLock(t *testing.T) {
 	go func() {
 		defer close(done)
 		defer rel() // release the first lock after testing contention
-		_, err := acquireLock(p, 300*time.Millisecond)
+		_, err := acquireLock(p, lockExclusive, 300*time.Millisecond)
 		if err == nil {
 			t.Errorf("expected timeout, got nil")
 		}
@@ -170,8 +153,7 @@ func TestDetectMIMEAndIsText(t *testing.T) {
 }
 
 func TestHandleWriteStrategies(t *testing.T) {
-	root := t.TempDir()
-	ctx, sessions, mu := testSession(root)
+	ctx, sessions, mu, mem := memSession()
 	// Overwrite create
 	wr := handleWrite(sessions, mu)
 	res, err := wr(ctx, mcp.CallToolRequest{}, WriteArgs{Path: "a.txt", Content: "A"})
@@ -188,7 +170,7 @@ func TestHandleWriteStrategies(t *testing.T) {
 	if err != nil {
 		t.Fatal(err)
 	}
-	b, _ := os.ReadFile(filepath.Join(root, "a.txt"))
+	b, _ := readBackendFile(mem, "a.txt")
 	if string(b) != "AC" {
 		t.Fatalf("append wrong: %q", string(b))
 	}
@@ -197,7 +179,7 @@ func TestHandleWriteStrategies(t *testing.T) {
 	if err != nil {
 		t.Fatal(err)
 	}
-	b, _ = os.ReadFile(filepath.Join(root, "a.txt"))
+	b, _ = readBackendFile(mem, "a.txt")
 	if string(b) != "ZAC" {
 		t.Fatalf("prepend wrong: %q", string(b))
 	}
@@ -207,21 +189,20 @@ func TestHandleWriteStrategies(t *testing.T) {
 	if err != nil {
 		t.Fatal(err)
 	}
-	b, _ = os.ReadFile(filepath.Join(root, "a.txt"))
+	b, _ = readBackendFile(mem, "a.txt")
 	if string(b) != "ZXYC" {
 		t.Fatalf("replace_range wrong: %q", string(b))
 	}
 }
 
 func TestHandleWritePrependCreates(t *testing.T) {
-	root := t.TempDir()
-	ctx, sessions, mu := testSession(root)
+	ctx, sessions, mu, mem := memSession()
 	wr := handleWrite(sessions, mu)
 	res, err := wr(ctx, mcp.CallToolRequest{}, WriteArgs{Path: "new.txt", Content: "X", Strategy: strategyPrepend})
 	if err != nil {
 		t.Fatal(err)
 	}
-	b, err := os.ReadFile(filepath.Join(root, "new.txt"))
+	b, err := readBackendFile(mem, "new.txt")
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -234,9 +215,8 @@ func TestHandleWritePrependCreates(t *testing.T) {
 }
 
 func TestHandleReadAndPeek(t *testing.T) {
-	root := t.TempDir()
-	mustWrite(t, filepath.Join(root, "b.txt"), []byte("hello world"), 0o644)
-	ctx, sessions, mu := testSession(root)
+	ctx, sessions, mu, mem := memSession()
+	memWrite(t, mem, "b.txt", []byte("hello world"), 0o644)
 	rd := handleRead(sessions, mu)
 	res, err := rd(ctx, mcp.CallToolRequest{}, ReadArgs{Path: "b.txt", MaxBytes: 5})
 	if err != nil || !res.Truncated || res.Content != "hello" {
@@ -250,17 +230,16 @@ func TestHandleReadAndPeek(t *testing.T) {
 }
 
 func TestHandleEdit_TextAndRegex(t *testing.T) {
-	root := t.TempDir()
-	p := filepath.Join(root, "e.txt")
-	mustWrite(t, p, []byte("one two two three"), 0o644)
-	ctx, sessions, mu := testSession(root)
+	ctx, sessions, mu, mem := memSession()
+	p := "e.txt"
+	memWrite(t, mem, p, []byte("one two two three"), 0o644)
 	ed := handleEdit(sessions, mu)
 	// text, limit 1
 	res, err := ed(ctx, mcp.CallToolRequest{}, EditArgs{Path: "e.txt", Pattern: "two", Replace: "2", Count: 1})
 	if err != nil || res.Replacements != 1 {
 		t.Fatalf("text edit failed: %+v err=%v", res, err)
 	}
-	b, _ := os.ReadFile(p)
+	b, _ := readBackendFile(mem, p)
 	if string(b) != "one 2 two three" {
 		t.Fatalf("text replace wrong: %q", string(b))
 	}
@@ -269,17 +248,16 @@ func TestHandleEdit_TextAndRegex(t *testing.T) {
 	if err != nil || res.Replacements != 2 {
 		t.Fatalf("regex edit failed: %+v err=%v", res, err)
 	}
-	b, _ = os.ReadFile(p)
+	b, _ = readBackendFile(mem, p)
 	if !strings.Contains(string(b), "one 2 X X") {
 		t.Fatalf("regex replace wrong: %q", string(b))
 	}
 }
 
 func TestHandleListAndGlob(t *testing.T) {
-	root := t.TempDir()
-	mustWrite(t, filepath.Join(root, "d", "x.txt"), []byte(""), 0o644)
-	mustWrite(t, filepath.Join(root, "d", "y.bin"), []byte{0}, 0o644)
-	ctx, sessions, mu := testSession(root)
+	ctx, sessions, mu, mem := memSession()
+	memWrite(t, mem, "d/x.txt", []byte(""), 0o644)
+	memWrite(t, mem, "d/y.bin", []byte{0}, 0o644)
 	ls := handleList(sessions, mu)
 	res, err := ls(ctx, mcp.CallToolRequest{}, ListArgs{Path: ".", Recursive: true, MaxEntries: 10})
 	if err != nil || len(res.Entries) < 2 {
@@ -293,9 +271,8 @@ func TestHandleListAndGlob(t *testing.T) {
 }
 
 func TestHandleGlobRecursive(t *testing.T) {
-	root := t.TempDir()
-	mustWrite(t, filepath.Join(root, "a", "b", "c.txt"), []byte(""), 0o644)
-	ctx, sessions, mu := testSession(root)
+	ctx, sessions, mu, mem := memSession()
+	memWrite(t, mem, "a/b/c.txt", []byte(""), 0o644)
 	gb := handleGlob(sessions, mu)
 	res, err := gb(ctx, mcp.CallToolRequest{}, GlobArgs{Pattern: "**/*.txt"})
 	if err != nil {
@@ -307,10 +284,9 @@ func TestHandleGlobRecursive(t *testing.T) {
 }
 
 func TestHandleRead_DefaultLimit(t *testing.T) {
-	root := t.TempDir()
+	ctx, sessions, mu, mem := memSession()
 	big := strings.Repeat("a", defaultReadMaxBytes+100)
-	mustWrite(t, filepath.Join(root, "big.txt"), []byte(big), 0o644)
-	ctx, sessions, mu := testSession(root)
+	memWrite(t, mem, "big.txt", []byte(big), 0o644)
 	rd := handleRead(sessions, mu)
 	res, err := rd(ctx, mcp.CallToolRequest{}, ReadArgs{Path: "big.txt"})
 	if err != nil {
@@ -5,8 +5,6 @@
 package main
 
 import (
-	"os"
-	"path/filepath"
 	"testing"
 
 	"github.com/mark3labs/mcp-go/mcp"
@@ -21,10 +19,8 @@ func FuzzEdit(f *testing.F) {
 	f.Add("hello", "(unclosed", "x", true, 0)  // invalid regex (exercise error path)
 
 	f.Fuzz(func(t *testing.T, content, pattern, repl string, regex bool, count int) {
-		root := t.TempDir() // use t.* within the target body
-		p := filepath.Join(root, "e.txt")
-		_ = os.WriteFile(p, []byte(content), 0o644)
-		ctx, sessions, mu := testSession(root)
+		ctx, sessions, mu, mem := memSession()
+		_ = mem.WriteFile("e.txt", []byte(content), 0o644)
 		h := handleEdit(sessions, mu)
 		_, _ = h(ctx, mcp.CallToolRequest{}, EditArgs{Path: "e.txt", Pattern: pattern, Replace: repl, Regex: regex, Count: count})
 	})
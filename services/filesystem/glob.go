@@ -6,7 +6,6 @@ import (
 	"fmt"
 	"io/fs"
 	"path/filepath"
-	"runtime"
 	"strings"
 	"sync"
 	"time"
@@ -25,7 +24,6 @@ func handleGlob(sessions map[string]*SessionState, mu *sync.RWMutex) mcp.Structu
 		if err != nil {
 			return GlobResult{}, err
 		}
-		root := state.Root
 		start := time.Now()
 		dprintf("%s -> fs_glob pattern=%q max_results=%d", sessionContext(ctx), args.Pattern, args.MaxResults)
 		var out GlobResult
@@ -36,10 +34,11 @@ func handleGlob(sessions map[string]*SessionState, mu *sync.RWMutex) mcp.Structu
 		if strings.Contains(args.Pattern, "../") || strings.HasPrefix(args.Pattern, "/") {
 			return out, fmt.Errorf("pattern cannot escape base folder: %s", args.Pattern)
 		}
-		max := args.MaxResults
-		if max <= 0 {
-			max = defaultGlobMaxResults
+		if err := checkAccess(ctx, state, "glob", capRead, args.Pattern, ""); err != nil {
+			dprintf("fs_glob error: %v", err)
+			return out, err
 		}
+		max := currentConfig().toolLimit("fs_glob", args.MaxResults)
 		pat := filepath.ToSlash(filepath.Clean(args.Pattern))
 		if _, err := doublestar.Match(pat, ""); err != nil {
 			dprintf("fs_glob error: %v", err)
@@ -53,7 +52,7 @@ func handleGlob(sessions map[string]*SessionState, mu *sync.RWMutex) mcp.Structu
 		walkWG.Add(1)
 		go func() {
 			defer walkWG.Done()
-			walkErr = filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+			walkErr = walkBackend(state.FS, "", func(name string, d fs.DirEntry, err error) error {
 				if err != nil {
 					return nil
 				}
@@ -62,11 +61,17 @@ func handleGlob(sessions map[string]*SessionState, mu *sync.RWMutex) mcp.Structu
 					return ctx.Err()
 				default:
 				}
-				rel, err := filepath.Rel(root, path)
-				if err != nil {
+				if name == "" {
+					paths <- "."
+					return nil
+				}
+				if !state.listed(name) {
+					if d.IsDir() {
+						return fs.SkipDir
+					}
 					return nil
 				}
-				paths <- filepath.ToSlash(rel)
+				paths <- name
 				return nil
 			})
 			close(paths)
@@ -74,7 +79,7 @@ func handleGlob(sessions map[string]*SessionState, mu *sync.RWMutex) mcp.Structu
 
 		var mu sync.Mutex
 		matches := []string{}
-		workers := runtime.NumCPU()
+		workers := currentConfig().GetWorkerCount("glob")
 		var wg sync.WaitGroup
 		for i := 0; i < workers; i++ {
 			wg.Add(1)
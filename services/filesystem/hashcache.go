@@ -0,0 +1,136 @@
+package main
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"hash"
+	"io"
+	"io/fs"
+	"strings"
+	"sync"
+)
+
+// hashKey identifies one version of a file. Atomic writes replace the inode,
+// so an in-place rewrite that keeps size and mtime still changes the key.
+type hashKey struct {
+	fs    Backend
+	name  string
+	size  int64
+	mtime int64
+	inode uint64
+}
+
+// inoder is implemented by file infos of backends without OS inodes
+type inoder interface{ Inode() uint64 }
+
+// inodeOf identifies the file behind fi, or returns 0 when that is unknown
+func inodeOf(fi fs.FileInfo) uint64 {
+	if in, ok := fi.(inoder); ok {
+		return in.Inode()
+	}
+	return fileInode(fi)
+}
+
+func hashKeyOf(b Backend, name string, fi fs.FileInfo) hashKey {
+	return hashKey{fs: b, name: name, size: fi.Size(), mtime: fi.ModTime().UnixNano(), inode: inodeOf(fi)}
+}
+
+// hashCache remembers SHA-256 digests so unchanged files are hashed once.
+// Entries are evicted oldest first once the cache is full.
+type hashCache struct {
+	mu      sync.Mutex
+	max     int
+	entries map[hashKey]string
+	order   []hashKey
+}
+
+func newHashCache(max int) *hashCache {
+	return &hashCache{max: max, entries: map[hashKey]string{}}
+}
+
+// fileHashes is shared by every session; keys include the backend
+var fileHashes = newHashCache(hashCacheEntries)
+
+func (c *hashCache) get(k hashKey) (string, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	sha, ok := c.entries[k]
+	return sha, ok
+}
+
+func (c *hashCache) put(k hashKey, sha string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, ok := c.entries[k]; ok {
+		return
+	}
+	for len(c.order) >= c.max {
+		delete(c.entries, c.order[0])
+		c.order = c.order[1:]
+	}
+	c.entries[k] = sha
+	c.order = append(c.order, k)
+}
+
+// hashingReader feeds everything read from a file into a SHA-256 so content
+// and digest come from a single pass. finish reads whatever the caller left
+// unread, up to maxHashBytes in total, and caches the result.
+type hashingReader struct {
+	io.Reader
+	src io.Reader
+	h   hash.Hash
+	n   int64
+	key hashKey
+}
+
+// newHashingReader wraps f; when the digest is already cached it returns the
+// digest instead and f should be read directly
+func newHashingReader(b Backend, name string, f io.Reader, fi fs.FileInfo) (*hashingReader, string) {
+	key := hashKeyOf(b, name, fi)
+	if sha, ok := fileHashes.get(key); ok {
+		return nil, sha
+	}
+	if fi.Size() > maxHashBytes {
+		return nil, ""
+	}
+	hr := &hashingReader{src: f, h: sha256.New(), key: key}
+	hr.Reader = io.TeeReader(f, hr)
+	return hr, ""
+}
+
+func (r *hashingReader) Write(p []byte) (int, error) {
+	r.n += int64(len(p))
+	return r.h.Write(p)
+}
+
+func (r *hashingReader) finish() (string, error) {
+	if _, err := io.CopyN(r.h, r.src, maxHashBytes-r.n); err != nil && err != io.EOF {
+		return "", err
+	}
+	sha := fmt.Sprintf("%x", r.h.Sum(nil))
+	fileHashes.put(r.key, sha)
+	return sha, nil
+}
+
+// rememberHash records the digest of content just written to name
+func rememberHash(b Backend, name, sha string) {
+	if sha == "" {
+		return
+	}
+	if fi, err := b.Stat(name); err == nil {
+		fileHashes.put(hashKeyOf(b, name, fi), sha)
+	}
+}
+
+// notModified reports whether name still hashes to etag, returning the
+// current digest when it does. Files beyond maxHashBytes never match.
+func notModified(b Backend, name string, fi fs.FileInfo, etag string) (string, bool) {
+	if fi.Size() > maxHashBytes {
+		return "", false
+	}
+	sha, err := hashBackendFile(b, name)
+	if err != nil || !strings.EqualFold(sha, strings.TrimSpace(etag)) {
+		return "", false
+	}
+	return sha, true
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/mark3labs/mcp-go/mcp"
+)
+
+func TestConditionalRead(t *testing.T) {
+	ctx, sessions, mu, mem := memSession()
+	body := strings.Repeat("0123456789", 1000)
+	memWrite(t, mem, "big.txt", []byte(body), 0o644)
+	req := mcp.CallToolRequest{}
+
+	// A truncated read still reports the digest of the whole file
+	res, err := handleRead(sessions, mu)(ctx, req, ReadArgs{Path: "big.txt", MaxBytes: 10})
+	if err != nil || !res.Truncated || res.SHA256 != sha256sum([]byte(body)) {
+		t.Fatalf("read = %+v %v", res, err)
+	}
+
+	nm, err := handleRead(sessions, mu)(ctx, req, ReadArgs{Path: "big.txt", IfNoneMatchSHA256: res.SHA256})
+	if err != nil || !nm.NotModified || nm.Content != "" || nm.SHA256 != res.SHA256 {
+		t.Fatalf("conditional read = %+v %v", nm, err)
+	}
+	pr, err := handlePeek(sessions, mu)(ctx, req, PeekArgs{Path: "big.txt", IfNoneMatchSHA256: strings.ToUpper(res.SHA256)})
+	if err != nil || !pr.NotModified || pr.Content != "" {
+		t.Fatalf("conditional peek = %+v %v", pr, err)
+	}
+
+	if _, err := handleWrite(sessions, mu)(ctx, req, WriteArgs{Path: "big.txt", Content: "changed"}); err != nil {
+		t.Fatal(err)
+	}
+	res2, err := handleRead(sessions, mu)(ctx, req, ReadArgs{Path: "big.txt", IfNoneMatchSHA256: res.SHA256})
+	if err != nil || res2.NotModified || res2.Content != "changed" || res2.SHA256 != sha256sum([]byte("changed")) {
+		t.Fatalf("read after change = %+v %v", res2, err)
+	}
+	pr, err = handlePeek(sessions, mu)(ctx, req, PeekArgs{Path: "big.txt", IfNoneMatchSHA256: res.SHA256})
+	if err != nil || pr.NotModified || pr.Content != "changed" || pr.SHA256 != res2.SHA256 {
+		t.Fatalf("peek after change = %+v %v", pr, err)
+	}
+}
+
+func TestHashCacheKeys(t *testing.T) {
+	root := t.TempDir()
+	p := filepath.Join(root, "f.txt")
+	mustWrite(t, p, []byte("one"), 0o644)
+	b := newLocalBackend(root)
+
+	sha, err := hashBackendFile(b, "f.txt")
+	if err != nil || sha != sha256sum([]byte("one")) {
+		t.Fatalf("hash = %s %v", sha, err)
+	}
+	fi, _ := b.Stat("f.txt")
+	if got, ok := fileHashes.get(hashKeyOf(b, "f.txt", fi)); !ok || got != sha {
+		t.Fatal("digest not cached")
+	}
+
+	// Same size and mtime, but a new inode after an atomic replace
+	mtime := fi.ModTime()
+	if err := b.WriteFile("f.txt", []byte("two"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	os.Chtimes(p, mtime, mtime)
+	if sha, _ := hashBackendFile(b, "f.txt"); sha != sha256sum([]byte("two")) {
+		t.Fatal("stale digest after replace")
+	}
+
+	m := newMemBackend()
+	m.WriteFile("f.txt", []byte("one"), 0o644)
+	hashBackendFile(m, "f.txt")
+	m.WriteFile("f.txt", []byte("two"), 0o644)
+	if sha, _ := hashBackendFile(m, "f.txt"); sha != sha256sum([]byte("two")) {
+		t.Fatal("stale digest in memory backend")
+	}
+
+	c := newHashCache(2)
+	for i, name := range []string{"a", "b", "c"} {
+		c.put(hashKey{name: name}, string(rune('0'+i)))
+	}
+	if _, ok := c.get(hashKey{name: "a"}); ok || len(c.entries) != 2 {
+		t.Fatalf("cache not bounded: %v", c.entries)
+	}
+}
@@ -2,9 +2,8 @@ package main
 
 import (
 	"crypto/sha256"
-	"errors"
 	"fmt"
-	"io"
+	"io/fs"
 	"mime"
 	"os"
 	"path/filepath"
@@ -28,11 +27,21 @@ func detectMIME(name string, sample []byte) string {
 	return "application/octet-stream"
 }
 
-// isText performs enhanced text detection with UTF-8 validation
+// isText reports whether b is text in UTF-8 or one of the legacy encodings
+// understood by detectTextFormat
 func isText(b []byte) bool {
 	if len(b) == 0 {
 		return true
 	}
+	_, ok := detectTextFormat(b)
+	return ok
+}
+
+// plainText performs enhanced text detection with UTF-8 validation
+func plainText(b []byte) bool {
+	if len(b) == 0 {
+		return true
+	}
 
 	// Check for null bytes (strong indicator of binary)
 	for _, c := range b {
@@ -69,22 +78,6 @@ func isText(b []byte) bool {
 	return true
 }
 
-// sha256sumStream computes SHA256 with streaming to avoid memory issues
-func sha256sumStream(path string) (string, error) {
-	f, err := os.Open(path)
-	if err != nil {
-		return "", err
-	}
-	defer f.Close()
-
-	h := sha256.New()
-	if _, err := io.CopyN(h, f, maxHashBytes); err != nil && err != io.EOF {
-		return "", err
-	}
-
-	return fmt.Sprintf("%x", h.Sum(nil)), nil
-}
-
 // sha256sum computes SHA256 of byte slice
 func sha256sum(b []byte) string {
 	s := sha256.Sum256(b)
@@ -187,57 +180,6 @@ func atomicWrite(target string, data []byte, mode os.FileMode) error {
 	return nil
 }
 
-// acquireLock creates an advisory lock with improved stale detection
-func acquireLock(path string, timeout time.Duration) (release func(), err error) {
-	lock := path + ".lock"
-	deadline := time.Now().Add(timeout)
-
-	// Use exponential backoff
-	wait := 10 * time.Millisecond
-	maxWait := 500 * time.Millisecond
-
-	for {
-		// Try to create lock file
-		f, err := os.OpenFile(lock, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0o644)
-		if err == nil {
-			// Write PID and timestamp for debugging
-			_, _ = fmt.Fprintf(f, "%d\n%d\n", os.Getpid(), time.Now().Unix())
-			_ = f.Close()
-
-			return func() {
-				_ = os.Remove(lock)
-			}, nil
-		}
-
-		if !errors.Is(err, os.ErrExist) {
-			return nil, fmt.Errorf("failed to create lock file: %w", err)
-		}
-
-		// Check for stale lock
-		if info, statErr := os.Stat(lock); statErr == nil {
-			age := time.Since(info.ModTime())
-			// Reduced stale timeout to 5 minutes
-			if age > 5*time.Minute {
-				dprintf("removing stale lock (age=%v): %s", age, lock)
-				_ = os.Remove(lock)
-				continue
-			}
-		}
-
-		// Check timeout
-		if time.Now().After(deadline) {
-			return nil, fmt.Errorf("lock acquisition timeout after %v: %s", timeout, path)
-		}
-
-		// Exponential backoff
-		time.Sleep(wait)
-		wait *= 2
-		if wait > maxWait {
-			wait = maxWait
-		}
-	}
-}
-
 // kindOf returns the file type as a string
 func kindOf(fi os.FileInfo) string {
 	m := fi.Mode()
@@ -263,9 +205,16 @@ func kindOf(fi os.FileInfo) string {
 func checkDiskSpace(path string, needed int64) error {
 	// This is a simplified check - proper implementation would use syscalls
 	// For now, just check if we're not trying to write something huge
-	const maxFileSize = 1 << 30 // 1GB limit per file
-	if needed > maxFileSize {
-		return fmt.Errorf("file size %d exceeds maximum allowed (%d)", needed, maxFileSize)
+	if limit := currentConfig().MaxFileSize; needed > limit {
+		return fmt.Errorf("file size %d exceeds maximum allowed (%d)", needed, limit)
 	}
 	return nil
 }
+
+// metaOf fills the common metadata fields from fi
+func metaOf(fi fs.FileInfo) MetaFields {
+	return MetaFields{
+		Mode:       fmt.Sprintf("%#o", fi.Mode()&os.ModePerm),
+		ModifiedAt: fi.ModTime().UTC().Format(time.RFC3339),
+	}
+}
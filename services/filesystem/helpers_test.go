@@ -19,25 +19,23 @@ func TestInitDebugAndDprintf(t *testing.T) {
 	}
 
 	// Debug disabled early return
-	*debugFlag = ""
 	debugEnabled = false
 	debugLog = nil
-	initDebug()
+	initDebug("")
 	if _, err := os.Stat("log"); !os.IsNotExist(err) {
 		t.Fatalf("log should not exist when disabled")
 	}
 
 	// Error when creating the log file
 	os.Mkdir("log", 0o755)
-	*debugFlag = "log"
-	initDebug()
+	initDebug("log")
 	if debugEnabled {
 		t.Fatalf("debug should not enable on error")
 	}
 	os.Remove("log")
 
 	// Successful run
-	initDebug()
+	initDebug("log")
 	dprintf("hello %s", "world")
 	data, err := os.ReadFile("log")
 	if err != nil {
@@ -51,16 +49,10 @@ func TestInitDebugAndDprintf(t *testing.T) {
 func TestGetRoot(t *testing.T) {
 	cwd, _ := os.Getwd()
 	defer os.Chdir(cwd)
-	oldFlag := *rootDirFlag
-	defer func() { *rootDirFlag = oldFlag }()
-	oldEnv := os.Getenv("FS_ROOT")
-	defer os.Setenv("FS_ROOT", oldEnv)
 
 	t.Run("flag", func(t *testing.T) {
 		dir := t.TempDir()
-		*rootDirFlag = dir
-		os.Setenv("FS_ROOT", "")
-		r, err := getRoot()
+		r, err := getRoot(dir)
 		dirResolved, _ := filepath.EvalSymlinks(dir)
 		if err != nil || (r != dir && r != dirResolved) {
 			t.Fatalf("getRoot flag failed: %q %v", r, err)
@@ -68,13 +60,22 @@ func TestGetRoot(t *testing.T) {
 	})
 
 	t.Run("env", func(t *testing.T) {
-		dir := t.TempDir()
-		*rootDirFlag = ""
-		os.Setenv("FS_ROOT", dir)
-		r, err := getRoot()
-		dirResolved, _ := filepath.EvalSymlinks(dir)
-		if err != nil || (r != dir && r != dirResolved) {
-			t.Fatalf("getRoot env failed: %q %v", r, err)
+		flagDir, envDir := t.TempDir(), t.TempDir()
+		getenv := func(k string) string {
+			if k == "FS_ROOT" {
+				return envDir
+			}
+			return ""
+		}
+		for _, c := range []struct {
+			args []string
+			want string
+		}{{nil, envDir}, {[]string{"--root", flagDir}, flagDir}} {
+			cfg, err := loadConfig(c.args, getenv)
+			want, _ := filepath.EvalSymlinks(c.want)
+			if err != nil || (cfg.Root != c.want && cfg.Root != want) {
+				t.Fatalf("args=%v: root %v %v, want %s", c.args, cfg, err, c.want)
+			}
 		}
 	})
 
@@ -83,9 +84,7 @@ func TestGetRoot(t *testing.T) {
 		if err := os.Chdir(dir); err != nil {
 			t.Fatal(err)
 		}
-		*rootDirFlag = ""
-		os.Setenv("FS_ROOT", "")
-		r, err := getRoot()
+		r, err := getRoot("")
 		dirResolved, _ := filepath.EvalSymlinks(dir)
 		if err != nil || (r != dir && r != dirResolved) {
 			t.Fatalf("getRoot cwd failed: %q %v", r, err)
@@ -111,23 +110,6 @@ func TestDetectMIMEAndIsTextExtra(t *testing.T) {
 	}
 }
 
-func TestReadWindowVariants(t *testing.T) {
-	root := t.TempDir()
-	p := filepath.Join(root, "a.txt")
-	os.WriteFile(p, []byte("0123456789"), 0o644)
-	b, _, _, err := readWindow(p, -5, 2)
-	if err != nil || string(b) != "01" {
-		t.Fatalf("neg offset failed: %q %v", string(b), err)
-	}
-	b, sz, eof, err := readWindow(p, 999, 2)
-	if err != nil || len(b) != 0 || !eof || sz != 10 {
-		t.Fatalf("beyond size failed: %q sz=%d eof=%v err=%v", string(b), sz, eof, err)
-	}
-	if _, _, _, err := readWindow(filepath.Join(root, "missing"), 0, 1); err == nil {
-		t.Fatalf("expected error for missing file")
-	}
-}
-
 func TestAtomicWrite(t *testing.T) {
 	dir := t.TempDir()
 	file := filepath.Join(dir, "f.txt")
@@ -187,22 +169,21 @@ func TestKindOf(t *testing.T) {
 
 func TestAcquireLock(t *testing.T) {
 	p := filepath.Join(t.TempDir(), "f")
-	release, err := acquireLock(p, time.Second)
+	release, err := acquireLock(p, lockExclusive, time.Second)
 	if err != nil {
 		t.Fatalf("acquireLock failed: %v", err)
 	}
 	defer release()
-	_, err = acquireLock(p, 100*time.Millisecond)
+	_, err = acquireLock(p, lockExclusive, 100*time.Millisecond)
 	if err == nil {
 		t.Fatalf("expected lock timeout")
 	}
 }
 
 func TestHandleReadAndPeekVariants(t *testing.T) {
-	root := t.TempDir()
-	p := filepath.Join(root, "b.bin")
-	os.WriteFile(p, []byte("hello"), 0o644)
-	ctx, sessions, mu := testSession(root)
+	ctx, sessions, mu, mem := memSession()
+	p := "b.bin"
+	mem.WriteFile(p, []byte("hello"), 0o644)
 	rd := handleRead(sessions, mu)
 	res, err := rd(ctx, mcp.CallToolRequest{}, ReadArgs{Path: "b.bin"})
 	if err != nil || res.Content != "hello" {
@@ -222,20 +203,19 @@ func TestHandleReadAndPeekVariants(t *testing.T) {
 }
 
 func TestHandleWriteErrors(t *testing.T) {
-	root := t.TempDir()
-	ctx, sessions, mu := testSession(root)
+	ctx, sessions, mu, mem := memSession()
 	wr := handleWrite(sessions, mu)
 	if _, err := wr(ctx, mcp.CallToolRequest{}, WriteArgs{Path: "a.txt", Strategy: "bogus", Content: "x"}); err == nil {
 		t.Fatalf("expected strategy error")
 	}
 	// append to directory should error
-	os.Mkdir(filepath.Join(root, "adir"), 0o755)
+	mem.MkdirAll("adir", 0o755)
 	if _, err := wr(ctx, mcp.CallToolRequest{}, WriteArgs{Path: "adir", Content: "x", Strategy: strategyAppend}); err == nil {
 		t.Fatalf("expected append dir error")
 	}
 
 	// prepare file for replace_range tests
-	os.WriteFile(filepath.Join(root, "r.txt"), []byte("abcd"), 0o644)
+	mem.WriteFile("r.txt", []byte("abcd"), 0o644)
 	s, e := 3, 2 // invalid range (end < start)
 	if _, err := wr(ctx, mcp.CallToolRequest{}, WriteArgs{Path: "r.txt", Content: "x", Strategy: strategyReplaceRange, Start: &s, End: &e}); err == nil {
 		t.Fatalf("expected invalid range error")
@@ -247,10 +227,9 @@ func TestHandleWriteErrors(t *testing.T) {
 }
 
 func TestHandleEditError(t *testing.T) {
-	root := t.TempDir()
-	p := filepath.Join(root, "e.txt")
-	os.WriteFile(p, []byte("data"), 0o644)
-	ctx, sessions, mu := testSession(root)
+	ctx, sessions, mu, mem := memSession()
+	p := "e.txt"
+	mem.WriteFile(p, []byte("data"), 0o644)
 	ed := handleEdit(sessions, mu)
 	if _, err := ed(ctx, mcp.CallToolRequest{}, EditArgs{Path: "e.txt", Pattern: "(", Replace: "x", Regex: true}); err == nil {
 		t.Fatalf("expected regex error")
@@ -261,10 +240,9 @@ func TestHandleEditError(t *testing.T) {
 }
 
 func TestHandleListVariants(t *testing.T) {
-	root := t.TempDir()
-	os.WriteFile(filepath.Join(root, "a.txt"), []byte(""), 0o644)
-	os.WriteFile(filepath.Join(root, "b.txt"), []byte(""), 0o644)
-	ctx, sessions, mu := testSession(root)
+	ctx, sessions, mu, mem := memSession()
+	mem.WriteFile("a.txt", []byte(""), 0o644)
+	mem.WriteFile("b.txt", []byte(""), 0o644)
 	hl := handleList(sessions, mu)
 	res, err := hl(ctx, mcp.CallToolRequest{}, ListArgs{Path: ".", MaxEntries: 1})
 	if err != nil || len(res.Entries) != 1 {
@@ -280,8 +258,7 @@ func TestHandleListVariants(t *testing.T) {
 }
 
 func TestHandleGlobErrors(t *testing.T) {
-	root := t.TempDir()
-	ctx, sessions, mu := testSession(root)
+	ctx, sessions, mu, _ := memSession()
 	gb := handleGlob(sessions, mu)
 	if _, err := gb(ctx, mcp.CallToolRequest{}, GlobArgs{Pattern: ""}); err == nil {
 		t.Fatalf("expected pattern error")
@@ -295,11 +272,10 @@ func TestHandleGlobErrors(t *testing.T) {
 }
 
 func TestHandleGlobMaxResults(t *testing.T) {
-	root := t.TempDir()
-	mustWrite(t, filepath.Join(root, "a.txt"), []byte(""), 0o644)
-	mustWrite(t, filepath.Join(root, "b.txt"), []byte(""), 0o644)
-	mustWrite(t, filepath.Join(root, "c.txt"), []byte(""), 0o644)
-	ctx, sessions, mu := testSession(root)
+	ctx, sessions, mu, mem := memSession()
+	memWrite(t, mem, "a.txt", []byte(""), 0o644)
+	memWrite(t, mem, "b.txt", []byte(""), 0o644)
+	memWrite(t, mem, "c.txt", []byte(""), 0o644)
 	gb := handleGlob(sessions, mu)
 	res, err := gb(ctx, mcp.CallToolRequest{}, GlobArgs{Pattern: "*.txt", MaxResults: 2})
 	if err != nil {
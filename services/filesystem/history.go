@@ -0,0 +1,833 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mark3labs/mcp-go/mcp"
+)
+
+const (
+	defaultHistoryMaxBytes = 64 << 20 // 64 MiB of retained pre-images per session
+	defaultHistoryMaxAge   = time.Hour
+	maxHistoryFileBytes    = maxHashBytes // larger files make an operation irreversible
+)
+
+// fileImage describes the state of a single path; a nil image means absent
+type fileImage struct {
+	Dir  bool
+	Link string
+	SHA  string
+	Mode os.FileMode
+}
+
+func (a *fileImage) equal(b *fileImage) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return *a == *b
+}
+
+// fileChange records the state of one path before and after an operation
+type fileChange struct {
+	Path string // slash-separated, relative to the base folder
+	Pre  *fileImage
+	Post *fileImage
+}
+
+// historyOp is one undoable mutation
+type historyOp struct {
+	Tool         string
+	Time         time.Time
+	Changes      []fileChange
+	Irreversible string // why the pre-images could not be retained, if they were not
+}
+
+// checkpoint is a named snapshot of a set of paths
+type checkpoint struct {
+	Time   time.Time
+	Roots  []string
+	Images map[string]*fileImage
+}
+
+// historyStore keeps a session's undo history and checkpoints in a
+// content-addressed store. Undo operations and checkpoints each get their
+// own budget of maxBytes, so checkpoints cannot crowd out undo; both are
+// also bounded by age.
+type historyStore struct {
+	mu          sync.Mutex
+	maxBytes    int64
+	maxAge      time.Duration
+	blobs       map[string][]byte
+	undo        blobRefs // pre-images referenced by ops
+	saved       blobRefs // contents referenced by checkpoints
+	ops         []*historyOp
+	checkpoints map[string]*checkpoint
+}
+
+// blobRefs counts the references one kind of owner holds on stored blobs
+// and the bytes those blobs take
+type blobRefs struct {
+	refs map[string]int
+	size int64
+}
+
+func newHistoryStore(maxBytes int64, maxAge time.Duration) *historyStore {
+	return &historyStore{
+		maxBytes:    maxBytes,
+		maxAge:      maxAge,
+		blobs:       map[string][]byte{},
+		undo:        blobRefs{refs: map[string]int{}},
+		saved:       blobRefs{refs: map[string]int{}},
+		checkpoints: map[string]*checkpoint{},
+	}
+}
+
+var historyInitMu sync.Mutex
+
+// history returns the session's undo history, creating it on first use.
+// It returns nil when history is disabled or the session is a sandbox,
+// whose pending changes are dropped with fs_overlay_discard instead.
+func (s *SessionState) history() *historyStore {
+	historyInitMu.Lock()
+	defer historyInitMu.Unlock()
+	if s.sandbox() != nil {
+		return nil
+	}
+	if h := currentConfig().History; s.History == nil && h.MaxBytes > 0 {
+		s.History = newHistoryStore(h.MaxBytes, h.MaxAge)
+	}
+	return s.History
+}
+
+func (h *historyStore) retain(r *blobRefs, sha string, data []byte) {
+	if r.refs[sha] == 0 {
+		if _, ok := h.blobs[sha]; !ok {
+			h.blobs[sha] = data
+		}
+		r.size += int64(len(h.blobs[sha]))
+	}
+	r.refs[sha]++
+}
+
+func (h *historyStore) release(r *blobRefs, sha string) {
+	r.refs[sha]--
+	if r.refs[sha] <= 0 {
+		r.size -= int64(len(h.blobs[sha]))
+		delete(r.refs, sha)
+		if h.undo.refs[sha] == 0 && h.saved.refs[sha] == 0 {
+			delete(h.blobs, sha)
+		}
+	}
+}
+
+func (h *historyStore) releaseImages(r *blobRefs, imgs []*fileImage) {
+	for _, img := range imgs {
+		if img != nil && img.SHA != "" {
+			h.release(r, img.SHA)
+		}
+	}
+}
+
+func (h *historyStore) dropOldest() {
+	op := h.ops[0]
+	h.ops = h.ops[1:]
+	for _, c := range op.Changes {
+		h.releaseImages(&h.undo, []*fileImage{c.Pre})
+	}
+}
+
+// dropCheckpoint forgets the checkpoint name and releases its contents
+func (h *historyStore) dropCheckpoint(name string) {
+	cp, ok := h.checkpoints[name]
+	if !ok {
+		return
+	}
+	for _, img := range cp.Images {
+		h.releaseImages(&h.saved, []*fileImage{img})
+	}
+	delete(h.checkpoints, name)
+}
+
+// oldestCheckpoint returns the name of the oldest checkpoint other than keep
+func (h *historyStore) oldestCheckpoint(keep string) (string, bool) {
+	name, found := "", false
+	for n, cp := range h.checkpoints {
+		if n != keep && (!found || cp.Time.Before(h.checkpoints[name].Time)) {
+			name, found = n, true
+		}
+	}
+	return name, found
+}
+
+// prune evicts operations and checkpoints that are too old or exceed their
+// byte budget, oldest first; keep names a checkpoint that must stay.
+// Callers must hold h.mu.
+func (h *historyStore) prune(keep string) {
+	for len(h.ops) > 0 {
+		tooOld := h.maxAge > 0 && time.Since(h.ops[0].Time) > h.maxAge
+		if !tooOld && h.undo.size <= h.maxBytes {
+			break
+		}
+		h.dropOldest()
+	}
+	for {
+		name, ok := h.oldestCheckpoint(keep)
+		if !ok {
+			return
+		}
+		tooOld := h.maxAge > 0 && time.Since(h.checkpoints[name].Time) > h.maxAge
+		if !tooOld && h.saved.size <= h.maxBytes {
+			return
+		}
+		h.dropCheckpoint(name)
+	}
+}
+
+// push records op, retaining the pre-image contents it references
+func (h *historyStore) push(op *historyOp, blobs map[string][]byte) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if op.Irreversible == "" {
+		for _, c := range op.Changes {
+			if c.Pre != nil && c.Pre.SHA != "" {
+				h.retain(&h.undo, c.Pre.SHA, blobs[c.Pre.SHA])
+			}
+		}
+	}
+	h.ops = append(h.ops, op)
+	h.prune("")
+}
+
+// snapshot maps relative paths to their images
+type snapshot map[string]*fileImage
+
+// imageOf describes name; when blobs is non-nil file contents are stored in
+// it. A non-nil room bounds the bytes stored and is reduced by them.
+func imageOf(b Backend, name string, blobs map[string][]byte, room *int64) (*fileImage, error) {
+	fi, err := b.Lstat(name)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	mode := fi.Mode() & os.ModePerm
+	switch {
+	case fi.Mode()&os.ModeSymlink != 0:
+		sb, ok := b.(symlinkBackend)
+		if !ok {
+			return nil, fmt.Errorf("unsupported file type %s", kindOf(fi))
+		}
+		target, err := sb.Readlink(name)
+		if err != nil {
+			return nil, err
+		}
+		return &fileImage{Link: target}, nil
+	case fi.IsDir():
+		return &fileImage{Dir: true, Mode: mode}, nil
+	case fi.Mode().IsRegular():
+		if fi.Size() > maxHistoryFileBytes {
+			return nil, newOpError("history", name, ErrFileTooLarge)
+		}
+		if blobs == nil {
+			sha, err := hashBackendFile(b, name)
+			if err != nil {
+				return nil, err
+			}
+			return &fileImage{SHA: sha, Mode: mode}, nil
+		}
+		if room != nil {
+			if fi.Size() > *room {
+				return nil, newOpError("history", name, ErrFileTooLarge, "contents exceed the history budget")
+			}
+			*room -= fi.Size()
+		}
+		data, err := readBackendFile(b, name)
+		if err != nil {
+			return nil, err
+		}
+		sha := sha256sum(data)
+		blobs[sha] = data
+		return &fileImage{SHA: sha, Mode: mode}, nil
+	default:
+		return nil, fmt.Errorf("unsupported file type %s", kindOf(fi))
+	}
+}
+
+// capture records rel and its ancestors and, when deep, every descendant of
+// rel. File contents stored in blobs are limited as imageOf limits them.
+func capture(b Backend, rel string, deep bool, snap snapshot, blobs map[string][]byte, room *int64) error {
+	parts := strings.Split(rel, "/")
+	for i := 1; i < len(parts); i++ {
+		anc := strings.Join(parts[:i], "/")
+		if _, seen := snap[anc]; seen {
+			continue
+		}
+		img, err := imageOf(b, anc, nil, nil)
+		if err != nil {
+			return err
+		}
+		snap[anc] = img
+	}
+	img, err := imageOf(b, rel, blobs, room)
+	if err != nil {
+		return err
+	}
+	snap[rel] = img
+	if !deep || img == nil || !img.Dir {
+		return nil
+	}
+	return walkBackend(b, rel, func(name string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if name == rel {
+			return nil
+		}
+		img, err := imageOf(b, name, blobs, room)
+		if err != nil {
+			return err
+		}
+		snap[name] = img
+		return nil
+	})
+}
+
+// diffSnapshots lists the paths whose image differs between pre and post
+func diffSnapshots(pre, post snapshot) []fileChange {
+	keys := map[string]bool{}
+	for k := range pre {
+		keys[k] = true
+	}
+	for k := range post {
+		keys[k] = true
+	}
+	var out []fileChange
+	for k := range keys {
+		if !pre[k].equal(post[k]) {
+			out = append(out, fileChange{Path: k, Pre: pre[k], Post: post[k]})
+		}
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Path < out[j].Path })
+	return out
+}
+
+// errHistoryRoot reports a call on the base folder itself, whose changes
+// history cannot capture
+var errHistoryRoot = errors.New("cannot track the base folder itself")
+
+// relPaths resolves request paths to backend names
+func relPaths(b Backend, reqPaths []string) ([]string, error) {
+	var out []string
+	for _, p := range reqPaths {
+		rel, err := b.Resolve(p, false)
+		if err != nil {
+			return nil, err
+		}
+		if rel == "" {
+			return nil, fmt.Errorf("%w: %s", errHistoryRoot, p)
+		}
+		out = append(out, rel)
+	}
+	return out, nil
+}
+
+// historyScope lists the request paths a call may change and whether
+// directories among them must be captured with their contents
+type historyScope[TArgs any] func(args TArgs) (paths []string, deep bool)
+
+func writeScope(a WriteArgs) ([]string, bool)        { return []string{a.Path}, false }
+func editScope(a EditArgs) ([]string, bool)          { return []string{a.Path}, false }
+func commitScope(a WriteCommitArgs) ([]string, bool) { return []string{a.Path}, false }
+func mkdirScope(a MkdirArgs) ([]string, bool)        { return expandBraces(a.Path), false }
+func rmdirScope(a RmdirArgs) ([]string, bool)        { return []string{a.Path}, true }
+
+// historyRecorderKey carries the recorder withHistory hands to its handler
+type historyRecorderKey struct{}
+
+// historyRecorder captures the images of one call's paths before and after
+// the change
+type historyRecorder struct {
+	fs           Backend
+	rels         []string
+	deep         bool
+	room         int64
+	pre, post    snapshot
+	blobs        map[string][]byte
+	taken        bool
+	irreversible string
+}
+
+// snapshotHistory captures the pre-images for the call in ctx, if it keeps
+// history, and returns a func that captures the post-images. Handlers call it
+// once access is checked and while they hold their locks, so the pre-image
+// is neither read without permission nor taken from a concurrent writer, and
+// call the returned func before they release the locks.
+func snapshotHistory(ctx context.Context) (done func()) {
+	r, ok := ctx.Value(historyRecorderKey{}).(*historyRecorder)
+	if !ok || r.taken {
+		return func() {}
+	}
+	r.taken = true
+	for _, rel := range r.rels {
+		if err := capture(r.fs, rel, r.deep, r.pre, r.blobs, &r.room); err != nil {
+			r.irreversible = err.Error()
+			return func() {}
+		}
+	}
+	return func() {
+		for _, rel := range r.rels {
+			if err := capture(r.fs, rel, r.deep, r.post, nil, nil); err != nil {
+				r.irreversible = err.Error()
+				return
+			}
+		}
+	}
+}
+
+// withHistory records the pre-images of everything a successful mutating
+// call changes so that fs_undo can revert it. Pre-images are held to the
+// history budget; a call that would need more, or that changes the base
+// folder itself, is recorded as irreversible.
+func withHistory[TArgs any, TResult any](tool string, sessions map[string]*SessionState, mu *sync.RWMutex, h mcp.StructuredToolHandlerFunc[TArgs, TResult], scope historyScope[TArgs]) mcp.StructuredToolHandlerFunc[TArgs, TResult] {
+	return func(ctx context.Context, req mcp.CallToolRequest, args TArgs) (TResult, error) {
+		state, err := getSessionState(ctx, sessions, mu)
+		if err != nil {
+			return h(ctx, req, args)
+		}
+		store := state.history()
+		if store == nil {
+			return h(ctx, req, args)
+		}
+		reqPaths, deep := scope(args)
+		rels, err := relPaths(state.FS, reqPaths)
+		if errors.Is(err, errHistoryRoot) {
+			res, err := h(ctx, req, args)
+			if err == nil {
+				dprintf("history: %s not undoable: %s", tool, errHistoryRoot)
+				store.push(&historyOp{Tool: tool, Time: time.Now(), Irreversible: errHistoryRoot.Error()}, nil)
+			}
+			return res, err
+		}
+		if err != nil {
+			// The handler reports path errors itself
+			return h(ctx, req, args)
+		}
+		r := &historyRecorder{fs: state.FS, rels: rels, deep: deep, room: store.maxBytes, pre: snapshot{}, post: snapshot{}, blobs: map[string][]byte{}}
+		res, err := h(context.WithValue(ctx, historyRecorderKey{}, r), req, args)
+		if err != nil || !r.taken {
+			return res, err
+		}
+		if r.irreversible != "" {
+			dprintf("history: %s not undoable: %s", tool, r.irreversible)
+			store.push(&historyOp{Tool: tool, Time: time.Now(), Irreversible: r.irreversible}, nil)
+			return res, err
+		}
+		if changes := diffSnapshots(r.pre, r.post); len(changes) > 0 {
+			store.push(&historyOp{Tool: tool, Time: time.Now(), Changes: changes}, r.blobs)
+		}
+		return res, err
+	}
+}
+
+// restoreTarget is the desired image of one path
+type restoreTarget struct {
+	Path string
+	Want *fileImage
+}
+
+// lockTargets takes exclusive locks on names in sorted order, as
+// fs_overlay_commit does, so restores and commits over the same paths
+// cannot deadlock. release frees them all.
+func lockTargets(b Backend, op string, names []string) (release func(), err error) {
+	names = slices.Compact(slices.Sorted(slices.Values(names)))
+	var held []func()
+	release = func() {
+		for i := len(held) - 1; i >= 0; i-- {
+			held[i]()
+		}
+	}
+	for _, name := range names {
+		r, err := b.Lock(name, lockExclusive, currentConfig().lockTimeout())
+		if err != nil {
+			release()
+			return nil, newOpError(op, name, err)
+		}
+		held = append(held, r)
+	}
+	return release, nil
+}
+
+// applyImages brings every path to its wanted image: directories are created
+// shallowest first, then files and links are written, then removals happen
+// deepest first so directories are empty when they are removed. The caller
+// holds the locks of every target.
+func applyImages(ctx context.Context, state *SessionState, op string, targets []restoreTarget, blobs map[string][]byte) error {
+	b := state.FS
+	for _, t := range targets {
+		if err := checkAccess(ctx, state, op, capWrite, t.Path, t.Path); err != nil {
+			return err
+		}
+	}
+	var dirs, files, removals []restoreTarget
+	for _, t := range targets {
+		switch {
+		case t.Want == nil:
+			removals = append(removals, t)
+		case t.Want.Dir:
+			dirs = append(dirs, t)
+		default:
+			files = append(files, t)
+		}
+	}
+	sort.Slice(dirs, func(i, j int) bool { return dirs[i].Path < dirs[j].Path })
+	sort.Slice(removals, func(i, j int) bool { return removals[i].Path > removals[j].Path })
+
+	for _, t := range dirs {
+		if fi, err := b.Lstat(t.Path); err == nil && !fi.IsDir() {
+			if err := b.Remove(t.Path); err != nil {
+				return err
+			}
+		}
+		if err := b.MkdirAll(t.Path, t.Want.Mode); err != nil {
+			return err
+		}
+		if err := b.Chmod(t.Path, t.Want.Mode); err != nil {
+			return err
+		}
+	}
+	for _, t := range files {
+		if fi, err := b.Lstat(t.Path); err == nil && fi.IsDir() {
+			// The directory's contents are part of targets as removals
+			if err := b.RemoveAll(t.Path); err != nil {
+				return err
+			}
+		}
+		if err := ensureParentDir(b, t.Path); err != nil {
+			return err
+		}
+		if t.Want.Link != "" {
+			sb, ok := b.(symlinkBackend)
+			if !ok {
+				return newOpError(op, t.Path, errors.New("backend cannot store symlinks"))
+			}
+			_ = b.Remove(t.Path)
+			if err := sb.Symlink(t.Want.Link, t.Path); err != nil {
+				return err
+			}
+			continue
+		}
+		data, ok := blobs[t.Want.SHA]
+		if !ok {
+			return newOpError(op, t.Path, ErrPathNotFound, "pre-image no longer retained")
+		}
+		if err := b.WriteFile(t.Path, data, t.Want.Mode); err != nil {
+			return err
+		}
+	}
+	for _, t := range removals {
+		// Directories that have since gained other content are kept
+		if fi, err := b.Lstat(t.Path); err == nil && fi.IsDir() {
+			if ents, err := b.ReadDir(t.Path); err == nil && len(ents) > 0 {
+				continue
+			}
+		}
+		if err := b.Remove(t.Path); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+	}
+	return nil
+}
+
+func formatUndoResult(r UndoResult) string {
+	return fmt.Sprintf("undone=%d remaining=%d paths=%s", r.Undone, r.Remaining, strings.Join(r.Paths, ","))
+}
+
+func handleUndo(sessions map[string]*SessionState, mu *sync.RWMutex) mcp.StructuredToolHandlerFunc[UndoArgs, UndoResult] {
+	return func(ctx context.Context, req mcp.CallToolRequest, args UndoArgs) (UndoResult, error) {
+		state, err := getSessionState(ctx, sessions, mu)
+		if err != nil {
+			return UndoResult{}, err
+		}
+		start := time.Now()
+		dprintf("%s -> fs_undo steps=%d", sessionContext(ctx), args.Steps)
+		var out UndoResult
+		store := state.history()
+		if store == nil {
+			return out, errors.New("undo history is disabled")
+		}
+		steps := args.Steps
+		if steps <= 0 {
+			steps = 1
+		}
+		store.mu.Lock()
+		defer store.mu.Unlock()
+		store.prune("")
+		if steps > len(store.ops) {
+			return out, fmt.Errorf("only %d operations can be undone", len(store.ops))
+		}
+		ops := store.ops[len(store.ops)-steps:]
+
+		// Writers are held off from the check until the images are applied
+		var paths []string
+		for _, op := range ops {
+			for _, c := range op.Changes {
+				paths = append(paths, c.Path)
+			}
+		}
+		release, err := lockTargets(state.FS, "undo", paths)
+		if err != nil {
+			return out, err
+		}
+		defer release()
+
+		// Walk back from the newest operation, checking each one against the
+		// state the later undos will leave behind before touching anything.
+		virtual := map[string]*fileImage{}
+		for i := len(ops) - 1; i >= 0; i-- {
+			op := ops[i]
+			if op.Irreversible != "" {
+				return out, fmt.Errorf("cannot undo %s: %s", op.Tool, op.Irreversible)
+			}
+			for _, c := range op.Changes {
+				cur, seen := virtual[c.Path]
+				if !seen {
+					cur, err = imageOf(state.FS, c.Path, nil, nil)
+					if err != nil {
+						return out, newOpError("undo", c.Path, err)
+					}
+				}
+				if !cur.equal(c.Post) {
+					return out, newOpError("undo", c.Path, ErrFileChanged, fmt.Sprintf("modified after %s", op.Tool))
+				}
+				virtual[c.Path] = c.Pre
+			}
+		}
+		targets := make([]restoreTarget, 0, len(virtual))
+		for p, img := range virtual {
+			targets = append(targets, restoreTarget{Path: p, Want: img})
+			out.Paths = append(out.Paths, p)
+		}
+		sort.Strings(out.Paths)
+		if err := applyImages(ctx, state, "undo", targets, store.blobs); err != nil {
+			dprintf("fs_undo error: %v", err)
+			return out, err
+		}
+		for range ops {
+			store.ops = store.ops[:len(store.ops)-1]
+		}
+		for _, op := range ops {
+			for _, c := range op.Changes {
+				store.releaseImages(&store.undo, []*fileImage{c.Pre})
+			}
+		}
+		out.Undone = steps
+		out.Remaining = len(store.ops)
+		dprintf("<- fs_undo ok undone=%d paths=%d dur=%s", steps, len(out.Paths), time.Since(start))
+		return out, nil
+	}
+}
+
+func formatCheckpointResult(r CheckpointResult) string {
+	return fmt.Sprintf("name=%s files=%d bytes=%d", r.Name, r.Files, r.Bytes)
+}
+
+func handleCheckpoint(sessions map[string]*SessionState, mu *sync.RWMutex) mcp.StructuredToolHandlerFunc[CheckpointArgs, CheckpointResult] {
+	return func(ctx context.Context, req mcp.CallToolRequest, args CheckpointArgs) (CheckpointResult, error) {
+		state, err := getSessionState(ctx, sessions, mu)
+		if err != nil {
+			return CheckpointResult{}, err
+		}
+		start := time.Now()
+		dprintf("%s -> fs_checkpoint name=%q paths=%v", sessionContext(ctx), args.Name, args.Paths)
+		var out CheckpointResult
+		if args.Name == "" {
+			return out, &ValidationError{Field: "name", Value: args.Name, Message: "checkpoint name is required"}
+		}
+		if len(args.Paths) == 0 {
+			return out, ErrPathRequired
+		}
+		store := state.history()
+		if store == nil {
+			return out, errors.New("undo history is disabled")
+		}
+		rels, err := relPaths(state.FS, args.Paths)
+		if err != nil {
+			return out, err
+		}
+		for _, rel := range rels {
+			if err := checkAccess(ctx, state, "checkpoint", capRead, rel, rel); err != nil {
+				return out, err
+			}
+		}
+		snap, blobs := snapshot{}, map[string][]byte{}
+		room := store.maxBytes
+		for _, rel := range rels {
+			if err := capture(state.FS, rel, true, snap, blobs, &room); err != nil {
+				dprintf("fs_checkpoint error: %v", err)
+				return out, err
+			}
+		}
+		// Ancestors are recorded for undo bookkeeping only; a checkpoint
+		// covers the requested paths and their contents.
+		images := map[string]*fileImage{}
+		for p, img := range snap {
+			if underAny(p, rels) {
+				images[p] = img
+			}
+		}
+
+		var size int64
+		counted := map[string]bool{}
+		for _, img := range images {
+			if img != nil && img.SHA != "" {
+				out.Files++
+				out.Bytes += int64(len(blobs[img.SHA]))
+				if !counted[img.SHA] {
+					counted[img.SHA] = true
+					size += int64(len(blobs[img.SHA]))
+				}
+			}
+		}
+		if size > store.maxBytes {
+			err := newOpError("checkpoint", args.Paths[0], ErrFileTooLarge, fmt.Sprintf("checkpoint needs %d bytes; the history budget is %d", size, store.maxBytes))
+			dprintf("fs_checkpoint error: %v", err)
+			return CheckpointResult{}, err
+		}
+
+		store.mu.Lock()
+		defer store.mu.Unlock()
+		// Retain the new contents before releasing the old so shared blobs stay
+		for _, img := range images {
+			if img != nil && img.SHA != "" {
+				store.retain(&store.saved, img.SHA, blobs[img.SHA])
+			}
+		}
+		store.dropCheckpoint(args.Name)
+		store.checkpoints[args.Name] = &checkpoint{Time: time.Now(), Roots: rels, Images: images}
+		store.prune(args.Name)
+		out.Name = args.Name
+		dprintf("<- fs_checkpoint ok files=%d bytes=%d dur=%s", out.Files, out.Bytes, time.Since(start))
+		return out, nil
+	}
+}
+
+// underAny reports whether p equals or lies beneath one of roots
+func underAny(p string, roots []string) bool {
+	for _, r := range roots {
+		if p == r || strings.HasPrefix(p, r+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+func formatRestoreCheckpointResult(r RestoreCheckpointResult) string {
+	return fmt.Sprintf("name=%s restored=%s removed=%s", r.Name, strings.Join(r.Restored, ","), strings.Join(r.Removed, ","))
+}
+
+func handleRestoreCheckpoint(sessions map[string]*SessionState, mu *sync.RWMutex) mcp.StructuredToolHandlerFunc[RestoreCheckpointArgs, RestoreCheckpointResult] {
+	return func(ctx context.Context, req mcp.CallToolRequest, args RestoreCheckpointArgs) (RestoreCheckpointResult, error) {
+		state, err := getSessionState(ctx, sessions, mu)
+		if err != nil {
+			return RestoreCheckpointResult{}, err
+		}
+		start := time.Now()
+		dprintf("%s -> fs_restore_checkpoint name=%q", sessionContext(ctx), args.Name)
+		var out RestoreCheckpointResult
+		store := state.history()
+		if store == nil {
+			return out, errors.New("undo history is disabled")
+		}
+		store.mu.Lock()
+		defer store.mu.Unlock()
+		cp, ok := store.checkpoints[args.Name]
+		if !ok {
+			return out, fmt.Errorf("checkpoint %s not found", args.Name)
+		}
+		var (
+			blobs   map[string][]byte
+			targets []restoreTarget
+			changes []fileChange
+		)
+		// plan compares the roots with the checkpoint. Their contents count
+		// against the history budget as an undo's pre-images do.
+		plan := func() error {
+			cur, room := snapshot{}, store.maxBytes
+			blobs, targets, changes = map[string][]byte{}, nil, nil
+			for _, rel := range cp.Roots {
+				if err := capture(state.FS, rel, true, cur, blobs, &room); err != nil {
+					return err
+				}
+			}
+			for _, c := range diffSnapshots(cur, snapshot(cp.Images)) {
+				if underAny(c.Path, cp.Roots) {
+					targets = append(targets, restoreTarget{Path: c.Path, Want: c.Post})
+					changes = append(changes, c)
+				}
+			}
+			return nil
+		}
+		// Lock what the restore changes and plan again under the locks; a
+		// writer may have created more paths meanwhile, which are then
+		// locked too.
+		var locked []string
+		release := func() {}
+		defer func() { release() }()
+		for planned := false; ; planned = true {
+			if err := plan(); err != nil {
+				dprintf("fs_restore_checkpoint error: %v", err)
+				return out, err
+			}
+			need := slices.Clone(locked)
+			for _, t := range targets {
+				if !slices.Contains(locked, t.Path) {
+					need = append(need, t.Path)
+				}
+			}
+			if planned && len(need) == len(locked) {
+				break
+			}
+			release()
+			if release, err = lockTargets(state.FS, "restore_checkpoint", need); err != nil {
+				release = func() {}
+				dprintf("fs_restore_checkpoint error: %v", err)
+				return out, err
+			}
+			locked = need
+		}
+		for _, c := range changes {
+			if c.Post == nil {
+				out.Removed = append(out.Removed, c.Path)
+			} else {
+				out.Restored = append(out.Restored, c.Path)
+			}
+		}
+		if err := applyImages(ctx, state, "restore_checkpoint", targets, store.blobs); err != nil {
+			dprintf("fs_restore_checkpoint error: %v", err)
+			return out, err
+		}
+		if len(changes) > 0 {
+			// Record the restore so it can itself be undone
+			for _, c := range changes {
+				if c.Pre != nil && c.Pre.SHA != "" {
+					store.retain(&store.undo, c.Pre.SHA, blobs[c.Pre.SHA])
+				}
+			}
+			store.ops = append(store.ops, &historyOp{Tool: "fs_restore_checkpoint", Time: time.Now(), Changes: changes})
+			store.prune("")
+		}
+		out.Name = args.Name
+		dprintf("<- fs_restore_checkpoint ok restored=%d removed=%d dur=%s", len(out.Restored), len(out.Removed), time.Since(start))
+		return out, nil
+	}
+}
@@ -0,0 +1,314 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mark3labs/mcp-go/mcp"
+)
+
+func mustRead(t *testing.T, p string) string {
+	t.Helper()
+	b, err := os.ReadFile(p)
+	if err != nil {
+		t.Fatalf("read: %v", err)
+	}
+	return string(b)
+}
+
+func TestUndoWriteAndEdit(t *testing.T) {
+	ctx, sessions, mu, mem := memSession()
+	p := "f.txt"
+	memWrite(t, mem, p, []byte("v1"), 0o600)
+	wr := withHistory("fs_write", sessions, mu, handleWrite(sessions, mu), writeScope)
+	ed := withHistory("fs_edit", sessions, mu, handleEdit(sessions, mu), editScope)
+	undo := handleUndo(sessions, mu)
+
+	if _, err := wr(ctx, mcp.CallToolRequest{}, WriteArgs{Path: "f.txt", Content: "v2"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ed(ctx, mcp.CallToolRequest{}, EditArgs{Path: "f.txt", Pattern: "v2", Replace: "v3"}); err != nil {
+		t.Fatal(err)
+	}
+	res, err := undo(ctx, mcp.CallToolRequest{}, UndoArgs{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Undone != 1 || res.Remaining != 1 || memRead(t, mem, p) != "v2" {
+		t.Fatalf("unexpected undo result %+v content=%q", res, memRead(t, mem, p))
+	}
+	if _, err := undo(ctx, mcp.CallToolRequest{}, UndoArgs{Steps: 1}); err != nil {
+		t.Fatal(err)
+	}
+	if memRead(t, mem, p) != "v1" {
+		t.Fatalf("expected v1, got %q", memRead(t, mem, p))
+	}
+	if fi, _ := mem.Stat(p); fi.Mode()&os.ModePerm != 0o600 {
+		t.Fatalf("mode not restored: %#o", fi.Mode()&os.ModePerm)
+	}
+	if _, err := undo(ctx, mcp.CallToolRequest{}, UndoArgs{}); err == nil {
+		t.Fatal("expected error with empty history")
+	}
+}
+
+func TestUndoRemovesCreatedFileAndParents(t *testing.T) {
+	ctx, sessions, mu, mem := memSession()
+	wr := withHistory("fs_write", sessions, mu, handleWrite(sessions, mu), writeScope)
+	if _, err := wr(ctx, mcp.CallToolRequest{}, WriteArgs{Path: "a/b/new.txt", Content: "x"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := handleUndo(sessions, mu)(ctx, mcp.CallToolRequest{}, UndoArgs{}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := mem.Stat("a"); !os.IsNotExist(err) {
+		t.Fatalf("expected created directories to be removed, got %v", err)
+	}
+}
+
+func TestUndoRefusesWhenFileChanged(t *testing.T) {
+	ctx, sessions, mu, mem := memSession()
+	p := "f.txt"
+	memWrite(t, mem, p, []byte("v1"), 0o644)
+	wr := withHistory("fs_write", sessions, mu, handleWrite(sessions, mu), writeScope)
+	if _, err := wr(ctx, mcp.CallToolRequest{}, WriteArgs{Path: "f.txt", Content: "v2"}); err != nil {
+		t.Fatal(err)
+	}
+	memWrite(t, mem, p, []byte("outside change"), 0o644)
+	_, err := handleUndo(sessions, mu)(ctx, mcp.CallToolRequest{}, UndoArgs{})
+	if !errors.Is(err, ErrFileChanged) || toErrorResponse(err).Code != "CONFLICT" {
+		t.Fatalf("expected conflict, got %v", err)
+	}
+	if memRead(t, mem, p) != "outside change" {
+		t.Fatalf("file modified by refused undo")
+	}
+}
+
+func TestUndoRecursiveRmdir(t *testing.T) {
+	ctx, sessions, mu, mem := memSession()
+	memWrite(t, mem, "d/x.txt", []byte("x"), 0o644)
+	memWrite(t, mem, "d/sub/y.txt", []byte("y"), 0o640)
+	rm := withHistory("fs_rmdir", sessions, mu, handleRmdir(sessions, mu), rmdirScope)
+	if _, err := rm(ctx, mcp.CallToolRequest{}, RmdirArgs{Path: "d", Recursive: true}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := handleUndo(sessions, mu)(ctx, mcp.CallToolRequest{}, UndoArgs{}); err != nil {
+		t.Fatal(err)
+	}
+	if memRead(t, mem, "d/sub/y.txt") != "y" || memRead(t, mem, "d/x.txt") != "x" {
+		t.Fatalf("tree not restored")
+	}
+}
+
+func TestCheckpointRestore(t *testing.T) {
+	ctx, sessions, mu, mem := memSession()
+	memWrite(t, mem, "src/a.go", []byte("package a"), 0o644)
+	cp, err := handleCheckpoint(sessions, mu)(ctx, mcp.CallToolRequest{}, CheckpointArgs{Name: "before", Paths: []string{"src"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cp.Files != 1 || cp.Bytes != int64(len("package a")) {
+		t.Fatalf("unexpected checkpoint result: %+v", cp)
+	}
+
+	memWrite(t, mem, "src/a.go", []byte("broken"), 0o644)
+	memWrite(t, mem, "src/extra/b.go", []byte("package b"), 0o644)
+
+	res, err := handleRestoreCheckpoint(sessions, mu)(ctx, mcp.CallToolRequest{}, RestoreCheckpointArgs{Name: "before"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(res.Restored) != 1 || len(res.Removed) != 2 {
+		t.Fatalf("unexpected restore result: %+v", res)
+	}
+	if memRead(t, mem, "src/a.go") != "package a" {
+		t.Fatalf("content not restored")
+	}
+	if _, err := mem.Stat("src/extra"); !os.IsNotExist(err) {
+		t.Fatalf("expected new directory to be removed")
+	}
+
+	// The restore itself can be undone
+	if _, err := handleUndo(sessions, mu)(ctx, mcp.CallToolRequest{}, UndoArgs{}); err != nil {
+		t.Fatal(err)
+	}
+	if memRead(t, mem, "src/extra/b.go") != "package b" {
+		t.Fatalf("undo of restore failed")
+	}
+	if _, err := handleRestoreCheckpoint(sessions, mu)(ctx, mcp.CallToolRequest{}, RestoreCheckpointArgs{Name: "missing"}); err == nil {
+		t.Fatal("expected error for unknown checkpoint")
+	}
+}
+
+func TestHistoryStoreEviction(t *testing.T) {
+	h := newHistoryStore(10, time.Hour)
+	img := func(s string) *fileImage { return &fileImage{SHA: sha256sum([]byte(s)), Mode: 0o644} }
+	h.push(&historyOp{Tool: "a", Time: time.Now(), Changes: []fileChange{{Path: "f", Pre: img("123456"), Post: img("x")}}},
+		map[string][]byte{sha256sum([]byte("123456")): []byte("123456")})
+	h.push(&historyOp{Tool: "b", Time: time.Now(), Changes: []fileChange{{Path: "g", Pre: img("abcdef"), Post: img("y")}}},
+		map[string][]byte{sha256sum([]byte("abcdef")): []byte("abcdef")})
+	if len(h.ops) != 1 || h.ops[0].Tool != "b" || h.undo.size != 6 {
+		t.Fatalf("expected oldest op evicted, got %d ops size=%d", len(h.ops), h.undo.size)
+	}
+
+	h = newHistoryStore(1<<20, time.Millisecond)
+	h.push(&historyOp{Tool: "old", Time: time.Now().Add(-time.Second)}, nil)
+	if len(h.ops) != 0 {
+		t.Fatalf("expected aged op evicted")
+	}
+}
+
+func TestCheckpointBudgetLeavesUndo(t *testing.T) {
+	withConfig(t, func(c *ServerConfig) { c.History.MaxBytes = 16 })
+	ctx, sessions, mu, mem := memSession()
+	memWrite(t, mem, "big.txt", []byte("0123456789abcdefXYZ"), 0o644)
+	memWrite(t, mem, "small.txt", []byte("0123456789"), 0o644)
+	memWrite(t, mem, "f.txt", []byte("v1"), 0o644)
+	checkpoint := handleCheckpoint(sessions, mu)
+	wr := withHistory("fs_write", sessions, mu, handleWrite(sessions, mu), writeScope)
+
+	// A checkpoint larger than the whole budget is refused
+	if _, err := checkpoint(ctx, mcp.CallToolRequest{}, CheckpointArgs{Name: "big", Paths: []string{"big.txt"}}); !errors.Is(err, ErrFileTooLarge) {
+		t.Fatalf("oversized checkpoint: %v", err)
+	}
+	// One that fills most of it leaves the undo budget alone
+	if _, err := checkpoint(ctx, mcp.CallToolRequest{}, CheckpointArgs{Name: "small", Paths: []string{"small.txt"}}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := wr(ctx, mcp.CallToolRequest{}, WriteArgs{Path: "f.txt", Content: "v2"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := handleUndo(sessions, mu)(ctx, mcp.CallToolRequest{}, UndoArgs{}); err != nil {
+		t.Fatalf("undo after checkpoint: %v", err)
+	}
+	if memRead(t, mem, "f.txt") != "v1" {
+		t.Fatal("write not undone")
+	}
+
+	// Further checkpoints evict the oldest to stay within budget
+	memWrite(t, mem, "other.txt", []byte("abcdefghij"), 0o644)
+	if _, err := checkpoint(ctx, mcp.CallToolRequest{}, CheckpointArgs{Name: "other", Paths: []string{"other.txt"}}); err != nil {
+		t.Fatal(err)
+	}
+	h := sessions["s1"].History
+	if _, ok := h.checkpoints["small"]; ok || h.saved.size != 10 {
+		t.Fatalf("checkpoints=%v saved=%d", h.checkpoints, h.saved.size)
+	}
+}
+
+func TestHistoryCaptureBeyondBudgetIsIrreversible(t *testing.T) {
+	withConfig(t, func(c *ServerConfig) { c.History.MaxBytes = 16 })
+	ctx, sessions, mu, mem := memSession()
+	archiveTree(t, mem)
+	memWrite(t, mem, "d/a.txt", []byte("0123456789"), 0o644)
+	memWrite(t, mem, "d/b.txt", []byte("abcdefghij"), 0o644)
+	undo := handleUndo(sessions, mu)
+
+	rm := withHistory("fs_rmdir", sessions, mu, handleRmdir(sessions, mu), rmdirScope)
+	if _, err := rm(ctx, mcp.CallToolRequest{}, RmdirArgs{Path: "d", Recursive: true}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := undo(ctx, mcp.CallToolRequest{}, UndoArgs{}); err == nil || !strings.Contains(err.Error(), "history budget") {
+		t.Fatalf("undo past budget: %v", err)
+	}
+
+	// Extracting into the base folder cannot be captured at all
+	if _, err := handleArchiveCreate(sessions, mu)(ctx, mcp.CallToolRequest{}, ArchiveCreateArgs{Path: "a.zip", Include: []string{"docs"}}); err != nil {
+		t.Fatal(err)
+	}
+	extract := withHistory("fs_archive_extract", sessions, mu, handleArchiveExtract(sessions, mu), archiveExtractScope)
+	if _, err := extract(ctx, mcp.CallToolRequest{}, ArchiveExtractArgs{Path: "a.zip", Dest: ".", Overwrite: true}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := undo(ctx, mcp.CallToolRequest{}, UndoArgs{}); err == nil || !strings.Contains(err.Error(), errHistoryRoot.Error()) {
+		t.Fatalf("undo of extract into root: %v", err)
+	}
+}
+
+func TestFailedCallsLeaveHistoryUntouched(t *testing.T) {
+	withConfig(t, func(c *ServerConfig) { c.History.MaxBytes = 16 })
+	ctx, sessions, mu, mem := memSession()
+	memWrite(t, mem, "small.txt", []byte("v1"), 0o644)
+	memWrite(t, mem, "big.txt", []byte("far more than sixteen bytes"), 0o644)
+	wr := withHistory("fs_write", sessions, mu, handleWrite(sessions, mu), writeScope)
+	if _, err := wr(ctx, mcp.CallToolRequest{}, WriteArgs{Path: "small.txt", Content: "v2"}); err != nil {
+		t.Fatal(err)
+	}
+
+	// A refused write on a file beyond the budget must not block undo
+	if _, err := wr(ctx, mcp.CallToolRequest{}, WriteArgs{Path: "big.txt", Content: "x", Strategy: strategyNoClobber}); err == nil {
+		t.Fatal("expected no_clobber to fail")
+	}
+	sessions["s1"].Policy = &Policy{Rules: []PathRule{{Allow: false, Access: accessAny, Pattern: "big.txt"}}}
+	if _, err := wr(ctx, mcp.CallToolRequest{}, WriteArgs{Path: "big.txt", Content: "x"}); !errors.Is(err, ErrPermissionDenied) {
+		t.Fatalf("expected denied write, got %v", err)
+	}
+	if n := len(sessions["s1"].History.ops); n != 1 {
+		t.Fatalf("failed calls recorded %d ops", n-1)
+	}
+	if _, err := handleUndo(sessions, mu)(ctx, mcp.CallToolRequest{}, UndoArgs{}); err != nil {
+		t.Fatal(err)
+	}
+	if memRead(t, mem, "small.txt") != "v1" {
+		t.Fatalf("undo did not restore small.txt: %q", memRead(t, mem, "small.txt"))
+	}
+}
+
+func TestUndoWaitsForWriters(t *testing.T) {
+	ctx, sessions, mu, mem := memSession()
+	memWrite(t, mem, "f.txt", []byte("v1"), 0o644)
+	wr := withHistory("fs_write", sessions, mu, handleWrite(sessions, mu), writeScope)
+	if _, err := wr(ctx, mcp.CallToolRequest{}, WriteArgs{Path: "f.txt", Content: "v2"}); err != nil {
+		t.Fatal(err)
+	}
+
+	// A writer holding the file finishes before undo looks at it
+	release, err := mem.Lock("f.txt", lockExclusive, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	done := make(chan error, 1)
+	go func() {
+		_, err := handleUndo(sessions, mu)(ctx, mcp.CallToolRequest{}, UndoArgs{})
+		done <- err
+	}()
+	select {
+	case err := <-done:
+		t.Fatalf("undo did not wait for the lock: %v", err)
+	case <-time.After(50 * time.Millisecond):
+	}
+	memWrite(t, mem, "f.txt", []byte("v3"), 0o644)
+	release()
+	if err := <-done; !errors.Is(err, ErrFileChanged) {
+		t.Fatalf("undo over a concurrent write: %v", err)
+	}
+	if memRead(t, mem, "f.txt") != "v3" {
+		t.Fatal("concurrent write was overwritten")
+	}
+}
+
+func TestRestoreCheckpointWithinBudget(t *testing.T) {
+	withConfig(t, func(c *ServerConfig) { c.History.MaxBytes = 16 })
+	ctx, sessions, mu, mem := memSession()
+	memWrite(t, mem, "d/a.txt", []byte("v1"), 0o644)
+	if _, err := handleCheckpoint(sessions, mu)(ctx, mcp.CallToolRequest{}, CheckpointArgs{Name: "cp", Paths: []string{"d"}}); err != nil {
+		t.Fatal(err)
+	}
+	memWrite(t, mem, "d/big.txt", []byte("0123456789abcdefXYZ"), 0o644)
+	restore := handleRestoreCheckpoint(sessions, mu)
+	if _, err := restore(ctx, mcp.CallToolRequest{}, RestoreCheckpointArgs{Name: "cp"}); !errors.Is(err, ErrFileTooLarge) {
+		t.Fatalf("restore over budget: %v", err)
+	}
+	if _, err := mem.Lstat("d/big.txt"); err != nil {
+		t.Fatal("refused restore changed the tree")
+	}
+	memWrite(t, mem, "d/big.txt", []byte("small"), 0o644)
+	if _, err := restore(ctx, mcp.CallToolRequest{}, RestoreCheckpointArgs{Name: "cp"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := mem.Lstat("d/big.txt"); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("file added after the checkpoint kept: %v", err)
+	}
+}
@@ -0,0 +1,8 @@
+//go:build !unix
+
+package main
+
+import "io/fs"
+
+// fileInode returns 0; inode numbers are not exposed on this platform
+func fileInode(fs.FileInfo) uint64 { return 0 }
@@ -0,0 +1,321 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/mark3labs/mcp-go/mcp"
+)
+
+// lease reserves a path, or a directory and everything below it, for
+// writes by one session across calls. Other sessions' writes to it fail
+// with LOCK_TIMEOUT until the lease is released or lapses.
+type lease struct {
+	id      string
+	fs      Backend     // storage the path belongs to
+	holder  leaseHolder // who may write
+	path    string      // backend name; "" is the root
+	reqPath string      // path as the client gave it to fs_lock
+	subtree bool
+	expires time.Time
+}
+
+// leaseHolder identifies who took a lease. Every client of the "default"
+// session shares one state, so the MCP client session and the session id
+// are part of it too.
+type leaseHolder struct {
+	state   *SessionState
+	session string // session id, for conflict reports
+	client  string // MCP client session; ending it releases the lease
+}
+
+// holderOf returns the holder identity of the call in ctx made on state
+func holderOf(ctx context.Context, state *SessionState) leaseHolder {
+	return leaseHolder{state: state, session: getSessionID(ctx), client: clientSessionID(ctx)}
+}
+
+// covers reports whether writing name would touch what l reserves: the
+// path itself, anything below it for a subtree lease, or a directory
+// containing it, whose removal or rename would take the path along
+func (l *lease) covers(name string) bool {
+	return within(l.path, name) || (l.subtree && within(name, l.path))
+}
+
+// within reports whether name is dir or lies beneath it
+func within(name, dir string) bool {
+	return dir == "" || name == dir || strings.HasPrefix(name, dir+"/")
+}
+
+// leaseConflict reports a write blocked by another session's lease
+type leaseConflict struct {
+	lease lease
+}
+
+func (e *leaseConflict) Error() string {
+	return fmt.Sprintf("%v: leased by session %s until %s", ErrLockTimeout, e.lease.holder.session, e.lease.expires.UTC().Format(time.RFC3339))
+}
+
+func (e *leaseConflict) Unwrap() error { return ErrLockTimeout }
+
+// details describes the holder for ErrorResponse.Details
+func (e *leaseConflict) details() map[string]string {
+	path := e.lease.path
+	if e.lease.subtree {
+		path += "/**"
+	}
+	return map[string]string{
+		"lease_id":       e.lease.id,
+		"holder_session": e.lease.holder.session,
+		"leased_path":    path,
+		"expires_at":     e.lease.expires.UTC().Format(time.RFC3339),
+	}
+}
+
+// leaseTable holds the leases of every session. Lapsed leases are dropped
+// whenever the table is consulted.
+type leaseTable struct {
+	mu     sync.Mutex
+	leases map[string]*lease
+}
+
+var fileLeases = &leaseTable{leases: map[string]*lease{}}
+
+func (t *leaseTable) pruneLocked(now time.Time) {
+	for id, l := range t.leases {
+		if !now.Before(l.expires) {
+			delete(t.leases, id)
+		}
+	}
+}
+
+// conflictLocked returns a lease of another session on fs that covers any
+// of names
+func (t *leaseTable) conflictLocked(h leaseHolder, fs Backend, names ...string) *lease {
+	for _, l := range t.leases {
+		if l.holder == h || l.fs != fs {
+			continue
+		}
+		for _, name := range names {
+			if l.covers(name) {
+				return l
+			}
+		}
+	}
+	return nil
+}
+
+// check fails when a lease of another holder covers a write by h to name
+// in fs
+func (t *leaseTable) check(h leaseHolder, fs Backend, op, reqPath, name string) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if len(t.leases) == 0 {
+		return nil
+	}
+	t.pruneLocked(time.Now())
+	if l := t.conflictLocked(h, fs, name); l != nil {
+		return newOpError(op, reqPath, &leaseConflict{lease: *l})
+	}
+	return nil
+}
+
+// checkLease fails when another holder's lease covers a write by the call in
+// ctx to name. checkAccess includes it; handlers repeat it once they hold
+// the target's lock, since a lease may have been granted while they waited.
+func checkLease(ctx context.Context, state *SessionState, op, reqPath, name string) error {
+	return fileLeases.check(holderOf(ctx, state), state.FS, op, reqPath, name)
+}
+
+// grant leases name to the session unless another session's lease overlaps
+// it. A session asking again for a lease it holds gets it extended.
+func (t *leaseTable) grant(l *lease) (*lease, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.pruneLocked(time.Now())
+	// A lease covering the other's path in either direction overlaps
+	for _, o := range t.leases {
+		if o.holder != l.holder && o.fs == l.fs && (o.covers(l.path) || l.covers(o.path)) {
+			return nil, newOpError("lock", l.path, &leaseConflict{lease: *o})
+		}
+	}
+	for _, o := range t.leases {
+		if o.holder == l.holder && o.fs == l.fs && o.path == l.path && o.subtree == l.subtree {
+			o.expires = l.expires
+			return o, nil
+		}
+	}
+	l.id = uuid.NewString()
+	t.leases[l.id] = l
+	return l, nil
+}
+
+// lookupLocked returns the live lease id held by h
+func (t *leaseTable) lookupLocked(h leaseHolder, id string) (*lease, error) {
+	t.pruneLocked(time.Now())
+	l, ok := t.leases[id]
+	if !ok {
+		return nil, fmt.Errorf("lease %s not found or expired", id)
+	}
+	if l.holder != h {
+		return nil, newOpError("lease", l.path, ErrPermissionDenied, fmt.Sprintf("lease held by session %s", l.holder.session))
+	}
+	return l, nil
+}
+
+// renew moves the expiry of a lease held by h
+func (t *leaseTable) renew(h leaseHolder, id string, expires time.Time) (lease, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	l, err := t.lookupLocked(h, id)
+	if err != nil {
+		return lease{}, err
+	}
+	l.expires = expires
+	return *l, nil
+}
+
+// release drops a lease held by h; it reports false for unknown or lapsed
+// leases
+func (t *leaseTable) release(h leaseHolder, id string) (bool, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if _, err := t.lookupLocked(h, id); err != nil {
+		if errors.Is(err, ErrPermissionDenied) {
+			return false, err
+		}
+		return false, nil
+	}
+	delete(t.leases, id)
+	return true, nil
+}
+
+// releaseClient drops the leases taken through an MCP client session that
+// has ended
+func (t *leaseTable) releaseClient(client string) {
+	if client == "" {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for id, l := range t.leases {
+		if l.holder.client == client {
+			delete(t.leases, id)
+		}
+	}
+}
+
+// leaseTTL returns the lease duration for a requested ttl_ms
+func leaseTTL(ms int) (time.Duration, error) {
+	switch {
+	case ms == 0:
+		return defaultLeaseTTLMs * time.Millisecond, nil
+	case ms < 0 || ms > maxLeaseTTLMs:
+		return 0, &ValidationError{Field: "ttl_ms", Value: ms, Message: fmt.Sprintf("must be between 1 and %d", maxLeaseTTLMs)}
+	}
+	return time.Duration(ms) * time.Millisecond, nil
+}
+
+func leaseResult(l lease) LockResult {
+	return LockResult{
+		LeaseID:   l.id,
+		Path:      l.reqPath,
+		Subtree:   l.subtree,
+		ExpiresAt: l.expires.UTC().Format(time.RFC3339Nano),
+	}
+}
+
+func formatLockResult(r LockResult) string {
+	return fmt.Sprintf("lease_id=%s path=%s subtree=%v expires_at=%s", r.LeaseID, r.Path, r.Subtree, r.ExpiresAt)
+}
+
+func formatUnlockResult(r UnlockResult) string {
+	return fmt.Sprintf("lease_id=%s released=%v", r.LeaseID, r.Released)
+}
+
+func handleLock(sessions map[string]*SessionState, mu *sync.RWMutex) mcp.StructuredToolHandlerFunc[LockArgs, LockResult] {
+	return func(ctx context.Context, req mcp.CallToolRequest, args LockArgs) (LockResult, error) {
+		state, err := getSessionState(ctx, sessions, mu)
+		if err != nil {
+			return LockResult{}, err
+		}
+		dprintf("%s -> fs_lock path=%q subtree=%v ttl_ms=%d", sessionContext(ctx), args.Path, args.Subtree, args.TTLMs)
+		ttl, err := leaseTTL(args.TTLMs)
+		if err != nil {
+			return LockResult{}, err
+		}
+		name, err := state.FS.Resolve(args.Path, false)
+		if err != nil {
+			dprintf("fs_lock error: %v", err)
+			return LockResult{}, err
+		}
+		if err := checkAccess(ctx, state, "lock", capWrite, args.Path, name); err != nil {
+			dprintf("fs_lock error: %v", err)
+			return LockResult{}, err
+		}
+		// A sandbox's lease reserves the path in the folder it commits to
+		l := &lease{
+			fs:      state.baseFS(),
+			holder:  holderOf(ctx, state),
+			path:    name,
+			reqPath: args.Path,
+			subtree: args.Subtree,
+			expires: time.Now().Add(ttl),
+		}
+		got, err := fileLeases.grant(l)
+		if err != nil {
+			dprintf("fs_lock error: %v", err)
+			return LockResult{}, err
+		}
+		dprintf("<- fs_lock ok lease_id=%s", got.id)
+		return leaseResult(*got), nil
+	}
+}
+
+func handleLockRenew(sessions map[string]*SessionState, mu *sync.RWMutex) mcp.StructuredToolHandlerFunc[LockRenewArgs, LockResult] {
+	return func(ctx context.Context, req mcp.CallToolRequest, args LockRenewArgs) (LockResult, error) {
+		state, err := getSessionState(ctx, sessions, mu)
+		if err != nil {
+			return LockResult{}, err
+		}
+		dprintf("%s -> fs_lock_renew lease_id=%s ttl_ms=%d", sessionContext(ctx), args.LeaseID, args.TTLMs)
+		if args.LeaseID == "" {
+			return LockResult{}, &ValidationError{Field: "lease_id", Message: "lease_id required"}
+		}
+		ttl, err := leaseTTL(args.TTLMs)
+		if err != nil {
+			return LockResult{}, err
+		}
+		l, err := fileLeases.renew(holderOf(ctx, state), args.LeaseID, time.Now().Add(ttl))
+		if err != nil {
+			dprintf("fs_lock_renew error: %v", err)
+			return LockResult{}, err
+		}
+		dprintf("<- fs_lock_renew ok")
+		return leaseResult(l), nil
+	}
+}
+
+func handleUnlock(sessions map[string]*SessionState, mu *sync.RWMutex) mcp.StructuredToolHandlerFunc[UnlockArgs, UnlockResult] {
+	return func(ctx context.Context, req mcp.CallToolRequest, args UnlockArgs) (UnlockResult, error) {
+		state, err := getSessionState(ctx, sessions, mu)
+		if err != nil {
+			return UnlockResult{}, err
+		}
+		dprintf("%s -> fs_unlock lease_id=%s", sessionContext(ctx), args.LeaseID)
+		if args.LeaseID == "" {
+			return UnlockResult{}, &ValidationError{Field: "lease_id", Message: "lease_id required"}
+		}
+		ok, err := fileLeases.release(holderOf(ctx, state), args.LeaseID)
+		if err != nil {
+			dprintf("fs_unlock error: %v", err)
+			return UnlockResult{}, err
+		}
+		dprintf("<- fs_unlock ok released=%v", ok)
+		return UnlockResult{LeaseID: args.LeaseID, Released: ok}, nil
+	}
+}
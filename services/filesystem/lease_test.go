@@ -0,0 +1,224 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/mark3labs/mcp-go/mcp"
+)
+
+// twoSessions returns contexts for sessions s1 and s2 sharing one folder
+func twoSessions(t *testing.T) (ctx1, ctx2 context.Context, sessions map[string]*SessionState, mu *sync.RWMutex) {
+	ctx1, sessions, mu = testSession(t.TempDir())
+	sessions["s2"] = &SessionState{FS: sessions["s1"].FS}
+	ctx2 = withSessionManager(context.Background(), &sessionManager{id: "s2"})
+	return ctx1, ctx2, sessions, mu
+}
+
+func TestLeaseBlocksOtherSessions(t *testing.T) {
+	ctx1, ctx2, sessions, mu := twoSessions(t)
+	write := func(ctx context.Context, p string) error {
+		_, err := handleWrite(sessions, mu)(ctx, mcp.CallToolRequest{}, WriteArgs{Path: p, Content: "x", Strategy: strategyOverwrite})
+		return err
+	}
+	l, err := handleLock(sessions, mu)(ctx1, mcp.CallToolRequest{}, LockArgs{Path: "dir", Subtree: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if l.LeaseID == "" || !l.Subtree || l.ExpiresAt == "" {
+		t.Fatalf("lease = %+v", l)
+	}
+
+	err = write(ctx2, "dir/a.txt")
+	resp := toErrorResponse(err)
+	if resp.Code != "LOCK_TIMEOUT" || resp.Details["holder_session"] != "s1" || resp.Details["lease_id"] != l.LeaseID || resp.Details["leased_path"] != "dir/**" {
+		t.Fatalf("write to leased path: %+v", resp)
+	}
+	if err := write(ctx2, "other.txt"); err != nil {
+		t.Fatalf("write outside the lease: %v", err)
+	}
+	if err := write(ctx1, "dir/a.txt"); err != nil {
+		t.Fatalf("holder write: %v", err)
+	}
+	if _, err := handleRmdir(sessions, mu)(ctx2, mcp.CallToolRequest{}, RmdirArgs{Path: "dir", Recursive: true}); !errors.Is(err, ErrLockTimeout) {
+		t.Fatalf("rmdir of leased directory: %v", err)
+	}
+	if _, err := handleLock(sessions, mu)(ctx2, mcp.CallToolRequest{}, LockArgs{Path: "dir/a.txt"}); !errors.Is(err, ErrLockTimeout) {
+		t.Fatalf("overlapping lease granted: %v", err)
+	}
+	if _, err := handleUnlock(sessions, mu)(ctx2, mcp.CallToolRequest{}, UnlockArgs{LeaseID: l.LeaseID}); !errors.Is(err, ErrPermissionDenied) {
+		t.Fatalf("another session released the lease: %v", err)
+	}
+
+	// Asking again extends the same lease
+	again, err := handleLock(sessions, mu)(ctx1, mcp.CallToolRequest{}, LockArgs{Path: "dir", Subtree: true, TTLMs: 120_000})
+	if err != nil || again.LeaseID != l.LeaseID {
+		t.Fatalf("relock = %+v, %v", again, err)
+	}
+	renewed, err := handleLockRenew(sessions, mu)(ctx1, mcp.CallToolRequest{}, LockRenewArgs{LeaseID: l.LeaseID, TTLMs: 300_000})
+	if err != nil || renewed.ExpiresAt <= again.ExpiresAt || renewed.Path != l.Path {
+		t.Fatalf("renew = %+v, %v", renewed, err)
+	}
+	// Renewals report the path as the client gave it, not the resolved name
+	dotted, err := handleLock(sessions, mu)(ctx1, mcp.CallToolRequest{}, LockArgs{Path: "./other.txt"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res, err := handleLockRenew(sessions, mu)(ctx1, mcp.CallToolRequest{}, LockRenewArgs{LeaseID: dotted.LeaseID}); err != nil || res.Path != "./other.txt" {
+		t.Fatalf("renew of ./other.txt = %+v, %v", res, err)
+	}
+	if _, err := handleUnlock(sessions, mu)(ctx1, mcp.CallToolRequest{}, UnlockArgs{LeaseID: dotted.LeaseID}); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, want := range []bool{true, false} {
+		res, err := handleUnlock(sessions, mu)(ctx1, mcp.CallToolRequest{}, UnlockArgs{LeaseID: l.LeaseID})
+		if err != nil || res.Released != want {
+			t.Fatalf("unlock = %+v, %v; want released=%v", res, err, want)
+		}
+	}
+	if err := write(ctx2, "dir/a.txt"); err != nil {
+		t.Fatalf("write after release: %v", err)
+	}
+}
+
+func TestLeaseExpires(t *testing.T) {
+	ctx1, ctx2, sessions, mu := twoSessions(t)
+	l, err := handleLock(sessions, mu)(ctx1, mcp.CallToolRequest{}, LockArgs{Path: "f.txt", TTLMs: 30})
+	if err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(50 * time.Millisecond)
+	if _, err := handleWrite(sessions, mu)(ctx2, mcp.CallToolRequest{}, WriteArgs{Path: "f.txt", Content: "x"}); err != nil {
+		t.Fatalf("write after expiry: %v", err)
+	}
+	if _, err := handleLockRenew(sessions, mu)(ctx1, mcp.CallToolRequest{}, LockRenewArgs{LeaseID: l.LeaseID}); err == nil {
+		t.Fatal("lapsed lease renewed")
+	}
+	if _, err := handleLock(sessions, mu)(ctx1, mcp.CallToolRequest{}, LockArgs{Path: "f.txt", TTLMs: maxLeaseTTLMs + 1}); err == nil {
+		t.Fatal("ttl above the maximum accepted")
+	}
+}
+
+func TestLeaseCovers(t *testing.T) {
+	cases := []struct {
+		path    string
+		subtree bool
+		name    string
+		want    bool
+	}{
+		{"a/b.txt", false, "a/b.txt", true},
+		{"a/b.txt", false, "a", true}, // removing the parent removes the file
+		{"a/b.txt", false, "", true},
+		{"a/b.txt", false, "a/c.txt", false},
+		{"a/b.txt", false, "a/b.txt.bak", false},
+		{"a", false, "a/b.txt", false},
+		{"a", true, "a/b/c.txt", true},
+		{"a", true, "ab", false},
+		{"", true, "x", true},
+	}
+	for _, c := range cases {
+		l := &lease{path: c.path, subtree: c.subtree}
+		if got := l.covers(c.name); got != c.want {
+			t.Errorf("lease %q subtree=%v covers %q = %v", c.path, c.subtree, c.name, got)
+		}
+	}
+}
+
+func TestLeaseReleasedWithClient(t *testing.T) {
+	holder := leaseHolder{state: &SessionState{FS: newMemBackend()}, session: "s1", client: "c1"}
+	l, err := fileLeases.grant(&lease{fs: holder.state.FS, holder: holder, path: "f", expires: time.Now().Add(time.Minute)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var b sessionBindings
+	b.manager("c1")
+	b.drop("c1")
+	if _, err := fileLeases.renew(holder, l.id, time.Now().Add(time.Minute)); err == nil {
+		t.Fatal("lease survived its client session")
+	}
+}
+
+func TestLeaseHeldPerClient(t *testing.T) {
+	_, ts := newTestTransport(t, transportHTTP, httpAuth{})
+	a := &httpClient{t: t, url: ts.URL}
+	b := &httpClient{t: t, url: ts.URL}
+	a.initialize()
+	b.initialize()
+
+	// Both clients are on the "default" session, yet only a holds the lease
+	res := a.call(`{"jsonrpc":"2.0","id":2,"method":"tools/call","params":{"name":"fs_lock","arguments":{"path":"dir","subtree":true}}}`)
+	var lock struct {
+		StructuredContent LockResult `json:"structuredContent"`
+	}
+	if err := json.Unmarshal(res.Result, &lock); err != nil || lock.StructuredContent.LeaseID == "" {
+		t.Fatalf("fs_lock = %s, %v", res.Result, err)
+	}
+	id := lock.StructuredContent.LeaseID
+	if !b.callTool("fs_write", `{"path":"dir/a.txt","content":"x"}`) {
+		t.Fatal("other client wrote into the lease")
+	}
+	if !b.callTool("fs_lock_renew", `{"lease_id":"`+id+`"}`) {
+		t.Fatal("other client renewed the lease")
+	}
+	if !b.callTool("fs_unlock", `{"lease_id":"`+id+`"}`) {
+		t.Fatal("other client released the lease")
+	}
+	if a.callTool("fs_write", `{"path":"dir/a.txt","content":"x"}`) {
+		t.Fatal("holder could not write")
+	}
+	if a.callTool("fs_unlock", `{"lease_id":"`+id+`"}`) {
+		t.Fatal("holder could not release")
+	}
+}
+
+func TestLeaseGrantedWhileWriterWaits(t *testing.T) {
+	ctx1, ctx2, sessions, mu := twoSessions(t)
+	release, err := sessions["s1"].FS.Lock("f.txt", lockExclusive, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := make(chan error, 1)
+	go func() {
+		_, err := handleWrite(sessions, mu)(ctx2, mcp.CallToolRequest{}, WriteArgs{Path: "f.txt", Content: "x"})
+		got <- err
+	}()
+	time.Sleep(50 * time.Millisecond) // s2 has passed its access check and waits for the lock
+	if _, err := handleLock(sessions, mu)(ctx1, mcp.CallToolRequest{}, LockArgs{Path: "f.txt"}); err != nil {
+		t.Fatal(err)
+	}
+	release()
+	if err := <-got; !errors.Is(err, ErrLockTimeout) {
+		t.Fatalf("write after a lease was granted meanwhile: %v", err)
+	}
+}
+
+func TestSandboxLeaseReservesCommitTarget(t *testing.T) {
+	root := t.TempDir()
+	ctx1, sessions, mu := sandboxSession(t, root)
+	sessions["s2"] = &SessionState{FS: sessions["s1"].baseFS()}
+	ctx2 := withSessionManager(context.Background(), &sessionManager{id: "s2"})
+	req := mcp.CallToolRequest{}
+
+	if _, err := handleLock(sessions, mu)(ctx1, req, LockArgs{Path: "f.txt"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := handleWrite(sessions, mu)(ctx1, req, WriteArgs{Path: "f.txt", Content: "sandbox"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := handleWrite(sessions, mu)(ctx2, req, WriteArgs{Path: "f.txt", Content: "other"}); !errors.Is(err, ErrLockTimeout) {
+		t.Fatalf("write to a path the sandbox leased: %v", err)
+	}
+	// The sandbox's own lease does not hold up its commit
+	if _, err := handleOverlayCommit(sessions, mu)(ctx1, req, struct{}{}); err != nil {
+		t.Fatalf("commit under the session's own lease: %v", err)
+	}
+	if mustRead(t, filepath.Join(root, "f.txt")) != "sandbox" {
+		t.Fatal("sandbox change not committed")
+	}
+}
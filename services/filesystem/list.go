@@ -5,8 +5,9 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"io/fs"
 	"os"
-	"path/filepath"
+	"path"
 	"strings"
 	"sync"
 	"time"
@@ -31,26 +32,29 @@ func handleList(sessions map[string]*SessionState, mu *sync.RWMutex) mcp.Structu
 		if err != nil {
 			return ListResult{}, err
 		}
-		root := state.Root
 		start := time.Now()
 		dprintf("%s -> fs_list path=%q recursive=%v max_entries=%d", sessionContext(ctx), args.Path, args.Recursive, args.MaxEntries)
 		var out ListResult
-		base, err := safeJoinResolveFinal(root, args.Path)
+		base, err := state.FS.Resolve(args.Path, true)
 		if err != nil {
 			dprintf("fs_list error: %v", err)
 			return out, err
 		}
-		max := args.MaxEntries
-		if max <= 0 {
-			max = defaultListMaxEntries
+		if err := checkAccess(ctx, state, "list", capRead, args.Path, base); err != nil {
+			dprintf("fs_list error: %v", err)
+			return out, err
 		}
+		max := currentConfig().toolLimit("fs_list", args.MaxEntries)
 		count := 0
-		add := func(path string, fi os.FileInfo) {
+		add := func(name string, fi os.FileInfo) {
 			if count >= max {
 				return
 			}
+			if !state.listed(name) {
+				return
+			}
 			out.Entries = append(out.Entries, ListEntry{
-				Path:       filepath.ToSlash(trimUnderRoot(root, path)),
+				Path:       name,
 				Name:       fi.Name(),
 				Kind:       kindOf(fi),
 				Size:       fi.Size(),
@@ -59,14 +63,14 @@ func handleList(sessions map[string]*SessionState, mu *sync.RWMutex) mcp.Structu
 			})
 			count++
 		}
-		fi, err := os.Stat(base)
+		fi, err := state.FS.Stat(base)
 		if err != nil {
 			dprintf("fs_list stat error: %v", err)
 			return out, err
 		}
 		if fi.IsDir() {
 			if !args.Recursive {
-				ents, err := os.ReadDir(base)
+				ents, err := state.FS.ReadDir(base)
 				if err != nil {
 					dprintf("fs_list readdir error: %v", err)
 					return out, err
@@ -81,10 +85,14 @@ func handleList(sessions map[string]*SessionState, mu *sync.RWMutex) mcp.Structu
 					if err != nil {
 						continue
 					}
-					add(filepath.Join(base, e.Name()), info)
+					add(path.Join(base, e.Name()), info)
 				}
 			} else {
-				err := filepath.Walk(base, func(path string, info os.FileInfo, err error) error {
+				err := walkBackend(state.FS, base, func(name string, d fs.DirEntry, err error) error {
+					if err != nil {
+						return nil
+					}
+					info, err := d.Info()
 					if err != nil {
 						return nil
 					}
@@ -93,7 +101,10 @@ func handleList(sessions map[string]*SessionState, mu *sync.RWMutex) mcp.Structu
 						return ctx.Err()
 					default:
 					}
-					add(path, info)
+					if info.IsDir() && name != base && !state.listed(name) {
+						return fs.SkipDir
+					}
+					add(name, info)
 					if count >= max {
 						return io.EOF
 					}
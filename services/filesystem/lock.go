@@ -0,0 +1,152 @@
+package main
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+// lockMode selects how a lock is shared
+type lockMode int
+
+const (
+	lockShared    lockMode = iota // readers; any number may hold it together
+	lockExclusive                 // writers; excludes every other holder
+)
+
+func (m lockMode) String() string {
+	if m == lockShared {
+		return "shared"
+	}
+	return "exclusive"
+}
+
+// lockTable grants reader/writer locks on keys within the process. Waiting
+// writers hold off new readers so a steady stream of reads cannot starve
+// them.
+type lockTable struct {
+	mu    sync.Mutex
+	locks map[string]*lockEntry
+}
+
+type lockEntry struct {
+	readers int
+	writer  bool
+	waiting int           // goroutines blocked on this key
+	writers int           // of those, how many want exclusive access
+	changed chan struct{} // closed and replaced whenever a holder releases
+}
+
+func newLockTable() *lockTable {
+	return &lockTable{locks: map[string]*lockEntry{}}
+}
+
+// hostLocks serializes local backends on host paths, so sessions and
+// backends sharing a folder also share its locks
+var hostLocks = newLockTable()
+
+// acquire locks key in mode, waiting until deadline at most
+func (t *lockTable) acquire(key string, mode lockMode, deadline time.Time) (release func(), err error) {
+	t.mu.Lock()
+	e, ok := t.locks[key]
+	if !ok {
+		e = &lockEntry{changed: make(chan struct{})}
+		t.locks[key] = e
+	}
+	var timer *time.Timer
+	for !e.grantable(mode) {
+		if timer == nil {
+			timer = time.NewTimer(time.Until(deadline))
+			defer timer.Stop()
+			e.waiting++
+			if mode == lockExclusive {
+				e.writers++
+			}
+		}
+		changed := e.changed
+		t.mu.Unlock()
+		select {
+		case <-changed:
+			t.mu.Lock()
+		case <-timer.C:
+			t.mu.Lock()
+			e.stopWaiting(mode)
+			// Readers held back only for this writer may go ahead now
+			if mode == lockExclusive && e.writers == 0 {
+				close(e.changed)
+				e.changed = make(chan struct{})
+			}
+			t.forget(key, e)
+			t.mu.Unlock()
+			return nil, ErrLockTimeout
+		}
+	}
+	if timer != nil {
+		e.stopWaiting(mode)
+	}
+	if mode == lockExclusive {
+		e.writer = true
+	} else {
+		e.readers++
+	}
+	t.mu.Unlock()
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			t.mu.Lock()
+			defer t.mu.Unlock()
+			if mode == lockExclusive {
+				e.writer = false
+			} else {
+				e.readers--
+			}
+			close(e.changed)
+			e.changed = make(chan struct{})
+			t.forget(key, e)
+		})
+	}, nil
+}
+
+// forget drops e once nobody holds or waits for it
+func (t *lockTable) forget(key string, e *lockEntry) {
+	if !e.writer && e.readers == 0 && e.waiting == 0 {
+		delete(t.locks, key)
+	}
+}
+
+// grantable reports whether a lock in mode can be taken now. Readers that
+// did not have to wait step aside for waiting writers.
+func (e *lockEntry) grantable(mode lockMode) bool {
+	if mode == lockExclusive {
+		return !e.writer && e.readers == 0
+	}
+	return !e.writer && e.writers == 0
+}
+
+func (e *lockEntry) stopWaiting(mode lockMode) {
+	e.waiting--
+	if mode == lockExclusive {
+		e.writers--
+	}
+}
+
+// acquireLock locks the file at the host path: first against other
+// goroutines, then against other processes with OS advisory locks on a
+// per-path lock file outside the workspace and on the file itself.
+func acquireLock(path string, mode lockMode, timeout time.Duration) (release func(), err error) {
+	deadline := time.Now().Add(timeout)
+	unlockTable, err := hostLocks.acquire(path, mode, deadline)
+	if err != nil {
+		return nil, fmt.Errorf("%w after %v: %s", ErrLockTimeout, timeout, path)
+	}
+	unlockFile, err := lockFile(path, mode, deadline)
+	if err != nil {
+		unlockTable()
+		return nil, err
+	}
+	return func() {
+		unlockFile()
+		unlockTable()
+	}, nil
+}
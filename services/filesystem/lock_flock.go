@@ -0,0 +1,156 @@
+//go:build darwin || dragonfly || freebsd || linux || netbsd || openbsd
+
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"syscall"
+	"time"
+)
+
+// lockFile takes flocks for path in two places. The first is a lock file
+// named after the path in nameLockDir, which exists whether or not path
+// does, so it excludes other servers from creates and from a file an
+// atomic write has just renamed into place. The second is the file itself,
+// when it exists and can be opened, for other tools that flock files
+// directly. A lock won on a file that has since been replaced is dropped
+// and taken again on the current one.
+func lockFile(path string, mode lockMode, deadline time.Time) (unlock func(), err error) {
+	how := syscall.LOCK_SH
+	if mode == lockExclusive {
+		how = syscall.LOCK_EX
+	}
+	name, err := lockName(path, how, deadline)
+	if err != nil {
+		return nil, err
+	}
+	for {
+		// O_NONBLOCK keeps a FIFO from blocking the open
+		f, err := os.OpenFile(path, os.O_RDONLY|syscall.O_NONBLOCK, 0)
+		if errors.Is(err, fs.ErrNotExist) || errors.Is(err, fs.ErrPermission) {
+			// Nothing to lock, or a write-only file; the name lock covers it
+			return func() { unlockName(name, path) }, nil
+		}
+		if err != nil {
+			unlockName(name, path)
+			return nil, fmt.Errorf("lock %s: %w", path, err)
+		}
+		if err := flockUntil(f, how, path, deadline); err != nil {
+			_ = f.Close()
+			unlockName(name, path)
+			return nil, err
+		}
+		if sameFile(f, path) {
+			// Closing releases the flocks
+			return func() {
+				_ = f.Close()
+				unlockName(name, path)
+			}, nil
+		}
+		_ = f.Close()
+	}
+}
+
+// lockName flocks the lock file standing for path. The file is removed by
+// the last holder, so a lock won on a file that was removed meanwhile is
+// dropped and taken again on the current one.
+func lockName(path string, how int, deadline time.Time) (*os.File, error) {
+	for {
+		f, err := openNameLock(path)
+		if err != nil {
+			return nil, fmt.Errorf("lock %s: %w", path, err)
+		}
+		if err := flockUntil(f, how, path, deadline); err != nil {
+			_ = f.Close()
+			return nil, err
+		}
+		fi, err := f.Stat()
+		if err != nil {
+			_ = f.Close()
+			return nil, fmt.Errorf("lock %s: %w", path, err)
+		}
+		if cur, err := os.Lstat(nameLockPath(path)); err == nil && os.SameFile(fi, cur) {
+			return f, nil
+		}
+		_ = f.Close()
+	}
+}
+
+// unlockName releases a lock file taken by lockName and removes it when no
+// one else holds it, so lock files do not pile up for every path ever
+// locked
+func unlockName(f *os.File, path string) {
+	if syscall.Flock(int(f.Fd()), syscall.LOCK_EX|syscall.LOCK_NB) == nil {
+		_ = os.Remove(nameLockPath(path))
+	}
+	_ = f.Close()
+}
+
+// flockUntil flocks f, polling until deadline since flock cannot wait with
+// a timeout
+func flockUntil(f *os.File, how int, path string, deadline time.Time) error {
+	wait := time.Millisecond
+	for {
+		err := syscall.Flock(int(f.Fd()), how|syscall.LOCK_NB)
+		if err == nil {
+			return nil
+		}
+		if !errors.Is(err, syscall.EWOULDBLOCK) && !errors.Is(err, syscall.EINTR) {
+			return fmt.Errorf("lock %s: %w", path, err)
+		}
+		if time.Now().After(deadline) {
+			return fmt.Errorf("%w: %s is locked by another process", ErrLockTimeout, path)
+		}
+		time.Sleep(wait)
+		wait = min(wait*2, 50*time.Millisecond)
+	}
+}
+
+// nameLockDir holds the lock files standing for host paths. It is shared
+// by the servers one user runs and lies outside every workspace.
+func nameLockDir() string {
+	return filepath.Join(os.TempDir(), fmt.Sprintf("mcpfs-locks-%d", os.Getuid()))
+}
+
+// nameLockPath returns the lock file standing for path
+func nameLockPath(path string) string {
+	sum := sha256.Sum256([]byte(filepath.Clean(path)))
+	return filepath.Join(nameLockDir(), hex.EncodeToString(sum[:16]))
+}
+
+func openNameLock(path string) (*os.File, error) {
+	dir := nameLockDir()
+	if err := os.Mkdir(dir, 0o700); err != nil && !errors.Is(err, fs.ErrExist) {
+		return nil, err
+	}
+	// The temp dir is shared, so refuse a directory someone else made
+	fi, err := os.Lstat(dir)
+	if err != nil {
+		return nil, err
+	}
+	st, ok := fi.Sys().(*syscall.Stat_t)
+	if !fi.IsDir() || !ok || int(st.Uid) != os.Getuid() || fi.Mode().Perm() != 0o700 {
+		return nil, fmt.Errorf("%s must be a directory owned by uid %d with mode 0700", dir, os.Getuid())
+	}
+	return os.OpenFile(nameLockPath(path), os.O_RDWR|os.O_CREATE|syscall.O_NOFOLLOW, 0o600)
+}
+
+// sameFile reports whether path still names the open file f, or names
+// nothing at all
+func sameFile(f *os.File, path string) bool {
+	fi, err := f.Stat()
+	if err != nil {
+		return false
+	}
+	cur, err := os.Stat(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return true
+	}
+	return err == nil && os.SameFile(fi, cur)
+}
@@ -0,0 +1,173 @@
+//go:build darwin || dragonfly || freebsd || linux || netbsd || openbsd
+
+package main
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/mark3labs/mcp-go/mcp"
+)
+
+// flockFile holds a flock on path through a descriptor of its own, the way
+// another process would
+func flockFile(t *testing.T, path string, how int) *os.File {
+	t.Helper()
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := syscall.Flock(int(f.Fd()), how|syscall.LOCK_NB); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { f.Close() })
+	return f
+}
+
+func TestLockFileExcludesOtherHolders(t *testing.T) {
+	root := t.TempDir()
+	p := filepath.Join(root, "f.txt")
+	mustWrite(t, p, []byte("old"), 0o644)
+
+	held := flockFile(t, p, syscall.LOCK_SH)
+	release, err := acquireLock(p, lockShared, 100*time.Millisecond)
+	if err != nil {
+		t.Fatalf("shared locks should coexist: %v", err)
+	}
+	release()
+	if _, err := acquireLock(p, lockExclusive, 50*time.Millisecond); !errors.Is(err, ErrLockTimeout) {
+		t.Fatalf("exclusive lock over a foreign shared lock: %v", err)
+	}
+
+	// Writers report the lock timeout
+	withConfig(t, func(c *ServerConfig) { c.LockTimeout = 1 })
+	ctx, sessions, mu := testSession(root)
+	_, err = handleWrite(sessions, mu)(ctx, mcp.CallToolRequest{}, WriteArgs{Path: "f.txt", Content: "new", Strategy: strategyOverwrite})
+	if code := toErrorResponse(err).Code; code != "LOCK_TIMEOUT" {
+		t.Fatalf("write under a foreign lock: %v (%s)", err, code)
+	}
+	held.Close()
+	if _, err := handleWrite(sessions, mu)(ctx, mcp.CallToolRequest{}, WriteArgs{Path: "f.txt", Content: "new", Strategy: strategyOverwrite}); err != nil {
+		t.Fatalf("write after the lock was dropped: %v", err)
+	}
+}
+
+func TestLockFileFollowsReplacement(t *testing.T) {
+	dir := t.TempDir()
+	p := filepath.Join(dir, "f.txt")
+	mustWrite(t, p, []byte("old"), 0o644)
+	flockFile(t, p, syscall.LOCK_EX)
+
+	// An atomic write replaced the locked file; its lock no longer applies
+	mustWrite(t, p+".tmp", []byte("new"), 0o644)
+	if err := os.Rename(p+".tmp", p); err != nil {
+		t.Fatal(err)
+	}
+	release, err := acquireLock(p, lockExclusive, 100*time.Millisecond)
+	if err != nil {
+		t.Fatalf("lock on the replacement: %v", err)
+	}
+	release()
+}
+
+func TestLockFileCoversCreatesAndReplacements(t *testing.T) {
+	p := filepath.Join(t.TempDir(), "new.txt")
+	release, err := acquireLock(p, lockExclusive, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Another server locking the same missing path has to wait
+	other, err := openNameLock(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer other.Close()
+	if err := syscall.Flock(int(other.Fd()), syscall.LOCK_EX|syscall.LOCK_NB); !errors.Is(err, syscall.EWOULDBLOCK) {
+		t.Fatalf("name lock of a missing file not held: %v", err)
+	}
+
+	// Still so once the writer has renamed a new file into place
+	mustWrite(t, p+".tmp", []byte("new"), 0o644)
+	if err := os.Rename(p+".tmp", p); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := lockFile(p, lockShared, time.Now().Add(50*time.Millisecond)); !errors.Is(err, ErrLockTimeout) {
+		t.Fatalf("lock on the replacement while held: %v", err)
+	}
+	release()
+	if err := syscall.Flock(int(other.Fd()), syscall.LOCK_EX|syscall.LOCK_NB); err != nil {
+		t.Fatalf("name lock not released: %v", err)
+	}
+}
+
+func TestNameLocksAreRemovedAndGuarded(t *testing.T) {
+	t.Setenv("TMPDIR", t.TempDir())
+	dir := t.TempDir()
+	p := filepath.Join(dir, "f.txt")
+	mustWrite(t, p, []byte("x"), 0o644)
+
+	first, err := acquireLock(p, lockShared, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := acquireLock(p, lockShared, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	first()
+	if _, err := os.Stat(nameLockPath(p)); err != nil {
+		t.Fatalf("lock file removed while still held: %v", err)
+	}
+	second()
+	if entries, _ := os.ReadDir(nameLockDir()); len(entries) != 0 {
+		t.Fatalf("lock files left behind: %v", entries)
+	}
+
+	// A lock directory open to others is refused
+	if err := os.Chmod(nameLockDir(), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := acquireLock(p, lockExclusive, 50*time.Millisecond); err == nil {
+		t.Fatal("lock taken in a directory with loose permissions")
+	}
+	if err := os.Remove(nameLockDir()); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(dir, nameLockDir()); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := acquireLock(p, lockExclusive, 50*time.Millisecond); err == nil {
+		t.Fatal("lock taken through a symlinked lock directory")
+	}
+}
+
+func TestLockFileSpecialTargets(t *testing.T) {
+	t.Setenv("TMPDIR", t.TempDir())
+	dir := t.TempDir()
+
+	// Opening a FIFO for the flock must not wait for a writer
+	fifo := filepath.Join(dir, "fifo")
+	if err := syscall.Mkfifo(fifo, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	release, err := acquireLock(fifo, lockExclusive, time.Second)
+	if err != nil {
+		t.Fatalf("lock on a FIFO: %v", err)
+	}
+	release()
+
+	if os.Getuid() == 0 {
+		t.Skip("root can open write-only files")
+	}
+	wo := filepath.Join(dir, "write-only")
+	mustWrite(t, wo, []byte("x"), 0o200)
+	release, err = acquireLock(wo, lockExclusive, time.Second)
+	if err != nil {
+		t.Fatalf("lock on a write-only file: %v", err)
+	}
+	release()
+}
@@ -0,0 +1,180 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/mark3labs/mcp-go/mcp"
+)
+
+func TestLockTableModes(t *testing.T) {
+	lt := newLockTable()
+	soon := func() time.Time { return time.Now().Add(20 * time.Millisecond) }
+
+	r1, err := lt.acquire("k", lockShared, soon())
+	if err != nil {
+		t.Fatal(err)
+	}
+	r2, err := lt.acquire("k", lockShared, soon())
+	if err != nil {
+		t.Fatalf("second reader blocked: %v", err)
+	}
+	if _, err := lt.acquire("k", lockExclusive, soon()); !errors.Is(err, ErrLockTimeout) {
+		t.Fatalf("writer got a read-locked key: %v", err)
+	}
+	r1()
+	r2()
+	r2() // releasing twice is harmless
+
+	w, err := lt.acquire("k", lockExclusive, soon())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := lt.acquire("k", lockShared, soon()); !errors.Is(err, ErrLockTimeout) {
+		t.Fatalf("reader got a write-locked key: %v", err)
+	}
+	if _, err := lt.acquire("other", lockExclusive, soon()); err != nil {
+		t.Fatalf("keys interfere: %v", err)
+	}
+	w()
+	if len(lt.locks) != 1 { // "other" is still held
+		t.Errorf("lock table keeps %d entries", len(lt.locks))
+	}
+}
+
+func TestLockTableWriterPreference(t *testing.T) {
+	lt := newLockTable()
+	r, err := lt.acquire("k", lockShared, time.Now().Add(time.Second))
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := make(chan error, 1)
+	go func() {
+		release, err := lt.acquire("k", lockExclusive, time.Now().Add(5*time.Second))
+		if err == nil {
+			release()
+		}
+		got <- err
+	}()
+	for {
+		lt.mu.Lock()
+		waiting := lt.locks["k"].writers
+		lt.mu.Unlock()
+		if waiting == 1 {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+	if _, err := lt.acquire("k", lockShared, time.Now().Add(20*time.Millisecond)); !errors.Is(err, ErrLockTimeout) {
+		t.Fatalf("reader overtook a waiting writer: %v", err)
+	}
+	r()
+	if err := <-got; err != nil {
+		t.Fatalf("writer: %v", err)
+	}
+	if len(lt.locks) != 0 {
+		t.Errorf("lock table keeps %d entries", len(lt.locks))
+	}
+}
+
+func TestLockTableWriterTimeoutWakesReaders(t *testing.T) {
+	lt := newLockTable()
+	r, err := lt.acquire("k", lockShared, time.Now().Add(time.Second))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r()
+	waiting := func(n int) {
+		for {
+			lt.mu.Lock()
+			w := lt.locks["k"].waiting
+			lt.mu.Unlock()
+			if w == n {
+				return
+			}
+			time.Sleep(time.Millisecond)
+		}
+	}
+	go lt.acquire("k", lockExclusive, time.Now().Add(100*time.Millisecond))
+	waiting(1)
+	got := make(chan error, 1)
+	go func() {
+		release, err := lt.acquire("k", lockShared, time.Now().Add(5*time.Second))
+		if err == nil {
+			release()
+		}
+		got <- err
+	}()
+	waiting(2)
+
+	// Once the writer gives up, the reader held back for it goes ahead while
+	// the first reader still holds the key
+	select {
+	case err := <-got:
+		if err != nil {
+			t.Fatalf("reader: %v", err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("reader still blocked after the waiting writer timed out")
+	}
+}
+
+func TestAcquireLockLeavesNoFiles(t *testing.T) {
+	dir := t.TempDir()
+	p := filepath.Join(dir, "f.txt")
+	mustWrite(t, p, []byte("x"), 0o644)
+	release, err := acquireLock(p, lockExclusive, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := acquireLock(p, lockShared, 20*time.Millisecond); !errors.Is(err, ErrLockTimeout) {
+		t.Fatalf("shared lock during exclusive: %v", err)
+	}
+	entries, _ := os.ReadDir(dir)
+	if len(entries) != 1 {
+		t.Errorf("lock created files: %v", entries)
+	}
+	release()
+
+	// Locking a file that does not exist yet works and creates nothing
+	release, err = acquireLock(filepath.Join(dir, "new.txt"), lockExclusive, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	release()
+	if entries, _ := os.ReadDir(dir); len(entries) != 1 {
+		t.Errorf("lock created files: %v", entries)
+	}
+}
+
+func TestConcurrentNoClobberCreates(t *testing.T) {
+	ctx, sessions, mu, mem := memSession()
+	write := handleWrite(sessions, mu)
+	// Hold the lock so every writer finds the file missing before it waits
+	release, err := mem.Lock("new.txt", lockExclusive, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var wg sync.WaitGroup
+	var created atomic.Int32
+	for i := 0; i < 16; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := write(ctx, mcp.CallToolRequest{}, WriteArgs{Path: "new.txt", Content: "x", Strategy: strategyNoClobber}); err == nil {
+				created.Add(1)
+			}
+		}()
+	}
+	time.Sleep(50 * time.Millisecond)
+	release()
+	wg.Wait()
+	if n := created.Load(); n != 1 {
+		t.Fatalf("%d no_clobber writes created the file", n)
+	}
+}
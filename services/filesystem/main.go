@@ -2,27 +2,54 @@ package main
 
 import (
 	"context"
-	"flag"
 	"fmt"
 	"os"
-
-	"github.com/mark3labs/mcp-go/server"
 )
 
 func main() {
-	flag.Parse()
-	initDebug()
-	root, err := getRoot()
+	cfg, err := LoadConfig()
 	if err != nil {
+		_, _ = fmt.Fprintf(os.Stderr, "config error: %v\n", err)
+		os.Exit(2)
+	}
+	setConfig(cfg)
+	initDebug(cfg.Debug)
+	if err := initAudit(cfg.Audit); err != nil {
 		panic(err)
 	}
-	dprintf("server start root=%q debug=%v", root, debugEnabled)
+	var backend Backend
+	switch {
+	case cfg.Memfs:
+		backend = newMemBackend()
+	case archiveFormat(cfg.Root) != "":
+		if backend, err = openRootArchive(cfg.Root); err != nil {
+			panic(err)
+		}
+	default:
+		backend = newLocalBackend(cfg.Root)
+		sweepCommitStages(backend)
+	}
+	dprintf("server start root=%q memfs=%v debug=%v read_only=%v rules=%d workers=%d max_size=%d lock_timeout=%ds",
+		cfg.Root, cfg.Memfs, debugEnabled, cfg.Policy.ReadOnly, len(cfg.Policy.Rules), cfg.Workers, cfg.MaxFileSize, cfg.LockTimeout)
 
-	s := setupServer(root)
-	mgr := &sessionManager{id: "default"}
-	if err := server.ServeStdio(s, server.WithStdioContextFunc(func(ctx context.Context) context.Context {
-		return withSessionManager(ctx, mgr)
-	})); err != nil {
+	s, router := setupServer(backend)
+	go newConfigReloader(ReloadConfig).run(context.Background(), cfg.File)
+	switch cfg.HTTP.Transport {
+	case transportStdio:
+		mgr := &sessionManager{id: "default"}
+		err = serveStdio(s, router, func(ctx context.Context) context.Context {
+			return withSessionManager(ctx, mgr)
+		})
+	case transportSSE, transportHTTP:
+		err = serveHTTP(s, router, cfg.HTTP)
+	default:
+		err = fmt.Errorf("unknown transport %q: expected stdio, sse or http", cfg.HTTP.Transport)
+	}
+	closeOverlays()
+	if a, ok := backend.(*archiveBackend); ok {
+		_ = a.Close()
+	}
+	if err != nil {
 		_, _ = fmt.Fprintf(os.Stderr, "server error: %v\n", err)
 		dprintf("server error: %v", err)
 		os.Exit(1)
@@ -33,12 +33,11 @@ func TestTrimUnderRootNormalizesPath(t *testing.T) {
 }
 
 func TestReadSkipsHugeHash(t *testing.T) {
-	root := t.TempDir()
-	p := filepath.Join(root, "huge.bin")
-	if err := os.WriteFile(p, make([]byte, maxHashBytes+1), 0o644); err != nil {
+	ctx, sessions, mu, mem := memSession()
+	p := "huge.bin"
+	if err := mem.WriteFile(p, make([]byte, maxHashBytes+1), 0o644); err != nil {
 		t.Fatal(err)
 	}
-	ctx, sessions, mu := testSession(root)
 	h := handleRead(sessions, mu)
 	res, err := h(ctx, mcp.CallToolRequest{}, ReadArgs{Path: "huge.bin", MaxBytes: 1024})
 	if err != nil {
@@ -53,8 +52,7 @@ func TestReadSkipsHugeHash(t *testing.T) {
 }
 
 func TestWriteCreatesDirsByDefault(t *testing.T) {
-	root := t.TempDir()
-	ctx, sessions, mu := testSession(root)
+	ctx, sessions, mu, mem := memSession()
 	h := handleWrite(sessions, mu)
 	if _, err := h(ctx, mcp.CallToolRequest{}, WriteArgs{
 		Path:    "nested/dir/file.txt",
@@ -62,18 +60,17 @@ func TestWriteCreatesDirsByDefault(t *testing.T) {
 	}); err != nil {
 		t.Fatalf("write failed: %v", err)
 	}
-	if _, err := os.Stat(filepath.Join(root, "nested", "dir", "file.txt")); err != nil {
+	if _, err := mem.Stat("nested/dir/file.txt"); err != nil {
 		t.Fatalf("expected file to exist: %v", err)
 	}
 }
 
 func TestOverwritePreservesModeWhenEmpty(t *testing.T) {
-	root := t.TempDir()
-	p := filepath.Join(root, "f.txt")
-	if err := os.WriteFile(p, []byte("v1"), 0o600); err != nil {
+	ctx, sessions, mu, mem := memSession()
+	p := "f.txt"
+	if err := mem.WriteFile(p, []byte("v1"), 0o600); err != nil {
 		t.Fatal(err)
 	}
-	ctx, sessions, mu := testSession(root)
 	h := handleWrite(sessions, mu)
 	if _, err := h(ctx, mcp.CallToolRequest{}, WriteArgs{
 		Path:    "f.txt",
@@ -81,7 +78,7 @@ func TestOverwritePreservesModeWhenEmpty(t *testing.T) {
 	}); err != nil {
 		t.Fatal(err)
 	}
-	fi, err := os.Lstat(p)
+	fi, err := mem.Lstat(p)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -91,12 +88,11 @@ func TestOverwritePreservesModeWhenEmpty(t *testing.T) {
 }
 
 func TestOverwriteChangesModeWhenProvided(t *testing.T) {
-	root := t.TempDir()
-	p := filepath.Join(root, "f2.txt")
-	if err := os.WriteFile(p, []byte("v1"), 0o600); err != nil {
+	ctx, sessions, mu, mem := memSession()
+	p := "f2.txt"
+	if err := mem.WriteFile(p, []byte("v1"), 0o600); err != nil {
 		t.Fatal(err)
 	}
-	ctx, sessions, mu := testSession(root)
 	h := handleWrite(sessions, mu)
 	if _, err := h(ctx, mcp.CallToolRequest{}, WriteArgs{
 		Path:    "f2.txt",
@@ -105,7 +101,7 @@ func TestOverwriteChangesModeWhenProvided(t *testing.T) {
 	}); err != nil {
 		t.Fatal(err)
 	}
-	fi, err := os.Lstat(p)
+	fi, err := mem.Lstat(p)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -115,12 +111,11 @@ func TestOverwriteChangesModeWhenProvided(t *testing.T) {
 }
 
 func TestEditRegexCountConsistency(t *testing.T) {
-	root := t.TempDir()
-	p := filepath.Join(root, "t.txt")
-	if err := os.WriteFile(p, []byte("a a a"), 0o644); err != nil {
+	ctx, sessions, mu, mem := memSession()
+	p := "t.txt"
+	if err := mem.WriteFile(p, []byte("a a a"), 0o644); err != nil {
 		t.Fatal(err)
 	}
-	ctx, sessions, mu := testSession(root)
 	h := handleEdit(sessions, mu)
 	res, err := h(ctx, mcp.CallToolRequest{}, EditArgs{
 		Path:    "t.txt",
@@ -138,12 +133,11 @@ func TestEditRegexCountConsistency(t *testing.T) {
 }
 
 func TestEditRegexBackrefAll(t *testing.T) {
-	root := t.TempDir()
-	p := filepath.Join(root, "t.txt")
-	if err := os.WriteFile(p, []byte("x=1; x=2;"), 0o644); err != nil {
+	ctx, sessions, mu, mem := memSession()
+	p := "t.txt"
+	if err := mem.WriteFile(p, []byte("x=1; x=2;"), 0o644); err != nil {
 		t.Fatal(err)
 	}
-	ctx, sessions, mu := testSession(root)
 	h := handleEdit(sessions, mu)
 	res, err := h(ctx, mcp.CallToolRequest{}, EditArgs{
 		Path:    "t.txt",
@@ -158,23 +152,22 @@ func TestEditRegexBackrefAll(t *testing.T) {
 	if res.Replacements != 2 {
 		t.Fatalf("expected 2 replacements, got %d", res.Replacements)
 	}
-	b, _ := os.ReadFile(p)
+	b, _ := readBackendFile(mem, p)
 	if !regexp.MustCompile(`y=1; y=2;`).Match(b) {
 		t.Fatalf("unexpected content: %q", string(b))
 	}
 }
 
 func TestSearchLongLine(t *testing.T) {
-	root := t.TempDir()
+	ctx, sessions, mu, mem := memSession()
 	long := make([]byte, 200000)
 	for i := range long {
 		long[i] = 'x'
 	}
 	copy(long[:6], []byte("hello!"))
-	if err := os.WriteFile(filepath.Join(root, "big.txt"), long, 0o644); err != nil {
+	if err := mem.WriteFile("big.txt", long, 0o644); err != nil {
 		t.Fatal(err)
 	}
-	ctx, sessions, mu := testSession(root)
 	h := handleSearch(sessions, mu)
 	res, err := h(ctx, mcp.CallToolRequest{}, SearchArgs{Pattern: "hello"})
 	if err != nil {
@@ -185,12 +178,11 @@ func TestSearchLongLine(t *testing.T) {
 	}
 }
 
-func TestLockStale(t *testing.T) {
+func TestLockIgnoresSidecar(t *testing.T) {
+	// Sidecar lock files left by earlier versions no longer block anyone
 	p := filepath.Join(t.TempDir(), "x.txt")
 	_ = os.WriteFile(p+".lock", []byte("123\n"), 0o644)
-	old := time.Now().Add(-11 * time.Minute)
-	_ = os.Chtimes(p+".lock", old, old)
-	release, err := acquireLock(p, time.Second)
+	release, err := acquireLock(p, lockExclusive, 100*time.Millisecond)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -198,12 +190,11 @@ func TestLockStale(t *testing.T) {
 }
 
 func TestCompatReadText(t *testing.T) {
-	root := t.TempDir()
-	p := filepath.Join(root, "f.txt")
-	if err := os.WriteFile(p, []byte("hi"), 0o644); err != nil {
+	ctx, sessions, mu, mem := memSession()
+	p := "f.txt"
+	if err := mem.WriteFile(p, []byte("hi"), 0o644); err != nil {
 		t.Fatal(err)
 	}
-	ctx, sessions, mu := testSession(root)
 	handler := wrapTextHandler(handleRead(sessions, mu), formatReadResult)
 	req := mcp.CallToolRequest{Params: mcp.CallToolParams{Arguments: map[string]any{"path": "f.txt"}}}
 	res, err := handler(ctx, req)
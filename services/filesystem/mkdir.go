@@ -20,7 +20,6 @@ func handleMkdir(sessions map[string]*SessionState, mu *sync.RWMutex) mcp.Struct
 		if err != nil {
 			return MkdirResult{}, err
 		}
-		root := state.Root
 		start := time.Now()
 		dprintf("%s -> fs_mkdir path=%q mode=%s", sessionContext(ctx), args.Path, args.Mode)
 		var out MkdirResult
@@ -35,20 +34,30 @@ func handleMkdir(sessions map[string]*SessionState, mu *sync.RWMutex) mcp.Struct
 		}
 		anyCreated := false
 		var firstFi os.FileInfo
+		names := make([]string, len(paths))
 		for i, p := range paths {
-			full, err := safeJoin(root, p)
+			name, err := state.FS.Resolve(p, false)
 			if err != nil {
 				dprintf("fs_mkdir error: %v", err)
 				return out, err
 			}
+			if err := checkAccess(ctx, state, "mkdir", capWrite, p, name); err != nil {
+				dprintf("fs_mkdir error: %v", err)
+				return out, err
+			}
+			names[i] = name
+		}
+		defer snapshotHistory(ctx)()
+		for i, p := range paths {
+			name := names[i]
 			created := false
-			if fi, err := os.Lstat(full); err == nil {
+			if fi, err := state.FS.Lstat(name); err == nil {
 				if !fi.IsDir() {
 					dprintf("fs_mkdir exists but not dir")
 					return out, fmt.Errorf("exists and not a directory: %s", p)
 				}
 			} else if os.IsNotExist(err) {
-				if err := os.MkdirAll(full, mode); err != nil {
+				if err := state.FS.MkdirAll(name, mode); err != nil {
 					dprintf("fs_mkdir MkdirAll error: %v", err)
 					return out, err
 				}
@@ -57,7 +66,7 @@ func handleMkdir(sessions map[string]*SessionState, mu *sync.RWMutex) mcp.Struct
 				dprintf("fs_mkdir lstat error: %v", err)
 				return out, err
 			}
-			fi, err := os.Lstat(full)
+			fi, err := state.FS.Lstat(name)
 			if err != nil {
 				dprintf("fs_mkdir stat error: %v", err)
 				return out, err
@@ -2,15 +2,13 @@ package main
 
 import (
 	"os"
-	"path/filepath"
 	"testing"
 
 	"github.com/mark3labs/mcp-go/mcp"
 )
 
 func TestMkdirAndRmdir(t *testing.T) {
-	root := t.TempDir()
-	ctx, sessions, mu := testSession(root)
+	ctx, sessions, mu, mem := memSession()
 	mk := handleMkdir(sessions, mu)
 	rm := handleRmdir(sessions, mu)
 
@@ -18,12 +16,12 @@ func TestMkdirAndRmdir(t *testing.T) {
 	if err != nil || !res.Created {
 		t.Fatalf("mkdir failed: %+v err=%v", res, err)
 	}
-	info, err := os.Stat(filepath.Join(root, "a", "b"))
+	info, err := mem.Stat("a/b")
 	if err != nil || !info.IsDir() {
 		t.Fatalf("directory not created: %v", err)
 	}
 
-	if err := os.WriteFile(filepath.Join(root, "a", "b", "f.txt"), []byte("x"), 0o644); err != nil {
+	if err := mem.WriteFile("a/b/f.txt", []byte("x"), 0o644); err != nil {
 		t.Fatalf("write file: %v", err)
 	}
 
@@ -31,14 +29,13 @@ func TestMkdirAndRmdir(t *testing.T) {
 	if err != nil {
 		t.Fatalf("rmdir failed: %v", err)
 	}
-	if _, err := os.Stat(filepath.Join(root, "a")); !os.IsNotExist(err) {
+	if _, err := mem.Stat("a"); !os.IsNotExist(err) {
 		t.Fatalf("directory not removed: %v", err)
 	}
 }
 
 func TestMkdirBraceExpansion(t *testing.T) {
-	root := t.TempDir()
-	ctx, sessions, mu := testSession(root)
+	ctx, sessions, mu, mem := memSession()
 	mk := handleMkdir(sessions, mu)
 	pattern := "internal/agents/{dev,test,automation,security,uat}"
 	res, err := mk(ctx, mcp.CallToolRequest{}, MkdirArgs{Path: pattern})
@@ -50,8 +47,8 @@ func TestMkdirBraceExpansion(t *testing.T) {
 	}
 	dirs := []string{"dev", "test", "automation", "security", "uat"}
 	for _, d := range dirs {
-		p := filepath.Join(root, "internal", "agents", d)
-		info, err := os.Stat(p)
+		p := "internal/agents/" + d
+		info, err := mem.Stat(p)
 		if err != nil || !info.IsDir() {
 			t.Fatalf("directory %s not created: %v", d, err)
 		}
@@ -59,8 +56,7 @@ func TestMkdirBraceExpansion(t *testing.T) {
 }
 
 func TestMkdirIdempotent(t *testing.T) {
-	root := t.TempDir()
-	ctx, sessions, mu := testSession(root)
+	ctx, sessions, mu, mem := memSession()
 	mk := handleMkdir(sessions, mu)
 
 	// First call - should create directory
@@ -82,15 +78,14 @@ func TestMkdirIdempotent(t *testing.T) {
 	}
 
 	// Verify directory still exists
-	info, err := os.Stat(filepath.Join(root, "testdir"))
+	info, err := mem.Stat("testdir")
 	if err != nil || !info.IsDir() {
 		t.Fatalf("directory not found after idempotent calls: %v", err)
 	}
 }
 
 func TestRmdirIdempotent(t *testing.T) {
-	root := t.TempDir()
-	ctx, sessions, mu := testSession(root)
+	ctx, sessions, mu, mem := memSession()
 	mk := handleMkdir(sessions, mu)
 	rm := handleRmdir(sessions, mu)
 
@@ -119,7 +114,7 @@ func TestRmdirIdempotent(t *testing.T) {
 	}
 
 	// Verify directory doesn't exist
-	if _, err := os.Stat(filepath.Join(root, "testdir")); !os.IsNotExist(err) {
+	if _, err := mem.Stat("testdir"); !os.IsNotExist(err) {
 		t.Fatalf("directory still exists after removal: %v", err)
 	}
 }
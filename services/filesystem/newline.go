@@ -0,0 +1,63 @@
+package main
+
+import "strings"
+
+// Newline policies decide which line endings written text ends up with
+type newlinePolicy string
+
+const (
+	newlinePreserve newlinePolicy = "preserve" // Follow the existing file's convention
+	newlineLF       newlinePolicy = "lf"       // Store LF line endings
+	newlineCRLF     newlinePolicy = "crlf"     // Store CRLF line endings
+)
+
+// lineEndingsFor resolves a policy against the convention detected in the
+// existing file. Files with mixed or no line endings are left alone.
+func lineEndingsFor(p newlinePolicy, detected string) (string, error) {
+	switch p {
+	case "", newlinePreserve:
+		if detected == lineEndingsLF || detected == lineEndingsCRLF {
+			return detected, nil
+		}
+		return "", nil
+	case newlineLF:
+		return lineEndingsLF, nil
+	case newlineCRLF:
+		return lineEndingsCRLF, nil
+	}
+	return "", &ValidationError{Field: "newline", Value: p, Message: "expected preserve, lf or crlf"}
+}
+
+// normalizeNewlines rewrites every line break in s to the given convention;
+// any other convention leaves s unchanged
+func normalizeNewlines(s, endings string) string {
+	switch endings {
+	case lineEndingsLF:
+		return strings.ReplaceAll(s, "\r\n", "\n")
+	case lineEndingsCRLF:
+		return strings.ReplaceAll(strings.ReplaceAll(s, "\r\n", "\n"), "\n", "\r\n")
+	}
+	return s
+}
+
+// withFinalNewline terminates non-empty s with a line break in the given
+// convention
+func withFinalNewline(s, endings string) string {
+	if s == "" || strings.HasSuffix(s, "\n") {
+		return s
+	}
+	if endings == lineEndingsCRLF {
+		return s + "\r\n"
+	}
+	return s + "\n"
+}
+
+// storedLineEndings reports the line ending convention of encoded file
+// content, or "" for binary data
+func storedLineEndings(b []byte) string {
+	f, ok := detectTextFormat(b)
+	if !ok {
+		return ""
+	}
+	return f.LineEndings
+}
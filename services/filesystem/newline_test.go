@@ -0,0 +1,78 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/mark3labs/mcp-go/mcp"
+)
+
+func TestWriteFollowsLineEndings(t *testing.T) {
+	ctx, sessions, mu, mem := memSession()
+	req := mcp.CallToolRequest{}
+	p := "dos.txt"
+	memWrite(t, mem, p, []byte("one\r\ntwo"), 0o644)
+
+	res, err := handleWrite(sessions, mu)(ctx, req, WriteArgs{Path: "dos.txt", Content: "three\nfour", Strategy: strategyAppend, EnsureFinalNewline: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := memRead(t, mem, p); got != "one\r\ntwothree\r\nfour\r\n" || res.LineEndings != lineEndingsCRLF {
+		t.Fatalf("append = %q (%s)", got, res.LineEndings)
+	}
+
+	res, err = handleWrite(sessions, mu)(ctx, req, WriteArgs{Path: "dos.txt", Content: "a\r\nb\n", Newline: newlineLF})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := memRead(t, mem, p); got != "a\nb\n" || res.LineEndings != lineEndingsLF {
+		t.Fatalf("lf overwrite = %q (%s)", got, res.LineEndings)
+	}
+
+	// New files take the content as given unless a policy is named
+	if _, err := handleWrite(sessions, mu)(ctx, req, WriteArgs{Path: "new.txt", Content: "x\r\ny\n"}); err != nil {
+		t.Fatal(err)
+	}
+	if got := memRead(t, mem, "new.txt"); got != "x\r\ny\n" {
+		t.Fatalf("new file = %q", got)
+	}
+	if _, err := handleWrite(sessions, mu)(ctx, req, WriteArgs{Path: "crlf.txt", Content: "x\ny", Newline: newlineCRLF, EnsureFinalNewline: true}); err != nil {
+		t.Fatal(err)
+	}
+	if got := memRead(t, mem, "crlf.txt"); got != "x\r\ny\r\n" {
+		t.Fatalf("crlf file = %q", got)
+	}
+
+	if _, err := handleWrite(sessions, mu)(ctx, req, WriteArgs{Path: "new.txt", Content: "x", Newline: "cr"}); err == nil {
+		t.Fatal("unknown newline policy accepted")
+	}
+}
+
+func TestEditFollowsLineEndings(t *testing.T) {
+	ctx, sessions, mu, mem := memSession()
+	req := mcp.CallToolRequest{}
+	p := "dos.ini"
+	memWrite(t, mem, p, []byte("[a]\r\nk=v"), 0o644)
+
+	res, err := handleEdit(sessions, mu)(ctx, req, EditArgs{Path: "dos.ini", Pattern: "k=v", Replace: "k=v\nj=w", EnsureFinalNewline: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := memRead(t, mem, p); got != "[a]\r\nk=v\r\nj=w\r\n" || res.LineEndings != lineEndingsCRLF {
+		t.Fatalf("edit = %q (%s)", got, res.LineEndings)
+	}
+
+	res, err = handleEdit(sessions, mu)(ctx, req, EditArgs{Path: "dos.ini", Pattern: "j=w", Replace: "j=x", Newline: newlineLF})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := memRead(t, mem, p); got != "[a]\nk=v\nj=x\n" || res.LineEndings != lineEndingsLF {
+		t.Fatalf("lf edit = %q (%s)", got, res.LineEndings)
+	}
+
+	// Mixed files are not rewritten under the default policy
+	memWrite(t, mem, p, []byte("a\r\nb\nc"), 0o644)
+	res, err = handleEdit(sessions, mu)(ctx, req, EditArgs{Path: "dos.ini", Pattern: "c", Replace: "d"})
+	if err != nil || memRead(t, mem, p) != "a\r\nb\nd" || res.LineEndings != lineEndingsMixed {
+		t.Fatalf("mixed edit = %q %+v %v", memRead(t, mem, p), res, err)
+	}
+}
@@ -0,0 +1,751 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mark3labs/mcp-go/mcp"
+)
+
+// overlayBackend is the copy-on-write backend of sandbox sessions. Reads
+// fall through to lower unless a name was changed in upper, a private
+// overlay directory, or deleted, which is recorded as a whiteout.
+type overlayBackend struct {
+	mu       sync.Mutex
+	lower    Backend
+	upper    Backend
+	upperDir string          // host directory behind upper
+	whiteout map[string]bool // names hidden in the lower layer
+}
+
+// liveOverlays holds every overlay whose upper directory still exists.
+// Sandbox sessions last as long as the process, so the directories are
+// removed by closeOverlays on the way out.
+var liveOverlays = struct {
+	sync.Mutex
+	set map[*overlayBackend]bool
+}{set: map[*overlayBackend]bool{}}
+
+func newOverlayBackend(lower Backend) (*overlayBackend, error) {
+	dir, err := os.MkdirTemp("", "mcpfs-overlay-*")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create overlay directory: %w", err)
+	}
+	o := &overlayBackend{lower: lower, upper: newLocalBackend(dir), upperDir: dir, whiteout: map[string]bool{}}
+	liveOverlays.Lock()
+	liveOverlays.set[o] = true
+	liveOverlays.Unlock()
+	return o, nil
+}
+
+// close removes the upper directory along with any pending changes
+func (o *overlayBackend) close() error {
+	liveOverlays.Lock()
+	delete(liveOverlays.set, o)
+	liveOverlays.Unlock()
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.whiteout = map[string]bool{}
+	return os.RemoveAll(o.upperDir)
+}
+
+// closeOverlays removes the upper directories of all sandbox sessions
+func closeOverlays() {
+	liveOverlays.Lock()
+	open := make([]*overlayBackend, 0, len(liveOverlays.set))
+	for o := range liveOverlays.set {
+		open = append(open, o)
+	}
+	liveOverlays.Unlock()
+	for _, o := range open {
+		if err := o.close(); err != nil {
+			dprintf("overlay cleanup %s: %v", o.upperDir, err)
+		}
+	}
+}
+
+// hidden reports whether name or one of its ancestors was deleted
+func (o *overlayBackend) hidden(name string) bool {
+	for p := name; p != ""; p = parentName(p) {
+		if o.whiteout[p] {
+			return true
+		}
+	}
+	return false
+}
+
+// locateLocked returns the layer currently backing name
+func (o *overlayBackend) locateLocked(name string) (Backend, fs.FileInfo, error) {
+	if fi, err := o.upper.Lstat(name); err == nil {
+		return o.upper, fi, nil
+	}
+	if !o.hidden(name) {
+		if fi, err := o.lower.Lstat(name); err == nil {
+			return o.lower, fi, nil
+		}
+	}
+	return nil, nil, &fs.PathError{Op: "lstat", Path: name, Err: fs.ErrNotExist}
+}
+
+func (o *overlayBackend) locate(name string) (Backend, fs.FileInfo, error) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	return o.locateLocked(name)
+}
+
+// copyUpLocked makes the parents of name, and name itself when self is set,
+// exist in the upper layer with their merged content.
+func (o *overlayBackend) copyUpLocked(name string, self bool) error {
+	if name == "" {
+		return nil
+	}
+	parts := strings.Split(name, "/")
+	last := len(parts) - 1
+	if self {
+		last = len(parts)
+	}
+	for i := 1; i <= last; i++ {
+		p := strings.Join(parts[:i], "/")
+		if _, err := o.upper.Lstat(p); err == nil {
+			continue
+		}
+		layer, fi, err := o.locateLocked(p)
+		if err != nil {
+			// Nothing visible here; the caller creates it
+			return nil
+		}
+		switch {
+		case fi.IsDir():
+			if err := o.upper.MkdirAll(p, fi.Mode()&fs.ModePerm); err != nil {
+				return err
+			}
+		case fi.Mode().IsRegular() && i == len(parts):
+			data, err := readBackendFile(layer, p)
+			if err != nil {
+				return err
+			}
+			if err := o.upper.WriteFile(p, data, fi.Mode()&fs.ModePerm); err != nil {
+				return err
+			}
+		default:
+			return fmt.Errorf("cannot modify %s through a %s in a sandbox session", name, kindOf(fi))
+		}
+	}
+	return nil
+}
+
+func (o *overlayBackend) Resolve(reqPath string, followFinal bool) (string, error) {
+	name, err := o.lower.Resolve(reqPath, false)
+	if err != nil || !followFinal {
+		return name, err
+	}
+	if layer, _, err := o.locate(name); err == nil && layer == o.lower {
+		return o.lower.Resolve(reqPath, true)
+	}
+	return name, nil
+}
+
+func (o *overlayBackend) Stat(name string) (fs.FileInfo, error) {
+	layer, _, err := o.locate(name)
+	if err != nil {
+		return nil, err
+	}
+	return layer.Stat(name)
+}
+
+func (o *overlayBackend) Lstat(name string) (fs.FileInfo, error) {
+	_, fi, err := o.locate(name)
+	return fi, err
+}
+
+func (o *overlayBackend) Open(name string) (File, error) {
+	layer, _, err := o.locate(name)
+	if err != nil {
+		return nil, err
+	}
+	return layer.Open(name)
+}
+
+// ReadDir merges the upper and visible lower entries of name
+func (o *overlayBackend) ReadDir(name string) ([]fs.DirEntry, error) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if _, fi, err := o.locateLocked(name); err != nil {
+		return nil, err
+	} else if !fi.IsDir() {
+		return nil, &fs.PathError{Op: "readdir", Path: name, Err: errors.New("not a directory")}
+	}
+	byName := map[string]fs.DirEntry{}
+	if ents, err := o.upper.ReadDir(name); err == nil {
+		for _, e := range ents {
+			byName[e.Name()] = e
+		}
+	}
+	if !o.hidden(name) {
+		if ents, err := o.lower.ReadDir(name); err == nil {
+			for _, e := range ents {
+				if _, shadowed := byName[e.Name()]; shadowed || o.hidden(path.Join(name, e.Name())) {
+					continue
+				}
+				byName[e.Name()] = e
+			}
+		}
+	}
+	out := make([]fs.DirEntry, 0, len(byName))
+	for _, e := range byName {
+		out = append(out, e)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Name() < out[j].Name() })
+	return out, nil
+}
+
+func (o *overlayBackend) WriteFile(name string, data []byte, perm fs.FileMode) error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if err := o.copyUpLocked(name, false); err != nil {
+		return err
+	}
+	return o.upper.WriteFile(name, data, perm)
+}
+
+func (o *overlayBackend) AppendFile(name string, data []byte, perm fs.FileMode) error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if err := o.copyUpLocked(name, true); err != nil {
+		return err
+	}
+	return o.upper.AppendFile(name, data, perm)
+}
+
+func (o *overlayBackend) MkdirAll(name string, perm fs.FileMode) error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if err := o.copyUpLocked(name, true); err != nil {
+		return err
+	}
+	return o.upper.MkdirAll(name, perm)
+}
+
+func (o *overlayBackend) Chmod(name string, perm fs.FileMode) error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if err := o.copyUpLocked(name, true); err != nil {
+		return err
+	}
+	return o.upper.Chmod(name, perm)
+}
+
+// Rename copies the merged tree of oldName up before moving it
+func (o *overlayBackend) Rename(oldName, newName string) error {
+	var names []string
+	err := walkBackend(o, oldName, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		names = append(names, p)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	for _, p := range names {
+		if err := o.copyUpLocked(p, true); err != nil {
+			return err
+		}
+	}
+	if err := o.copyUpLocked(newName, false); err != nil {
+		return err
+	}
+	if err := o.upper.Rename(oldName, newName); err != nil {
+		return err
+	}
+	if _, err := o.lower.Lstat(oldName); err == nil {
+		o.whiteout[oldName] = true
+	}
+	return nil
+}
+
+func (o *overlayBackend) Remove(name string) error {
+	if ents, err := o.ReadDir(name); err == nil && len(ents) > 0 {
+		return &fs.PathError{Op: "remove", Path: name, Err: errors.New("directory not empty")}
+	}
+	return o.RemoveAll(name)
+}
+
+func (o *overlayBackend) RemoveAll(name string) error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if err := o.upper.RemoveAll(name); err != nil {
+		return err
+	}
+	if _, err := o.lower.Lstat(name); err == nil {
+		o.whiteout[name] = true
+	}
+	return nil
+}
+
+// Lock locks within the overlay so the base folder is left untouched
+func (o *overlayBackend) Lock(name string, mode lockMode, timeout time.Duration) (func(), error) {
+	return o.upper.Lock(name, mode, timeout)
+}
+
+// diff compares the merged view with the lower layer
+func (o *overlayBackend) diff() ([]OverlayChange, error) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	var out []OverlayChange
+	for w := range o.whiteout {
+		if _, err := o.upper.Lstat(w); err == nil {
+			continue // replaced; reported from the upper walk
+		}
+		if o.hidden(parentName(w)) {
+			continue // covered by a deleted ancestor
+		}
+		if fi, err := o.lower.Lstat(w); err == nil {
+			out = append(out, OverlayChange{Path: w, Kind: kindOf(fi), Change: "deleted"})
+		}
+	}
+	err := walkBackend(o.upper, "", func(name string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if name == "" {
+			return nil
+		}
+		fi, err := d.Info()
+		if err != nil {
+			return err
+		}
+		lowFi, lowErr := o.lower.Lstat(name)
+		change := OverlayChange{Path: name, Kind: kindOf(fi)}
+		switch {
+		case lowErr != nil:
+			change.Change = "added"
+		case kindOf(lowFi) != kindOf(fi):
+			change.Change = "modified"
+		default:
+			// Entries copied up but unchanged are not reported. Under a
+			// deleted path they are, since the commit recreates them.
+			same := !o.hidden(name) && lowFi.Mode()&fs.ModePerm == fi.Mode()&fs.ModePerm
+			if same && !fi.IsDir() {
+				same = sameContent(o.upper, o.lower, name)
+			}
+			if same {
+				return nil
+			}
+			change.Change = "modified"
+		}
+		out = append(out, change)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Path < out[j].Path })
+	return out, nil
+}
+
+func sameContent(a, b Backend, name string) bool {
+	ha, errA := hashBackendFile(a, name)
+	hb, errB := hashBackendFile(b, name)
+	return errA == nil && errB == nil && ha == hb
+}
+
+// commit applies the overlay to the lower layer. File contents are staged
+// inside the lower layer first; the tree is then updated with deletions,
+// directory creation and renames. Paths being replaced or deleted are moved
+// into the staging directory rather than removed, so a failure part way
+// through moves everything back and leaves the lower layer as it was.
+// Every changed path is locked against other writers for the duration.
+func (o *overlayBackend) commit(ctx context.Context, state *SessionState) ([]OverlayChange, error) {
+	changes, err := o.diff()
+	if err != nil {
+		return nil, err
+	}
+	// Deletions remove the lower tree, so check that rather than the merged
+	// view. Leases on lower paths are checked for the calling session, whose
+	// own fs_lock leases reserve them.
+	base := &SessionState{FS: o.lower, Policy: state.Policy, Caps: state.Caps}
+	holder := holderOf(ctx, state)
+	for _, c := range changes {
+		if err := checkAccess(ctx, state, "overlay_commit", capWrite, c.Path, c.Path); err != nil {
+			return nil, err
+		}
+		if err := fileLeases.check(holder, o.lower, "overlay_commit", c.Path, c.Path); err != nil {
+			return nil, err
+		}
+		if c.Change == "deleted" || o.whiteout[c.Path] {
+			if err := checkTreeWritable(base, "overlay_commit", c.Path, c.Path); err != nil {
+				return nil, err
+			}
+		}
+	}
+	// Changes are sorted by path, so concurrent commits lock in the same order
+	for _, c := range changes {
+		release, err := o.lower.Lock(c.Path, lockExclusive, currentConfig().lockTimeout())
+		if err != nil {
+			return nil, newOpError("overlay_commit", c.Path, err)
+		}
+		defer release()
+		// A lease may have been granted while the lock was awaited
+		if err := fileLeases.check(holder, o.lower, "overlay_commit", c.Path, c.Path); err != nil {
+			return nil, err
+		}
+	}
+
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	// The stage is locked while in use so sweepCommitStages leaves it alone
+	stage := fmt.Sprintf("%s%d", commitStagePrefix, time.Now().UnixNano())
+	releaseStage, err := o.lower.Lock(stage, lockExclusive, currentConfig().lockTimeout())
+	if err != nil {
+		return nil, newOpError("overlay_commit", stage, err)
+	}
+	defer releaseStage()
+	if err := o.lower.MkdirAll(stage, 0o700); err != nil {
+		return nil, fmt.Errorf("failed to create staging directory: %w", err)
+	}
+	keepStage := false
+	defer func() {
+		if !keepStage {
+			_ = o.lower.RemoveAll(stage)
+		}
+	}()
+	staged := map[string]string{}
+	for i, c := range changes {
+		if c.Kind != "file" || c.Change == "deleted" {
+			continue
+		}
+		fi, err := o.upper.Lstat(c.Path)
+		if err != nil {
+			return nil, err
+		}
+		data, err := readBackendFile(o.upper, c.Path)
+		if err != nil {
+			return nil, err
+		}
+		tmp := path.Join(stage, fmt.Sprint(i))
+		if err := o.lower.WriteFile(tmp, data, fi.Mode()&fs.ModePerm); err != nil {
+			return nil, fmt.Errorf("failed to stage %s: %w", c.Path, err)
+		}
+		staged[c.Path] = tmp
+	}
+
+	tx := &commitTx{lower: o.lower, stage: stage}
+	if err := o.applyLocked(tx, changes, staged); err != nil {
+		if rerr := tx.rollback(); rerr != nil {
+			dprintf("overlay commit rollback failed: %v", rerr)
+			keepStage = true
+			return nil, fmt.Errorf("%w; rolling back failed, moved paths remain in %s: %v", err, stage, rerr)
+		}
+		return nil, err
+	}
+	if err := o.resetLocked(); err != nil {
+		return nil, err
+	}
+	return changes, nil
+}
+
+// commitStagePrefix starts the names of the directories commits stage into
+const commitStagePrefix = ".mcpfs-commit-"
+
+// sweepCommitStages removes staging directories in the root of b that no
+// running commit holds, left by a server that exited mid-commit. A stage
+// that still holds paths moved aside from the tree may be their only copy,
+// so it is kept and reported instead.
+func sweepCommitStages(b Backend) {
+	entries, err := b.ReadDir("")
+	if err != nil {
+		return
+	}
+	for _, e := range entries {
+		if !e.IsDir() || !strings.HasPrefix(e.Name(), commitStagePrefix) {
+			continue
+		}
+		release, err := b.Lock(e.Name(), lockExclusive, 0)
+		if err != nil {
+			continue // a commit is using it
+		}
+		inner, err := b.ReadDir(e.Name())
+		aside := slices.ContainsFunc(inner, func(d fs.DirEntry) bool { return strings.HasPrefix(d.Name(), "old-") })
+		switch {
+		case err != nil:
+			dprintf("overlay stage sweep %s: %v", e.Name(), err)
+		case aside:
+			_, _ = fmt.Fprintf(os.Stderr, "overlay: %s holds paths an unfinished commit moved aside; restore or remove it by hand\n", e.Name())
+		default:
+			if err := b.RemoveAll(e.Name()); err != nil {
+				dprintf("overlay stage sweep %s: %v", e.Name(), err)
+			}
+		}
+		release()
+	}
+}
+
+// applyLocked updates the lower layer through tx
+func (o *overlayBackend) applyLocked(tx *commitTx, changes []OverlayChange, staged map[string]string) error {
+	whiteouts := make([]string, 0, len(o.whiteout))
+	for w := range o.whiteout {
+		whiteouts = append(whiteouts, w)
+	}
+	sort.Strings(whiteouts)
+	for _, w := range whiteouts {
+		if err := tx.setAside(w); err != nil {
+			return err
+		}
+	}
+	for _, c := range changes {
+		if c.Change == "deleted" {
+			continue
+		}
+		switch c.Kind {
+		case "dir":
+			fi, err := o.upper.Lstat(c.Path)
+			if err != nil {
+				return err
+			}
+			if lfi, err := o.lower.Lstat(c.Path); err == nil && !lfi.IsDir() {
+				if err := tx.setAside(c.Path); err != nil {
+					return err
+				}
+			}
+			if err := tx.mkdirAll(c.Path, fi.Mode()&fs.ModePerm); err != nil {
+				return err
+			}
+			if err := tx.chmod(c.Path, fi.Mode()&fs.ModePerm); err != nil {
+				return err
+			}
+		case "file":
+			if dir := parentName(c.Path); dir != "" {
+				if err := tx.mkdirAll(dir, 0o755); err != nil {
+					return fmt.Errorf("failed to create parent directories: %w", err)
+				}
+			}
+			if err := tx.setAside(c.Path); err != nil {
+				return err
+			}
+			if err := tx.rename(staged[c.Path], c.Path); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// commitTx records the steps of a commit so they can be reverted
+type commitTx struct {
+	lower Backend
+	stage string
+	aside int
+	undo  []func() error
+}
+
+// setAside moves name, if it exists, into the staging directory
+func (tx *commitTx) setAside(name string) error {
+	if _, err := tx.lower.Lstat(name); err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil
+		}
+		return err
+	}
+	tmp := path.Join(tx.stage, fmt.Sprintf("old-%d", tx.aside))
+	tx.aside++
+	if err := tx.lower.Rename(name, tmp); err != nil {
+		return err
+	}
+	tx.undo = append(tx.undo, func() error { return tx.lower.Rename(tmp, name) })
+	return nil
+}
+
+// mkdirAll creates dir and the parents it lacks
+func (tx *commitTx) mkdirAll(dir string, perm fs.FileMode) error {
+	var created []string // deepest first
+	for p := dir; p != ""; p = parentName(p) {
+		if _, err := tx.lower.Lstat(p); err == nil {
+			break
+		}
+		created = append(created, p)
+	}
+	if len(created) == 0 {
+		return nil
+	}
+	if err := tx.lower.MkdirAll(dir, perm); err != nil {
+		return err
+	}
+	tx.undo = append(tx.undo, func() error {
+		for _, p := range created {
+			if err := tx.lower.Remove(p); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	return nil
+}
+
+func (tx *commitTx) chmod(name string, perm fs.FileMode) error {
+	fi, err := tx.lower.Lstat(name)
+	if err != nil {
+		return err
+	}
+	if err := tx.lower.Chmod(name, perm); err != nil {
+		return err
+	}
+	old := fi.Mode() & fs.ModePerm
+	tx.undo = append(tx.undo, func() error { return tx.lower.Chmod(name, old) })
+	return nil
+}
+
+func (tx *commitTx) rename(from, to string) error {
+	if err := tx.lower.Rename(from, to); err != nil {
+		return err
+	}
+	tx.undo = append(tx.undo, func() error { return tx.lower.Rename(to, from) })
+	return nil
+}
+
+// rollback reverts the recorded steps, newest first
+func (tx *commitTx) rollback() error {
+	var errs []error
+	for i := len(tx.undo) - 1; i >= 0; i-- {
+		if err := tx.undo[i](); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// reset drops every pending change
+func (o *overlayBackend) reset() error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	return o.resetLocked()
+}
+
+func (o *overlayBackend) resetLocked() error {
+	ents, err := o.upper.ReadDir("")
+	if err != nil {
+		return err
+	}
+	for _, e := range ents {
+		if err := o.upper.RemoveAll(e.Name()); err != nil {
+			return err
+		}
+	}
+	o.whiteout = map[string]bool{}
+	return nil
+}
+
+// sandbox returns the overlay of a sandbox session, or nil
+func (s *SessionState) sandbox() *overlayBackend {
+	o, _ := s.FS.(*overlayBackend)
+	return o
+}
+
+// baseFS returns the backend a sandbox session is layered on, or FS itself
+func (s *SessionState) baseFS() Backend {
+	if o := s.sandbox(); o != nil {
+		return o.lower
+	}
+	return s.FS
+}
+
+func formatOverlayResult(r OverlayResult) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "action=%s changes=%d", r.Action, len(r.Changes))
+	for _, c := range r.Changes {
+		fmt.Fprintf(&b, "\n%s %s %s", c.Change, c.Kind, c.Path)
+	}
+	return b.String()
+}
+
+func sandboxOverlay(state *SessionState) (*overlayBackend, error) {
+	o := state.sandbox()
+	if o == nil {
+		return nil, errors.New("session is not a sandbox; create one with createsession sandbox=true")
+	}
+	return o, nil
+}
+
+func handleOverlayDiff(sessions map[string]*SessionState, mu *sync.RWMutex) mcp.StructuredToolHandlerFunc[struct{}, OverlayResult] {
+	return func(ctx context.Context, req mcp.CallToolRequest, _ struct{}) (OverlayResult, error) {
+		state, err := getSessionState(ctx, sessions, mu)
+		if err != nil {
+			return OverlayResult{}, err
+		}
+		start := time.Now()
+		dprintf("%s -> fs_overlay_diff", sessionContext(ctx))
+		o, err := sandboxOverlay(state)
+		if err != nil {
+			return OverlayResult{}, err
+		}
+		changes, err := o.diff()
+		if err != nil {
+			dprintf("fs_overlay_diff error: %v", err)
+			return OverlayResult{}, err
+		}
+		dprintf("<- fs_overlay_diff ok changes=%d dur=%s", len(changes), time.Since(start))
+		return OverlayResult{Action: "diff", Changes: changes}, nil
+	}
+}
+
+func handleOverlayCommit(sessions map[string]*SessionState, mu *sync.RWMutex) mcp.StructuredToolHandlerFunc[struct{}, OverlayResult] {
+	return func(ctx context.Context, req mcp.CallToolRequest, _ struct{}) (OverlayResult, error) {
+		state, err := getSessionState(ctx, sessions, mu)
+		if err != nil {
+			return OverlayResult{}, err
+		}
+		start := time.Now()
+		dprintf("%s -> fs_overlay_commit", sessionContext(ctx))
+		o, err := sandboxOverlay(state)
+		if err != nil {
+			return OverlayResult{}, err
+		}
+		if p := state.policy(); !state.Caps.has(capWrite) || (p != nil && p.ReadOnly) {
+			return OverlayResult{}, newOpError("overlay_commit", "", ErrPermissionDenied, "session cannot write to the base folder")
+		}
+		changes, err := o.commit(ctx, state)
+		if err != nil {
+			dprintf("fs_overlay_commit error: %v", err)
+			return OverlayResult{}, err
+		}
+		dprintf("<- fs_overlay_commit ok changes=%d dur=%s", len(changes), time.Since(start))
+		return OverlayResult{Action: "commit", Changes: changes}, nil
+	}
+}
+
+func handleOverlayDiscard(sessions map[string]*SessionState, mu *sync.RWMutex) mcp.StructuredToolHandlerFunc[struct{}, OverlayResult] {
+	return func(ctx context.Context, req mcp.CallToolRequest, _ struct{}) (OverlayResult, error) {
+		state, err := getSessionState(ctx, sessions, mu)
+		if err != nil {
+			return OverlayResult{}, err
+		}
+		start := time.Now()
+		dprintf("%s -> fs_overlay_discard", sessionContext(ctx))
+		o, err := sandboxOverlay(state)
+		if err != nil {
+			return OverlayResult{}, err
+		}
+		changes, err := o.diff()
+		if err != nil {
+			return OverlayResult{}, err
+		}
+		if err := o.reset(); err != nil {
+			dprintf("fs_overlay_discard error: %v", err)
+			return OverlayResult{}, err
+		}
+		dprintf("<- fs_overlay_discard ok changes=%d dur=%s", len(changes), time.Since(start))
+		return OverlayResult{Action: "discard", Changes: changes}, nil
+	}
+}
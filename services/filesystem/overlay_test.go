@@ -0,0 +1,351 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/mark3labs/mcp-go/mcp"
+)
+
+// sandboxSession is testSession with the session in sandbox mode
+func sandboxSession(t *testing.T, root string) (context.Context, map[string]*SessionState, *sync.RWMutex) {
+	t.Helper()
+	ctx, sessions, mu := testSession(root)
+	o, err := newOverlayBackend(newLocalBackend(root))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { o.close() })
+	sessions["s1"].FS = o
+	return ctx, sessions, mu
+}
+
+func changeSet(changes []OverlayChange) map[string]string {
+	m := map[string]string{}
+	for _, c := range changes {
+		m[c.Path] = c.Change
+	}
+	return m
+}
+
+func TestSandboxMergedView(t *testing.T) {
+	root := t.TempDir()
+	mustWrite(t, filepath.Join(root, "keep.txt"), []byte("keep needle"), 0o644)
+	mustWrite(t, filepath.Join(root, "edit.txt"), []byte("old needle"), 0o644)
+	mustWrite(t, filepath.Join(root, "gone", "x.txt"), []byte("x needle"), 0o644)
+	ctx, sessions, mu := sandboxSession(t, root)
+	req := mcp.CallToolRequest{}
+
+	if _, err := handleEdit(sessions, mu)(ctx, req, EditArgs{Path: "edit.txt", Pattern: "old", Replace: "new"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := handleWrite(sessions, mu)(ctx, req, WriteArgs{Path: "sub/new.txt", Content: "fresh needle"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := handleRmdir(sessions, mu)(ctx, req, RmdirArgs{Path: "gone", Recursive: true}); err != nil {
+		t.Fatal(err)
+	}
+
+	// The base folder is untouched
+	if mustRead(t, filepath.Join(root, "edit.txt")) != "old needle" {
+		t.Fatal("edit leaked into base folder")
+	}
+	if _, err := os.Stat(filepath.Join(root, "sub")); !os.IsNotExist(err) {
+		t.Fatal("write leaked into base folder")
+	}
+	if _, err := os.Stat(filepath.Join(root, "gone", "x.txt")); err != nil {
+		t.Fatal("rmdir leaked into base folder")
+	}
+
+	rd, err := handleRead(sessions, mu)(ctx, req, ReadArgs{Path: "edit.txt"})
+	if err != nil || rd.Content != "new needle" {
+		t.Fatalf("read through overlay: %q %v", rd.Content, err)
+	}
+	if _, err := handleRead(sessions, mu)(ctx, req, ReadArgs{Path: "gone/x.txt"}); !os.IsNotExist(err) {
+		t.Fatalf("expected deleted file to be hidden, got %v", err)
+	}
+
+	ls, err := handleList(sessions, mu)(ctx, req, ListArgs{Path: ".", Recursive: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var listed []string
+	for _, e := range ls.Entries {
+		listed = append(listed, e.Path)
+	}
+	sort.Strings(listed)
+	want := []string{"", "edit.txt", "keep.txt", "sub", "sub/new.txt"}
+	if len(listed) != len(want) {
+		t.Fatalf("merged listing = %v, want %v", listed, want)
+	}
+	for i := range want {
+		if listed[i] != want[i] {
+			t.Fatalf("merged listing = %v, want %v", listed, want)
+		}
+	}
+
+	gl, err := handleGlob(sessions, mu)(ctx, req, GlobArgs{Pattern: "**/*.txt"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(gl.Matches) != 3 {
+		t.Fatalf("expected 3 glob matches, got %v", gl.Matches)
+	}
+
+	sr, err := handleSearch(sessions, mu)(ctx, req, SearchArgs{Pattern: "needle"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	found := map[string]string{}
+	for _, m := range sr.Matches {
+		found[m.Path] = m.Text
+	}
+	if len(found) != 3 || found["edit.txt"] != "new needle" {
+		t.Fatalf("unexpected search matches: %v", found)
+	}
+}
+
+func TestSandboxDiffAndCommit(t *testing.T) {
+	root := t.TempDir()
+	mustWrite(t, filepath.Join(root, "a.txt"), []byte("a"), 0o644)
+	mustWrite(t, filepath.Join(root, "same.txt"), []byte("same"), 0o644)
+	mustWrite(t, filepath.Join(root, "old", "y.txt"), []byte("y"), 0o644)
+	ctx, sessions, mu := sandboxSession(t, root)
+	req := mcp.CallToolRequest{}
+
+	if _, err := handleWrite(sessions, mu)(ctx, req, WriteArgs{Path: "a.txt", Content: "A"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := handleWrite(sessions, mu)(ctx, req, WriteArgs{Path: "same.txt", Content: "same"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := handleMkdir(sessions, mu)(ctx, req, MkdirArgs{Path: "newdir"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := handleRmdir(sessions, mu)(ctx, req, RmdirArgs{Path: "old", Recursive: true}); err != nil {
+		t.Fatal(err)
+	}
+
+	diff, err := handleOverlayDiff(sessions, mu)(ctx, req, struct{}{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := changeSet(diff.Changes)
+	if len(got) != 3 || got["a.txt"] != "modified" || got["newdir"] != "added" || got["old"] != "deleted" {
+		t.Fatalf("unexpected diff: %+v", diff.Changes)
+	}
+
+	if _, err := handleOverlayCommit(sessions, mu)(ctx, req, struct{}{}); err != nil {
+		t.Fatal(err)
+	}
+	if mustRead(t, filepath.Join(root, "a.txt")) != "A" {
+		t.Fatal("modification not committed")
+	}
+	if fi, err := os.Stat(filepath.Join(root, "newdir")); err != nil || !fi.IsDir() {
+		t.Fatalf("directory not committed: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(root, "old")); !os.IsNotExist(err) {
+		t.Fatalf("deletion not committed: %v", err)
+	}
+	ents, _ := os.ReadDir(root)
+	for _, e := range ents {
+		if filepath.Ext(e.Name()) != ".txt" && e.Name() != "newdir" {
+			t.Fatalf("staging leftovers in base folder: %s", e.Name())
+		}
+	}
+	diff, err = handleOverlayDiff(sessions, mu)(ctx, req, struct{}{})
+	if err != nil || len(diff.Changes) != 0 {
+		t.Fatalf("expected empty overlay after commit, got %+v %v", diff.Changes, err)
+	}
+}
+
+func TestSandboxRecreateAfterDelete(t *testing.T) {
+	root := t.TempDir()
+	mustWrite(t, filepath.Join(root, "d", "old.txt"), []byte("old"), 0o644)
+	ctx, sessions, mu := sandboxSession(t, root)
+	req := mcp.CallToolRequest{}
+
+	if _, err := handleRmdir(sessions, mu)(ctx, req, RmdirArgs{Path: "d", Recursive: true}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := handleWrite(sessions, mu)(ctx, req, WriteArgs{Path: "d/new.txt", Content: "new"}); err != nil {
+		t.Fatal(err)
+	}
+	ls, err := handleList(sessions, mu)(ctx, req, ListArgs{Path: "d"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ls.Entries) != 1 || ls.Entries[0].Name != "new.txt" {
+		t.Fatalf("deleted entries visible in recreated directory: %+v", ls.Entries)
+	}
+	if _, err := handleOverlayCommit(sessions, mu)(ctx, req, struct{}{}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(root, "d", "old.txt")); !os.IsNotExist(err) {
+		t.Fatal("old file survived commit")
+	}
+	if mustRead(t, filepath.Join(root, "d", "new.txt")) != "new" {
+		t.Fatal("new file not committed")
+	}
+}
+
+func TestSandboxDiscard(t *testing.T) {
+	root := t.TempDir()
+	mustWrite(t, filepath.Join(root, "f.txt"), []byte("v1"), 0o644)
+	ctx, sessions, mu := sandboxSession(t, root)
+	req := mcp.CallToolRequest{}
+	if _, err := handleWrite(sessions, mu)(ctx, req, WriteArgs{Path: "f.txt", Content: "v2"}); err != nil {
+		t.Fatal(err)
+	}
+	res, err := handleOverlayDiscard(sessions, mu)(ctx, req, struct{}{})
+	if err != nil || len(res.Changes) != 1 {
+		t.Fatalf("unexpected discard result: %+v %v", res, err)
+	}
+	rd, err := handleRead(sessions, mu)(ctx, req, ReadArgs{Path: "f.txt"})
+	if err != nil || rd.Content != "v1" {
+		t.Fatalf("expected base content after discard, got %q %v", rd.Content, err)
+	}
+}
+
+func TestOverlayToolsRequireSandbox(t *testing.T) {
+	ctx, sessions, mu := testSession(t.TempDir())
+	if _, err := handleOverlayDiff(sessions, mu)(ctx, mcp.CallToolRequest{}, struct{}{}); err == nil {
+		t.Fatal("expected error outside a sandbox session")
+	}
+}
+
+func TestCreateSessionInheritsSandbox(t *testing.T) {
+	root := t.TempDir()
+	ctx, sessions, mu := sandboxSession(t, root)
+	res, err := handleCreateSession(sessions, mu)(ctx, mcp.CallToolRequest{}, CreateSessionArgs{ID: "child"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { sessions["child"].sandbox().close() })
+	if !res.Sandbox || sessions["child"].sandbox() == sessions["s1"].sandbox() {
+		t.Fatalf("expected child session with its own overlay: %+v", res)
+	}
+}
+
+// renameFailBackend fails renames onto one name
+type renameFailBackend struct {
+	*memBackend
+	failTo string
+}
+
+func (b *renameFailBackend) Rename(oldName, newName string) error {
+	if newName == b.failTo {
+		return &fs.PathError{Op: "rename", Path: newName, Err: fs.ErrPermission}
+	}
+	return b.memBackend.Rename(oldName, newName)
+}
+
+func TestSandboxCommitRollsBack(t *testing.T) {
+	lower := &renameFailBackend{memBackend: newMemBackend(), failTo: "z.txt"}
+	memWrite(t, lower, "a.txt", []byte("old"), 0o644)
+	memWrite(t, lower, "gone/x.txt", []byte("x"), 0o644)
+	ctx, sessions, mu, _ := memSession()
+	o, err := newOverlayBackend(lower)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { o.close() })
+	sessions["s1"].FS = o
+	req := mcp.CallToolRequest{}
+	for _, args := range []WriteArgs{{Path: "a.txt", Content: "new"}, {Path: "d/e/f.txt", Content: "f"}, {Path: "z.txt", Content: "z"}} {
+		if _, err := handleWrite(sessions, mu)(ctx, req, args); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := handleRmdir(sessions, mu)(ctx, req, RmdirArgs{Path: "gone", Recursive: true}); err != nil {
+		t.Fatal(err)
+	}
+
+	// A writer holding a changed path in the lower layer holds off the commit
+	withConfig(t, func(c *ServerConfig) { c.LockTimeout = 1 })
+	release, err := lower.Lock("a.txt", lockExclusive, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := handleOverlayCommit(sessions, mu)(ctx, req, struct{}{}); !errors.Is(err, ErrLockTimeout) {
+		t.Fatalf("commit under a held lock: %v", err)
+	}
+	release()
+
+	if _, err := handleOverlayCommit(sessions, mu)(ctx, req, struct{}{}); err == nil {
+		t.Fatal("commit succeeded despite failing rename")
+	}
+	if memRead(t, lower, "a.txt") != "old" || memRead(t, lower, "gone/x.txt") != "x" {
+		t.Fatal("lower layer not restored")
+	}
+	ents, _ := lower.ReadDir("")
+	var names []string
+	for _, e := range ents {
+		names = append(names, e.Name())
+	}
+	sort.Strings(names)
+	if strings.Join(names, ",") != "a.txt,gone" {
+		t.Fatalf("lower layer left with %v", names)
+	}
+	// The pending changes survive for another try
+	diff, err := handleOverlayDiff(sessions, mu)(ctx, req, struct{}{})
+	if err != nil || len(diff.Changes) != 6 {
+		t.Fatalf("diff after failed commit = %+v %v", diff.Changes, err)
+	}
+}
+
+func TestCloseOverlaysRemovesUpperDirs(t *testing.T) {
+	ctx, sessions, mu := sandboxSession(t, t.TempDir())
+	if _, err := handleWrite(sessions, mu)(ctx, mcp.CallToolRequest{}, WriteArgs{Path: "f.txt", Content: "x"}); err != nil {
+		t.Fatal(err)
+	}
+	o := sessions["s1"].sandbox()
+	closeOverlays()
+	if _, err := os.Stat(o.upperDir); !os.IsNotExist(err) {
+		t.Fatalf("upper directory left behind: %v", err)
+	}
+	liveOverlays.Lock()
+	defer liveOverlays.Unlock()
+	if liveOverlays.set[o] {
+		t.Fatal("closed overlay still tracked")
+	}
+}
+
+func TestSweepCommitStages(t *testing.T) {
+	mem := newMemBackend()
+	memWrite(t, mem, ".mcpfs-commit-1/0", []byte("staged"), 0o600)
+	memWrite(t, mem, ".mcpfs-commit-2/old-0", []byte("moved aside"), 0o644)
+	memWrite(t, mem, ".mcpfs-commit-3/0", []byte("in use"), 0o600)
+	memWrite(t, mem, "keep.txt", []byte("keep"), 0o644)
+	release, err := mem.Lock(".mcpfs-commit-3", lockExclusive, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer release()
+
+	// Stages never show up in listings while they exist
+	ctx, sessions, mu := newTestSession(mem)
+	list, err := handleList(sessions, mu)(ctx, mcp.CallToolRequest{}, ListArgs{Path: ""})
+	if err != nil || len(list.Entries) != 1 || list.Entries[0].Name != "keep.txt" {
+		t.Fatalf("list = %+v %v", list.Entries, err)
+	}
+
+	sweepCommitStages(mem)
+	if _, err := mem.Stat(".mcpfs-commit-1"); !os.IsNotExist(err) {
+		t.Fatalf("stale stage kept: %v", err)
+	}
+	if _, err := mem.Stat(".mcpfs-commit-2/old-0"); err != nil {
+		t.Fatalf("stage with moved-aside paths removed: %v", err)
+	}
+	if _, err := mem.Stat(".mcpfs-commit-3/0"); err != nil {
+		t.Fatalf("stage of a running commit removed: %v", err)
+	}
+}
@@ -12,12 +12,8 @@ import (
 	"github.com/mark3labs/mcp-go/mcp"
 )
 
-func readWindow(path string, offset, max int) ([]byte, int64, bool, error) {
-	f, err := os.Open(path)
-	if err != nil {
-		return nil, 0, false, err
-	}
-	defer f.Close()
+// readFileWindow reads up to max bytes of an open file starting at offset
+func readFileWindow(f File, offset, max int) ([]byte, int64, bool, error) {
 	fi, err := f.Stat()
 	if err != nil {
 		return nil, 0, false, err
@@ -54,36 +50,88 @@ func handlePeek(sessions map[string]*SessionState, mu *sync.RWMutex) mcp.Structu
 		if err != nil {
 			return PeekResult{}, err
 		}
-		root := state.Root
 		start := time.Now()
-		if args.MaxBytes <= 0 {
-			args.MaxBytes = defaultPeekMaxBytes
-		}
-		dprintf("%s -> fs_peek path=%q offset=%d max_bytes=%d", sessionContext(ctx), args.Path, args.Offset, args.MaxBytes)
+		args.MaxBytes = currentConfig().toolLimit("fs_peek", args.MaxBytes)
+		dprintf("%s -> fs_peek path=%q offset=%d max_bytes=%d decompress=%v encoding=%q", sessionContext(ctx), args.Path, args.Offset, args.MaxBytes, args.Decompress, args.Encoding)
 		var res PeekResult
-		full, err := safeJoinResolveFinal(root, args.Path)
+		name, err := state.FS.Resolve(args.Path, true)
 		if err != nil {
 			dprintf("fs_peek error: %v", err)
 			return res, err
 		}
-		chunk, sz, eof, err := readWindow(full, args.Offset, args.MaxBytes)
+		if err := checkAccess(ctx, state, "peek", capRead, args.Path, name); err != nil {
+			dprintf("fs_peek error: %v", err)
+			return res, err
+		}
+		release, err := state.FS.Lock(name, lockShared, currentConfig().lockTimeout())
+		if err != nil {
+			dprintf("fs_peek lock error: %v", err)
+			return res, err
+		}
+		defer release()
+		f, err := state.FS.Open(name)
 		if err != nil {
 			dprintf("fs_peek read error: %v", err)
 			return res, err
 		}
-		content := string(chunk)
+		defer f.Close()
+		var sha string
+		if args.IfNoneMatchSHA256 != "" {
+			fi, err := f.Stat()
+			if err != nil {
+				return res, err
+			}
+			if nm, ok := notModified(state.FS, name, fi, args.IfNoneMatchSHA256); ok {
+				dprintf("<- fs_peek not modified dur=%s", time.Since(start))
+				return PeekResult{Path: args.Path, Offset: args.Offset, Size: fi.Size(), SHA256: nm, NotModified: true, MetaFields: metaOf(fi)}, nil
+			}
+			if fi.Size() <= maxHashBytes {
+				sha, _ = hashBackendFile(state.FS, name)
+			}
+		}
+		var chunk []byte
+		var sz int64
+		var eof bool
+		var compression string
+		if args.Decompress {
+			if args.Offset < 0 {
+				args.Offset = 0
+			}
+			chunk, compression, eof, err = readDecompressed(f, int64(args.Offset), int64(args.MaxBytes))
+			if err != nil {
+				dprintf("fs_peek decompress error: %v", err)
+				return res, newOpError("peek", args.Path, err)
+			}
+			if fi, statErr := f.Stat(); statErr == nil {
+				sz = fi.Size()
+			}
+		} else {
+			chunk, sz, eof, err = readFileWindow(f, args.Offset, args.MaxBytes)
+			if err != nil {
+				dprintf("fs_peek read error: %v", err)
+				return res, err
+			}
+		}
+		content, err := encodeContent(args.Encoding, chunk, int64(max(args.Offset, 0)))
+		if err != nil {
+			dprintf("fs_peek error: %v", err)
+			return res, err
+		}
 		var mode string
 		var modAt string
-		if fi, statErr := os.Lstat(full); statErr == nil {
+		if fi, statErr := f.Stat(); statErr == nil {
 			mode = fmt.Sprintf("%#o", fi.Mode()&os.ModePerm)
 			modAt = fi.ModTime().UTC().Format(time.RFC3339)
 		}
 		res = PeekResult{
-			Path:    args.Path,
-			Offset:  args.Offset,
-			Size:    sz,
-			EOF:     eof,
-			Content: content,
+			Path:            args.Path,
+			Offset:          args.Offset,
+			Size:            sz,
+			EOF:             eof,
+			Content:         content,
+			Compression:     compression,
+			ContentEncoding: resultEncoding(args.Encoding),
+			SHA256:          sha,
 			MetaFields: MetaFields{
 				Mode:       mode,
 				ModifiedAt: modAt,
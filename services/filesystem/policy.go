@@ -0,0 +1,265 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io/fs"
+	"path/filepath"
+	"strings"
+
+	"github.com/bmatcuk/doublestar/v4"
+)
+
+// capability names a class of operations a session may perform
+type capability string
+
+const (
+	capRead   capability = "read"   // fs_read, fs_peek, fs_list, fs_search, fs_glob
+	capWrite  capability = "write"  // fs_write, fs_edit, fs_mkdir
+	capDelete capability = "delete" // fs_rmdir
+)
+
+var allCapabilities = []capability{capRead, capWrite, capDelete}
+
+// capabilitySet is the set of capabilities granted to a session.
+// A nil set grants every capability.
+type capabilitySet map[capability]bool
+
+func (c capabilitySet) has(k capability) bool {
+	return c == nil || c[k]
+}
+
+// names returns the granted capabilities in a stable order
+func (c capabilitySet) names() []string {
+	var out []string
+	for _, k := range allCapabilities {
+		if c.has(k) {
+			out = append(out, string(k))
+		}
+	}
+	return out
+}
+
+// parseCapabilities builds a capability set from names supplied by a client.
+// The result never exceeds parent so sessions cannot escalate privileges.
+func parseCapabilities(names []string, parent capabilitySet) (capabilitySet, error) {
+	if len(names) == 0 {
+		return parent, nil
+	}
+	set := capabilitySet{}
+	for _, n := range names {
+		k := capability(strings.ToLower(strings.TrimSpace(n)))
+		switch k {
+		case capRead, capWrite, capDelete:
+		default:
+			return nil, &ValidationError{Field: "capabilities", Value: n, Message: "expected read, write or delete"}
+		}
+		if !parent.has(k) {
+			return nil, newOpError("createsession", "", ErrPermissionDenied, fmt.Sprintf("current session lacks %s capability", k))
+		}
+		set[k] = true
+	}
+	return set, nil
+}
+
+// within reports whether c grants no capability that parent lacks
+func (c capabilitySet) within(parent capabilitySet) bool {
+	for _, k := range allCapabilities {
+		if c.has(k) && !parent.has(k) {
+			return false
+		}
+	}
+	return true
+}
+
+// accessKind is the kind of access a path rule applies to
+type accessKind string
+
+const (
+	accessRead  accessKind = "read"
+	accessWrite accessKind = "write"
+	accessAny   accessKind = "any"
+)
+
+// PathRule allows or denies access to paths matching a doublestar pattern
+type PathRule struct {
+	Allow   bool
+	Access  accessKind
+	Pattern string
+}
+
+func (r PathRule) String() string {
+	action := "deny"
+	if r.Allow {
+		action = "allow"
+	}
+	return fmt.Sprintf("%s:%s:%s", action, r.Access, r.Pattern)
+}
+
+// parsePathRule parses a rule of the form "allow|deny:read|write|any:pattern"
+func parsePathRule(s string) (PathRule, error) {
+	parts := strings.SplitN(s, ":", 3)
+	if len(parts) != 3 || parts[2] == "" {
+		return PathRule{}, fmt.Errorf("invalid path rule %q: expected allow|deny:read|write|any:pattern", s)
+	}
+	var r PathRule
+	switch parts[0] {
+	case "allow":
+		r.Allow = true
+	case "deny":
+	default:
+		return PathRule{}, fmt.Errorf("invalid path rule %q: action must be allow or deny", s)
+	}
+	switch accessKind(parts[1]) {
+	case accessRead, accessWrite, accessAny:
+		r.Access = accessKind(parts[1])
+	default:
+		return PathRule{}, fmt.Errorf("invalid path rule %q: access must be read, write or any", s)
+	}
+	r.Pattern = strings.TrimPrefix(filepath.ToSlash(parts[2]), "/")
+	if !doublestar.ValidatePattern(r.Pattern) {
+		return PathRule{}, fmt.Errorf("invalid path rule %q: %w", s, ErrInvalidGlob)
+	}
+	return r, nil
+}
+
+// Policy is an access policy. The server-wide one comes from the
+// configuration and is shared by all sessions.
+// Rules are evaluated in order and the first match decides; paths that
+// match no rule are allowed.
+type Policy struct {
+	ReadOnly bool
+	Rules    []PathRule
+}
+
+// newPolicy builds a policy from the read-only switch and textual rules
+func newPolicy(readOnly bool, rules []string) (*Policy, error) {
+	p := &Policy{ReadOnly: readOnly}
+	for _, s := range rules {
+		r, err := parsePathRule(s)
+		if err != nil {
+			return nil, err
+		}
+		p.Rules = append(p.Rules, r)
+	}
+	return p, nil
+}
+
+// narrows reports whether p permits nothing that parent denies: it keeps
+// parent read-only and is parent's rules with only deny rules put in front,
+// since the first matching rule decides.
+func (p *Policy) narrows(parent *Policy) bool {
+	if p == parent || parent == nil {
+		return true
+	}
+	if p == nil || (parent.ReadOnly && !p.ReadOnly) || len(p.Rules) < len(parent.Rules) {
+		return false
+	}
+	extra := len(p.Rules) - len(parent.Rules)
+	for i, r := range p.Rules {
+		if i < extra {
+			if r.Allow {
+				return false
+			}
+		} else if r != parent.Rules[i-extra] {
+			return false
+		}
+	}
+	return true
+}
+
+// match returns the first rule that applies to access on rel, if any
+func (p *Policy) match(access accessKind, rel string) (PathRule, bool) {
+	if p == nil {
+		return PathRule{}, false
+	}
+	rel = filepath.ToSlash(rel)
+	for _, r := range p.Rules {
+		if r.Access != accessAny && r.Access != access {
+			continue
+		}
+		if ok, _ := doublestar.Match(r.Pattern, rel); ok {
+			return r, true
+		}
+	}
+	return PathRule{}, false
+}
+
+// permits reports whether access to rel is allowed by the path rules
+func (p *Policy) permits(access accessKind, rel string) bool {
+	r, ok := p.match(access, rel)
+	return !ok || r.Allow
+}
+
+// checkAccess enforces the session capabilities, the read-only switch, archive
+// roots, the path rules and other sessions' leases for an operation on name,
+// as resolved by the session backend.
+func checkAccess(ctx context.Context, state *SessionState, op string, c capability, reqPath, name string) error {
+	if !state.Caps.has(c) {
+		return newOpError(op, reqPath, ErrPermissionDenied, fmt.Sprintf("session lacks %s capability", c))
+	}
+	access := accessRead
+	if c != capRead {
+		access = accessWrite
+		if p := state.policy(); p != nil && p.ReadOnly {
+			return newOpError(op, reqPath, ErrPermissionDenied, "server is read-only")
+		}
+		if a, ok := state.FS.(*archiveBackend); ok {
+			return a.readOnly(op, reqPath)
+		}
+	}
+	if r, ok := state.policy().match(access, name); ok && !r.Allow {
+		return newOpError(op, reqPath, ErrPermissionDenied, fmt.Sprintf("denied by rule %s", r))
+	}
+	if access == accessWrite {
+		return checkLease(ctx, state, op, reqPath, name)
+	}
+	return nil
+}
+
+// readable reports whether rel may be read; walkers use it to hide entries
+func (s *SessionState) readable(rel string) bool {
+	return s.Caps.has(capRead) && s.policy().permits(accessRead, rel)
+}
+
+// listed reports whether rel shows up in fs_list, fs_search and fs_glob: it
+// must be readable and not one of the server's own temporary files
+func (s *SessionState) listed(rel string) bool {
+	return s.readable(rel) && !internalArtifact(rel)
+}
+
+// policy returns the access policy of the session: its own, or else the
+// server-wide policy in effect, which a configuration reload may replace
+func (s *SessionState) policy() *Policy {
+	if s.Policy != nil {
+		return s.Policy
+	}
+	return currentConfig().Policy
+}
+
+// checkTreeWritable walks dir and fails on the first entry a write rule denies.
+// It guards recursive removal from deleting protected descendants.
+func checkTreeWritable(state *SessionState, op, reqPath, dir string) error {
+	policy := state.policy()
+	if policy == nil || len(policy.Rules) == 0 {
+		return nil
+	}
+	denied := ""
+	err := walkBackend(state.FS, dir, func(name string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return nil
+		}
+		if !policy.permits(accessWrite, name) {
+			denied = name
+			return fs.SkipAll
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	if denied != "" {
+		return newOpError(op, reqPath, ErrPermissionDenied, fmt.Sprintf("protected path %s", denied))
+	}
+	return nil
+}
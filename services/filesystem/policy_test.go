@@ -0,0 +1,205 @@
+package main
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/mark3labs/mcp-go/mcp"
+)
+
+func mustPolicy(t *testing.T, readOnly bool, rules ...string) *Policy {
+	t.Helper()
+	p, err := newPolicy(readOnly, rules)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return p
+}
+
+func TestParsePathRule(t *testing.T) {
+	r, err := parsePathRule("deny:write:.git/**")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.Allow || r.Access != accessWrite || r.Pattern != ".git/**" {
+		t.Fatalf("unexpected rule: %+v", r)
+	}
+	for _, bad := range []string{"deny:write", "block:read:x", "allow:exec:x", "deny:read:[", "deny:any:"} {
+		if _, err := parsePathRule(bad); err == nil {
+			t.Fatalf("expected error for %q", bad)
+		}
+	}
+}
+
+func TestPolicyFirstMatchWins(t *testing.T) {
+	p := mustPolicy(t, false, "allow:read:secrets/public.txt", "deny:read:secrets/**")
+	if !p.permits(accessRead, "secrets/public.txt") {
+		t.Fatalf("allow rule should take precedence")
+	}
+	if p.permits(accessRead, "secrets/key.pem") {
+		t.Fatalf("deny rule should apply")
+	}
+	if !p.permits(accessWrite, "secrets/key.pem") {
+		t.Fatalf("read rule must not affect writes")
+	}
+}
+
+func TestReadOnlyRejectsMutations(t *testing.T) {
+	ctx, sessions, mu, mem := memSession()
+	memWrite(t, mem, "f.txt", []byte("hi"), 0o644)
+	sessions["s1"].Policy = mustPolicy(t, true)
+
+	_, err := handleWrite(sessions, mu)(ctx, mcp.CallToolRequest{}, WriteArgs{Path: "f.txt", Content: "x"})
+	if !errors.Is(err, ErrPermissionDenied) {
+		t.Fatalf("expected permission denied, got %v", err)
+	}
+	if code := toErrorResponse(err).Code; code != "PERMISSION_DENIED" {
+		t.Fatalf("unexpected code %s", code)
+	}
+	if _, err := handleMkdir(sessions, mu)(ctx, mcp.CallToolRequest{}, MkdirArgs{Path: "d"}); !errors.Is(err, ErrPermissionDenied) {
+		t.Fatalf("expected mkdir to be denied, got %v", err)
+	}
+	if _, err := handleRead(sessions, mu)(ctx, mcp.CallToolRequest{}, ReadArgs{Path: "f.txt"}); err != nil {
+		t.Fatalf("read should be allowed: %v", err)
+	}
+}
+
+func TestPathRulesDenyWritesAndHideReads(t *testing.T) {
+	ctx, sessions, mu, mem := memSession()
+	memWrite(t, mem, ".git/config", []byte("core"), 0o644)
+	memWrite(t, mem, "app/.env", []byte("SECRET=1"), 0o644)
+	memWrite(t, mem, "app/main.go", []byte("SECRET usage"), 0o644)
+	sessions["s1"].Policy = mustPolicy(t, false, "deny:write:.git/**", "deny:read:**/.env")
+
+	if _, err := handleEdit(sessions, mu)(ctx, mcp.CallToolRequest{}, EditArgs{Path: ".git/config", Pattern: "core", Replace: "x"}); !errors.Is(err, ErrPermissionDenied) {
+		t.Fatalf("expected edit to be denied, got %v", err)
+	}
+	if _, err := handleRead(sessions, mu)(ctx, mcp.CallToolRequest{}, ReadArgs{Path: "app/.env"}); !errors.Is(err, ErrPermissionDenied) {
+		t.Fatalf("expected read to be denied, got %v", err)
+	}
+
+	list, err := handleList(sessions, mu)(ctx, mcp.CallToolRequest{}, ListArgs{Path: "app"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(list.Entries) != 1 || list.Entries[0].Name != "main.go" {
+		t.Fatalf("expected .env to be hidden, got %+v", list.Entries)
+	}
+	glob, err := handleGlob(sessions, mu)(ctx, mcp.CallToolRequest{}, GlobArgs{Pattern: "**/.env"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(glob.Matches) != 0 {
+		t.Fatalf("expected no glob matches, got %v", glob.Matches)
+	}
+	search, err := handleSearch(sessions, mu)(ctx, mcp.CallToolRequest{}, SearchArgs{Pattern: "SECRET"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(search.Matches) != 1 || search.Matches[0].Path != "app/main.go" {
+		t.Fatalf("unexpected search matches: %+v", search.Matches)
+	}
+
+	_, err = handleRmdir(sessions, mu)(ctx, mcp.CallToolRequest{}, RmdirArgs{Path: ".", Recursive: true})
+	if !errors.Is(err, ErrPermissionDenied) {
+		t.Fatalf("expected recursive rmdir over .git to be denied, got %v", err)
+	}
+	if _, err := mem.Stat(".git/config"); err != nil {
+		t.Fatalf("protected file removed: %v", err)
+	}
+}
+
+func TestCreateSessionCapabilities(t *testing.T) {
+	ctx, sessions, mu, mem := memSession()
+	memWrite(t, mem, "f.txt", []byte("hi"), 0o644)
+
+	res, err := handleCreateSession(sessions, mu)(ctx, mcp.CallToolRequest{}, CreateSessionArgs{ID: "ro", Capabilities: []string{"read"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(res.Capabilities) != 1 || res.Capabilities[0] != "read" {
+		t.Fatalf("unexpected capabilities: %v", res.Capabilities)
+	}
+	if _, err := handleSwitchSession(sessions, mu)(ctx, mcp.CallToolRequest{}, SwitchSessionArgs{ID: "ro"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := handleRead(sessions, mu)(ctx, mcp.CallToolRequest{}, ReadArgs{Path: "f.txt"}); err != nil {
+		t.Fatalf("read should be allowed: %v", err)
+	}
+	if _, err := handleWrite(sessions, mu)(ctx, mcp.CallToolRequest{}, WriteArgs{Path: "f.txt", Content: "x"}); !errors.Is(err, ErrPermissionDenied) {
+		t.Fatalf("expected write to be denied, got %v", err)
+	}
+
+	// A restricted session cannot create a more privileged one
+	if _, err := handleCreateSession(sessions, mu)(ctx, mcp.CallToolRequest{}, CreateSessionArgs{ID: "rw", Capabilities: []string{"read", "write"}}); !errors.Is(err, ErrPermissionDenied) {
+		t.Fatalf("expected escalation to be refused, got %v", err)
+	}
+	if _, err := handleCreateSession(sessions, mu)(ctx, mcp.CallToolRequest{}, CreateSessionArgs{Capabilities: []string{"root"}}); err == nil {
+		t.Fatalf("expected unknown capability to be rejected")
+	}
+}
+
+func TestSwitchSessionNoEscalation(t *testing.T) {
+	ctx, sessions, mu, _ := memSession()
+	sessions["s1"].Policy = &Policy{Rules: []PathRule{{Allow: false, Access: accessWrite, Pattern: "secret/**"}}}
+	sessions["open"] = &SessionState{FS: sessions["s1"].FS}
+
+	if _, err := handleCreateSession(sessions, mu)(ctx, mcp.CallToolRequest{}, CreateSessionArgs{ID: "ro", Capabilities: []string{"read"}}); err != nil {
+		t.Fatal(err)
+	}
+	switchTo := func(id string) error {
+		_, err := handleSwitchSession(sessions, mu)(ctx, mcp.CallToolRequest{}, SwitchSessionArgs{ID: id})
+		return err
+	}
+	// s1 has every capability but a stricter policy than open
+	if err := switchTo("open"); !errors.Is(err, ErrPermissionDenied) {
+		t.Fatalf("expected switch to a broader policy to be refused, got %v", err)
+	}
+	if err := switchTo("ro"); err != nil {
+		t.Fatalf("narrowing switch should be allowed: %v", err)
+	}
+	if err := switchTo("s1"); !errors.Is(err, ErrPermissionDenied) {
+		t.Fatalf("expected switch back to a writable session to be refused, got %v", err)
+	}
+	if getSessionID(ctx) != "ro" {
+		t.Fatalf("refused switch changed the session to %s", getSessionID(ctx))
+	}
+
+	// A session created with its own deny rules cannot switch back out of them
+	setSessionID(ctx, "s1")
+	create := func(args CreateSessionArgs) error {
+		_, err := handleCreateSession(sessions, mu)(ctx, mcp.CallToolRequest{}, args)
+		return err
+	}
+	if err := create(CreateSessionArgs{ID: "nokeys", Rules: []string{"deny:read:**/*.key"}}); err != nil {
+		t.Fatal(err)
+	}
+	if got := sessions["nokeys"].Policy.Rules; len(got) != 2 || got[1] != sessions["s1"].Policy.Rules[0] {
+		t.Fatalf("created policy rules = %v", got)
+	}
+	if err := switchTo("nokeys"); err != nil {
+		t.Fatalf("switch into a session with extra deny rules: %v", err)
+	}
+	if err := switchTo("s1"); !errors.Is(err, ErrPermissionDenied) {
+		t.Fatalf("switch out of extra deny rules: %v", err)
+	}
+	if err := create(CreateSessionArgs{ID: "wide", Rules: []string{"allow:write:secret/**"}}); !errors.Is(err, ErrPermissionDenied) {
+		t.Fatalf("allow rule accepted: %v", err)
+	}
+	if err := create(CreateSessionArgs{ID: "bad", Rules: []string{"deny:read:"}}); err == nil {
+		t.Fatal("malformed rule accepted")
+	}
+	if err := create(CreateSessionArgs{ID: "frozen", ReadOnly: true}); err != nil || !sessions["frozen"].Policy.ReadOnly {
+		t.Fatalf("read_only session: %v", err)
+	}
+
+	// Prepending deny rules narrows a policy; dropping or allowing widens it
+	parent := sessions["s1"].Policy
+	deny := PathRule{Allow: false, Access: accessAny, Pattern: "**/*.key"}
+	if !(&Policy{Rules: append([]PathRule{deny}, parent.Rules...)}).narrows(parent) {
+		t.Fatalf("extra deny rule should narrow the policy")
+	}
+	if (&Policy{}).narrows(parent) || (&Policy{Rules: append([]PathRule{{Allow: true, Access: accessAny, Pattern: "**"}}, parent.Rules...)}).narrows(parent) {
+		t.Fatalf("broader policy reported as narrowing")
+	}
+}
@@ -2,10 +2,8 @@ package main
 
 import (
 	"context"
-	"crypto/sha256"
 	"fmt"
 	"io"
-	"os"
 	"sync"
 	"time"
 
@@ -22,65 +20,106 @@ func handleRead(sessions map[string]*SessionState, mu *sync.RWMutex) mcp.Structu
 		if err != nil {
 			return ReadResult{}, err
 		}
-		root := state.Root
 		start := time.Now()
-		dprintf("%s -> fs_read path=%q max_bytes=%d", sessionContext(ctx), args.Path, args.MaxBytes)
+		dprintf("%s -> fs_read path=%q max_bytes=%d decompress=%v encoding=%q", sessionContext(ctx), args.Path, args.MaxBytes, args.Decompress, args.Encoding)
 		var res ReadResult
-		full, err := safeJoinResolveFinal(root, args.Path)
+		name, err := state.FS.Resolve(args.Path, true)
 		if err != nil {
 			dprintf("fs_read error: %v", err)
 			return res, err
 		}
-		fi, err := os.Stat(full)
-		if err != nil {
-			dprintf("fs_read stat error: %v", err)
+		if err := checkAccess(ctx, state, "read", capRead, args.Path, name); err != nil {
+			dprintf("fs_read error: %v", err)
 			return res, err
 		}
-		limit := args.MaxBytes
-		if limit <= 0 {
-			limit = defaultReadMaxBytes
+		release, err := state.FS.Lock(name, lockShared, currentConfig().lockTimeout())
+		if err != nil {
+			dprintf("fs_read lock error: %v", err)
+			return res, err
 		}
-		f, err := os.Open(full)
+		defer release()
+		f, err := state.FS.Open(name)
 		if err != nil {
 			dprintf("fs_read open error: %v", err)
 			return res, err
 		}
 		defer f.Close()
-		r := io.LimitReader(f, int64(limit))
-		buf, err := io.ReadAll(r)
+		fi, err := f.Stat()
 		if err != nil {
-			dprintf("fs_read read error: %v", err)
+			dprintf("fs_read stat error: %v", err)
 			return res, err
 		}
-		trunc := fi.Size() > int64(len(buf))
-
-		sha := ""
-		if fi.Size() <= maxHashBytes {
-			hf, err := os.Open(full)
-			if err == nil {
-				h := sha256.New()
-				if _, err := io.Copy(h, hf); err == nil {
-					sha = fmt.Sprintf("%x", h.Sum(nil))
-				}
-				hf.Close()
+		if args.IfNoneMatchSHA256 != "" {
+			if nm, ok := notModified(state.FS, name, fi, args.IfNoneMatchSHA256); ok {
+				dprintf("<- fs_read not modified dur=%s", time.Since(start))
+				return ReadResult{Path: args.Path, Size: fi.Size(), SHA256: nm, NotModified: true, MetaFields: metaOf(fi)}, nil
 			}
+		}
+		limit := currentConfig().toolLimit("fs_read", args.MaxBytes)
+		// Content and digest come from the same pass over the file
+		var src io.Reader = f
+		hr, sha := newHashingReader(state.FS, name, f, fi)
+		if hr != nil {
+			src = hr
+		}
+		var buf []byte
+		var trunc bool
+		var compression string
+		if args.Decompress {
+			// The limit applies to decoded bytes; size and sha256 describe the stored file
+			data, format, eof, err := readDecompressed(src, 0, int64(limit))
+			if err != nil {
+				dprintf("fs_read decompress error: %v", err)
+				return res, newOpError("read", args.Path, err)
+			}
+			buf, trunc, compression = data, !eof, format
 		} else {
+			buf, err = io.ReadAll(io.LimitReader(src, int64(limit)))
+			if err != nil {
+				dprintf("fs_read read error: %v", err)
+				return res, err
+			}
+			trunc = fi.Size() > int64(len(buf))
+		}
+
+		if hr != nil {
+			if h, err := hr.finish(); err == nil {
+				sha = h
+			}
+		} else if sha == "" {
 			dprintf("fs_read: skip sha256 (size %d > cap %d)", fi.Size(), maxHashBytes)
 		}
 
-		content := string(buf)
+		tf, isTextual := detectTextFormat(buf)
+		var content string
+		if isTextual && !tf.plain() && resultEncoding(args.Encoding) == "" {
+			// Text is returned as UTF-8 whatever the file is stored in
+			content, err = decodeText(buf, tf)
+		} else {
+			content, err = encodeContent(args.Encoding, buf, 0)
+		}
+		if err != nil {
+			dprintf("fs_read error: %v", err)
+			return res, err
+		}
+		mimeName := name
+		if compression != "" {
+			mimeName = innerName(name)
+		}
 
 		res = ReadResult{
-			Path:      args.Path,
-			Size:      fi.Size(),
-			MIMEType:  detectMIME(full, buf),
-			SHA256:    sha,
-			Content:   content,
-			Truncated: trunc,
-			MetaFields: MetaFields{
-				Mode:       fmt.Sprintf("%#o", fi.Mode()&os.ModePerm),
-				ModifiedAt: fi.ModTime().UTC().Format(time.RFC3339),
-			},
+			Path:            args.Path,
+			Size:            fi.Size(),
+			MIMEType:        detectMIME(mimeName, buf),
+			SHA256:          sha,
+			Content:         content,
+			Truncated:       trunc,
+			Compression:     compression,
+			ContentEncoding: resultEncoding(args.Encoding),
+			MetaFields:      metaOf(fi),
+		}
+		if isTextual {
+			res.Encoding, res.BOM, res.LineEndings = tf.Encoding, tf.BOM, tf.LineEndings
 		}
 		dprintf("<- fs_read ok size=%d truncated=%v dur=%s", len(buf), trunc, time.Since(start))
 		return res, nil
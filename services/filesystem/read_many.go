@@ -0,0 +1,231 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/bmatcuk/doublestar/v4"
+	"github.com/mark3labs/mcp-go/mcp"
+)
+
+const (
+	skippedBinary = "binary"
+	skippedBudget = "budget"
+)
+
+func formatReadManyResult(r ReadManyResult) string {
+	var b strings.Builder
+	for i, e := range r.Files {
+		if i > 0 {
+			b.WriteByte('\n')
+		}
+		switch {
+		case e.Error != nil:
+			fmt.Fprintf(&b, "==> %s <== error=%s %s", e.Path, e.Error.Code, e.Error.Error)
+		case e.Skipped != "":
+			fmt.Fprintf(&b, "==> %s <== skipped=%s", e.Path, e.Skipped)
+		default:
+			fmt.Fprintf(&b, "==> %s <== size=%d sha=%s truncated=%v\n%s", e.Path, e.Result.Size, e.Result.SHA256, e.Result.Truncated, e.Result.Content)
+		}
+	}
+	return b.String()
+}
+
+// globFiles returns the regular files matching pattern, sorted. The walk is
+// sequential so the set kept when more than max files match is stable.
+func globFiles(ctx context.Context, state *SessionState, pattern string, max int) ([]string, bool, error) {
+	if strings.Contains(pattern, "../") || strings.HasPrefix(pattern, "/") {
+		return nil, false, fmt.Errorf("pattern cannot escape base folder: %s", pattern)
+	}
+	pat := filepath.ToSlash(filepath.Clean(pattern))
+	if _, err := doublestar.Match(pat, ""); err != nil {
+		return nil, false, err
+	}
+	var names []string
+	more := false
+	err := walkBackend(state.FS, "", func(name string, d fs.DirEntry, err error) error {
+		if err != nil || name == "" {
+			return nil
+		}
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if !state.readable(name) {
+			if d.IsDir() {
+				return fs.SkipDir
+			}
+			return nil
+		}
+		if !d.Type().IsRegular() {
+			return nil
+		}
+		if ok, _ := doublestar.Match(pat, filepath.ToSlash(name)); ok {
+			if len(names) == max {
+				more = true
+				return fs.SkipAll
+			}
+			names = append(names, filepath.ToSlash(name))
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, false, err
+	}
+	sort.Strings(names)
+	return names, more, nil
+}
+
+// clipContent shortens encoded content to at most n bytes without splitting
+// a rune or an encoding group
+func clipContent(content, enc string, n int) string {
+	switch contentEncoding(enc) {
+	case encodingBase64:
+		n -= n % 4
+	case encodingHex:
+		n -= n % 2
+	default:
+		return string(trimPartialRune([]byte(content[:n])))
+	}
+	return content[:n]
+}
+
+func handleReadMany(sessions map[string]*SessionState, mu *sync.RWMutex) mcp.StructuredToolHandlerFunc[ReadManyArgs, ReadManyResult] {
+	read := handleRead(sessions, mu)
+	return func(ctx context.Context, req mcp.CallToolRequest, args ReadManyArgs) (ReadManyResult, error) {
+		state, err := getSessionState(ctx, sessions, mu)
+		if err != nil {
+			return ReadManyResult{}, err
+		}
+		start := time.Now()
+		dprintf("%s -> fs_read_many paths=%d glob=%q max_bytes_per_file=%d max_total_bytes=%d include_binary=%v", sessionContext(ctx), len(args.Paths), args.Glob, args.MaxBytesPerFile, args.MaxTotalBytes, args.IncludeBinary)
+		var out ReadManyResult
+		if len(args.Paths) == 0 && args.Glob == "" {
+			return out, &ValidationError{Field: "paths", Message: "paths or glob required"}
+		}
+		maxFiles := args.MaxFiles
+		if maxFiles <= 0 {
+			maxFiles = defaultReadManyFiles
+		}
+		budget := currentConfig().toolLimit("fs_read_many", args.MaxTotalBytes)
+
+		paths := args.Paths
+		if len(paths) > maxFiles {
+			paths, out.Truncated = paths[:maxFiles], true
+		}
+		if args.Glob != "" {
+			if err := checkAccess(ctx, state, "read_many", capRead, args.Glob, ""); err != nil {
+				dprintf("fs_read_many error: %v", err)
+				return out, err
+			}
+			matches, more, err := globFiles(ctx, state, args.Glob, maxFiles-len(paths))
+			if err != nil {
+				dprintf("fs_read_many glob error: %v", err)
+				return out, err
+			}
+			paths = append(paths[:len(paths):len(paths)], matches...)
+			out.Truncated = out.Truncated || more
+		}
+
+		// Files are read concurrently; reserved stops workers early once the
+		// budget is clearly spent, and the ordered pass below settles it exactly
+		entries := make([]ReadManyEntry, len(paths))
+		var reserved atomic.Int64
+		jobs := make(chan int)
+		workers := min(currentConfig().GetWorkerCount("read_many"), len(paths))
+		var wg sync.WaitGroup
+		for w := 0; w < workers; w++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for i := range jobs {
+					entries[i] = readManyEntry(ctx, req, read, args, paths[i], budget, &reserved)
+				}
+			}()
+		}
+		for i := range paths {
+			if ctx.Err() != nil {
+				break
+			}
+			jobs <- i
+		}
+		close(jobs)
+		wg.Wait()
+		if err := ctx.Err(); err != nil {
+			return ReadManyResult{}, err
+		}
+
+		full := false
+		for i := range entries {
+			e := &entries[i]
+			left := budget - out.TotalBytes
+			if e.Skipped == skippedBudget && left > 0 && !full {
+				// Skipped while later files held the budget; in order it fits
+				*e = readManyEntry(ctx, req, read, args, e.Path, budget, new(atomic.Int64))
+			}
+			if e.Skipped == skippedBudget {
+				out.Truncated = true
+				continue
+			}
+			if e.Result == nil {
+				continue
+			}
+			n := len(e.Result.Content)
+			if n > 0 && (full || left <= 0) {
+				e.Result, e.Skipped = nil, skippedBudget
+				out.Truncated = true
+				continue
+			}
+			if n > left {
+				e.Result.Content = clipContent(e.Result.Content, e.Result.ContentEncoding, left)
+				e.Result.Truncated = true
+				out.Truncated, full = true, true
+			}
+			out.TotalBytes += len(e.Result.Content)
+		}
+		out.Files = entries
+		dprintf("<- fs_read_many ok files=%d bytes=%d truncated=%v dur=%s", len(entries), out.TotalBytes, out.Truncated, time.Since(start))
+		return out, nil
+	}
+}
+
+// readManyEntry reads one file of a batch. A budget skip is provisional:
+// whether the file fits is only known once earlier files are accounted for.
+func readManyEntry(ctx context.Context, req mcp.CallToolRequest, read mcp.StructuredToolHandlerFunc[ReadArgs, ReadResult], args ReadManyArgs, path string, budget int, reserved *atomic.Int64) ReadManyEntry {
+	e := ReadManyEntry{Path: path}
+	if reserved.Load() >= int64(budget) {
+		e.Skipped = skippedBudget
+		return e
+	}
+	ra := ReadArgs{Path: path, MaxBytes: args.MaxBytesPerFile, Encoding: args.Encoding}
+	res, err := read(ctx, req, ra)
+	if err == nil && res.Encoding == "" && res.Size > 0 {
+		if !args.IncludeBinary {
+			e.Skipped = skippedBinary
+			return e
+		}
+		if resultEncoding(args.Encoding) == "" {
+			// Raw bytes would not survive as UTF-8
+			ra.Encoding = encodingBase64
+			res, err = read(ctx, req, ra)
+		}
+	}
+	if err != nil {
+		if errors.Is(err, context.Canceled) {
+			return e
+		}
+		resp := toErrorResponse(err)
+		e.Error = &resp
+		return e
+	}
+	reserved.Add(int64(len(res.Content)))
+	e.Result = &res
+	return e
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"encoding/base64"
+	"strings"
+	"testing"
+
+	"github.com/mark3labs/mcp-go/mcp"
+)
+
+func TestReadMany(t *testing.T) {
+	ctx, sessions, mu, mem := memSession()
+	memWrite(t, mem, "pkg/b.go", []byte("package b\n"), 0o644)
+	memWrite(t, mem, "pkg/a.go", []byte("package a\n"), 0o644)
+	memWrite(t, mem, "pkg/sub/c.go", []byte("package c\n"), 0o644)
+	memWrite(t, mem, "pkg/logo.png", binaryBlob(), 0o644)
+	memWrite(t, mem, "README", []byte("readme\n"), 0o644)
+	req := mcp.CallToolRequest{}
+
+	res, err := handleReadMany(sessions, mu)(ctx, req, ReadManyArgs{Paths: []string{"README", "missing.txt"}, Glob: "pkg/**"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, e := range res.Files {
+		got = append(got, e.Path)
+	}
+	if strings.Join(got, ",") != "README,missing.txt,pkg/a.go,pkg/b.go,pkg/logo.png,pkg/sub/c.go" {
+		t.Fatalf("order = %v", got)
+	}
+	if res.Files[0].Result.Content != "readme\n" || res.Files[2].Result.Content != "package a\n" {
+		t.Fatalf("contents = %+v", res.Files)
+	}
+	if res.Files[1].Error == nil || res.Files[1].Error.Code != "NOT_FOUND" {
+		t.Fatalf("missing file = %+v", res.Files[1])
+	}
+	if res.Files[4].Skipped != skippedBinary || res.Files[4].Result != nil {
+		t.Fatalf("binary = %+v", res.Files[4])
+	}
+	if res.TotalBytes != 37 || res.Truncated {
+		t.Fatalf("total = %d truncated=%v", res.TotalBytes, res.Truncated)
+	}
+
+	res, err = handleReadMany(sessions, mu)(ctx, req, ReadManyArgs{Paths: []string{"pkg/logo.png"}, IncludeBinary: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r := res.Files[0].Result; r == nil || r.ContentEncoding != "base64" || r.Content != base64.StdEncoding.EncodeToString(binaryBlob()) {
+		t.Fatalf("included binary = %+v", res.Files[0])
+	}
+}
+
+func TestReadManyBudget(t *testing.T) {
+	ctx, sessions, mu, mem := memSession()
+	for _, n := range []string{"1.txt", "2.txt", "3.txt", "4.txt"} {
+		memWrite(t, mem, n, []byte(strings.Repeat(n[:1], 10)), 0o644)
+	}
+	memWrite(t, mem, "0.txt", nil, 0o644)
+	req := mcp.CallToolRequest{}
+
+	// The limits apply in request order however the reads interleave
+	for i := 0; i < 20; i++ {
+		res, err := handleReadMany(sessions, mu)(ctx, req, ReadManyArgs{Glob: "*.txt", MaxBytesPerFile: 8, MaxTotalBytes: 20})
+		if err != nil {
+			t.Fatal(err)
+		}
+		f := res.Files
+		if len(f) != 5 || f[0].Result.Content != "" || f[1].Result.Content != "11111111" || f[2].Result.Content != "22222222" {
+			t.Fatalf("files = %+v", f)
+		}
+		if f[3].Result.Content != "3333" || !f[3].Result.Truncated || f[4].Skipped != skippedBudget {
+			t.Fatalf("budget = %+v %+v", f[3], f[4])
+		}
+		if res.TotalBytes != 20 || !res.Truncated {
+			t.Fatalf("total = %d truncated=%v", res.TotalBytes, res.Truncated)
+		}
+	}
+
+	res, err := handleReadMany(sessions, mu)(ctx, req, ReadManyArgs{Glob: "*.txt", MaxFiles: 2})
+	if err != nil || len(res.Files) != 2 || res.Files[1].Path != "1.txt" || !res.Truncated {
+		t.Fatalf("max_files = %+v %v", res, err)
+	}
+	if _, err := handleReadMany(sessions, mu)(ctx, req, ReadManyArgs{}); err == nil {
+		t.Fatal("empty request accepted")
+	}
+}
+
+func TestClipContent(t *testing.T) {
+	if got := clipContent("héllo", "", 2); got != "h" {
+		t.Errorf("utf8 clip = %q", got)
+	}
+	if got := clipContent("aGVsbG8=", string(encodingBase64), 7); got != "aGVs" {
+		t.Errorf("base64 clip = %q", got)
+	}
+	if got := clipContent("68656c", string(encodingHex), 5); got != "6865" {
+		t.Errorf("hex clip = %q", got)
+	}
+}
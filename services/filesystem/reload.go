@@ -0,0 +1,236 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// Reload triggers
+const (
+	reloadSignal = "sighup"
+	reloadFile   = "file"
+)
+
+// restartSettings are the settings read only at startup. A reload keeps
+// their running values and reports changes to them as needing a restart.
+var restartSettings = map[string]bool{
+	"root": true, "memfs": true, "debug": true, "compat": true, "watch-poll": true,
+	"audit-log": true, "audit-max-size": true, "audit-max-backups": true,
+	"transport": true, "listen": true, "tls-cert": true, "tls-key": true, "tls-client-ca": true, "auth-token": true,
+}
+
+// configChange is one setting that differs between two configurations
+type configChange struct {
+	Setting string `json:"setting"`
+	Old     string `json:"old"`
+	New     string `json:"new"`
+}
+
+// reloadEvent is the structured log record of a reload
+type reloadEvent struct {
+	Event           string         `json:"event"`
+	Trigger         string         `json:"trigger"`
+	Status          string         `json:"status"` // applied, unchanged or rejected
+	Error           string         `json:"error,omitempty"`
+	Changes         []configChange `json:"changes,omitempty"`
+	RestartRequired []configChange `json:"restart_required,omitempty"`
+}
+
+// configReloader swaps in a freshly loaded configuration on SIGHUP and when
+// the config file changes. Handlers and sessions read the configuration per
+// call, so limits, tool settings and the access policy apply to sessions
+// already open.
+type configReloader struct {
+	load func() (*ServerConfig, error)
+	mu   sync.Mutex // serializes reloads
+}
+
+func newConfigReloader(load func() (*ServerConfig, error)) *configReloader {
+	return &configReloader{load: load}
+}
+
+// reload loads and validates the configuration and makes it current. A
+// configuration that fails to load leaves the running one in place.
+func (r *configReloader) reload(trigger string) reloadEvent {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	ev := reloadEvent{Event: "config_reload", Trigger: trigger}
+	defer logReload(&ev)
+
+	running := currentConfig()
+	loaded, err := r.load()
+	if err != nil {
+		ev.Status, ev.Error = "rejected", err.Error()
+		return ev
+	}
+	for _, c := range diffConfig(running, loaded) {
+		if restartSettings[c.Setting] {
+			ev.RestartRequired = append(ev.RestartRequired, c)
+		} else {
+			ev.Changes = append(ev.Changes, c)
+		}
+	}
+	if len(ev.Changes) == 0 {
+		ev.Status = "unchanged"
+		return ev
+	}
+	setConfig(loaded.withStartupSettings(running))
+	ev.Status = "applied"
+	return ev
+}
+
+// run reloads on SIGHUP and when the file at path is written or replaced,
+// until ctx is done. An empty path reloads on SIGHUP only.
+func (r *configReloader) run(ctx context.Context, path string) {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	defer signal.Stop(hup)
+
+	var events <-chan fsnotify.Event
+	var errs <-chan error
+	if path != "" {
+		// Watch the directory: editors and config management often replace
+		// the file by renaming a new one over it, which ends a file watch
+		path = mustAbs(path)
+		w, err := fsnotify.NewWatcher()
+		if err == nil {
+			err = w.Add(filepath.Dir(path))
+		}
+		if err != nil {
+			_, _ = fmt.Fprintf(os.Stderr, "config watch: %v; reload with SIGHUP\n", err)
+			dprintf("config watch error: %v", err)
+		} else {
+			defer w.Close()
+			events, errs = w.Events, w.Errors
+		}
+	}
+
+	settle := time.NewTimer(0)
+	<-settle.C
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-hup:
+			r.reload(reloadSignal)
+		case ev, ok := <-events:
+			if !ok {
+				events = nil
+				continue
+			}
+			if ev.Name == path && !ev.Has(fsnotify.Chmod) {
+				// Writes arrive in bursts; reload once the file settles
+				settle.Reset(watchCoalesceMs * time.Millisecond)
+			}
+		case err, ok := <-errs:
+			if !ok {
+				errs = nil
+				continue
+			}
+			dprintf("config watch error: %v", err)
+		case <-settle.C:
+			r.reload(reloadFile)
+		}
+	}
+}
+
+// withStartupSettings returns a copy of c that keeps the settings of running
+// which only take effect at startup
+func (c *ServerConfig) withStartupSettings(running *ServerConfig) *ServerConfig {
+	next := *c
+	next.Root = running.Root
+	next.Memfs = running.Memfs
+	next.Debug = running.Debug
+	next.CompatMode = running.CompatMode
+	next.WatchPoll = running.WatchPoll
+	next.Audit = running.Audit
+	next.HTTP = running.HTTP
+	return &next
+}
+
+// settings flattens c into values keyed by setting name, as used in the
+// config file; tool settings are keyed tools.<tool>.<setting>
+func (c *ServerConfig) settings() map[string]string {
+	var readOnly bool
+	var rules []string
+	if c.Policy != nil {
+		readOnly = c.Policy.ReadOnly
+		for _, r := range c.Policy.Rules {
+			rules = append(rules, r.String())
+		}
+	}
+	token := ""
+	if c.HTTP.Token != "" {
+		token = "(set)" // never log the secret
+	}
+	m := map[string]string{
+		"root":              c.Root,
+		"memfs":             strconv.FormatBool(c.Memfs),
+		"debug":             c.Debug,
+		"compat":            strconv.FormatBool(c.CompatMode),
+		"watch-poll":        strconv.FormatBool(c.WatchPoll),
+		"workers":           strconv.Itoa(c.Workers),
+		"max-size":          strconv.FormatInt(c.MaxFileSize, 10),
+		"lock-timeout":      strconv.Itoa(c.LockTimeout),
+		"read-only":         strconv.FormatBool(readOnly),
+		"path-rule":         strings.Join(rules, ", "),
+		"audit-log":         c.Audit.Log,
+		"audit-max-size":    strconv.FormatInt(c.Audit.MaxSize, 10),
+		"audit-max-backups": strconv.Itoa(c.Audit.MaxBackups),
+		"history-max-bytes": strconv.FormatInt(c.History.MaxBytes, 10),
+		"history-max-age":   c.History.MaxAge.String(),
+		"transport":         c.HTTP.Transport,
+		"listen":            c.HTTP.Addr,
+		"tls-cert":          c.HTTP.CertFile,
+		"tls-key":           c.HTTP.KeyFile,
+		"tls-client-ca":     c.HTTP.ClientCAFile,
+		"auth-token":        token,
+	}
+	for name, tc := range c.Tools {
+		m["tools."+name+".disabled"] = strconv.FormatBool(tc.Disabled)
+		m["tools."+name+".default"] = strconv.Itoa(tc.Default)
+		m["tools."+name+".max"] = strconv.Itoa(tc.Max)
+	}
+	return m
+}
+
+// diffConfig lists the settings that differ between old and new, sorted by
+// setting name. Settings of tools missing from one side count as empty.
+func diffConfig(old, new *ServerConfig) []configChange {
+	was, now := old.settings(), new.settings()
+	var changes []configChange
+	for k, v := range now {
+		if was[k] != v {
+			changes = append(changes, configChange{Setting: k, Old: was[k], New: v})
+		}
+	}
+	for k, v := range was {
+		if _, ok := now[k]; !ok {
+			changes = append(changes, configChange{Setting: k, Old: v})
+		}
+	}
+	sort.Slice(changes, func(i, j int) bool { return changes[i].Setting < changes[j].Setting })
+	return changes
+}
+
+// logReload writes ev as one JSON line to stderr and the debug log
+func logReload(ev *reloadEvent) {
+	b, err := json.Marshal(ev)
+	if err != nil {
+		return
+	}
+	_, _ = fmt.Fprintf(os.Stderr, "%s\n", b)
+	dprintf("%s", b)
+}
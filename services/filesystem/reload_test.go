@@ -0,0 +1,148 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/mark3labs/mcp-go/mcp"
+)
+
+// startConfig writes body to a config file, loads it as the running
+// configuration and returns a reloader that re-reads it
+func startConfig(t *testing.T, root, body string) (string, *configReloader) {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "fs.yaml")
+	mustWrite(t, path, []byte(body), 0o644)
+	args := []string{"--config", path}
+	if root != "" {
+		args = append(args, "--root", root)
+	}
+	noenv := func(string) string { return "" }
+	cfg, err := loadConfig(args, noenv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	withConfig(t, func(c *ServerConfig) { *c = *cfg })
+	return path, newConfigReloader(func() (*ServerConfig, error) { return reloadConfig(args, noenv) })
+}
+
+func TestConfigReloadSkipsStartupValidation(t *testing.T) {
+	root := t.TempDir()
+	path, r := startConfig(t, "", "root: "+root+"\n")
+
+	// A root and transport that startup would refuse only need a restart
+	bad := "root: " + filepath.Join(root, "missing") + "\ntransport: carrier-pigeon\nmax-size: 8192\n"
+	mustWrite(t, path, []byte(bad), 0o644)
+	if _, err := loadConfig([]string{"--config", path}, func(string) string { return "" }); err == nil {
+		t.Fatal("startup accepted a missing root")
+	}
+	ev := r.reload(reloadFile)
+	if ev.Status != "applied" || len(ev.RestartRequired) != 2 {
+		t.Fatalf("reload = %+v", ev)
+	}
+	if c := currentConfig(); c.MaxFileSize != 8192 || c.Root != root {
+		t.Fatalf("config after reload: max-size=%d root=%s", c.MaxFileSize, c.Root)
+	}
+}
+
+func TestConfigReload(t *testing.T) {
+	root := t.TempDir()
+	path, r := startConfig(t, root, "max-size: 4096\n")
+
+	mustWrite(t, path, []byte("max-size: 8192\ntransport: http\ntools:\n  fs_read: {max: 100000}\n"), 0o644)
+	ev := r.reload(reloadSignal)
+	if ev.Status != "applied" {
+		t.Fatalf("reload = %+v", ev)
+	}
+	want := []configChange{
+		{Setting: "max-size", Old: "4096", New: "8192"},
+		{Setting: "tools.fs_read.max", Old: "0", New: "100000"},
+	}
+	if len(ev.Changes) != len(want) || ev.Changes[0] != want[0] || ev.Changes[1] != want[1] {
+		t.Errorf("changes = %+v", ev.Changes)
+	}
+	if len(ev.RestartRequired) != 1 || ev.RestartRequired[0].Setting != "transport" {
+		t.Errorf("restart required = %+v", ev.RestartRequired)
+	}
+	c := currentConfig()
+	if c.MaxFileSize != 8192 || c.toolLimit("fs_read", 1<<20) != 100000 {
+		t.Errorf("config not applied: max-size=%d", c.MaxFileSize)
+	}
+	if c.HTTP.Transport != transportStdio {
+		t.Errorf("transport changed to %s without a restart", c.HTTP.Transport)
+	}
+
+	// An invalid file leaves the running configuration in place
+	mustWrite(t, path, []byte("max-size: 8192\nworkers: 99\n"), 0o644)
+	if ev := r.reload(reloadSignal); ev.Status != "rejected" || ev.Error == "" {
+		t.Errorf("invalid reload = %+v", ev)
+	}
+	if currentConfig() != c {
+		t.Error("rejected reload replaced the configuration")
+	}
+
+	mustWrite(t, path, []byte("max-size: 8192\ntransport: http\ntools:\n  fs_read: {max: 100000}\n"), 0o644)
+	if ev := r.reload(reloadSignal); ev.Status != "unchanged" {
+		t.Errorf("unchanged reload = %+v", ev)
+	}
+}
+
+func TestConfigReloadPolicyReachesSessions(t *testing.T) {
+	root := t.TempDir()
+	mustWrite(t, filepath.Join(root, "f.txt"), []byte("hi"), 0o644)
+	path, r := startConfig(t, root, "read-only: false\n")
+	ctx, sessions, mu := testSession(root)
+	write := func() error {
+		_, err := handleWrite(sessions, mu)(ctx, mcp.CallToolRequest{}, WriteArgs{Path: "f.txt", Content: "x", Strategy: strategyOverwrite})
+		return err
+	}
+	if err := write(); err != nil {
+		t.Fatal(err)
+	}
+
+	mustWrite(t, path, []byte("path-rule: [deny:write:f.txt]\n"), 0o644)
+	if ev := r.reload(reloadSignal); ev.Status != "applied" {
+		t.Fatalf("reload = %+v", ev)
+	}
+	if err := write(); !errors.Is(err, ErrPermissionDenied) {
+		t.Fatalf("write after reload: %v", err)
+	}
+
+	// A session with its own policy keeps it
+	sessions["s1"].Policy = mustPolicy(t, false)
+	if err := write(); err != nil {
+		t.Fatalf("session policy overridden: %v", err)
+	}
+}
+
+func TestConfigReloadWatchesFile(t *testing.T) {
+	path, r := startConfig(t, t.TempDir(), "lock-timeout: 3\n")
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	done := make(chan struct{})
+	go func() {
+		r.run(ctx, path)
+		close(done)
+	}()
+	time.Sleep(50 * time.Millisecond) // let the watch start
+
+	// Replace the file the way editors do
+	tmp := path + ".new"
+	mustWrite(t, tmp, []byte("lock-timeout: 7\n"), 0o644)
+	if err := os.Rename(tmp, path); err != nil {
+		t.Fatal(err)
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for currentConfig().LockTimeout != 7 {
+		if time.Now().After(deadline) {
+			t.Fatal("config file change not reloaded")
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	cancel()
+	<-done
+}
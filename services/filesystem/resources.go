@@ -0,0 +1,284 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"io"
+	"io/fs"
+	"mime"
+	"net/url"
+	"path"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/mark3labs/mcp-go/mcp"
+	"github.com/mark3labs/mcp-go/server"
+)
+
+const (
+	fileResourceTemplate = "file:///{+path}"
+	uriListMIME          = "text/uri-list"
+
+	methodResourcesSubscribe   mcp.MCPMethod = "resources/subscribe"
+	methodResourcesUnsubscribe mcp.MCPMethod = "resources/unsubscribe"
+)
+
+// resourceURI returns the resource URI for a backend name. Resource URIs
+// are relative to the base folder, so file:///src/a.go is src/a.go.
+func resourceURI(name string) string {
+	return "file:///" + (&url.URL{Path: name}).EscapedPath()
+}
+
+// resourceName maps a resource URI back to a request path for Resolve
+func resourceName(uri string) (string, error) {
+	u, err := url.Parse(uri)
+	if err != nil || u.Scheme != "file" || (u.Host != "" && u.Host != "localhost") {
+		return "", &ValidationError{Field: "uri", Value: uri, Message: "not a file:/// resource URI"}
+	}
+	name := strings.TrimPrefix(u.Path, "/")
+	if name == "" {
+		name = "."
+	}
+	return name, nil
+}
+
+// registerResources exposes the session's files as MCP resources. Reads go
+// through the resource template; listing and subscriptions are served by
+// router because mcp-go only lists static resources and has no subscribe.
+func registerResources(s *server.MCPServer, router *rpcRouter, sessions map[string]*SessionState, mu *sync.RWMutex) {
+	tmpl := mcp.NewResourceTemplate(fileResourceTemplate, "file",
+		mcp.WithTemplateDescription("File or directory within the base folder; directories read as a text/uri-list of their entries"),
+	)
+	s.AddResourceTemplate(tmpl, handleReadResource(sessions, mu))
+	router.handle(mcp.MethodResourcesList, handleListResources(sessions, mu))
+	subs := &resourceSubscriptions{srv: s, sessions: sessions, mu: mu, subs: map[resourceSub]subscription{}}
+	router.handle(methodResourcesSubscribe, subs.subscribe)
+	router.handle(methodResourcesUnsubscribe, subs.unsubscribe)
+}
+
+func handleReadResource(sessions map[string]*SessionState, mu *sync.RWMutex) server.ResourceTemplateHandlerFunc {
+	return func(ctx context.Context, req mcp.ReadResourceRequest) ([]mcp.ResourceContents, error) {
+		state, err := getSessionState(ctx, sessions, mu)
+		if err != nil {
+			return nil, err
+		}
+		start := time.Now()
+		uri := req.Params.URI
+		dprintf("%s -> resources/read uri=%q", sessionContext(ctx), uri)
+		reqPath, err := resourceName(uri)
+		if err != nil {
+			return nil, err
+		}
+		name, err := state.FS.Resolve(reqPath, true)
+		if err != nil {
+			dprintf("resources/read error: %v", err)
+			return nil, err
+		}
+		if err := checkAccess(ctx, state, "resource_read", capRead, uri, name); err != nil {
+			dprintf("resources/read error: %v", err)
+			return nil, err
+		}
+		fi, err := state.FS.Stat(name)
+		if err != nil {
+			dprintf("resources/read error: %v", err)
+			return nil, err
+		}
+		if fi.IsDir() {
+			entries, err := state.FS.ReadDir(name)
+			if err != nil {
+				return nil, err
+			}
+			var b strings.Builder
+			for _, e := range entries {
+				child := path.Join(name, e.Name())
+				if state.readable(child) {
+					b.WriteString(resourceURI(child) + "\r\n")
+				}
+			}
+			dprintf("<- resources/read ok dir entries=%d dur=%s", len(entries), time.Since(start))
+			return []mcp.ResourceContents{mcp.TextResourceContents{URI: uri, MIMEType: uriListMIME, Text: b.String()}}, nil
+		}
+		if fi.Size() > currentConfig().MaxFileSize {
+			return nil, newOpError("resource_read", uri, ErrFileTooLarge)
+		}
+		f, err := state.FS.Open(name)
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+		data, err := io.ReadAll(io.LimitReader(f, currentConfig().MaxFileSize))
+		if err != nil {
+			return nil, err
+		}
+		mt := detectMIME(name, data)
+		if tf, ok := detectTextFormat(data); ok {
+			text, err := decodeText(data, tf)
+			if err == nil {
+				dprintf("<- resources/read ok text bytes=%d dur=%s", len(data), time.Since(start))
+				return []mcp.ResourceContents{mcp.TextResourceContents{URI: uri, MIMEType: mt, Text: text}}, nil
+			}
+		}
+		dprintf("<- resources/read ok blob bytes=%d dur=%s", len(data), time.Since(start))
+		return []mcp.ResourceContents{mcp.BlobResourceContents{URI: uri, MIMEType: mt, Blob: base64.StdEncoding.EncodeToString(data)}}, nil
+	}
+}
+
+// handleListResources lists readable regular files in name order, a page
+// at a time. The cursor is the encoded name of the last file returned.
+func handleListResources(sessions map[string]*SessionState, mu *sync.RWMutex) rpcHandler {
+	return func(ctx context.Context, params json.RawMessage) (any, error) {
+		state, err := getSessionState(ctx, sessions, mu)
+		if err != nil {
+			return nil, err
+		}
+		start := time.Now()
+		var p mcp.PaginatedParams
+		if err := decodeParams(params, &p); err != nil {
+			return nil, err
+		}
+		dprintf("%s -> resources/list cursor=%q", sessionContext(ctx), p.Cursor)
+		var after string
+		if p.Cursor != "" {
+			b, err := base64.RawURLEncoding.DecodeString(string(p.Cursor))
+			if err != nil {
+				return nil, &ValidationError{Field: "cursor", Value: string(p.Cursor), Message: "not a cursor returned by resources/list"}
+			}
+			after = string(b)
+		}
+		var names []string
+		err = walkBackend(state.FS, "", func(name string, d fs.DirEntry, err error) error {
+			if err != nil || name == "" {
+				return nil
+			}
+			if !state.readable(name) || internalArtifact(name) {
+				if d.IsDir() {
+					return fs.SkipDir
+				}
+				return nil
+			}
+			if d.Type().IsRegular() && name > after {
+				names = append(names, name)
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+		slices.Sort(names)
+		res := mcp.ListResourcesResult{Resources: []mcp.Resource{}}
+		if len(names) > defaultResourcePageSize {
+			names = names[:defaultResourcePageSize]
+			res.NextCursor = mcp.Cursor(base64.RawURLEncoding.EncodeToString([]byte(names[len(names)-1])))
+		}
+		for _, name := range names {
+			res.Resources = append(res.Resources, mcp.Resource{
+				URI:      resourceURI(name),
+				Name:     name,
+				MIMEType: mime.TypeByExtension(path.Ext(name)),
+			})
+		}
+		dprintf("<- resources/list ok count=%d more=%v dur=%s", len(res.Resources), res.NextCursor != "", time.Since(start))
+		return res, nil
+	}
+}
+
+// resourceSub identifies one client's subscription to one URI
+type resourceSub struct {
+	client string
+	uri    string
+}
+
+// resourceSubscriptions backs resources/subscribe with watches in the
+// session's watchStore; each subscription owns one watch
+type resourceSubscriptions struct {
+	srv      *server.MCPServer
+	sessions map[string]*SessionState
+	mu       *sync.RWMutex
+
+	subMu sync.Mutex
+	subs  map[resourceSub]subscription
+}
+
+// subscription is the watch serving a subscription and the store holding it,
+// which stays put if the client switches sessions
+type subscription struct {
+	ws *watchStore
+	id string
+}
+
+func subscriptionKey(ctx context.Context, uri string) resourceSub {
+	k := resourceSub{uri: uri}
+	if cs := server.ClientSessionFromContext(ctx); cs != nil {
+		k.client = cs.SessionID()
+	}
+	return k
+}
+
+func (rs *resourceSubscriptions) subscribe(ctx context.Context, params json.RawMessage) (any, error) {
+	state, err := getSessionState(ctx, rs.sessions, rs.mu)
+	if err != nil {
+		return nil, err
+	}
+	var p mcp.SubscribeParams
+	if err := decodeParams(params, &p); err != nil {
+		return nil, err
+	}
+	dprintf("%s -> resources/subscribe uri=%q", sessionContext(ctx), p.URI)
+	reqPath, err := resourceName(p.URI)
+	if err != nil {
+		return nil, err
+	}
+	name, err := state.FS.Resolve(reqPath, false)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkAccess(ctx, state, "resource_subscribe", capRead, p.URI, name); err != nil {
+		return nil, err
+	}
+	key := subscriptionKey(ctx, p.URI)
+	rs.subMu.Lock()
+	defer rs.subMu.Unlock()
+	if _, ok := rs.subs[key]; ok {
+		return mcp.EmptyResult{}, nil
+	}
+	// Ending the client removes the watch, which drops the subscription
+	w := &watch{id: uuid.NewString(), paths: []string{name}, client: key.client}
+	w.ended = func() {
+		rs.subMu.Lock()
+		defer rs.subMu.Unlock()
+		if sub, ok := rs.subs[key]; ok && sub.id == w.id {
+			delete(rs.subs, key)
+		}
+	}
+	if notify := sessionNotifier(rs.srv, server.ClientSessionFromContext(ctx), mcp.MethodNotificationResourceUpdated); notify != nil {
+		uri := p.URI
+		w.notify = func(map[string]any) { notify(map[string]any{"uri": uri}) }
+	}
+	ws := state.watches()
+	if _, _, err := ws.add(w, []watchRoot{watchRootOf(state.FS, name)}); err != nil {
+		return nil, err
+	}
+	rs.subs[key] = subscription{ws: ws, id: w.id}
+	dprintf("<- resources/subscribe ok watch=%s", w.id)
+	return mcp.EmptyResult{}, nil
+}
+
+func (rs *resourceSubscriptions) unsubscribe(ctx context.Context, params json.RawMessage) (any, error) {
+	var p mcp.UnsubscribeParams
+	if err := decodeParams(params, &p); err != nil {
+		return nil, err
+	}
+	dprintf("%s -> resources/unsubscribe uri=%q", sessionContext(ctx), p.URI)
+	key := subscriptionKey(ctx, p.URI)
+	rs.subMu.Lock()
+	defer rs.subMu.Unlock()
+	if sub, ok := rs.subs[key]; ok {
+		sub.ws.remove(sub.id)
+		delete(rs.subs, key)
+	}
+	return mcp.EmptyResult{}, nil
+}
@@ -0,0 +1,150 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/mark3labs/mcp-go/mcp"
+	"github.com/mark3labs/mcp-go/server"
+)
+
+// resourceServer returns a server with resources registered for root and a
+// context carrying a client session that records its notifications
+func resourceServer(t *testing.T, root string) (context.Context, *server.MCPServer, *rpcRouter, *notifySession, map[string]*SessionState) {
+	t.Helper()
+	sessions := map[string]*SessionState{"s1": {FS: newLocalBackend(root)}}
+	var mu sync.RWMutex
+	srv := server.NewMCPServer("test", "1.0.0", server.WithResourceCapabilities(true, false))
+	router := newRPCRouter(srv)
+	registerResources(srv, router, sessions, &mu)
+	client := &notifySession{ch: make(chan mcp.JSONRPCNotification, 8)}
+	ctx := withSessionManager(context.Background(), &sessionManager{id: "s1"})
+	if err := srv.RegisterSession(ctx, client); err != nil {
+		t.Fatal(err)
+	}
+	return srv.WithContext(ctx, client), srv, router, client, sessions
+}
+
+func readResource(t *testing.T, ctx context.Context, srv *server.MCPServer, uri string) (mcp.ResourceContents, error) {
+	t.Helper()
+	msg := srv.HandleMessage(ctx, []byte(fmt.Sprintf(`{"jsonrpc":"2.0","id":1,"method":"resources/read","params":{"uri":%q}}`, uri)))
+	switch m := msg.(type) {
+	case mcp.JSONRPCResponse:
+		return m.Result.(mcp.ReadResourceResult).Contents[0], nil
+	case mcp.JSONRPCError:
+		return nil, fmt.Errorf("%s", m.Error.Message)
+	}
+	t.Fatalf("unexpected message %+v", msg)
+	return nil, nil
+}
+
+func TestResourceRead(t *testing.T) {
+	root := t.TempDir()
+	mustWrite(t, filepath.Join(root, "src", "a.txt"), []byte("hello\n"), 0o644)
+	mustWrite(t, filepath.Join(root, "my file.bin"), binaryBlob(), 0o644)
+	ctx, srv, _, _, _ := resourceServer(t, root)
+
+	c, err := readResource(t, ctx, srv, "file:///src/a.txt")
+	if tc, ok := c.(mcp.TextResourceContents); err != nil || !ok || tc.Text != "hello\n" || tc.MIMEType != "text/plain; charset=utf-8" {
+		t.Fatalf("text = %+v %v", c, err)
+	}
+	c, err = readResource(t, ctx, srv, "file:///my%20file.bin")
+	if bc, ok := c.(mcp.BlobResourceContents); err != nil || !ok || bc.Blob == "" {
+		t.Fatalf("blob = %+v %v", c, err)
+	}
+	c, err = readResource(t, ctx, srv, "file:///")
+	if tc, ok := c.(mcp.TextResourceContents); err != nil || !ok || tc.MIMEType != uriListMIME || tc.Text != "file:///my%20file.bin\r\nfile:///src\r\n" {
+		t.Fatalf("dir = %+v %v", c, err)
+	}
+	if _, err := readResource(t, ctx, srv, "file:///../outside.txt"); err == nil {
+		t.Fatal("escape accepted")
+	}
+}
+
+func TestResourceListPages(t *testing.T) {
+	root := t.TempDir()
+	for i := range defaultResourcePageSize + 5 {
+		mustWrite(t, filepath.Join(root, "d", fmt.Sprintf("f%03d.txt", i)), []byte("x"), 0o644)
+	}
+	mustWrite(t, filepath.Join(root, ".mcpfs-commit-1", "x"), []byte("x"), 0o644)
+	ctx, _, router, _, _ := resourceServer(t, root)
+
+	var uris []string
+	cursor := ""
+	for pages := 0; ; pages++ {
+		if pages > 2 {
+			t.Fatal("too many pages")
+		}
+		msg, ok := router.dispatch(ctx, []byte(fmt.Sprintf(`{"jsonrpc":"2.0","id":1,"method":"resources/list","params":{"cursor":%q}}`, cursor)))
+		if !ok {
+			t.Fatal("resources/list not routed")
+		}
+		res := msg.(mcp.JSONRPCResponse).Result.(mcp.ListResourcesResult)
+		for _, r := range res.Resources {
+			uris = append(uris, r.URI)
+		}
+		if cursor = string(res.NextCursor); cursor == "" {
+			break
+		}
+	}
+	if len(uris) != defaultResourcePageSize+5 || uris[0] != "file:///d/f000.txt" || uris[len(uris)-1] != "file:///d/f104.txt" {
+		t.Fatalf("listed %d: %v ... %v", len(uris), uris[0], uris[len(uris)-1])
+	}
+	msg, _ := router.dispatch(ctx, []byte(`{"jsonrpc":"2.0","id":2,"method":"resources/list","params":{"cursor":"!!"}}`))
+	if e, ok := msg.(mcp.JSONRPCError); !ok || e.Error.Code != mcp.INVALID_PARAMS {
+		t.Fatalf("bad cursor = %+v", msg)
+	}
+	if _, ok := router.dispatch(ctx, []byte(`{"jsonrpc":"2.0","id":3,"method":"tools/list"}`)); ok {
+		t.Fatal("tools/list routed")
+	}
+}
+
+func TestResourceSubscribe(t *testing.T) {
+	root := t.TempDir()
+	mustWrite(t, filepath.Join(root, "a.txt"), []byte("one\n"), 0o644)
+	mustWrite(t, filepath.Join(root, "b.txt"), []byte("one\n"), 0o644)
+	ctx, _, router, client, sessions := resourceServer(t, root)
+	call := func(method, uri string) {
+		t.Helper()
+		params, _ := json.Marshal(map[string]string{"uri": uri})
+		msg, _ := router.dispatch(ctx, []byte(fmt.Sprintf(`{"jsonrpc":"2.0","id":1,"method":%q,"params":%s}`, method, params)))
+		if _, ok := msg.(mcp.JSONRPCResponse); !ok {
+			t.Fatalf("%s = %+v", method, msg)
+		}
+	}
+
+	call("resources/subscribe", "file:///a.txt")
+	mustWrite(t, filepath.Join(root, "b.txt"), []byte("two\n"), 0o644)
+	mustWrite(t, filepath.Join(root, "a.txt"), []byte("two\n"), 0o644)
+	select {
+	case n := <-client.ch:
+		if n.Method != mcp.MethodNotificationResourceUpdated || n.Params.AdditionalFields["uri"] != "file:///a.txt" {
+			t.Fatalf("notification = %+v", n)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("no notification")
+	}
+
+	call("resources/unsubscribe", "file:///a.txt")
+	if sessions["s1"].Watches.src != nil {
+		t.Fatal("watch kept after unsubscribe")
+	}
+
+	// Subscriptions go with the client session that made them
+	call("resources/subscribe", "file:///a.txt")
+	var b sessionBindings
+	b.drop(client.SessionID())
+	if sessions["s1"].Watches.src != nil {
+		t.Fatal("subscription survived its client session")
+	}
+	call("resources/subscribe", "file:///a.txt")
+	if sessions["s1"].Watches.src == nil {
+		t.Fatal("stale subscription blocked a new one")
+	}
+	call("resources/unsubscribe", "file:///a.txt")
+}
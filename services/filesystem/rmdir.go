@@ -20,16 +20,19 @@ func handleRmdir(sessions map[string]*SessionState, mu *sync.RWMutex) mcp.Struct
 		if err != nil {
 			return RmdirResult{}, err
 		}
-		root := state.Root
 		start := time.Now()
 		dprintf("%s -> fs_rmdir path=%q recursive=%v", sessionContext(ctx), args.Path, args.Recursive)
 		var out RmdirResult
-		full, err := safeJoin(root, args.Path)
+		name, err := state.FS.Resolve(args.Path, false)
 		if err != nil {
 			dprintf("fs_rmdir error: %v", err)
 			return out, err
 		}
-		fi, err := os.Lstat(full)
+		if err := checkAccess(ctx, state, "rmdir", capDelete, args.Path, name); err != nil {
+			dprintf("fs_rmdir error: %v", err)
+			return out, err
+		}
+		fi, err := state.FS.Lstat(name)
 		if err != nil {
 			if os.IsNotExist(err) {
 				dprintf("fs_rmdir path does not exist, idempotent success")
@@ -45,16 +48,20 @@ func handleRmdir(sessions map[string]*SessionState, mu *sync.RWMutex) mcp.Struct
 			return out, fmt.Errorf("not a directory: %s", args.Path)
 		}
 		if args.Recursive {
-			if err := os.RemoveAll(full); err != nil {
-				dprintf("fs_rmdir RemoveAll error: %v", err)
-				return out, err
-			}
-		} else {
-			if err := os.Remove(full); err != nil {
-				dprintf("fs_rmdir Remove error: %v", err)
+			if err := checkTreeWritable(state, "rmdir", args.Path, name); err != nil {
+				dprintf("fs_rmdir error: %v", err)
 				return out, err
 			}
 		}
+		defer snapshotHistory(ctx)()
+		remove := state.FS.Remove
+		if args.Recursive {
+			remove = state.FS.RemoveAll
+		}
+		if err := remove(name); err != nil {
+			dprintf("fs_rmdir remove error: %v", err)
+			return out, err
+		}
 		out = RmdirResult{Path: args.Path, Removed: true}
 		dprintf("<- fs_rmdir ok removed=true dur=%s", time.Since(start))
 		return out, nil
@@ -0,0 +1,255 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+
+	"github.com/mark3labs/mcp-go/mcp"
+	"github.com/mark3labs/mcp-go/server"
+)
+
+// rpcHandler answers one JSON-RPC request; the result is marshalled as is
+type rpcHandler func(ctx context.Context, params json.RawMessage) (any, error)
+
+// rpcRouter serves JSON-RPC methods that mcp-go does not implement, or
+// implements too narrowly, before messages reach the MCP server
+type rpcRouter struct {
+	srv          *server.MCPServer
+	methods      map[mcp.MCPMethod]rpcHandler
+	capabilities map[string]any // added to the server's own in initialize
+
+	hooks   *server.Hooks // the server's hooks, set by trackSessions
+	clients sync.Map      // session ID -> server.ClientSession registered by a transport
+	ended   []func(id string)
+}
+
+func newRPCRouter(srv *server.MCPServer) *rpcRouter {
+	return &rpcRouter{srv: srv, methods: map[mcp.MCPMethod]rpcHandler{}, capabilities: map[string]any{}}
+}
+
+func (r *rpcRouter) handle(method mcp.MCPMethod, h rpcHandler) {
+	r.methods[method] = h
+}
+
+// trackSessions records the client sessions transports register with the
+// server, so routed requests can run in the same session as mcp-go's own
+func (r *rpcRouter) trackSessions(hooks *server.Hooks) {
+	r.hooks = hooks
+	hooks.AddOnRegisterSession(func(_ context.Context, cs server.ClientSession) {
+		r.clients.Store(cs.SessionID(), cs)
+	})
+	hooks.AddOnUnregisterSession(func(_ context.Context, cs server.ClientSession) {
+		r.clients.Delete(cs.SessionID())
+	})
+}
+
+// onClientEnd registers fn to run when a transport reports that a client
+// session has ended
+func (r *rpcRouter) onClientEnd(fn func(id string)) {
+	r.ended = append(r.ended, fn)
+}
+
+// endClient runs the onClientEnd callbacks for the client session id
+func (r *rpcRouter) endClient(id string) {
+	for _, fn := range r.ended {
+		fn(id)
+	}
+}
+
+// client returns the registered session with id, if any
+func (r *rpcRouter) client(id string) (server.ClientSession, bool) {
+	cs, ok := r.clients.Load(id)
+	if !ok {
+		return nil, false
+	}
+	return cs.(server.ClientSession), true
+}
+
+// advertise declares a capability mcp-go does not know about, so clients
+// learn of methods served by the router
+func (r *rpcRouter) advertise(name string, v any) {
+	r.capabilities[name] = v
+	r.methods[mcp.MethodInitialize] = r.initialize
+}
+
+// initialize lets the MCP server answer initialize, then adds the
+// advertised capabilities to its result
+func (r *rpcRouter) initialize(ctx context.Context, params json.RawMessage) (any, error) {
+	req, err := json.Marshal(map[string]any{"jsonrpc": mcp.JSONRPC_VERSION, "id": 0, "method": mcp.MethodInitialize, "params": params})
+	if err != nil {
+		return nil, err
+	}
+	switch m := r.srv.HandleMessage(ctx, req).(type) {
+	case mcp.JSONRPCResponse:
+		b, err := json.Marshal(m.Result)
+		if err != nil {
+			return nil, err
+		}
+		var res map[string]any
+		if err := json.Unmarshal(b, &res); err != nil {
+			return nil, err
+		}
+		caps, _ := res["capabilities"].(map[string]any)
+		if caps == nil {
+			caps = map[string]any{}
+		}
+		for name, v := range r.capabilities {
+			caps[name] = v
+		}
+		res["capabilities"] = caps
+		return res, nil
+	case mcp.JSONRPCError:
+		return nil, errors.New(m.Error.Message)
+	default:
+		return nil, fmt.Errorf("unexpected initialize response %T", m)
+	}
+}
+
+// dispatch answers raw when it is a request for a routed method. Anything
+// else, including notifications, is left for the MCP server.
+func (r *rpcRouter) dispatch(ctx context.Context, raw []byte) (mcp.JSONRPCMessage, bool) {
+	var msg struct {
+		ID     any             `json:"id"`
+		Method mcp.MCPMethod   `json:"method"`
+		Params json.RawMessage `json:"params"`
+	}
+	if err := json.Unmarshal(raw, &msg); err != nil || msg.ID == nil {
+		return nil, false
+	}
+	h, ok := r.methods[msg.Method]
+	if !ok {
+		return nil, false
+	}
+	id := mcp.NewRequestId(msg.ID)
+	res, err := h(ctx, msg.Params)
+	if err != nil {
+		dprintf("%s error: %v", msg.Method, err)
+		code := mcp.INTERNAL_ERROR
+		var ve *ValidationError
+		switch {
+		case errors.As(err, &ve):
+			code = mcp.INVALID_PARAMS
+		case errors.Is(err, os.ErrNotExist):
+			code = mcp.RESOURCE_NOT_FOUND
+		}
+		return mcp.NewJSONRPCError(id, code, err.Error(), toErrorResponse(err)), true
+	}
+	return mcp.JSONRPCResponse{JSONRPC: mcp.JSONRPC_VERSION, ID: id, Result: res}, true
+}
+
+// decodeParams unmarshals request params, treating absent params as empty
+func decodeParams(params json.RawMessage, v any) error {
+	if len(params) == 0 {
+		return nil
+	}
+	if err := json.Unmarshal(params, v); err != nil {
+		return &ValidationError{Field: "params", Message: err.Error()}
+	}
+	return nil
+}
+
+// lockedWriter serialises whole writes so responses from the router and the
+// MCP server never interleave on stdout
+type lockedWriter struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+func (l *lockedWriter) Write(p []byte) (int, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.w.Write(p)
+}
+
+// serveStdio is server.ServeStdio with router in front: requests for routed
+// methods are answered here and every other line goes to mcp-go unchanged
+func serveStdio(s *server.MCPServer, router *rpcRouter, contextFunc server.StdioContextFunc) error {
+	ctx, cancel := signal.NotifyContext(context.Background(), syscall.SIGTERM, syscall.SIGINT)
+	defer cancel()
+
+	out := &lockedWriter{w: os.Stdout}
+	in, forward := io.Pipe()
+	// Routed handlers need the same session context as mcp-go's handlers;
+	// the stdio server builds it once before reading its first message
+	sessionCtx := make(chan context.Context, 1)
+	stdio := server.NewStdioServer(s)
+	stdio.SetContextFunc(func(ctx context.Context) context.Context {
+		ctx = contextFunc(ctx)
+		sessionCtx <- ctx
+		return ctx
+	})
+	go func() {
+		forward.CloseWithError(router.filter(os.Stdin, forward, out, sessionCtx))
+	}()
+	return stdio.Listen(ctx, in, out)
+}
+
+// filter copies lines from in to forward, answering routed requests on out
+// instead. It returns when in is exhausted.
+func (r *rpcRouter) filter(in io.Reader, forward, out io.Writer, sessionCtx <-chan context.Context) error {
+	var ctx context.Context
+	br := bufio.NewReader(in)
+	for {
+		line, err := br.ReadBytes('\n')
+		if len(line) > 0 {
+			if ctx == nil {
+				ctx = <-sessionCtx
+			}
+			switch method := r.route(line); method {
+			case "":
+				if _, werr := forward.Write(line); werr != nil {
+					return werr
+				}
+			case mcp.MethodInitialize:
+				// Answered before anything after it reaches the server
+				r.reply(ctx, line, out)
+			default:
+				go r.reply(ctx, line, out)
+			}
+		}
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// route returns the method of line when the router serves it, without
+// decoding params, or "" when the line belongs to the MCP server
+func (r *rpcRouter) route(line []byte) mcp.MCPMethod {
+	var msg struct {
+		Method mcp.MCPMethod `json:"method"`
+	}
+	if json.Unmarshal(line, &msg) != nil {
+		return ""
+	}
+	if _, ok := r.methods[msg.Method]; !ok {
+		return ""
+	}
+	return msg.Method
+}
+
+func (r *rpcRouter) reply(ctx context.Context, line []byte, out io.Writer) {
+	resp, ok := r.dispatch(ctx, line)
+	if !ok {
+		return
+	}
+	b, err := json.Marshal(resp)
+	if err != nil {
+		dprintf("rpc marshal error: %v", err)
+		return
+	}
+	if _, err := fmt.Fprintf(out, "%s\n", b); err != nil {
+		dprintf("rpc write error: %v", err)
+	}
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"encoding/json"
+	"reflect"
+
+	"github.com/invopop/jsonschema"
+	"github.com/mark3labs/mcp-go/mcp"
+)
+
+// schemaReflector generates tool schemas from the Args and Result structs.
+// Property names and required fields follow the json tags, descriptions come
+// from the description tags and constraints from the jsonschema tags. The
+// settings match those mcp-go uses for WithOutputSchema.
+var schemaReflector = jsonschema.Reflector{
+	DoNotReference:            true,
+	Anonymous:                 true,
+	AllowAdditionalProperties: true,
+	LookupComment:             fieldDescription,
+}
+
+// fieldDescription returns the description tag of field on struct type t
+func fieldDescription(t reflect.Type, field string) string {
+	if field == "" || t.Kind() != reflect.Struct {
+		return ""
+	}
+	f, ok := t.FieldByName(field)
+	if !ok {
+		return ""
+	}
+	return f.Tag.Get("description")
+}
+
+// schemaOf returns the JSON schema of T
+func schemaOf[T any]() json.RawMessage {
+	var zero T
+	s := schemaReflector.Reflect(zero)
+	s.Version = ""
+	b, err := json.Marshal(s)
+	if err != nil {
+		panic(err)
+	}
+	return b
+}
+
+// inputSchema replaces the schema mcp.NewTool starts with by that of T
+func inputSchema[T any]() mcp.ToolOption {
+	schema := schemaOf[T]()
+	return func(t *mcp.Tool) {
+		t.InputSchema.Type = ""
+		t.RawInputSchema = schema
+	}
+}
+
+func outputSchema[T any]() mcp.ToolOption {
+	return mcp.WithRawOutputSchema(schemaOf[T]())
+}
@@ -0,0 +1,220 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"reflect"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/mark3labs/mcp-go/mcp"
+)
+
+// toolArgs pairs every tool with the Args struct its handler decodes
+var toolArgs = map[string]any{
+	"fs_read":               ReadArgs{},
+	"fs_peek":               PeekArgs{},
+	"fs_tail":               TailArgs{},
+	"fs_read_many":          ReadManyArgs{},
+	"fs_write":              WriteArgs{},
+	"fs_write_begin":        WriteBeginArgs{},
+	"fs_write_chunk":        WriteChunkArgs{},
+	"fs_write_commit":       WriteCommitArgs{},
+	"fs_write_abort":        WriteAbortArgs{},
+	"fs_edit":               EditArgs{},
+	"fs_list":               ListArgs{},
+	"fs_stat":               StatArgs{},
+	"fs_search":             SearchArgs{},
+	"fs_glob":               GlobArgs{},
+	"fs_watch":              WatchArgs{},
+	"fs_unwatch":            UnwatchArgs{},
+	"fs_lock":               LockArgs{},
+	"fs_lock_renew":         LockRenewArgs{},
+	"fs_unlock":             UnlockArgs{},
+	"fs_changes_since":      ChangesSinceArgs{},
+	"fs_mkdir":              MkdirArgs{},
+	"fs_rmdir":              RmdirArgs{},
+	"fs_archive_create":     ArchiveCreateArgs{},
+	"fs_archive_extract":    ArchiveExtractArgs{},
+	"fs_undo":               UndoArgs{},
+	"fs_checkpoint":         CheckpointArgs{},
+	"fs_restore_checkpoint": RestoreCheckpointArgs{},
+	"fs_overlay_diff":       struct{}{},
+	"fs_overlay_commit":     struct{}{},
+	"fs_overlay_discard":    struct{}{},
+	"fs_audit_query":        AuditQueryArgs{},
+	"createsession":         CreateSessionArgs{},
+	"switchsession":         SwitchSessionArgs{},
+	"listsessions":          struct{}{},
+	"debuggingapproach":     DebuggingApproachArgs{},
+	"pendingdebug":          struct{}{},
+}
+
+// propertySchema is the part of a property schema the drift checks read
+type propertySchema struct {
+	Type        string   `json:"type"`
+	Description string   `json:"description"`
+	Enum        []string `json:"enum"`
+	Maximum     *float64 `json:"maximum"`
+	Items       *struct {
+		Enum []string `json:"enum"`
+	} `json:"items"`
+}
+
+type objectSchema struct {
+	Type       string                    `json:"type"`
+	Properties map[string]propertySchema `json:"properties"`
+	Required   []string                  `json:"required"`
+}
+
+func listTools(t *testing.T) []mcp.Tool {
+	t.Helper()
+	srv, _ := setupServer(newMemBackend())
+	msg := srv.HandleMessage(context.Background(), []byte(`{"jsonrpc":"2.0","id":1,"method":"tools/list"}`))
+	resp, ok := msg.(mcp.JSONRPCResponse)
+	if !ok {
+		t.Fatalf("tools/list = %+v", msg)
+	}
+	return resp.Result.(mcp.ListToolsResult).Tools
+}
+
+func TestToolSchemasMatchArgs(t *testing.T) {
+	tools := listTools(t)
+	if len(tools) != len(toolArgs) {
+		t.Errorf("%d tools registered, %d in toolArgs", len(tools), len(toolArgs))
+	}
+	for _, tool := range tools {
+		args, ok := toolArgs[tool.Name]
+		if !ok {
+			t.Errorf("%s: missing from toolArgs", tool.Name)
+			continue
+		}
+		var served, derived objectSchema
+		if err := json.Unmarshal(tool.RawInputSchema, &served); err != nil {
+			t.Fatalf("%s: %v", tool.Name, err)
+		}
+		if err := json.Unmarshal(schemaFor(reflect.TypeOf(args)), &derived); err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(served, derived) {
+			t.Errorf("%s: served schema differs from its Args struct\nserved:  %+v\nderived: %+v", tool.Name, served, derived)
+		}
+		if served.Type != "object" {
+			t.Errorf("%s: schema type %q", tool.Name, served.Type)
+		}
+
+		// Every field is a documented property, required unless omitempty
+		at := reflect.TypeOf(args)
+		fields := 0
+		for i := range at.NumField() {
+			f := at.Field(i)
+			name, opts, _ := strings.Cut(f.Tag.Get("json"), ",")
+			if name == "" || name == "-" {
+				t.Errorf("%s.%s: no json name", at.Name(), f.Name)
+				continue
+			}
+			fields++
+			p, ok := served.Properties[name]
+			if !ok {
+				t.Errorf("%s: property %s missing", tool.Name, name)
+				continue
+			}
+			if p.Description == "" || p.Description != f.Tag.Get("description") {
+				t.Errorf("%s.%s: description %q", tool.Name, name, p.Description)
+			}
+			required := !strings.Contains(opts, "omitempty")
+			if required != slices.Contains(served.Required, name) {
+				t.Errorf("%s.%s: required=%v in schema", tool.Name, name, !required)
+			}
+		}
+		if fields != len(served.Properties) {
+			t.Errorf("%s: %d properties for %d fields", tool.Name, len(served.Properties), fields)
+		}
+	}
+}
+
+// TestToolSchemaConstraints checks the values schemas advertise against the
+// code that interprets them
+func TestToolSchemaConstraints(t *testing.T) {
+	for _, tool := range listTools(t) {
+		var s objectSchema
+		if err := json.Unmarshal(tool.RawInputSchema, &s); err != nil {
+			t.Fatal(err)
+		}
+		for name, p := range s.Properties {
+			for _, v := range p.Enum {
+				var err error
+				switch name {
+				case "encoding":
+					if tool.Name == "fs_peek" || tool.Name == "fs_read" || tool.Name == "fs_tail" || tool.Name == "fs_read_many" {
+						_, err = encodeContent(contentEncoding(v), nil, 0)
+					} else {
+						_, err = decodeContent(contentEncoding(v), "")
+					}
+				case "charset":
+					_, err = parseCharset(v)
+				case "newline":
+					_, err = lineEndingsFor(newlinePolicy(v), "")
+				}
+				if err != nil {
+					t.Errorf("%s.%s: enum value %q rejected: %v", tool.Name, name, v, err)
+				}
+			}
+			if name == "capabilities" {
+				for _, v := range p.Items.Enum {
+					if !slices.Contains(capabilityNames(), v) {
+						t.Errorf("%s.%s: unknown capability %q", tool.Name, name, v)
+					}
+				}
+			}
+		}
+	}
+	waits := map[string]int{"fs_tail": maxTailWaitMs, "fs_changes_since": maxChangesWaitMs}
+	for _, tool := range listTools(t) {
+		limit, ok := waits[tool.Name]
+		if !ok {
+			continue
+		}
+		var s objectSchema
+		_ = json.Unmarshal(tool.RawInputSchema, &s)
+		if m := s.Properties["timeout_ms"].Maximum; m == nil || int(*m) != limit {
+			t.Errorf("%s.timeout_ms: maximum %v, handler allows %d", tool.Name, m, limit)
+		}
+	}
+}
+
+func TestToolAnnotations(t *testing.T) {
+	for _, tool := range listTools(t) {
+		a := tool.Annotations
+		if a.Title == "" || a.ReadOnlyHint == nil || a.DestructiveHint == nil || a.IdempotentHint == nil || a.OpenWorldHint == nil {
+			t.Errorf("%s: incomplete annotations %+v", tool.Name, a)
+			continue
+		}
+		if *a.ReadOnlyHint && *a.DestructiveHint {
+			t.Errorf("%s: read-only and destructive", tool.Name)
+		}
+	}
+	tools := map[string]mcp.Tool{}
+	for _, tool := range listTools(t) {
+		tools[tool.Name] = tool
+	}
+	if !*tools["fs_read"].Annotations.ReadOnlyHint || !*tools["fs_rmdir"].Annotations.DestructiveHint {
+		t.Error("fs_read must be read-only and fs_rmdir destructive")
+	}
+}
+
+func schemaFor(t reflect.Type) json.RawMessage {
+	s := schemaReflector.ReflectFromType(t)
+	s.Version = ""
+	b, _ := json.Marshal(s)
+	return b
+}
+
+func capabilityNames() []string {
+	var out []string
+	for _, c := range allCapabilities {
+		out = append(out, string(c))
+	}
+	return out
+}
@@ -9,7 +9,6 @@ import (
 	"os"
 	"path/filepath"
 	"regexp"
-	"runtime"
 	"strings"
 	"sync"
 	"sync/atomic"
@@ -22,16 +21,14 @@ import (
 type SearchConfig struct {
 	Workers    int
 	ScanBuffer int
+	Readable   func(name string) bool // optional filter applied to backend names
+	Decompress bool                   // decode compressed files instead of skipping them
 }
 
 // DefaultSearchConfig returns optimized search configuration
 func DefaultSearchConfig() SearchConfig {
-	workers := runtime.NumCPU()
-	if workers > 8 {
-		workers = 8 // Cap workers to prevent resource exhaustion
-	}
 	return SearchConfig{
-		Workers:    workers,
+		Workers:    currentConfig().GetWorkerCount("search"),
 		ScanBuffer: 64 * 1024, // 64KB initial buffer
 	}
 }
@@ -58,19 +55,15 @@ func handleSearch(sessions map[string]*SessionState, mu *sync.RWMutex) mcp.Struc
 		if err != nil {
 			return SearchResult{}, err
 		}
-		root := state.Root
 		start := time.Now()
-		dprintf("%s -> fs_search path=%q pattern=%q regex=%v max=%d", sessionContext(ctx), args.Path, args.Pattern, args.Regex, args.MaxResults)
+		dprintf("%s -> fs_search path=%q pattern=%q regex=%v max=%d decompress=%v", sessionContext(ctx), args.Path, args.Pattern, args.Regex, args.MaxResults, args.Decompress)
 
 		var out SearchResult
 		if args.Pattern == "" {
 			return out, newOpError("search", args.Path, ErrPatternRequired)
 		}
 
-		max := args.MaxResults
-		if max <= 0 {
-			max = defaultSearchMaxResults
-		}
+		max := currentConfig().toolLimit("fs_search", args.MaxResults)
 
 		// Compile regex if needed
 		var rx *regexp.Regexp
@@ -83,23 +76,28 @@ func handleSearch(sessions map[string]*SessionState, mu *sync.RWMutex) mcp.Struc
 		}
 
 		// Determine start path
-		startPath := root
+		startName := ""
 		if args.Path != "" {
-			p, err := safeJoin(root, args.Path)
+			p, err := state.FS.Resolve(args.Path, false)
 			if err != nil {
 				return out, newOpError("search", args.Path, err)
 			}
-			startPath = p
+			startName = p
 		}
 
 		// Verify path exists
-		if _, err := os.Stat(startPath); err != nil {
-			return out, newOpError("search", startPath, ErrPathNotFound)
+		if _, err := state.FS.Stat(startName); err != nil {
+			return out, newOpError("search", args.Path, ErrPathNotFound)
+		}
+		if err := checkAccess(ctx, state, "search", capRead, args.Path, startName); err != nil {
+			return out, err
 		}
 
 		// Set up search
 		config := DefaultSearchConfig()
-		matches, stats, err := performSearch(ctx, startPath, root, args.Pattern, rx, max, config)
+		config.Readable = state.listed
+		config.Decompress = args.Decompress
+		matches, stats, err := performSearch(ctx, state.FS, startName, args.Pattern, rx, max, config)
 		if err != nil {
 			return out, err
 		}
@@ -122,7 +120,7 @@ type searchStats struct {
 	bytesRead    int64
 }
 
-func performSearch(ctx context.Context, startPath, root, pattern string, rx *regexp.Regexp, max int, config SearchConfig) ([]SearchMatch, *searchStats, error) {
+func performSearch(ctx context.Context, b Backend, start, pattern string, rx *regexp.Regexp, max int, config SearchConfig) ([]SearchMatch, *searchStats, error) {
 	ctx, cancel := context.WithCancel(ctx)
 	defer cancel()
 
@@ -140,7 +138,7 @@ func performSearch(ctx context.Context, startPath, root, pattern string, rx *reg
 		defer walkWG.Done()
 		defer close(files)
 
-		walkErr = filepath.WalkDir(startPath, func(path string, d fs.DirEntry, err error) error {
+		walkErr = walkBackend(b, start, func(path string, d fs.DirEntry, err error) error {
 			if err != nil {
 				dprintf("walk error at %s: %v", path, err)
 				return nil // Continue walking
@@ -152,13 +150,22 @@ func performSearch(ctx context.Context, startPath, root, pattern string, rx *reg
 			default:
 			}
 
+			// Skip entries hidden by the access policy and the server's own files
+			if config.Readable != nil && path != start && !config.Readable(path) {
+				if d.IsDir() {
+					return fs.SkipDir
+				}
+				return nil
+			}
+
 			// Skip directories and symlinks
 			if d.IsDir() || d.Type()&os.ModeSymlink != 0 {
 				return nil
 			}
 
 			// Skip files that are likely binary based on extension
-			if isBinaryExtension(filepath.Ext(path)) {
+			compressed := config.Decompress && compressionByExt(path) != ""
+			if isBinaryExtension(filepath.Ext(path)) && !compressed {
 				return nil
 			}
 
@@ -168,8 +175,8 @@ func performSearch(ctx context.Context, startPath, root, pattern string, rx *reg
 				return nil
 			}
 
-			// Skip huge files (>100MB)
-			if info.Size() > 100<<20 {
+			// Skip huge files
+			if info.Size() > maxSearchFileBytes {
 				dprintf("skipping large file: %s (%d bytes)", path, info.Size())
 				return nil
 			}
@@ -200,7 +207,7 @@ func performSearch(ctx context.Context, startPath, root, pattern string, rx *reg
 					return
 				}
 
-				fileMatches, bytesRead := searchFile(path, pattern, rx, root, max-int(atomic.LoadInt32(&matchCount)), config)
+				fileMatches, bytesRead := searchFile(b, path, pattern, rx, max-int(atomic.LoadInt32(&matchCount)), config)
 
 				// Update stats
 				atomic.AddInt64(&stats.filesScanned, 1)
@@ -238,8 +245,8 @@ func performSearch(ctx context.Context, startPath, root, pattern string, rx *reg
 	return matches, stats, nil
 }
 
-func searchFile(path, pattern string, rx *regexp.Regexp, root string, maxMatches int, config SearchConfig) ([]SearchMatch, int64) {
-	f, err := os.Open(path)
+func searchFile(b Backend, path, pattern string, rx *regexp.Regexp, maxMatches int, config SearchConfig) ([]SearchMatch, int64) {
+	f, err := b.Open(path)
 	if err != nil {
 		return nil, 0
 	}
@@ -248,7 +255,18 @@ func searchFile(path, pattern string, rx *regexp.Regexp, root string, maxMatches
 	var matches []SearchMatch
 	var bytesRead int64
 
-	reader := bufio.NewReaderSize(f, config.ScanBuffer)
+	var r io.Reader = f
+	if config.Decompress {
+		// Uncompressed files pass through unchanged
+		dec, _, err := decompressStream(f)
+		if err != nil {
+			dprintf("decompress error in %s: %v", path, err)
+			return nil, 0
+		}
+		defer dec.Close()
+		r = io.LimitReader(dec, maxSearchFileBytes)
+	}
+	reader := bufio.NewReaderSize(r, config.ScanBuffer)
 
 	lineNo := 1
 	for len(matches) < maxMatches {
@@ -274,8 +292,6 @@ func searchFile(path, pattern string, rx *regexp.Regexp, root string, maxMatches
 		}
 
 		if found {
-			rel, _ := filepath.Rel(root, path)
-
 			// Truncate very long lines
 			displayText := line
 			if len(displayText) > 500 {
@@ -283,7 +299,7 @@ func searchFile(path, pattern string, rx *regexp.Regexp, root string, maxMatches
 			}
 
 			matches = append(matches, SearchMatch{
-				Path: filepath.ToSlash(rel),
+				Path: path,
 				Line: lineNo,
 				Text: displayText,
 			})
@@ -17,7 +17,7 @@ func TestSearchFileHandlesLongLines(t *testing.T) {
 		t.Fatalf("failed to write temp file: %v", err)
 	}
 
-	matches, bytesRead := searchFile(tmpFile, "needle", nil, tmpDir, 10, config)
+	matches, bytesRead := searchFile(newLocalBackend(tmpDir), "long.txt", "needle", nil, 10, config)
 	if len(matches) != 1 {
 		t.Fatalf("expected 1 match, got %d", len(matches))
 	}
@@ -49,222 +49,164 @@ func wrapStructuredHandler[TArgs any, TResult any](h mcp.StructuredToolHandlerFu
 	}
 }
 
-func setupServer(root string) *server.MCPServer {
-	s := server.NewMCPServer("fs-mcp-go", "0.1.0")
-
-	sessions := map[string]*SessionState{
-		"default": {Root: root},
-	}
-	var mu sync.RWMutex
-
-	readOpts := []mcp.ToolOption{
-		mcp.WithDescription("Read a file up to a byte limit."),
-		mcp.WithString("path", mcp.Required(), mcp.Description("File path or file:// URI within base folder")),
-		mcp.WithNumber("max_bytes", mcp.Min(1), mcp.Description("Maximum bytes to return")),
-	}
-	if !*compatFlag {
-		readOpts = append(readOpts, mcp.WithOutputSchema[ReadResult]())
-	}
-	readTool := mcp.NewTool("fs_read", readOpts...)
-	if *compatFlag {
-		s.AddTool(readTool, wrapTextHandler(handleRead(sessions, &mu), formatReadResult))
-	} else {
-		s.AddTool(readTool, wrapStructuredHandler(handleRead(sessions, &mu)))
-	}
-
-	peekOpts := []mcp.ToolOption{
-		mcp.WithDescription("Read a file window without loading the whole file"),
-		mcp.WithString("path", mcp.Required(), mcp.Description("File path")),
-		mcp.WithNumber("offset", mcp.Min(0), mcp.Description("Byte offset to start at")),
-		mcp.WithNumber("max_bytes", mcp.Min(1), mcp.Description("Window size in bytes")),
-	}
-	if !*compatFlag {
-		peekOpts = append(peekOpts, mcp.WithOutputSchema[PeekResult]())
-	}
-	peekTool := mcp.NewTool("fs_peek", peekOpts...)
-	if *compatFlag {
-		s.AddTool(peekTool, wrapTextHandler(handlePeek(sessions, &mu), formatPeekResult))
-	} else {
-		s.AddTool(peekTool, wrapStructuredHandler(handlePeek(sessions, &mu)))
-	}
-
-	writeOpts := []mcp.ToolOption{
-		mcp.WithDescription("Create or modify a file with a strategy"),
-		mcp.WithString("path", mcp.Required(), mcp.Description("Target file path")),
-		mcp.WithString("content", mcp.Required(), mcp.Description("Data to write")),
-		mcp.WithString("strategy", mcp.Enum(string(strategyOverwrite), string(strategyNoClobber), string(strategyAppend), string(strategyPrepend), string(strategyReplaceRange)), mcp.Description("Write strategy: overwrite, no_clobber, append, prepend, replace_range")),
-		mcp.WithString("mode", mcp.Pattern("^0?[0-7]{3,4}$"), mcp.Description("File mode in octal, keep existing if omitted")),
-		mcp.WithNumber("start", mcp.Min(0), mcp.Description("Start byte for replace_range")),
-		mcp.WithNumber("end", mcp.Min(0), mcp.Description("End byte (exclusive) for replace_range")),
-	}
-	if !*compatFlag {
-		writeOpts = append(writeOpts, mcp.WithOutputSchema[WriteResult]())
-	}
-	writeTool := mcp.NewTool("fs_write", writeOpts...)
-	if *compatFlag {
-		s.AddTool(writeTool, wrapTextHandler(handleWrite(sessions, &mu), formatWriteResult))
-	} else {
-		s.AddTool(writeTool, wrapStructuredHandler(handleWrite(sessions, &mu)))
+// toolHints builds a tool's MCP annotations. Every tool works inside the
+// base folder, so none is open-world.
+func toolHints(title string, readOnly, destructive, idempotent bool) mcp.ToolAnnotation {
+	openWorld := false
+	return mcp.ToolAnnotation{
+		Title:           title,
+		ReadOnlyHint:    &readOnly,
+		DestructiveHint: &destructive,
+		IdempotentHint:  &idempotent,
+		OpenWorldHint:   &openWorld,
 	}
+}
 
-	editOpts := []mcp.ToolOption{
-		mcp.WithDescription("Search and replace text in a file"),
-		mcp.WithString("path", mcp.Required(), mcp.Description("Target text file")),
-		mcp.WithString("pattern", mcp.Required(), mcp.Description("Substring or regex to match")),
-		mcp.WithString("replace", mcp.Required(), mcp.Description("Replacement text; $1 etc. works in regex mode")),
-		mcp.WithBoolean("regex", mcp.Description("Treat pattern as a regular expression")),
-		mcp.WithNumber("count", mcp.Min(0), mcp.Description("Maximum replacements; 0 means all")),
-	}
-	if !*compatFlag {
-		editOpts = append(editOpts, mcp.WithOutputSchema[EditResult]())
-	}
-	editTool := mcp.NewTool("fs_edit", editOpts...)
-	if *compatFlag {
-		s.AddTool(editTool, wrapTextHandler(handleEdit(sessions, &mu), formatEditResult))
-	} else {
-		s.AddTool(editTool, wrapStructuredHandler(handleEdit(sessions, &mu)))
-	}
+// readOnlyHints annotates a tool that leaves files untouched
+func readOnlyHints(title string) mcp.ToolAnnotation {
+	return toolHints(title, true, false, true)
+}
 
-	listOpts := []mcp.ToolOption{
-		mcp.WithDescription("List directory contents"),
-		mcp.WithString("path", mcp.Required(), mcp.Description("Directory to list")),
-		mcp.WithBoolean("recursive", mcp.Description("Recurse into subdirectories")),
-		mcp.WithNumber("max_entries", mcp.Min(1), mcp.Description("Maximum entries to return")),
-	}
-	if !*compatFlag {
-		listOpts = append(listOpts, mcp.WithOutputSchema[ListResult]())
-	}
-	listTool := mcp.NewTool("fs_list", listOpts...)
-	if *compatFlag {
-		s.AddTool(listTool, wrapTextHandler(handleList(sessions, &mu), formatListResult))
-	} else {
-		s.AddTool(listTool, wrapStructuredHandler(handleList(sessions, &mu)))
+// toolEnabled rejects calls to name while the configuration disables it
+func toolEnabled[TArgs any, TResult any](name string, h mcp.StructuredToolHandlerFunc[TArgs, TResult]) mcp.StructuredToolHandlerFunc[TArgs, TResult] {
+	return func(ctx context.Context, req mcp.CallToolRequest, args TArgs) (TResult, error) {
+		if currentConfig().Tools[name].Disabled {
+			var zero TResult
+			return zero, newOpError(name, "", ErrPermissionDenied, "tool disabled by configuration")
+		}
+		return h(ctx, req, args)
 	}
+}
 
-	searchOpts := []mcp.ToolOption{
-		mcp.WithDescription("Search files recursively for text"),
-		mcp.WithString("pattern", mcp.Required(), mcp.Description("Substring or regex to find")),
-		mcp.WithString("path", mcp.Description("Start directory relative to base folder")),
-		mcp.WithBoolean("regex", mcp.Description("Interpret pattern as regular expression")),
-		mcp.WithNumber("max_results", mcp.Min(1), mcp.Description("Maximum matches to return")),
-	}
-	if !*compatFlag {
-		searchOpts = append(searchOpts, mcp.WithOutputSchema[SearchResult]())
-	}
-	searchTool := mcp.NewTool("fs_search", searchOpts...)
-	if *compatFlag {
-		s.AddTool(searchTool, wrapTextHandler(handleSearch(sessions, &mu), formatSearchResult))
+// addTool registers a tool whose input schema is generated from TArgs and,
+// outside compat mode, whose output schema is generated from TResult.
+// format renders results as text in compat mode; tools without one always
+// return structured results.
+func addTool[TArgs any, TResult any](s *server.MCPServer, name, description string, hints mcp.ToolAnnotation, h mcp.StructuredToolHandlerFunc[TArgs, TResult], format func(TResult) string) {
+	opts := []mcp.ToolOption{
+		mcp.WithDescription(description),
+		mcp.WithToolAnnotation(hints),
+		inputSchema[TArgs](),
+	}
+	compat := currentConfig().CompatMode
+	if !compat {
+		opts = append(opts, outputSchema[TResult]())
+	}
+	tool := mcp.NewTool(name, opts...)
+	h = toolEnabled(name, h)
+	if compat && format != nil {
+		s.AddTool(tool, wrapTextHandler(h, format))
 	} else {
-		s.AddTool(searchTool, wrapStructuredHandler(handleSearch(sessions, &mu)))
-	}
-
-	globOpts := []mcp.ToolOption{
-		mcp.WithDescription("Match paths using shell-style globbing; ** enables recursion"),
-		mcp.WithString("pattern", mcp.Required(), mcp.Description("Glob pattern relative to base folder")),
-		mcp.WithNumber("max_results", mcp.Min(1), mcp.Description("Maximum matches to return")),
-	}
-	if !*compatFlag {
-		globOpts = append(globOpts, mcp.WithOutputSchema[GlobResult]())
-	}
-	globTool := mcp.NewTool("fs_glob", globOpts...)
-	if *compatFlag {
-		s.AddTool(globTool, wrapTextHandler(handleGlob(sessions, &mu), formatGlobResult))
-	} else {
-		s.AddTool(globTool, wrapStructuredHandler(handleGlob(sessions, &mu)))
+		s.AddTool(tool, wrapStructuredHandler(h))
 	}
+}
 
-	mkdirOpts := []mcp.ToolOption{
-		mcp.WithDescription("Create a directory"),
-		mcp.WithString("path", mcp.Required(), mcp.Description("Directory path to create")),
-		mcp.WithString("mode", mcp.Pattern("^0?[0-7]{3,4}$"), mcp.Description("Directory mode in octal")),
-	}
-	if !*compatFlag {
-		mkdirOpts = append(mkdirOpts, mcp.WithOutputSchema[MkdirResult]())
-	}
-	mkdirTool := mcp.NewTool("fs_mkdir", mkdirOpts...)
-	if *compatFlag {
-		s.AddTool(mkdirTool, wrapTextHandler(handleMkdir(sessions, &mu), formatMkdirResult))
-	} else {
-		s.AddTool(mkdirTool, wrapStructuredHandler(handleMkdir(sessions, &mu)))
-	}
+func setupServer(backend Backend) (*server.MCPServer, *rpcRouter) {
+	hooks := &server.Hooks{}
+	s := server.NewMCPServer("fs-mcp-go", "0.1.0", server.WithResourceCapabilities(true, false), server.WithHooks(hooks))
+	router := newRPCRouter(s)
+	router.trackSessions(hooks)
 
-	rmdirOpts := []mcp.ToolOption{
-		mcp.WithDescription("Remove a directory"),
-		mcp.WithString("path", mcp.Required(), mcp.Description("Directory to remove")),
-		mcp.WithBoolean("recursive", mcp.Description("Remove directory contents recursively")),
-	}
-	if !*compatFlag {
-		rmdirOpts = append(rmdirOpts, mcp.WithOutputSchema[RmdirResult]())
-	}
-	rmdirTool := mcp.NewTool("fs_rmdir", rmdirOpts...)
-	if *compatFlag {
-		s.AddTool(rmdirTool, wrapTextHandler(handleRmdir(sessions, &mu), formatRmdirResult))
-	} else {
-		s.AddTool(rmdirTool, wrapStructuredHandler(handleRmdir(sessions, &mu)))
+	sessions := map[string]*SessionState{
+		"default": {FS: backend},
 	}
+	var mu sync.RWMutex
+	router.onClientEnd(func(id string) { dropClientSessions(sessions, &mu, id) })
+
+	addTool(s, "fs_read", "Read a file up to a byte limit.",
+		readOnlyHints("Read file"), handleRead(sessions, &mu), formatReadResult)
+	addTool(s, "fs_peek", "Read a file window without loading the whole file",
+		readOnlyHints("Peek at file"), handlePeek(sessions, &mu), formatPeekResult)
+	addTool(s, "fs_tail", "Return the last lines of a file, or follow it: pass the returned cursor back to get content appended since, waiting up to timeout_ms for more.",
+		readOnlyHints("Tail file"), handleTail(sessions, &mu), formatTailResult)
+	addTool(s, "fs_read_many", "Read many files concurrently within a total byte budget. Binary files are skipped unless include_binary is set.",
+		readOnlyHints("Read many files"), handleReadMany(sessions, &mu), formatReadManyResult)
+
+	addTool(s, "fs_write", "Create or modify a file with a strategy",
+		toolHints("Write file", false, true, false),
+		withAudit("fs_write", sessions, &mu, withHistory("fs_write", sessions, &mu, handleWrite(sessions, &mu), writeScope)), formatWriteResult)
+	addTool(s, "fs_write_begin", "Start or resume a chunked upload of a large file",
+		toolHints("Begin upload", false, false, false), handleWriteBegin(sessions, &mu), formatUploadResult)
+	addTool(s, "fs_write_chunk", "Append one chunk to an upload",
+		toolHints("Upload chunk", false, false, false), handleWriteChunk(sessions, &mu), formatUploadResult)
+	addTool(s, "fs_write_commit", "Verify an upload and move it into place",
+		toolHints("Commit upload", false, true, false),
+		withAudit("fs_write_commit", sessions, &mu, withHistory("fs_write_commit", sessions, &mu, handleWriteCommit(sessions, &mu), commitScope)), formatWriteResult)
+	addTool(s, "fs_write_abort", "Abandon an upload and delete its staged data",
+		toolHints("Abort upload", false, true, true), handleWriteAbort(sessions, &mu), formatUploadResult)
+	addTool(s, "fs_edit", "Search and replace text in a file",
+		toolHints("Edit file", false, true, false),
+		withAudit("fs_edit", sessions, &mu, withHistory("fs_edit", sessions, &mu, handleEdit(sessions, &mu), editScope)), formatEditResult)
+
+	addTool(s, "fs_list", "List directory contents",
+		readOnlyHints("List directory"), handleList(sessions, &mu), formatListResult)
+	addTool(s, "fs_stat", "Describe a file or directory without reading it",
+		readOnlyHints("Stat path"), handleStat(sessions, &mu), formatStatResult)
+	addTool(s, "fs_search", "Search files recursively for text",
+		readOnlyHints("Search files"), handleSearch(sessions, &mu), formatSearchResult)
+	addTool(s, "fs_glob", "Match paths using shell-style globbing; ** enables recursion",
+		readOnlyHints("Glob paths"), handleGlob(sessions, &mu), formatGlobResult)
+
+	addTool(s, "fs_watch", "Watch files, directories or globs for changes. Changes arrive as notifications/fs/changed and through fs_changes_since.",
+		toolHints("Watch paths", true, false, false), handleWatch(sessions, &mu), formatWatchResult)
+	addTool(s, "fs_unwatch", "Stop a watch started by fs_watch",
+		readOnlyHints("Stop watching"), handleUnwatch(sessions, &mu), formatUnwatchResult)
+	addTool(s, "fs_changes_since", "List changes recorded by this session's watches after a cursor, optionally waiting for the next one",
+		readOnlyHints("Changes since cursor"), handleChangesSince(sessions, &mu), formatChangesSinceResult)
+
+	addTool(s, "fs_lock", "Lease a file, or a directory and everything below it, to this session for a time. Other sessions' writes to it fail with LOCK_TIMEOUT until the lease is released, lapses or the client disconnects.",
+		toolHints("Lease path", false, false, true), handleLock(sessions, &mu), formatLockResult)
+	addTool(s, "fs_lock_renew", "Extend a lease taken with fs_lock",
+		toolHints("Renew lease", false, false, true), handleLockRenew(sessions, &mu), formatLockResult)
+	addTool(s, "fs_unlock", "Release a lease taken with fs_lock",
+		toolHints("Release lease", false, false, true), handleUnlock(sessions, &mu), formatUnlockResult)
+
+	addTool(s, "fs_mkdir", "Create a directory",
+		toolHints("Make directory", false, false, true),
+		withAudit("fs_mkdir", sessions, &mu, withHistory("fs_mkdir", sessions, &mu, handleMkdir(sessions, &mu), mkdirScope)), formatMkdirResult)
+	addTool(s, "fs_rmdir", "Remove a directory",
+		toolHints("Remove directory", false, true, true),
+		withAudit("fs_rmdir", sessions, &mu, withHistory("fs_rmdir", sessions, &mu, handleRmdir(sessions, &mu), rmdirScope)), formatRmdirResult)
+
+	addTool(s, "fs_archive_create", "Pack files and directories into a zip or tar.gz archive",
+		toolHints("Create archive", false, true, true),
+		withAudit("fs_archive_create", sessions, &mu, withHistory("fs_archive_create", sessions, &mu, handleArchiveCreate(sessions, &mu), archiveCreateScope)), formatArchiveResult)
+	addTool(s, "fs_archive_extract", "Unpack a zip or tar archive into a directory",
+		toolHints("Extract archive", false, true, true),
+		withAudit("fs_archive_extract", sessions, &mu, withHistory("fs_archive_extract", sessions, &mu, handleArchiveExtract(sessions, &mu), archiveExtractScope)), formatArchiveResult)
+
+	addTool(s, "fs_undo", "Revert the most recent file changes made in this session",
+		toolHints("Undo", false, true, false), withAudit("fs_undo", sessions, &mu, handleUndo(sessions, &mu)), formatUndoResult)
+	addTool(s, "fs_checkpoint", "Name the current state of a set of paths",
+		toolHints("Create checkpoint", false, false, true), handleCheckpoint(sessions, &mu), formatCheckpointResult)
+	addTool(s, "fs_restore_checkpoint", "Restore paths to a named checkpoint; the restore can be undone",
+		toolHints("Restore checkpoint", false, true, true), withAudit("fs_restore_checkpoint", sessions, &mu, handleRestoreCheckpoint(sessions, &mu)), formatRestoreCheckpointResult)
+
+	addTool(s, "fs_overlay_diff", "Show the pending changes of a sandbox session",
+		readOnlyHints("Sandbox diff"), handleOverlayDiff(sessions, &mu), formatOverlayResult)
+	addTool(s, "fs_overlay_commit", "Apply the pending changes of a sandbox session to the base folder",
+		toolHints("Commit sandbox", false, true, true), withAudit("fs_overlay_commit", sessions, &mu, handleOverlayCommit(sessions, &mu)), formatOverlayResult)
+	addTool(s, "fs_overlay_discard", "Drop the pending changes of a sandbox session",
+		toolHints("Discard sandbox", false, true, true), handleOverlayDiscard(sessions, &mu), formatOverlayResult)
+
+	addTool(s, "fs_audit_query", "Query the audit log of mutating operations",
+		readOnlyHints("Query audit log"), handleAuditQuery(sessions, &mu), formatAuditQueryResult)
 
 	// Session management tools
-	createOpts := []mcp.ToolOption{
-		mcp.WithDescription("Create a new session"),
-		mcp.WithString("id", mcp.Description("Optional session id")),
-	}
-	if !*compatFlag {
-		createOpts = append(createOpts, mcp.WithOutputSchema[CreateSessionResult]())
-	}
-	createTool := mcp.NewTool("createsession", createOpts...)
-	if *compatFlag {
-		s.AddTool(createTool, wrapTextHandler(handleCreateSession(sessions, &mu), func(r CreateSessionResult) string { return r.ID }))
-	} else {
-		s.AddTool(createTool, wrapStructuredHandler(handleCreateSession(sessions, &mu)))
-	}
-
-	switchOpts := []mcp.ToolOption{
-		mcp.WithDescription("Switch the active session"),
-		mcp.WithString("id", mcp.Required(), mcp.Description("Session id to activate")),
-	}
-	if !*compatFlag {
-		switchOpts = append(switchOpts, mcp.WithOutputSchema[SwitchSessionResult]())
-	}
-	switchTool := mcp.NewTool("switchsession", switchOpts...)
-	if *compatFlag {
-		s.AddTool(switchTool, wrapTextHandler(handleSwitchSession(sessions, &mu), func(r SwitchSessionResult) string { return r.ID }))
-	} else {
-		s.AddTool(switchTool, wrapStructuredHandler(handleSwitchSession(sessions, &mu)))
-	}
-
-	sessListOpts := []mcp.ToolOption{
-		mcp.WithDescription("List available sessions"),
-	}
-	if !*compatFlag {
-		sessListOpts = append(sessListOpts, mcp.WithOutputSchema[ListSessionsResult]())
-	}
-	listSessionsTool := mcp.NewTool("listsessions", sessListOpts...)
-	if *compatFlag {
-		s.AddTool(listSessionsTool, wrapTextHandler(handleListSessions(sessions, &mu), func(r ListSessionsResult) string { return strings.Join(r.Sessions, ",") }))
-	} else {
-		s.AddTool(listSessionsTool, wrapStructuredHandler(handleListSessions(sessions, &mu)))
-	}
-
-	dbgApproachOpts := []mcp.ToolOption{
-		mcp.WithDescription("Record debugging approach and resolution"),
-		mcp.WithString("session", mcp.Description("Existing session identifier")),
-		mcp.WithString("approach", mcp.Required(), mcp.Description("Debugging steps taken")),
-		mcp.WithString("resolution", mcp.Description("Outcome or fix applied")),
-	}
-	if !*compatFlag {
-		dbgApproachOpts = append(dbgApproachOpts, mcp.WithOutputSchema[DebuggingApproachResult]())
-	}
-	dbgApproachTool := mcp.NewTool("debuggingapproach", dbgApproachOpts...)
-	s.AddTool(dbgApproachTool, wrapStructuredHandler(handleDebuggingApproach()))
-
-	pendingOpts := []mcp.ToolOption{
-		mcp.WithDescription("List unresolved debugging sessions"),
-	}
-	if !*compatFlag {
-		pendingOpts = append(pendingOpts, mcp.WithOutputSchema[PendingDebugResult]())
-	}
-	pendingTool := mcp.NewTool("pendingdebug", pendingOpts...)
-	s.AddTool(pendingTool, wrapStructuredHandler(handlePendingDebug()))
-
-	return s
+	addTool(s, "createsession", "Create a new session",
+		toolHints("Create session", false, false, false), handleCreateSession(sessions, &mu), func(r CreateSessionResult) string { return r.ID })
+	addTool(s, "switchsession", "Switch the active session",
+		toolHints("Switch session", false, false, true), handleSwitchSession(sessions, &mu), func(r SwitchSessionResult) string { return r.ID })
+	addTool(s, "listsessions", "List available sessions",
+		readOnlyHints("List sessions"), handleListSessions(sessions, &mu), func(r ListSessionsResult) string { return strings.Join(r.Sessions, ",") })
+
+	addTool(s, "debuggingapproach", "Record debugging approach and resolution",
+		toolHints("Record debugging approach", false, false, false), handleDebuggingApproach(), nil)
+	addTool(s, "pendingdebug", "List unresolved debugging sessions",
+		readOnlyHints("Pending debugging sessions"), handlePendingDebug(), nil)
+
+	registerResources(s, router, sessions, &mu)
+	registerCompletions(router, sessions, &mu)
+
+	return s, router
 }
@@ -13,7 +13,7 @@ import (
 
 func TestWriteReadIntegration(t *testing.T) {
 	root := t.TempDir()
-	sessions := map[string]*SessionState{"s1": {Root: root}}
+	sessions := map[string]*SessionState{"s1": {FS: newLocalBackend(root)}}
 	var mu sync.RWMutex
 	manager := &sessionManager{id: "s1"}
 	addSession := func(h server.ToolHandlerFunc) server.ToolHandlerFunc {
@@ -66,7 +66,7 @@ func TestWriteReadIntegration(t *testing.T) {
 
 func TestWriteErrorResponse(t *testing.T) {
 	root := t.TempDir()
-	sessions := map[string]*SessionState{"s1": {Root: root}}
+	sessions := map[string]*SessionState{"s1": {FS: newLocalBackend(root)}}
 	var mu sync.RWMutex
 	manager := &sessionManager{id: "s1"}
 	addSession := func(h server.ToolHandlerFunc) server.ToolHandlerFunc {
@@ -4,11 +4,20 @@ import (
 	"context"
 	"fmt"
 	"sync"
+
+	"github.com/mark3labs/mcp-go/server"
 )
 
 // SessionState holds data for a single session.
 type SessionState struct {
-	Root string
+	FS     Backend       // storage of the session root
+	Policy *Policy       // access policy of this session; nil follows the server-wide policy
+	Caps   capabilitySet // capabilities granted at creation; nil grants all
+	Owner  string        // MCP client session that created it; "" is shared by every client
+
+	History *historyStore // undo history, created on first mutation
+	Uploads *uploadStore  // chunked writes in progress, created on first use
+	Watches *watchStore   // fs_watch registrations and change log, created on first use
 }
 
 // sessionManager keeps track of the active session ID per connection.
@@ -48,14 +57,70 @@ func sessionContext(ctx context.Context) string {
 	return fmt.Sprintf("session=%s", id)
 }
 
-// getSessionState retrieves the SessionState for the current session ID.
-func getSessionState(ctx context.Context, sessions map[string]*SessionState, mu *sync.RWMutex) (*SessionState, error) {
-	id := getSessionID(ctx)
+// clientSessionID returns the id of the MCP client session bound to ctx, or
+// "" when there is none
+func clientSessionID(ctx context.Context) string {
+	if cs := server.ClientSessionFromContext(ctx); cs != nil {
+		return cs.SessionID()
+	}
+	return ""
+}
+
+// visibleTo reports whether the MCP client session client may list and use s
+func (s *SessionState) visibleTo(client string) bool {
+	return s.Owner == "" || s.Owner == client
+}
+
+// lookupSession returns the session id if the client bound to ctx may use it
+func lookupSession(ctx context.Context, sessions map[string]*SessionState, mu *sync.RWMutex, id string) (*SessionState, bool) {
 	mu.RLock()
 	state, ok := sessions[id]
 	mu.RUnlock()
+	if !ok || !state.visibleTo(clientSessionID(ctx)) {
+		return nil, false
+	}
+	return state, true
+}
+
+// getSessionState retrieves the SessionState for the current session ID.
+func getSessionState(ctx context.Context, sessions map[string]*SessionState, mu *sync.RWMutex) (*SessionState, error) {
+	id := getSessionID(ctx)
+	state, ok := lookupSession(ctx, sessions, mu, id)
 	if !ok {
 		return nil, fmt.Errorf("unknown session %s", id)
 	}
 	return state, nil
 }
+
+// dropClientSessions removes the sessions an MCP client session created once
+// it has ended, with their sandbox overlays and the archives no remaining
+// session reads
+func dropClientSessions(sessions map[string]*SessionState, mu *sync.RWMutex, client string) {
+	if client == "" {
+		return
+	}
+	mu.Lock()
+	var dropped []*SessionState
+	for id, s := range sessions {
+		if s.Owner == client {
+			dropped = append(dropped, s)
+			delete(sessions, id)
+		}
+	}
+	inUse := map[Backend]bool{}
+	for _, s := range sessions {
+		inUse[s.baseFS()] = true
+	}
+	mu.Unlock()
+	for _, s := range dropped {
+		if o := s.sandbox(); o != nil {
+			if err := o.close(); err != nil {
+				dprintf("overlay cleanup %s: %v", o.upperDir, err)
+			}
+		}
+		if a, ok := s.baseFS().(*archiveBackend); ok && !inUse[a] {
+			inUse[a] = true // close each archive once
+			_ = a.Close()
+		}
+	}
+}
@@ -15,30 +15,98 @@ func handleCreateSession(sessions map[string]*SessionState, mu *sync.RWMutex) mc
 		if id == "" {
 			id = fmt.Sprintf("%d", time.Now().UnixNano())
 		}
+		// Inherit backend, policy and capabilities from the current session if available
+		parent := &SessionState{}
+		if state, err := getSessionState(ctx, sessions, mu); err == nil {
+			parent = state
+		}
+		caps, err := parseCapabilities(args.Capabilities, parent.Caps)
+		if err != nil {
+			return CreateSessionResult{}, err
+		}
+		policy, err := sessionPolicy(parent, args)
+		if err != nil {
+			return CreateSessionResult{}, err
+		}
+		var archive *archiveBackend
+		if args.Archive != "" {
+			if archive, err = openSessionArchive(ctx, parent, args.Archive); err != nil {
+				return CreateSessionResult{}, err
+			}
+		}
+		// fail releases the archive opened above, which no session holds yet
+		fail := func(err error) (CreateSessionResult, error) {
+			mu.Unlock()
+			if archive != nil {
+				_ = archive.Close()
+			}
+			return CreateSessionResult{}, err
+		}
 		mu.Lock()
 		if _, exists := sessions[id]; exists {
-			mu.Unlock()
-			return CreateSessionResult{}, fmt.Errorf("session %s exists", id)
+			return fail(fmt.Errorf("session %s exists", id))
 		}
-		// Copy root from current session if available
-		root := ""
-		if state, err := getSessionState(ctx, sessions, mu); err == nil {
-			root = state.Root
+		state := &SessionState{FS: parent.baseFS(), Policy: policy, Caps: caps, Owner: clientSessionID(ctx)}
+		if archive != nil {
+			state.FS = archive
+		}
+		// Sessions created from a sandbox stay sandboxed so they cannot bypass it
+		if args.Sandbox || parent.sandbox() != nil {
+			if state.FS == nil {
+				return fail(fmt.Errorf("sandbox requires a parent session"))
+			}
+			o, err := newOverlayBackend(state.FS)
+			if err != nil {
+				return fail(err)
+			}
+			state.FS = o
 		}
-		sessions[id] = &SessionState{Root: root}
+		sessions[id] = state
 		mu.Unlock()
-		return CreateSessionResult{ID: id}, nil
+		return CreateSessionResult{ID: id, Capabilities: caps.names(), Sandbox: state.sandbox() != nil, Archive: args.Archive}, nil
 	}
 }
 
+// sessionPolicy returns the policy of a session created from parent: the
+// parent's, or with read_only or rules given, a copy of it with those rules
+// put in front. A policy that would permit more than the parent's is refused.
+func sessionPolicy(parent *SessionState, args CreateSessionArgs) (*Policy, error) {
+	if !args.ReadOnly && len(args.Rules) == 0 {
+		return parent.Policy, nil
+	}
+	base := parent.policy()
+	p, err := newPolicy(args.ReadOnly, args.Rules)
+	if err != nil {
+		return nil, &ValidationError{Field: "rules", Value: args.Rules, Message: err.Error()}
+	}
+	if base != nil {
+		p.ReadOnly = p.ReadOnly || base.ReadOnly
+		p.Rules = append(p.Rules, base.Rules...)
+	}
+	if !p.narrows(base) {
+		return nil, newOpError("createsession", "", ErrPermissionDenied, "allow rules could widen the current session's access")
+	}
+	return p, nil
+}
+
 func handleSwitchSession(sessions map[string]*SessionState, mu *sync.RWMutex) mcp.StructuredToolHandlerFunc[SwitchSessionArgs, SwitchSessionResult] {
 	return func(ctx context.Context, req mcp.CallToolRequest, args SwitchSessionArgs) (SwitchSessionResult, error) {
-		mu.RLock()
-		_, ok := sessions[args.ID]
-		mu.RUnlock()
+		target, ok := lookupSession(ctx, sessions, mu, args.ID)
 		if !ok {
 			return SwitchSessionResult{}, fmt.Errorf("session %s not found", args.ID)
 		}
+		// A session may only narrow its rights, never switch into broader ones
+		if current, err := getSessionState(ctx, sessions, mu); err == nil && current != target {
+			if !target.Caps.within(current.Caps) {
+				return SwitchSessionResult{}, newOpError("switchsession", "", ErrPermissionDenied, fmt.Sprintf("session %s has capabilities the current session lacks", args.ID))
+			}
+			if !target.policy().narrows(current.policy()) {
+				return SwitchSessionResult{}, newOpError("switchsession", "", ErrPermissionDenied, fmt.Sprintf("session %s has a broader policy than the current session", args.ID))
+			}
+			if current.sandbox() != nil && target.sandbox() == nil {
+				return SwitchSessionResult{}, newOpError("switchsession", "", ErrPermissionDenied, fmt.Sprintf("session %s is not sandboxed", args.ID))
+			}
+		}
 		setSessionID(ctx, args.ID)
 		return SwitchSessionResult{ID: args.ID}, nil
 	}
@@ -46,10 +114,13 @@ func handleSwitchSession(sessions map[string]*SessionState, mu *sync.RWMutex) mc
 
 func handleListSessions(sessions map[string]*SessionState, mu *sync.RWMutex) mcp.StructuredToolHandlerFunc[struct{}, ListSessionsResult] {
 	return func(ctx context.Context, req mcp.CallToolRequest, args struct{}) (ListSessionsResult, error) {
+		client := clientSessionID(ctx)
 		mu.RLock()
 		ids := make([]string, 0, len(sessions))
-		for id := range sessions {
-			ids = append(ids, id)
+		for id, s := range sessions {
+			if s.visibleTo(client) {
+				ids = append(ids, id)
+			}
 		}
 		mu.RUnlock()
 		return ListSessionsResult{Sessions: ids, Active: getSessionID(ctx)}, nil
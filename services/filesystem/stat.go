@@ -0,0 +1,65 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/mark3labs/mcp-go/mcp"
+)
+
+func formatStatResult(r StatResult) string {
+	s := fmt.Sprintf("path=%s kind=%s size=%d mode=%s modified_at=%s", r.Path, r.Kind, r.Size, r.Mode, r.ModifiedAt)
+	if r.Target != "" {
+		s += " target=" + r.Target
+	}
+	return s
+}
+
+func handleStat(sessions map[string]*SessionState, mu *sync.RWMutex) mcp.StructuredToolHandlerFunc[StatArgs, StatResult] {
+	return func(ctx context.Context, req mcp.CallToolRequest, args StatArgs) (StatResult, error) {
+		state, err := getSessionState(ctx, sessions, mu)
+		if err != nil {
+			return StatResult{}, err
+		}
+		start := time.Now()
+		dprintf("%s -> fs_stat path=%q", sessionContext(ctx), args.Path)
+		var out StatResult
+		name, err := state.FS.Resolve(args.Path, false)
+		if err != nil {
+			dprintf("fs_stat error: %v", err)
+			return out, err
+		}
+		if err := checkAccess(ctx, state, "stat", capRead, args.Path, name); err != nil {
+			dprintf("fs_stat error: %v", err)
+			return out, err
+		}
+		fi, err := state.FS.Lstat(name)
+		if err != nil {
+			if os.IsNotExist(err) {
+				err = newOpError("stat", args.Path, ErrPathNotFound)
+			}
+			dprintf("fs_stat error: %v", err)
+			return out, err
+		}
+		out = StatResult{
+			Path: args.Path,
+			Name: fi.Name(),
+			Kind: kindOf(fi),
+			Size: fi.Size(),
+			MetaFields: MetaFields{
+				Mode:       fmt.Sprintf("%#o", fi.Mode()&os.ModePerm),
+				ModifiedAt: fi.ModTime().UTC().Format(time.RFC3339),
+			},
+		}
+		if fi.Mode()&os.ModeSymlink != 0 {
+			if sb, ok := state.FS.(symlinkBackend); ok {
+				out.Target, _ = sb.Readlink(name)
+			}
+		}
+		dprintf("<- fs_stat ok kind=%s dur=%s", out.Kind, time.Since(start))
+		return out, nil
+	}
+}
@@ -0,0 +1,36 @@
+package main
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+
+	"github.com/mark3labs/mcp-go/mcp"
+)
+
+func TestStat(t *testing.T) {
+	root := t.TempDir()
+	mustWrite(t, filepath.Join(root, "dir", "f.txt"), []byte("hello"), 0o640)
+	ctx, sessions, mu := testSession(root)
+	req := mcp.CallToolRequest{}
+
+	res, err := handleStat(sessions, mu)(ctx, req, StatArgs{Path: "dir/f.txt"})
+	if err != nil || res.Kind != "file" || res.Size != 5 || res.Name != "f.txt" || res.Mode != "0640" {
+		t.Fatalf("stat file: %+v %v", res, err)
+	}
+	if res, err = handleStat(sessions, mu)(ctx, req, StatArgs{Path: "dir"}); err != nil || res.Kind != "dir" {
+		t.Fatalf("stat dir: %+v %v", res, err)
+	}
+	if err := makeSymlink(t, "dir/f.txt", filepath.Join(root, "link")); err == nil {
+		res, err = handleStat(sessions, mu)(ctx, req, StatArgs{Path: "link"})
+		if err != nil || res.Kind != "symlink" || res.Target != "dir/f.txt" {
+			t.Fatalf("stat link: %+v %v", res, err)
+		}
+	}
+	if _, err := handleStat(sessions, mu)(ctx, req, StatArgs{Path: "missing"}); !errors.Is(err, ErrPathNotFound) {
+		t.Fatalf("stat missing err = %v", err)
+	}
+	if _, err := handleStat(sessions, mu)(ctx, req, StatArgs{Path: "../x"}); err == nil {
+		t.Fatal("stat allowed an escape")
+	}
+}
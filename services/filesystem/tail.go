@@ -0,0 +1,225 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"github.com/mark3labs/mcp-go/mcp"
+)
+
+const (
+	tailChunk = 8 * 1024 // bytes read per step when scanning backwards
+
+	resetTruncated = "truncated"
+	resetRotated   = "rotated"
+)
+
+// tailCursor marks how far a follower has read and which file it was reading,
+// so a rotated file can be told apart from one that merely grew
+type tailCursor struct {
+	offset int64
+	inode  uint64
+}
+
+func (c tailCursor) String() string {
+	return fmt.Sprintf("%d:%x", c.offset, c.inode)
+}
+
+func parseTailCursor(s string) (tailCursor, error) {
+	var c tailCursor
+	if _, err := fmt.Sscanf(s, "%d:%x", &c.offset, &c.inode); err != nil || c.offset < 0 {
+		return c, &ValidationError{Field: "cursor", Value: s, Message: "not a cursor returned by fs_tail"}
+	}
+	return c, nil
+}
+
+// countLines counts lines in b, including an unterminated last line
+func countLines(b []byte) int {
+	n := bytes.Count(b, []byte{'\n'})
+	if len(b) > 0 && b[len(b)-1] != '\n' {
+		n++
+	}
+	return n
+}
+
+// tailLines returns the last n lines of a file of the given size, reading
+// backwards in chunks and never holding more than max bytes. A newline
+// ending the file does not start another line.
+func tailLines(f File, size int64, n, max int) ([]byte, int64, bool, error) {
+	var buf []byte
+	pos := size
+	seen := 0
+	for pos > 0 {
+		step := min(int64(tailChunk), pos, int64(max-len(buf)))
+		if step == 0 {
+			return buf, pos, true, nil
+		}
+		pos -= step
+		chunk := make([]byte, step)
+		if _, err := f.Seek(pos, io.SeekStart); err != nil {
+			return nil, 0, false, err
+		}
+		if _, err := io.ReadFull(f, chunk); err != nil {
+			return nil, 0, false, err
+		}
+		buf = append(chunk, buf...)
+		for i := len(chunk) - 1; i >= 0; i-- {
+			if buf[i] == '\n' && pos+int64(i) != size-1 {
+				if seen++; seen == n {
+					return buf[i+1:], pos + int64(i) + 1, false, nil
+				}
+			}
+		}
+	}
+	return buf, 0, false, nil
+}
+
+func formatTailResult(r TailResult) string {
+	s := fmt.Sprintf("path=%s offset=%d size=%d lines=%d cursor=%s truncated=%v", r.Path, r.Offset, r.Size, r.Lines, r.Cursor, r.Truncated)
+	if r.Reset != "" {
+		s += " reset=" + r.Reset
+	}
+	return s + " content=" + r.Content
+}
+
+func handleTail(sessions map[string]*SessionState, mu *sync.RWMutex) mcp.StructuredToolHandlerFunc[TailArgs, TailResult] {
+	return func(ctx context.Context, req mcp.CallToolRequest, args TailArgs) (TailResult, error) {
+		state, err := getSessionState(ctx, sessions, mu)
+		if err != nil {
+			return TailResult{}, err
+		}
+		start := time.Now()
+		dprintf("%s -> fs_tail path=%q lines=%d cursor=%q timeout_ms=%d", sessionContext(ctx), args.Path, args.Lines, args.Cursor, args.TimeoutMs)
+		var res TailResult
+		name, err := state.FS.Resolve(args.Path, true)
+		if err != nil {
+			dprintf("fs_tail error: %v", err)
+			return res, err
+		}
+		if err := checkAccess(ctx, state, "tail", capRead, args.Path, name); err != nil {
+			dprintf("fs_tail error: %v", err)
+			return res, err
+		}
+		limit := currentConfig().toolLimit("fs_tail", args.MaxBytes)
+
+		var cur tailCursor
+		following := args.Cursor != ""
+		if following {
+			if cur, err = parseTailCursor(args.Cursor); err != nil {
+				return res, err
+			}
+			grown, err := waitForTail(ctx, state.FS, name, cur, args.TimeoutMs)
+			if err != nil {
+				dprintf("fs_tail error: %v", err)
+				return res, err
+			}
+			if !grown {
+				dprintf("<- fs_tail ok no new content dur=%s", time.Since(start))
+				return TailResult{Path: args.Path, Offset: cur.offset, Size: cur.offset, Cursor: args.Cursor}, nil
+			}
+		}
+
+		f, err := state.FS.Open(name)
+		if err != nil {
+			dprintf("fs_tail open error: %v", err)
+			return res, err
+		}
+		defer f.Close()
+		fi, err := f.Stat()
+		if err != nil {
+			return res, err
+		}
+		if fi.IsDir() {
+			return res, newOpError("tail", args.Path, ErrPathIsDirectory)
+		}
+
+		var buf []byte
+		var offset int64
+		var trunc bool
+		utf := resultEncoding(args.Encoding) == ""
+		if following {
+			offset = cur.offset
+			switch ino := inodeOf(fi); {
+			case cur.inode != 0 && ino != 0 && ino != cur.inode:
+				offset, res.Reset = 0, resetRotated
+			case fi.Size() < cur.offset:
+				offset, res.Reset = 0, resetTruncated
+			}
+			if _, err := f.Seek(offset, io.SeekStart); err != nil {
+				return res, err
+			}
+			buf, err = io.ReadAll(io.LimitReader(f, int64(limit)))
+			if err != nil {
+				return res, err
+			}
+			if utf {
+				// Leave a split rune for the next call
+				buf = trimPartialRune(buf)
+			}
+			trunc = offset+int64(len(buf)) < fi.Size()
+		} else {
+			lines := args.Lines
+			if lines <= 0 {
+				lines = defaultTailLines
+			}
+			buf, offset, trunc, err = tailLines(f, fi.Size(), lines, limit)
+			if err != nil {
+				dprintf("fs_tail read error: %v", err)
+				return res, err
+			}
+			for utf && trunc && len(buf) > 0 && !utf8.RuneStart(buf[0]) {
+				buf, offset = buf[1:], offset+1
+			}
+		}
+
+		content, err := encodeContent(args.Encoding, buf, offset)
+		if err != nil {
+			return res, err
+		}
+		res.Path = args.Path
+		res.Content = content
+		res.Offset = offset
+		res.Lines = countLines(buf)
+		res.Size = fi.Size()
+		res.Cursor = tailCursor{offset: offset + int64(len(buf)), inode: inodeOf(fi)}.String()
+		res.Truncated = trunc
+		res.ContentEncoding = resultEncoding(args.Encoding)
+		res.MetaFields = metaOf(fi)
+		dprintf("<- fs_tail ok offset=%d bytes=%d reset=%q dur=%s", offset, len(buf), res.Reset, time.Since(start))
+		return res, nil
+	}
+}
+
+// waitForTail polls until the file behind name differs from the cursor or
+// the timeout passes. A file missing mid-rotation counts as unchanged.
+func waitForTail(ctx context.Context, b Backend, name string, cur tailCursor, timeoutMs int) (bool, error) {
+	deadline := time.Now().Add(time.Duration(min(max(timeoutMs, 0), maxTailWaitMs)) * time.Millisecond)
+	for {
+		fi, err := b.Stat(name)
+		switch {
+		case err == nil:
+			ino := inodeOf(fi)
+			if fi.Size() != cur.offset || (cur.inode != 0 && ino != 0 && ino != cur.inode) {
+				return true, nil
+			}
+		case !errors.Is(err, fs.ErrNotExist):
+			return false, err
+		}
+		wait := time.Until(deadline)
+		if wait <= 0 {
+			return false, nil
+		}
+		select {
+		case <-ctx.Done():
+			return false, ctx.Err()
+		case <-time.After(min(wait, tailPollMs*time.Millisecond)):
+		}
+	}
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mark3labs/mcp-go/mcp"
+)
+
+func TestTailLines(t *testing.T) {
+	ctx, sessions, mu, mem := memSession()
+	var b strings.Builder
+	for i := 1; i <= 5000; i++ {
+		fmt.Fprintf(&b, "line %d\n", i)
+	}
+	memWrite(t, mem, "app.log", []byte(b.String()), 0o644)
+	memWrite(t, mem, "short.log", []byte("a\nb"), 0o644)
+	req := mcp.CallToolRequest{}
+
+	res, err := handleTail(sessions, mu)(ctx, req, TailArgs{Path: "app.log", Lines: 3})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Content != "line 4998\nline 4999\nline 5000\n" || res.Lines != 3 || res.Truncated {
+		t.Fatalf("tail = %+v", res)
+	}
+	if res.Offset+int64(len(res.Content)) != res.Size {
+		t.Fatalf("offset %d does not end at size %d", res.Offset, res.Size)
+	}
+
+	// Lines spanning several backward chunks
+	res, err = handleTail(sessions, mu)(ctx, req, TailArgs{Path: "app.log", Lines: 2000})
+	if err != nil || res.Lines != 2000 || !strings.HasPrefix(res.Content, "line 3001\n") {
+		t.Fatalf("long tail = %d lines %v", res.Lines, err)
+	}
+	res, err = handleTail(sessions, mu)(ctx, req, TailArgs{Path: "app.log", Lines: 100, MaxBytes: 25})
+	if err != nil || !res.Truncated || len(res.Content) != 25 {
+		t.Fatalf("capped tail = %+v %v", res, err)
+	}
+
+	res, err = handleTail(sessions, mu)(ctx, req, TailArgs{Path: "short.log"})
+	if err != nil || res.Content != "a\nb" || res.Lines != 2 || res.Offset != 0 {
+		t.Fatalf("whole file = %+v %v", res, err)
+	}
+	if _, err := handleTail(sessions, mu)(ctx, req, TailArgs{Path: "short.log", Cursor: "bogus"}); err == nil {
+		t.Fatal("bad cursor accepted")
+	}
+}
+
+func TestTailFollow(t *testing.T) {
+	root := t.TempDir()
+	p := filepath.Join(root, "app.log")
+	mustWrite(t, p, []byte("one\n"), 0o644)
+	ctx, sessions, mu := testSession(root)
+	req := mcp.CallToolRequest{}
+
+	res, err := handleTail(sessions, mu)(ctx, req, TailArgs{Path: "app.log"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	cursor := res.Cursor
+
+	// Nothing new: the cursor comes back unchanged after the timeout
+	res, err = handleTail(sessions, mu)(ctx, req, TailArgs{Path: "app.log", Cursor: cursor, TimeoutMs: 50})
+	if err != nil || res.Content != "" || res.Cursor != cursor {
+		t.Fatalf("idle follow = %+v %v", res, err)
+	}
+
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		f, _ := os.OpenFile(p, os.O_APPEND|os.O_WRONLY, 0)
+		f.WriteString("two\n")
+		f.Close()
+	}()
+	res, err = handleTail(sessions, mu)(ctx, req, TailArgs{Path: "app.log", Cursor: cursor, TimeoutMs: 5000})
+	if err != nil || res.Content != "two\n" || res.Offset != 4 || res.Reset != "" {
+		t.Fatalf("follow = %+v %v", res, err)
+	}
+	cursor = res.Cursor
+
+	// Truncated in place
+	mustWrite(t, p, []byte("x\n"), 0o644)
+	res, err = handleTail(sessions, mu)(ctx, req, TailArgs{Path: "app.log", Cursor: cursor})
+	if err != nil || res.Content != "x\n" || res.Reset != resetTruncated {
+		t.Fatalf("after truncate = %+v %v", res, err)
+	}
+	cursor = res.Cursor
+
+	// Rotated: the old file moves away and a new one takes its name
+	if err := os.Rename(p, p+".1"); err != nil {
+		t.Fatal(err)
+	}
+	mustWrite(t, p, []byte("fresh log\n"), 0o644)
+	res, err = handleTail(sessions, mu)(ctx, req, TailArgs{Path: "app.log", Cursor: cursor})
+	if err != nil || res.Content != "fresh log\n" || res.Reset != resetRotated {
+		t.Fatalf("after rotate = %+v %v", res, err)
+	}
+}
+
+func TestTailFollowMemAppend(t *testing.T) {
+	ctx, sessions, mu, b := memSession()
+	req := mcp.CallToolRequest{}
+	if err := b.WriteFile("app.log", []byte("a\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	res, err := handleTail(sessions, mu)(ctx, req, TailArgs{Path: "app.log"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := b.AppendFile("app.log", []byte("b\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	res, err = handleTail(sessions, mu)(ctx, req, TailArgs{Path: "app.log", Cursor: res.Cursor})
+	if err != nil || res.Content != "b\n" || res.Offset != 2 || res.Reset != "" {
+		t.Fatalf("follow after append = %+v %v", res, err)
+	}
+
+	// Replacing the file is still a rotation
+	if err := b.WriteFile("app.log", []byte("new\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	res, err = handleTail(sessions, mu)(ctx, req, TailArgs{Path: "app.log", Cursor: res.Cursor})
+	if err != nil || res.Content != "new\n" || res.Reset != resetRotated {
+		t.Fatalf("follow after replace = %+v %v", res, err)
+	}
+}
@@ -2,13 +2,61 @@ package main
 
 import (
 	"context"
+	"os"
 	"sync"
+	"testing"
 )
 
-// testSession creates a context with a default session and returns the session map and mutex.
+// testSession creates a context with a default session on the local folder
+// root and returns the session map and mutex. Tests that do not depend on
+// the local disk use memSession instead.
 func testSession(root string) (context.Context, map[string]*SessionState, *sync.RWMutex) {
-	sessions := map[string]*SessionState{"s1": {Root: root}}
+	return newTestSession(newLocalBackend(root))
+}
+
+// memSession creates a context with a default session on an in-memory
+// backend and returns the session map, mutex and backend.
+func memSession() (context.Context, map[string]*SessionState, *sync.RWMutex, *memBackend) {
+	b := newMemBackend()
+	ctx, sessions, mu := newTestSession(b)
+	return ctx, sessions, mu, b
+}
+
+func newTestSession(b Backend) (context.Context, map[string]*SessionState, *sync.RWMutex) {
+	sessions := map[string]*SessionState{"s1": {FS: b}}
 	var mu sync.RWMutex
 	ctx := withSessionManager(context.Background(), &sessionManager{id: "s1"})
 	return ctx, sessions, &mu
 }
+
+// memWrite stores data at name in b, creating parent directories
+func memWrite(t *testing.T, b Backend, name string, data []byte, mode os.FileMode) {
+	t.Helper()
+	if err := b.MkdirAll(parentName(name), 0o755); err != nil {
+		t.Fatalf("mkdir: %v", err)
+	}
+	if err := b.WriteFile(name, data, mode); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+}
+
+// memRead returns the contents of name in b
+func memRead(t *testing.T, b Backend, name string) string {
+	t.Helper()
+	data, err := readBackendFile(b, name)
+	if err != nil {
+		t.Fatalf("read: %v", err)
+	}
+	return string(data)
+}
+
+// withConfig runs the rest of the test with a copy of the default
+// configuration changed by edit
+func withConfig(t *testing.T, edit func(c *ServerConfig)) {
+	t.Helper()
+	c := *newDefaultConfig()
+	edit(&c)
+	prev := activeConfig.Load()
+	setConfig(&c)
+	t.Cleanup(func() { activeConfig.Store(prev) })
+}
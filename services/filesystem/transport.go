@@ -0,0 +1,404 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/subtle"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/mark3labs/mcp-go/mcp"
+	"github.com/mark3labs/mcp-go/server"
+)
+
+const (
+	transportStdio = "stdio"
+	transportSSE   = "sse"
+	transportHTTP  = "http"
+
+	healthPath  = "/healthz"
+	mcpPath     = "/mcp"     // streamable HTTP endpoint
+	ssePath     = "/sse"     // SSE event stream
+	messagePath = "/message" // SSE client messages
+)
+
+// httpOptions configures serveHTTP
+type httpOptions struct {
+	Transport    string // transportSSE or transportHTTP
+	Addr         string
+	CertFile     string
+	KeyFile      string
+	ClientCAFile string
+	Token        string
+}
+
+// serveHTTP serves s over SSE or streamable HTTP until SIGTERM or SIGINT,
+// then stops accepting connections, ends event streams and waits up to
+// shutdownTimeout for requests in flight
+func serveHTTP(s *server.MCPServer, router *rpcRouter, opts httpOptions) error {
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGTERM, syscall.SIGINT)
+	defer stop()
+
+	tlsConfig, err := serverTLSConfig(opts.CertFile, opts.KeyFile, opts.ClientCAFile)
+	if err != nil {
+		return err
+	}
+	t, err := newHTTPTransport(s, router, opts.Transport, httpAuth{token: opts.Token, requireCert: opts.ClientCAFile != ""})
+	if err != nil {
+		return err
+	}
+	ln, err := net.Listen("tcp", opts.Addr)
+	if err != nil {
+		return err
+	}
+	hs := &http.Server{
+		Handler:           t,
+		TLSConfig:         tlsConfig,
+		ReadHeaderTimeout: readHeaderTimeout * time.Second,
+	}
+	errc := make(chan error, 1)
+	go func() {
+		if tlsConfig != nil {
+			errc <- hs.ServeTLS(ln, "", "")
+		} else {
+			errc <- hs.Serve(ln)
+		}
+	}()
+	scheme := "http"
+	if tlsConfig != nil {
+		scheme = "https"
+	}
+	_, _ = fmt.Fprintf(os.Stderr, "serving %s on %s://%s\n", opts.Transport, scheme, ln.Addr())
+	dprintf("http listen transport=%s addr=%s tls=%v mtls=%v token=%v", opts.Transport, ln.Addr(), tlsConfig != nil, opts.ClientCAFile != "", opts.Token != "")
+
+	select {
+	case err := <-errc:
+		return err
+	case <-ctx.Done():
+	}
+	dprintf("http shutdown")
+	t.drain()
+	sctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout*time.Second)
+	defer cancel()
+	return hs.Shutdown(sctx)
+}
+
+// serverTLSConfig loads the server certificate and, for mTLS, the client
+// CA pool. It returns nil when TLS is not configured. Client certificates
+// are verified when presented and required by httpAuth, so health probes
+// can connect without one.
+func serverTLSConfig(certFile, keyFile, clientCAFile string) (*tls.Config, error) {
+	if certFile == "" && keyFile == "" {
+		if clientCAFile != "" {
+			return nil, errors.New("--tls-client-ca requires --tls-cert and --tls-key")
+		}
+		return nil, nil
+	}
+	if certFile == "" || keyFile == "" {
+		return nil, errors.New("--tls-cert and --tls-key must be given together")
+	}
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return nil, fmt.Errorf("load TLS certificate: %w", err)
+	}
+	cfg := &tls.Config{Certificates: []tls.Certificate{cert}, MinVersion: tls.VersionTLS12}
+	if clientCAFile != "" {
+		pem, err := os.ReadFile(clientCAFile)
+		if err != nil {
+			return nil, fmt.Errorf("read client CA: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates in %s", clientCAFile)
+		}
+		cfg.ClientCAs = pool
+		cfg.ClientAuth = tls.VerifyClientCertIfGiven
+	}
+	return cfg, nil
+}
+
+// httpAuth checks the bearer token and client certificate of a request
+type httpAuth struct {
+	token       string
+	requireCert bool
+}
+
+func (a httpAuth) check(w http.ResponseWriter, r *http.Request) bool {
+	if a.requireCert && (r.TLS == nil || len(r.TLS.VerifiedChains) == 0) {
+		http.Error(w, "client certificate required", http.StatusUnauthorized)
+		return false
+	}
+	if a.token != "" {
+		got, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok || subtle.ConstantTimeCompare([]byte(got), []byte(a.token)) != 1 {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="mcpfs"`)
+			http.Error(w, "invalid or missing bearer token", http.StatusUnauthorized)
+			return false
+		}
+	}
+	return true
+}
+
+// sessionBindings gives every client session of an HTTP transport its own
+// active filesystem session, so createsession and switchsession on one
+// connection leave the others alone
+type sessionBindings struct {
+	mu       sync.Mutex
+	managers map[string]*sessionManager
+}
+
+// manager returns the binding of the client session id, starting it on the
+// default filesystem session. Requests without a session share nothing.
+func (b *sessionBindings) manager(id string) *sessionManager {
+	if id == "" {
+		return &sessionManager{id: "default"}
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	m, ok := b.managers[id]
+	if !ok {
+		if b.managers == nil {
+			b.managers = map[string]*sessionManager{}
+		}
+		m = &sessionManager{id: "default"}
+		b.managers[id] = m
+	}
+	return m
+}
+
+// drop forgets a client session that has ended and releases the leases,
+// watches and resource subscriptions made through it
+func (b *sessionBindings) drop(id string) {
+	b.mu.Lock()
+	delete(b.managers, id)
+	b.mu.Unlock()
+	fileLeases.releaseClient(id)
+	clientWatches.releaseClient(id)
+}
+
+// httpSessionIDs issues streamable HTTP session IDs and remembers them until
+// the client deletes its session. Unknown IDs, including those issued
+// before a restart, count as terminated so clients initialize again.
+type httpSessionIDs struct {
+	mu          sync.Mutex
+	live        map[string]bool
+	onTerminate func(id string)
+}
+
+func (m *httpSessionIDs) Generate() string {
+	id := uuid.NewString()
+	m.mu.Lock()
+	if m.live == nil {
+		m.live = map[string]bool{}
+	}
+	m.live[id] = true
+	m.mu.Unlock()
+	return id
+}
+
+func (m *httpSessionIDs) Validate(id string) (bool, error) {
+	if id == "" {
+		return false, errors.New("missing session ID")
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return !m.live[id], nil
+}
+
+func (m *httpSessionIDs) Terminate(id string) (bool, error) {
+	m.mu.Lock()
+	delete(m.live, id)
+	m.mu.Unlock()
+	if m.onTerminate != nil {
+		m.onTerminate(id)
+	}
+	return false, nil
+}
+
+// streamClient stands in for a streamable HTTP client session in routed
+// requests: mcp-go makes a throwaway session per POST and registers one only
+// while the client listens on its GET stream
+type streamClient struct{ id string }
+
+func (c streamClient) Initialize()                                         {}
+func (c streamClient) Initialized() bool                                   { return true }
+func (c streamClient) NotificationChannel() chan<- mcp.JSONRPCNotification { return nil }
+func (c streamClient) SessionID() string                                   { return c.id }
+
+// httpTransport serves one of mcp-go's HTTP transports with the health
+// endpoint, authentication and the router in front
+type httpTransport struct {
+	kind     string
+	srv      *server.MCPServer
+	router   *rpcRouter
+	auth     httpAuth
+	bindings sessionBindings
+	ids      httpSessionIDs
+	sse      *server.SSEServer
+	next     http.Handler // mcp-go's transport
+
+	draining    context.Context // done once shutdown starts
+	stopStreams context.CancelFunc
+}
+
+func newHTTPTransport(s *server.MCPServer, router *rpcRouter, kind string, auth httpAuth) (*httpTransport, error) {
+	t := &httpTransport{kind: kind, srv: s, router: router, auth: auth}
+	t.draining, t.stopStreams = context.WithCancel(context.Background())
+	switch kind {
+	case transportHTTP:
+		t.ids.onTerminate = t.endClient
+		t.next = server.NewStreamableHTTPServer(s,
+			server.WithEndpointPath(mcpPath),
+			server.WithSessionIdManager(&t.ids),
+			server.WithHTTPContextFunc(func(ctx context.Context, r *http.Request) context.Context {
+				return withSessionManager(ctx, t.bindings.manager(r.Header.Get(server.HeaderKeySessionID)))
+			}))
+	case transportSSE:
+		if router.hooks != nil {
+			router.hooks.AddOnUnregisterSession(func(_ context.Context, cs server.ClientSession) {
+				t.endClient(cs.SessionID())
+			})
+		}
+		t.sse = server.NewSSEServer(s,
+			server.WithSSEEndpoint(ssePath),
+			server.WithMessageEndpoint(messagePath),
+			server.WithSSEContextFunc(func(ctx context.Context, r *http.Request) context.Context {
+				return withSessionManager(ctx, t.bindings.manager(r.URL.Query().Get("sessionId")))
+			}))
+		t.next = t.sse
+	default:
+		return nil, &ValidationError{Field: "transport", Value: kind, Message: "expected stdio, sse or http"}
+	}
+	return t, nil
+}
+
+// endClient forgets a client session that has ended along with everything
+// made through it
+func (t *httpTransport) endClient(id string) {
+	t.bindings.drop(id)
+	t.router.endClient(id)
+}
+
+// drain marks the transport as shutting down: the health check starts
+// failing and open event streams end, so http.Server.Shutdown only waits
+// for ordinary requests
+func (t *httpTransport) drain() {
+	t.stopStreams()
+}
+
+func (t *httpTransport) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path == healthPath {
+		t.health(w)
+		return
+	}
+	if !t.auth.check(w, r) {
+		return
+	}
+	switch {
+	case r.Method == http.MethodGet:
+		// Event streams last until the client leaves or shutdown starts
+		ctx, cancel := context.WithCancel(r.Context())
+		defer cancel()
+		defer context.AfterFunc(t.draining, cancel)()
+		r = r.WithContext(ctx)
+	case r.Method == http.MethodPost && (r.URL.Path == mcpPath || r.URL.Path == messagePath):
+		if mt, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type")); mt != "application/json" && t.kind == transportHTTP {
+			break
+		}
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			http.Error(w, "read request body: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		if method := t.router.route(body); method != "" {
+			if t.kind == transportHTTP {
+				t.replyStreamable(w, r, method, body)
+			} else {
+				t.replySSE(w, r, body)
+			}
+			return
+		}
+		r.Body = io.NopCloser(bytes.NewReader(body))
+	}
+	t.next.ServeHTTP(w, r)
+}
+
+func (t *httpTransport) health(w http.ResponseWriter) {
+	w.Header().Set("Content-Type", "application/json")
+	status := "ok"
+	if t.draining.Err() != nil {
+		status = "draining"
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	_ = json.NewEncoder(w).Encode(map[string]string{"status": status, "transport": t.kind})
+}
+
+// replyStreamable answers a routed request in the response body, issuing
+// the session ID on initialize as mcp-go would
+func (t *httpTransport) replyStreamable(w http.ResponseWriter, r *http.Request, method mcp.MCPMethod, body []byte) {
+	var id string
+	if method == mcp.MethodInitialize {
+		id = t.ids.Generate()
+	} else {
+		id = r.Header.Get(server.HeaderKeySessionID)
+		terminated, err := t.ids.Validate(id)
+		if err != nil {
+			http.Error(w, "Invalid session ID", http.StatusBadRequest)
+			return
+		}
+		if terminated {
+			http.Error(w, "Session terminated", http.StatusNotFound)
+			return
+		}
+	}
+	var cs server.ClientSession = streamClient{id: id}
+	if registered, ok := t.router.client(id); ok {
+		cs = registered
+	}
+	ctx := withSessionManager(t.srv.WithContext(r.Context(), cs), t.bindings.manager(id))
+	resp, ok := t.router.dispatch(ctx, body)
+	if !ok {
+		w.WriteHeader(http.StatusAccepted)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if method == mcp.MethodInitialize {
+		w.Header().Set(server.HeaderKeySessionID, id)
+	}
+	if err := json.NewEncoder(w).Encode(resp); err != nil {
+		dprintf("rpc write error: %v", err)
+	}
+}
+
+// replySSE answers a routed request on the client's event stream. Unlike
+// mcp-go it replies before acknowledging the POST, so initialize is
+// answered before the client can send anything else.
+func (t *httpTransport) replySSE(w http.ResponseWriter, r *http.Request, body []byte) {
+	id := r.URL.Query().Get("sessionId")
+	cs, ok := t.router.client(id)
+	if !ok {
+		http.Error(w, "Invalid session ID", http.StatusBadRequest)
+		return
+	}
+	ctx := withSessionManager(t.srv.WithContext(context.WithoutCancel(r.Context()), cs), t.bindings.manager(id))
+	if resp, ok := t.router.dispatch(ctx, body); ok {
+		if err := t.sse.SendEventToSession(id, resp); err != nil {
+			dprintf("rpc send session=%s: %v", id, err)
+		}
+	}
+	w.WriteHeader(http.StatusAccepted)
+}
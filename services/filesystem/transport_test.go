@@ -0,0 +1,380 @@
+package main
+
+import (
+	"bufio"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"io"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mark3labs/mcp-go/server"
+)
+
+const initializeRequest = `{"jsonrpc":"2.0","id":1,"method":"initialize","params":{"protocolVersion":"2025-06-18","capabilities":{},"clientInfo":{"name":"t","version":"1"}}}`
+
+func newTestTransport(t *testing.T, kind string, auth httpAuth) (*httpTransport, *httptest.Server) {
+	t.Helper()
+	s, router := setupServer(newMemBackend())
+	tr, err := newHTTPTransport(s, router, kind, auth)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ts := httptest.NewServer(tr)
+	t.Cleanup(ts.Close)
+	return tr, ts
+}
+
+// rpcResponse is the part of a JSON-RPC response the tests read
+type rpcResponse struct {
+	Result json.RawMessage `json:"result"`
+	Error  *struct {
+		Code int `json:"code"`
+	} `json:"error"`
+}
+
+// httpClient speaks streamable HTTP to one server as one client session
+type httpClient struct {
+	t       *testing.T
+	url     string
+	session string
+	token   string
+}
+
+func (c *httpClient) do(method, body string) *http.Response {
+	c.t.Helper()
+	req, err := http.NewRequest(method, c.url+mcpPath, strings.NewReader(body))
+	if err != nil {
+		c.t.Fatal(err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Accept", "application/json, text/event-stream")
+	if c.session != "" {
+		req.Header.Set(server.HeaderKeySessionID, c.session)
+	}
+	if c.token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.token)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		c.t.Fatal(err)
+	}
+	c.t.Cleanup(func() { resp.Body.Close() })
+	return resp
+}
+
+func (c *httpClient) call(body string) rpcResponse {
+	c.t.Helper()
+	resp := c.do(http.MethodPost, body)
+	if resp.StatusCode != http.StatusOK {
+		b, _ := io.ReadAll(resp.Body)
+		c.t.Fatalf("%s: status %d: %s", body, resp.StatusCode, b)
+	}
+	var out rpcResponse
+	if err := json.NewDecoder(resp.Body).Decode(&out); err != nil {
+		c.t.Fatal(err)
+	}
+	return out
+}
+
+func (c *httpClient) initialize() rpcResponse {
+	c.t.Helper()
+	c.session = ""
+	resp := c.do(http.MethodPost, initializeRequest)
+	c.session = resp.Header.Get(server.HeaderKeySessionID)
+	if resp.StatusCode != http.StatusOK || c.session == "" {
+		c.t.Fatalf("initialize: status %d session %q", resp.StatusCode, c.session)
+	}
+	var out rpcResponse
+	if err := json.NewDecoder(resp.Body).Decode(&out); err != nil {
+		c.t.Fatal(err)
+	}
+	if c.do(http.MethodPost, `{"jsonrpc":"2.0","method":"notifications/initialized"}`).StatusCode != http.StatusAccepted {
+		c.t.Fatal("notifications/initialized not accepted")
+	}
+	return out
+}
+
+func (c *httpClient) callTool(name, args string) (isError bool) {
+	c.t.Helper()
+	res := c.call(`{"jsonrpc":"2.0","id":2,"method":"tools/call","params":{"name":"` + name + `","arguments":` + args + `}}`)
+	var r struct {
+		IsError bool `json:"isError"`
+	}
+	if res.Error != nil || json.Unmarshal(res.Result, &r) != nil {
+		c.t.Fatalf("%s: %+v", name, res)
+	}
+	return r.IsError
+}
+
+func TestStreamableHTTPTransport(t *testing.T) {
+	_, ts := newTestTransport(t, transportHTTP, httpAuth{})
+	c := &httpClient{t: t, url: ts.URL}
+	var init struct {
+		Capabilities map[string]any `json:"capabilities"`
+	}
+	if err := json.Unmarshal(c.initialize().Result, &init); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"tools", "resources", "completions"} {
+		if _, ok := init.Capabilities[name]; !ok {
+			t.Errorf("capability %s missing: %v", name, init.Capabilities)
+		}
+	}
+
+	// Tool calls go to mcp-go, completion through the router
+	if c.callTool("fs_write", `{"path":"notes.txt","content":"hi"}`) {
+		t.Fatal("fs_write failed")
+	}
+	res := c.call(`{"jsonrpc":"2.0","id":3,"method":"completion/complete","params":{"ref":{"type":"ref/tool","name":"fs_read"},"argument":{"name":"path","value":"no"}}}`)
+	if !strings.Contains(string(res.Result), `"notes.txt"`) {
+		t.Fatalf("completion = %s", res.Result)
+	}
+
+	session := c.session
+	c.session = "not-a-session"
+	if code := c.do(http.MethodPost, `{"jsonrpc":"2.0","id":4,"method":"completion/complete","params":{}}`).StatusCode; code != http.StatusNotFound {
+		t.Errorf("unknown session: status %d", code)
+	}
+	c.session = ""
+	if code := c.do(http.MethodPost, `{"jsonrpc":"2.0","id":4,"method":"completion/complete","params":{}}`).StatusCode; code != http.StatusBadRequest {
+		t.Errorf("missing session: status %d", code)
+	}
+	c.session = session
+	if code := c.do(http.MethodDelete, "").StatusCode; code != http.StatusOK {
+		t.Fatalf("delete: status %d", code)
+	}
+	if code := c.do(http.MethodPost, `{"jsonrpc":"2.0","id":5,"method":"tools/list"}`).StatusCode; code != http.StatusNotFound {
+		t.Errorf("deleted session: status %d", code)
+	}
+}
+
+func TestHTTPSessionBinding(t *testing.T) {
+	_, ts := newTestTransport(t, transportHTTP, httpAuth{})
+	a := &httpClient{t: t, url: ts.URL}
+	b := &httpClient{t: t, url: ts.URL}
+	a.initialize()
+	b.initialize()
+
+	// A moves to a read-only filesystem session; B stays on the default
+	if a.callTool("createsession", `{"id":"ro","capabilities":["read"]}`) || a.callTool("switchsession", `{"id":"ro"}`) {
+		t.Fatal("session setup failed")
+	}
+	if !a.callTool("fs_write", `{"path":"a.txt","content":"x"}`) {
+		t.Error("write allowed in read-only session")
+	}
+	if b.callTool("fs_write", `{"path":"b.txt","content":"x"}`) {
+		t.Error("switchsession by one client changed another's session")
+	}
+}
+
+func TestHTTPSessionsScopedToClient(t *testing.T) {
+	_, ts := newTestTransport(t, transportHTTP, httpAuth{})
+	a := &httpClient{t: t, url: ts.URL}
+	b := &httpClient{t: t, url: ts.URL}
+	a.initialize()
+	b.initialize()
+
+	if a.callTool("createsession", `{"id":"mine","sandbox":true}`) {
+		t.Fatal("createsession failed")
+	}
+	list := func(c *httpClient) string {
+		return string(c.call(`{"jsonrpc":"2.0","id":3,"method":"tools/call","params":{"name":"listsessions","arguments":{}}}`).Result)
+	}
+	if !strings.Contains(list(a), `mine`) {
+		t.Fatalf("creator does not see its session: %s", list(a))
+	}
+	if strings.Contains(list(b), `mine`) || !strings.Contains(list(b), `default`) {
+		t.Fatalf("other client sees %s", list(b))
+	}
+	if !b.callTool("switchsession", `{"id":"mine"}`) {
+		t.Fatal("other client switched into the session")
+	}
+	if a.callTool("switchsession", `{"id":"mine"}`) {
+		t.Fatal("creator could not switch into its session")
+	}
+
+	// Ending the client removes its sessions, freeing the id
+	if code := a.do(http.MethodDelete, "").StatusCode; code != http.StatusOK {
+		t.Fatalf("delete: status %d", code)
+	}
+	if b.callTool("createsession", `{"id":"mine"}`) {
+		t.Fatal("session of an ended client still exists")
+	}
+}
+
+func TestHTTPAuth(t *testing.T) {
+	_, ts := newTestTransport(t, transportHTTP, httpAuth{token: "s3cret"})
+	resp, err := http.Get(ts.URL + healthPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("health without token: status %d", resp.StatusCode)
+	}
+	for _, token := range []string{"", "wrong"} {
+		c := &httpClient{t: t, url: ts.URL, token: token}
+		resp := c.do(http.MethodPost, initializeRequest)
+		if resp.StatusCode != http.StatusUnauthorized || resp.Header.Get("WWW-Authenticate") == "" {
+			t.Errorf("token %q: status %d", token, resp.StatusCode)
+		}
+	}
+	(&httpClient{t: t, url: ts.URL, token: "s3cret"}).initialize()
+}
+
+func TestHTTPClientCertificates(t *testing.T) {
+	caKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	caTmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "test CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+	}
+	caDER, err := x509.CreateCertificate(rand.Reader, caTmpl, caTmpl, &caKey.PublicKey, caKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ca, _ := x509.ParseCertificate(caDER)
+	clientKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	clientDER, err := x509.CreateCertificate(rand.Reader, &x509.Certificate{
+		SerialNumber: big.NewInt(2),
+		Subject:      pkix.Name{CommonName: "client"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}, ca, &clientKey.PublicKey, caKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s, router := setupServer(newMemBackend())
+	tr, err := newHTTPTransport(s, router, transportHTTP, httpAuth{requireCert: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ts := httptest.NewUnstartedServer(tr)
+	pool := x509.NewCertPool()
+	pool.AddCert(ca)
+	ts.TLS = &tls.Config{ClientCAs: pool, ClientAuth: tls.VerifyClientCertIfGiven}
+	ts.StartTLS()
+	defer ts.Close()
+
+	post := func(client *http.Client, path string) int {
+		resp, err := client.Post(ts.URL+path, "application/json", strings.NewReader(initializeRequest))
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+	if code := post(ts.Client(), mcpPath); code != http.StatusUnauthorized {
+		t.Errorf("without certificate: status %d", code)
+	}
+	if code := post(ts.Client(), healthPath); code != http.StatusOK {
+		t.Errorf("health without certificate: status %d", code)
+	}
+	transport := ts.Client().Transport.(*http.Transport).Clone()
+	transport.TLSClientConfig.Certificates = []tls.Certificate{{
+		Certificate: [][]byte{clientDER},
+		PrivateKey:  clientKey,
+	}}
+	withCert := &http.Client{Transport: transport}
+	if code := post(withCert, mcpPath); code != http.StatusOK {
+		t.Errorf("with certificate: status %d", code)
+	}
+}
+
+func TestSSETransport(t *testing.T) {
+	tr, ts := newTestTransport(t, transportSSE, httpAuth{})
+	resp, err := http.Get(ts.URL + ssePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	events := make(chan string, 16)
+	go func() {
+		defer close(events)
+		sc := bufio.NewScanner(resp.Body)
+		for sc.Scan() {
+			if data, ok := strings.CutPrefix(sc.Text(), "data:"); ok {
+				events <- strings.TrimSpace(data)
+			}
+		}
+	}()
+	next := func() string {
+		t.Helper()
+		select {
+		case e, ok := <-events:
+			if !ok {
+				t.Fatal("event stream closed")
+			}
+			return e
+		case <-time.After(5 * time.Second):
+			t.Fatal("no event")
+		}
+		return ""
+	}
+	endpoint := ts.URL + next()
+	send := func(body string) {
+		t.Helper()
+		resp, err := http.Post(endpoint, "application/json", strings.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusAccepted {
+			t.Fatalf("%s: status %d", body, resp.StatusCode)
+		}
+	}
+
+	send(initializeRequest)
+	if e := next(); !strings.Contains(e, `"completions"`) || !strings.Contains(e, `"tools"`) {
+		t.Fatalf("initialize = %s", e)
+	}
+	send(`{"jsonrpc":"2.0","method":"notifications/initialized"}`)
+	send(`{"jsonrpc":"2.0","id":2,"method":"resources/list"}`)
+	if e := next(); !strings.Contains(e, `"resources"`) {
+		t.Fatalf("resources/list = %s", e)
+	}
+	send(`{"jsonrpc":"2.0","id":3,"method":"tools/list"}`)
+	if e := next(); !strings.Contains(e, `"fs_read"`) {
+		t.Fatalf("tools/list = %s", e)
+	}
+
+	// Shutdown ends the stream and fails the health check
+	tr.drain()
+	for range events {
+	}
+	hr, err := http.Get(ts.URL + healthPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hr.Body.Close()
+	if hr.StatusCode != http.StatusServiceUnavailable {
+		t.Errorf("health while draining: status %d", hr.StatusCode)
+	}
+}
+
+func TestServerTLSConfig(t *testing.T) {
+	if cfg, err := serverTLSConfig("", "", ""); cfg != nil || err != nil {
+		t.Fatalf("no TLS = %v, %v", cfg, err)
+	}
+	for _, c := range [][3]string{{"cert.pem", "", ""}, {"", "", "ca.pem"}, {"missing.pem", "missing.key", ""}} {
+		if _, err := serverTLSConfig(c[0], c[1], c[2]); err == nil {
+			t.Errorf("serverTLSConfig%q accepted", c)
+		}
+	}
+}
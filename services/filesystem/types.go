@@ -4,11 +4,16 @@ package main
 type writeStrategy string
 
 const (
-	strategyOverwrite    writeStrategy = "overwrite"     // Replace entire file content
-	strategyNoClobber    writeStrategy = "no_clobber"    // Fail if file exists
-	strategyAppend       writeStrategy = "append"        // Add to end of file
-	strategyPrepend      writeStrategy = "prepend"       // Add to beginning of file
-	strategyReplaceRange writeStrategy = "replace_range" // Replace specific byte range
+	strategyOverwrite        writeStrategy = "overwrite"          // Replace entire file content
+	strategyNoClobber        writeStrategy = "no_clobber"         // Fail if file exists
+	strategyAppend           writeStrategy = "append"             // Add to end of file
+	strategyPrepend          writeStrategy = "prepend"            // Add to beginning of file
+	strategyReplaceRange     writeStrategy = "replace_range"      // Replace specific byte range
+	strategyInsertAtLine     writeStrategy = "insert_at_line"     // Insert before a line
+	strategyInsertAfterLine  writeStrategy = "insert_after_line"  // Insert after a line
+	strategyReplaceLines     writeStrategy = "replace_lines"      // Replace an inclusive line range
+	strategyDeleteLines      writeStrategy = "delete_lines"       // Remove an inclusive line range
+	strategyInsertAfterMatch writeStrategy = "insert_after_match" // Insert after the first line matching a regex
 )
 
 // MetaFields contains common file metadata
@@ -19,66 +24,270 @@ type MetaFields struct {
 
 // ReadArgs defines parameters for reading files
 type ReadArgs struct {
-	Path     string `json:"path" description:"File path or file:// URI within base folder"`
-	MaxBytes int    `json:"max_bytes,omitempty" description:"Maximum bytes to return"`
+	Path              string          `json:"path" description:"File path or file:// URI within base folder"`
+	MaxBytes          int             `json:"max_bytes,omitempty" description:"Maximum bytes to return" jsonschema:"minimum=1"`
+	Decompress        bool            `json:"decompress,omitempty" description:"Decode gzip, bzip2, xz or zstd content; max_bytes applies to the decoded bytes"`
+	Encoding          contentEncoding `json:"encoding,omitempty" description:"Content encoding; use base64 or hex for binary files" jsonschema:"enum=utf8,enum=base64,enum=hex"`
+	IfNoneMatchSHA256 string          `json:"if_none_match_sha256,omitempty" description:"SHA256 from an earlier read; an unchanged file returns not_modified without content"`
 }
 
 // ReadResult contains file read operation results
 type ReadResult struct {
-	Path      string `json:"path" description:"Original requested path"`
-	Size      int64  `json:"size" description:"Total file size in bytes"`
-	MIMEType  string `json:"mime_type" description:"Detected MIME type"`
-	SHA256    string `json:"sha256" description:"SHA256 hash of content (if under 32MB)"`
-	Content   string `json:"content" description:"File content (possibly truncated)"`
-	Truncated bool   `json:"truncated" description:"Whether content was truncated"`
+	Path            string `json:"path" description:"Original requested path"`
+	Size            int64  `json:"size" description:"Total file size in bytes"`
+	MIMEType        string `json:"mime_type" description:"Detected MIME type"`
+	SHA256          string `json:"sha256" description:"SHA256 hash of content (if under 32MB)"`
+	Content         string `json:"content" description:"File content (possibly truncated)"`
+	Truncated       bool   `json:"truncated" description:"Whether content was truncated"`
+	Compression     string `json:"compression,omitempty" description:"Compression format decoded: gzip, bzip2, xz or zstd"`
+	ContentEncoding string `json:"content_encoding,omitempty" description:"Encoding of content when not utf8: base64 or hex"`
+	Encoding        string `json:"encoding,omitempty" description:"Detected text encoding of the file: utf-8, utf-16le, utf-16be, latin-1 or windows-1252"`
+	BOM             bool   `json:"bom,omitempty" description:"Whether the file starts with a byte order mark"`
+	LineEndings     string `json:"line_endings,omitempty" description:"Line ending convention: lf, crlf or mixed"`
+	NotModified     bool   `json:"not_modified,omitempty" description:"The file matched if_none_match_sha256; content was not returned"`
 	MetaFields
 }
 
 // PeekArgs defines parameters for peeking into files
 type PeekArgs struct {
-	Path     string `json:"path" description:"File path"`
-	Offset   int    `json:"offset,omitempty" description:"Byte offset to start at"`
-	MaxBytes int    `json:"max_bytes,omitempty" description:"Window size in bytes"`
+	Path              string          `json:"path" description:"File path"`
+	Offset            int             `json:"offset,omitempty" description:"Byte offset to start at" jsonschema:"minimum=0"`
+	MaxBytes          int             `json:"max_bytes,omitempty" description:"Window size in bytes" jsonschema:"minimum=1"`
+	Decompress        bool            `json:"decompress,omitempty" description:"Decode gzip, bzip2, xz or zstd content; offset and max_bytes apply to the decoded bytes"`
+	Encoding          contentEncoding `json:"encoding,omitempty" description:"Content encoding; hexdump shows offset, hex and ASCII columns" jsonschema:"enum=utf8,enum=base64,enum=hex,enum=hexdump"`
+	IfNoneMatchSHA256 string          `json:"if_none_match_sha256,omitempty" description:"SHA256 from an earlier read; an unchanged file returns not_modified without content"`
 }
 
 // PeekResult contains file peek operation results
 type PeekResult struct {
-	Path    string `json:"path" description:"Original requested path"`
-	Offset  int    `json:"offset" description:"Starting byte offset"`
-	Size    int64  `json:"size" description:"Total file size"`
-	EOF     bool   `json:"eof" description:"Whether window reached end of file"`
-	Content string `json:"content" description:"Window content"`
+	Path            string `json:"path" description:"Original requested path"`
+	Offset          int    `json:"offset" description:"Starting byte offset"`
+	Size            int64  `json:"size" description:"Total file size"`
+	EOF             bool   `json:"eof" description:"Whether window reached end of file"`
+	Content         string `json:"content" description:"Window content"`
+	Compression     string `json:"compression,omitempty" description:"Compression format decoded: gzip, bzip2, xz or zstd"`
+	ContentEncoding string `json:"content_encoding,omitempty" description:"Encoding of content when not utf8: base64, hex or hexdump"`
+	SHA256          string `json:"sha256,omitempty" description:"SHA256 of the whole file; set when if_none_match_sha256 was given"`
+	NotModified     bool   `json:"not_modified,omitempty" description:"The file matched if_none_match_sha256; content was not returned"`
 	MetaFields
 }
 
+// ReadManyArgs defines parameters for reading several files in one call
+type ReadManyArgs struct {
+	Paths           []string        `json:"paths,omitempty" description:"Files to read, in the order results should be returned"`
+	Glob            string          `json:"glob,omitempty" description:"Glob pattern selecting files; ** enables recursion. Matches follow any paths, sorted"`
+	MaxBytesPerFile int             `json:"max_bytes_per_file,omitempty" description:"Maximum bytes returned for each file" jsonschema:"minimum=1"`
+	MaxTotalBytes   int             `json:"max_total_bytes,omitempty" description:"Maximum content bytes returned across all files" jsonschema:"minimum=1"`
+	MaxFiles        int             `json:"max_files,omitempty" description:"Maximum number of files to read" jsonschema:"minimum=1"`
+	IncludeBinary   bool            `json:"include_binary,omitempty" description:"Return binary files too, base64 encoded unless encoding is set"`
+	Encoding        contentEncoding `json:"encoding,omitempty" description:"Content encoding for every file" jsonschema:"enum=utf8,enum=base64,enum=hex"`
+}
+
+// ReadManyEntry is the outcome for one file of a batch read
+type ReadManyEntry struct {
+	Path    string         `json:"path" description:"Requested path"`
+	Result  *ReadResult    `json:"result,omitempty" description:"Read result when the file was read"`
+	Error   *ErrorResponse `json:"error,omitempty" description:"Error with code when the file could not be read"`
+	Skipped string         `json:"skipped,omitempty" description:"Why the content was left out: binary or budget"`
+}
+
+// ReadManyResult contains batch read results
+type ReadManyResult struct {
+	Files      []ReadManyEntry `json:"files" description:"One entry per file, in request order or sorted glob order"`
+	TotalBytes int             `json:"total_bytes" description:"Content bytes returned across all files"`
+	Truncated  bool            `json:"truncated" description:"Whether the byte budget or file limit cut the batch short"`
+}
+
+// TailArgs defines parameters for tailing files
+type TailArgs struct {
+	Path      string          `json:"path" description:"File path"`
+	Lines     int             `json:"lines,omitempty" description:"Number of lines from the end (default 10); ignored when following" jsonschema:"minimum=1"`
+	Cursor    string          `json:"cursor,omitempty" description:"Cursor from an earlier fs_tail; returns content appended since then"`
+	TimeoutMs int             `json:"timeout_ms,omitempty" description:"How long a follow waits for new content, in milliseconds" jsonschema:"minimum=0,maximum=60000"`
+	MaxBytes  int             `json:"max_bytes,omitempty" description:"Maximum bytes to return" jsonschema:"minimum=1"`
+	Encoding  contentEncoding `json:"encoding,omitempty" description:"Content encoding: utf8, base64 or hex" jsonschema:"enum=utf8,enum=base64,enum=hex"`
+}
+
+// TailResult contains tail and follow results
+type TailResult struct {
+	Path            string `json:"path" description:"Original requested path"`
+	Content         string `json:"content" description:"Returned content"`
+	Offset          int64  `json:"offset" description:"Byte offset where content starts"`
+	Lines           int    `json:"lines" description:"Number of lines in content"`
+	Size            int64  `json:"size" description:"File size when read"`
+	Cursor          string `json:"cursor" description:"Pass back to fs_tail to follow the file"`
+	Truncated       bool   `json:"truncated" description:"Whether max_bytes cut the content short"`
+	Reset           string `json:"reset,omitempty" description:"Set when following restarted from the beginning: truncated or rotated"`
+	ContentEncoding string `json:"content_encoding,omitempty" description:"Encoding of content when not utf8: base64 or hex"`
+	MetaFields
+}
+
+// LockArgs defines parameters for leasing a path
+type LockArgs struct {
+	Path    string `json:"path" description:"File or directory to lease; it need not exist yet"`
+	Subtree bool   `json:"subtree,omitempty" description:"Also lease everything below the directory"`
+	TTLMs   int    `json:"ttl_ms,omitempty" description:"Lease duration in milliseconds; defaults to 60000" jsonschema:"minimum=1,maximum=600000"`
+}
+
+// LockRenewArgs defines parameters for extending a lease
+type LockRenewArgs struct {
+	LeaseID string `json:"lease_id" description:"Lease returned by fs_lock"`
+	TTLMs   int    `json:"ttl_ms,omitempty" description:"New lease duration from now, in milliseconds; defaults to 60000" jsonschema:"minimum=1,maximum=600000"`
+}
+
+// LockResult describes a granted or renewed lease
+type LockResult struct {
+	LeaseID   string `json:"lease_id" description:"Identifier for fs_lock_renew and fs_unlock"`
+	Path      string `json:"path" description:"Leased path"`
+	Subtree   bool   `json:"subtree" description:"Whether everything below the path is leased too"`
+	ExpiresAt string `json:"expires_at" description:"When the lease lapses unless renewed (RFC 3339)"`
+}
+
+// UnlockArgs defines parameters for releasing a lease
+type UnlockArgs struct {
+	LeaseID string `json:"lease_id" description:"Lease to release"`
+}
+
+// UnlockResult reports a released lease
+type UnlockResult struct {
+	LeaseID  string `json:"lease_id" description:"Lease that was released"`
+	Released bool   `json:"released" description:"Whether the lease was still held"`
+}
+
+// WatchArgs defines parameters for watching paths
+type WatchArgs struct {
+	Paths  []string `json:"paths,omitempty" description:"Files or directories to watch; directories include everything below them"`
+	Globs  []string `json:"globs,omitempty" description:"Doublestar globs of paths to watch"`
+	Ignore []string `json:"ignore,omitempty" description:"Doublestar globs of paths to leave out"`
+	Notify *bool    `json:"notify,omitempty" description:"Send notifications/fs/changed to the client; defaults to true"`
+}
+
+// WatchResult describes a new watch
+type WatchResult struct {
+	WatchID string `json:"watch_id" description:"Identifier for fs_unwatch and fs_changes_since"`
+	Cursor  int64  `json:"cursor" description:"Pass to fs_changes_since to get changes from now on"`
+	Mode    string `json:"mode" description:"How changes are detected: notify or poll"`
+}
+
+// UnwatchArgs defines parameters for removing a watch
+type UnwatchArgs struct {
+	WatchID string `json:"watch_id" description:"Watch to remove"`
+}
+
+// UnwatchResult reports a removed watch
+type UnwatchResult struct {
+	WatchID string `json:"watch_id" description:"Watch that was removed"`
+	Removed bool   `json:"removed" description:"Whether the watch existed"`
+}
+
+// ChangesSinceArgs defines parameters for querying recorded changes
+type ChangesSinceArgs struct {
+	Cursor     int64  `json:"cursor,omitempty" description:"Cursor from fs_watch or an earlier fs_changes_since" jsonschema:"minimum=0"`
+	WatchID    string `json:"watch_id,omitempty" description:"Only return changes seen by this watch"`
+	MaxResults int    `json:"max_results,omitempty" description:"Maximum changes to return" jsonschema:"minimum=1"`
+	TimeoutMs  int    `json:"timeout_ms,omitempty" description:"How long to wait for a change when there is none yet, in milliseconds" jsonschema:"minimum=0,maximum=60000"`
+}
+
+// ChangeEvent is one coalesced change to a path
+type ChangeEvent struct {
+	Seq      int64    `json:"seq" description:"Position in the session's change log"`
+	Path     string   `json:"path" description:"Changed path relative to the session root"`
+	Ops      []string `json:"ops" description:"What happened: create, write, remove, rename or chmod"`
+	Time     string   `json:"time" description:"When the change was recorded (RFC3339)"`
+	WatchIDs []string `json:"watch_ids" description:"Watches that matched the path"`
+}
+
+// ChangesSinceResult contains changes recorded after a cursor
+type ChangesSinceResult struct {
+	Changes  []ChangeEvent `json:"changes" description:"Changes in the order they were recorded"`
+	Cursor   int64         `json:"cursor" description:"Pass back to continue after the last returned change"`
+	Overflow bool          `json:"overflow" description:"Changes after the cursor were dropped from the log; rescan the watched paths"`
+}
+
 // WriteArgs defines parameters for writing files
 type WriteArgs struct {
-	Path     string        `json:"path" description:"Target file path"`
-	Content  string        `json:"content" description:"Data to write"`
-	Strategy writeStrategy `json:"strategy,omitempty" description:"Write strategy: overwrite, no_clobber, append, prepend, replace_range"`
-	Mode     string        `json:"mode,omitempty" description:"File mode in octal, e.g. 0644"`
-	Start    *int          `json:"start,omitempty" description:"Start byte for replace_range strategy"`
-	End      *int          `json:"end,omitempty" description:"End byte (exclusive) for replace_range"`
+	Path               string          `json:"path" description:"Target file path"`
+	Content            string          `json:"content" description:"Data to write"`
+	Strategy           writeStrategy   `json:"strategy,omitempty" description:"Write strategy: overwrite, no_clobber, append, prepend, replace_range, insert_at_line, insert_after_line, replace_lines, delete_lines, insert_after_match" jsonschema:"enum=overwrite,enum=no_clobber,enum=append,enum=prepend,enum=replace_range,enum=insert_at_line,enum=insert_after_line,enum=replace_lines,enum=delete_lines,enum=insert_after_match"`
+	Mode               string          `json:"mode,omitempty" description:"File mode in octal, e.g. 0644; keeps the existing mode if omitted" jsonschema:"pattern=^0?[0-7]{3\\,4}$"`
+	Start              *int            `json:"start,omitempty" description:"Start byte for replace_range strategy" jsonschema:"minimum=0"`
+	End                *int            `json:"end,omitempty" description:"End byte (exclusive) for replace_range" jsonschema:"minimum=0"`
+	Line               *int            `json:"line,omitempty" description:"1-based line for line strategies; first line of the range for replace_lines and delete_lines" jsonschema:"minimum=0"`
+	EndLine            *int            `json:"end_line,omitempty" description:"Last line (inclusive) for replace_lines and delete_lines; defaults to line" jsonschema:"minimum=1"`
+	Match              string          `json:"match,omitempty" description:"Regex whose first matching line insert_after_match inserts after"`
+	Encoding           contentEncoding `json:"encoding,omitempty" description:"Encoding of content: utf8, base64 or hex; use base64 or hex for binary data" jsonschema:"enum=utf8,enum=base64,enum=hex"`
+	Charset            string          `json:"charset,omitempty" description:"Text encoding to store; defaults to that of the existing file" jsonschema:"enum=utf-8,enum=utf-16le,enum=utf-16be,enum=latin-1,enum=windows-1252"`
+	BOM                *bool           `json:"bom,omitempty" description:"Write a byte order mark; defaults to that of the existing file"`
+	Newline            newlinePolicy   `json:"newline,omitempty" description:"Line endings: preserve follows the existing file (default), lf or crlf" jsonschema:"enum=preserve,enum=lf,enum=crlf"`
+	EnsureFinalNewline bool            `json:"ensure_final_newline,omitempty" description:"Terminate the content with a line break"`
 }
 
 // WriteResult contains file write operation results
 type WriteResult struct {
-	Path     string `json:"path" description:"File path written"`
-	Action   string `json:"action" description:"Write strategy used"`
-	Bytes    int    `json:"bytes" description:"Total bytes in final file"`
-	Created  bool   `json:"created" description:"Whether file was newly created"`
-	MIMEType string `json:"mime_type" description:"Detected MIME type"`
-	SHA256   string `json:"sha256" description:"SHA256 of final content"`
+	Path        string `json:"path" description:"File path written"`
+	Action      string `json:"action" description:"Write strategy used"`
+	Bytes       int    `json:"bytes" description:"Total bytes in final file"`
+	Created     bool   `json:"created" description:"Whether file was newly created"`
+	MIMEType    string `json:"mime_type" description:"Detected MIME type"`
+	SHA256      string `json:"sha256" description:"SHA256 of final content"`
+	Charset     string `json:"charset,omitempty" description:"Text encoding the content was stored in"`
+	LineEndings string `json:"line_endings,omitempty" description:"Line ending convention of the final file: lf, crlf or mixed"`
+	LineStart   int    `json:"line_start,omitempty" description:"First line affected by a line strategy"`
+	LineEnd     int    `json:"line_end,omitempty" description:"Last line affected by a line strategy; for delete_lines, the removed range of the original; equal to line_start when empty content was written"`
 	MetaFields
 }
 
+// WriteBeginArgs defines parameters for starting a chunked upload
+type WriteBeginArgs struct {
+	Path      string `json:"path" description:"Target file path"`
+	Size      int64  `json:"size,omitempty" description:"Expected total size in bytes; checked against --max-size and at commit" jsonschema:"minimum=0"`
+	Mode      string `json:"mode,omitempty" description:"File mode in octal; omit to keep existing permissions" jsonschema:"pattern=^0?[0-7]{3\\,4}$"`
+	Overwrite bool   `json:"overwrite,omitempty" description:"Replace an existing file on commit"`
+	UploadID  string `json:"upload_id,omitempty" description:"Resume this upload instead of starting a new one"`
+}
+
+// WriteChunkArgs defines parameters for sending one upload chunk
+type WriteChunkArgs struct {
+	UploadID string          `json:"upload_id" description:"Upload returned by fs_write_begin"`
+	Offset   int64           `json:"offset" description:"Byte offset of this chunk; must equal the bytes received so far" jsonschema:"minimum=0"`
+	Data     string          `json:"data" description:"Chunk content"`
+	Encoding contentEncoding `json:"encoding,omitempty" description:"Encoding of data: utf8, base64 or hex" jsonschema:"enum=utf8,enum=base64,enum=hex"`
+	SHA256   string          `json:"sha256,omitempty" description:"SHA256 of the decoded chunk"`
+}
+
+// WriteCommitArgs defines parameters for finishing an upload
+type WriteCommitArgs struct {
+	UploadID string `json:"upload_id" description:"Upload returned by fs_write_begin"`
+	Path     string `json:"path" description:"Target file path given to fs_write_begin"`
+	SHA256   string `json:"sha256" description:"SHA256 of the complete file"`
+}
+
+// WriteAbortArgs defines parameters for abandoning an upload
+type WriteAbortArgs struct {
+	UploadID string `json:"upload_id" description:"Upload returned by fs_write_begin"`
+}
+
+// UploadResult describes the state of a chunked upload
+type UploadResult struct {
+	UploadID string `json:"upload_id" description:"Upload identifier"`
+	Path     string `json:"path" description:"Target file path"`
+	Offset   int64  `json:"offset" description:"Bytes received so far; the offset of the next chunk"`
+	Size     int64  `json:"size,omitempty" description:"Declared total size"`
+	MaxSize  int64  `json:"max_size" description:"Largest file the server accepts"`
+	Aborted  bool   `json:"aborted,omitempty" description:"Whether the upload was abandoned"`
+}
+
 // EditArgs defines parameters for editing files
 type EditArgs struct {
-	Path    string `json:"path" description:"Target text file"`
-	Pattern string `json:"pattern" description:"Substring or regex to match"`
-	Replace string `json:"replace" description:"Replacement text; $1 etc. works in regex mode"`
-	Regex   bool   `json:"regex,omitempty" description:"Treat pattern as regex"`
-	Count   int    `json:"count,omitempty" description:"Maximum replacements; 0 means all"`
+	Path               string        `json:"path" description:"Target text file"`
+	Pattern            string        `json:"pattern" description:"Substring or regex to match"`
+	Replace            string        `json:"replace" description:"Replacement text; $1 etc. works in regex mode"`
+	Regex              bool          `json:"regex,omitempty" description:"Treat pattern as regex"`
+	Count              int           `json:"count,omitempty" description:"Maximum replacements; 0 means all" jsonschema:"minimum=0"`
+	Charset            string        `json:"charset,omitempty" description:"Text encoding to store; defaults to that of the existing file" jsonschema:"enum=utf-8,enum=utf-16le,enum=utf-16be,enum=latin-1,enum=windows-1252"`
+	BOM                *bool         `json:"bom,omitempty" description:"Write a byte order mark; defaults to that of the existing file"`
+	Newline            newlinePolicy `json:"newline,omitempty" description:"Line endings for the file: preserve (default), lf or crlf" jsonschema:"enum=preserve,enum=lf,enum=crlf"`
+	EnsureFinalNewline bool          `json:"ensure_final_newline,omitempty" description:"Terminate the file with a line break"`
 }
 
 // EditResult contains file edit operation results
@@ -87,6 +296,8 @@ type EditResult struct {
 	Replacements int    `json:"replacements" description:"Number of replacements made"`
 	Bytes        int    `json:"bytes" description:"Final file size"`
 	SHA256       string `json:"sha256" description:"SHA256 of final content"`
+	Charset      string `json:"charset,omitempty" description:"Text encoding the file was stored in"`
+	LineEndings  string `json:"line_endings,omitempty" description:"Line ending convention of the final file: lf, crlf or mixed"`
 	MetaFields
 }
 
@@ -94,7 +305,7 @@ type EditResult struct {
 type ListArgs struct {
 	Path       string `json:"path" description:"Directory to list"`
 	Recursive  bool   `json:"recursive,omitempty" description:"Recurse into subdirectories"`
-	MaxEntries int    `json:"max_entries,omitempty" description:"Maximum entries to return"`
+	MaxEntries int    `json:"max_entries,omitempty" description:"Maximum entries to return" jsonschema:"minimum=1"`
 }
 
 // ListEntry represents a single file/directory entry
@@ -112,10 +323,25 @@ type ListResult struct {
 	Entries []ListEntry `json:"entries" description:"Directory entries"`
 }
 
+// StatArgs defines parameters for describing a single path
+type StatArgs struct {
+	Path string `json:"path" description:"File or directory to describe"`
+}
+
+// StatResult describes a single path without following a final symlink
+type StatResult struct {
+	Path   string `json:"path" description:"Path as requested"`
+	Name   string `json:"name" description:"Base filename"`
+	Kind   string `json:"kind" description:"Type: file/dir/symlink/other"`
+	Size   int64  `json:"size" description:"Size in bytes"`
+	Target string `json:"target,omitempty" description:"Link target when kind is symlink"`
+	MetaFields
+}
+
 // GlobArgs defines parameters for glob pattern matching
 type GlobArgs struct {
 	Pattern    string `json:"pattern" description:"Glob pattern; ** enables recursion"`
-	MaxResults int    `json:"max_results,omitempty" description:"Maximum matches to return"`
+	MaxResults int    `json:"max_results,omitempty" description:"Maximum matches to return" jsonschema:"minimum=1"`
 }
 
 // GlobResult contains glob matching results
@@ -128,7 +354,8 @@ type SearchArgs struct {
 	Pattern    string `json:"pattern" description:"Text or regex pattern to find"`
 	Path       string `json:"path,omitempty" description:"Start directory relative to base folder"`
 	Regex      bool   `json:"regex,omitempty" description:"Interpret pattern as regex"`
-	MaxResults int    `json:"max_results,omitempty" description:"Maximum matches to return"`
+	MaxResults int    `json:"max_results,omitempty" description:"Maximum matches to return" jsonschema:"minimum=1"`
+	Decompress bool   `json:"decompress,omitempty" description:"Also search gzip, bzip2, xz and zstd files through their decoders"`
 }
 
 // SearchMatch represents a single search result
@@ -147,7 +374,7 @@ type SearchResult struct {
 // MkdirArgs defines parameters for creating directories
 type MkdirArgs struct {
 	Path string `json:"path" description:"Directory path to create; supports brace expansion"`
-	Mode string `json:"mode,omitempty" description:"Directory mode in octal"`
+	Mode string `json:"mode,omitempty" description:"Directory mode in octal" jsonschema:"pattern=^0?[0-7]{3\\,4}$"`
 }
 
 // MkdirResult contains directory creation results
@@ -169,14 +396,133 @@ type RmdirResult struct {
 	Removed bool   `json:"removed" description:"Whether directory was removed"`
 }
 
+// AuditQueryArgs defines filters for querying the audit log
+type AuditQueryArgs struct {
+	Session string `json:"session,omitempty" description:"Only entries from this session id"`
+	Tool    string `json:"tool,omitempty" description:"Only entries for this tool, e.g. fs_write"`
+	Path    string `json:"path,omitempty" description:"Doublestar pattern matched against the recorded path"`
+	Result  string `json:"result,omitempty" description:"Only ok or error entries" jsonschema:"enum=ok,enum=error"`
+	Since   string `json:"since,omitempty" description:"Only entries at or after this RFC3339 time"`
+	Until   string `json:"until,omitempty" description:"Only entries at or before this RFC3339 time"`
+	Limit   int    `json:"limit,omitempty" description:"Maximum entries to return, most recent last" jsonschema:"minimum=1"`
+}
+
+// AuditQueryResult contains matching audit log entries
+type AuditQueryResult struct {
+	Entries   []AuditEntry `json:"entries" description:"Matching entries, oldest first"`
+	Truncated bool         `json:"truncated" description:"Whether older matches were dropped to honor the limit"`
+}
+
+// UndoArgs defines parameters for reverting recent operations
+type UndoArgs struct {
+	Steps int `json:"steps,omitempty" description:"Number of operations to revert; defaults to 1" jsonschema:"minimum=1"`
+}
+
+// UndoResult contains undo results
+type UndoResult struct {
+	Undone    int      `json:"undone" description:"Number of operations reverted"`
+	Remaining int      `json:"remaining" description:"Operations still available to undo"`
+	Paths     []string `json:"paths" description:"Paths restored or removed"`
+}
+
+// CheckpointArgs defines parameters for naming the current state of paths
+type CheckpointArgs struct {
+	Name  string   `json:"name" description:"Checkpoint name; reusing a name replaces it"`
+	Paths []string `json:"paths" description:"Files or directories to capture; directories include their contents"`
+}
+
+// CheckpointResult contains checkpoint creation results
+type CheckpointResult struct {
+	Name  string `json:"name" description:"Checkpoint name"`
+	Files int    `json:"files" description:"Number of files captured"`
+	Bytes int64  `json:"bytes" description:"Total bytes captured"`
+}
+
+// RestoreCheckpointArgs defines parameters for restoring a checkpoint
+type RestoreCheckpointArgs struct {
+	Name string `json:"name" description:"Checkpoint to restore"`
+}
+
+// RestoreCheckpointResult contains checkpoint restore results
+type RestoreCheckpointResult struct {
+	Name     string   `json:"name" description:"Checkpoint restored"`
+	Restored []string `json:"restored" description:"Paths written back to their captured state"`
+	Removed  []string `json:"removed" description:"Paths removed because they did not exist at the checkpoint"`
+}
+
+// OverlayChange describes one pending change of a sandbox session
+type OverlayChange struct {
+	Path   string `json:"path" description:"Path relative to the base folder"`
+	Kind   string `json:"kind" description:"file, dir or symlink"`
+	Change string `json:"change" description:"added, modified or deleted"`
+}
+
+// OverlayResult contains the changes shown, committed or discarded
+type OverlayResult struct {
+	Action  string          `json:"action" description:"diff, commit or discard"`
+	Changes []OverlayChange `json:"changes" description:"Pending changes relative to the base folder"`
+}
+
+// ArchiveCreateArgs defines parameters for packing files into an archive
+type ArchiveCreateArgs struct {
+	Path          string   `json:"path" description:"Archive to write; .zip, .tar.gz or .tgz"`
+	Include       []string `json:"include" description:"Files, directories or doublestar globs to pack"`
+	Exclude       []string `json:"exclude,omitempty" description:"Doublestar globs of paths to leave out"`
+	Format        string   `json:"format,omitempty" description:"zip or tar.gz; defaults to the extension of path" jsonschema:"enum=zip,enum=tar.gz"`
+	PreserveTimes bool     `json:"preserve_times,omitempty" description:"Store modification times instead of a fixed 1980-01-01 timestamp"`
+	Overwrite     bool     `json:"overwrite,omitempty" description:"Replace an existing archive"`
+}
+
+// ArchiveExtractArgs defines parameters for unpacking an archive
+type ArchiveExtractArgs struct {
+	Path      string  `json:"path" description:"Archive to unpack; .zip, .tar, .tar.gz or .tar.zst"`
+	Dest      string  `json:"dest" description:"Directory to unpack into; created if missing"`
+	Overwrite bool    `json:"overwrite,omitempty" description:"Replace existing files instead of failing"`
+	MaxBytes  int64   `json:"max_bytes,omitempty" description:"Maximum total uncompressed size; defaults to --max-size" jsonschema:"minimum=1"`
+	MaxRatio  float64 `json:"max_ratio,omitempty" description:"Maximum uncompressed to compressed size ratio (default 100)" jsonschema:"minimum=1"`
+}
+
+// ArchiveEntry describes one entry written to or from an archive
+type ArchiveEntry struct {
+	Path string `json:"path" description:"Entry path"`
+	Kind string `json:"kind" description:"file or dir"`
+	Size int64  `json:"size" description:"Uncompressed size in bytes"`
+	Mode string `json:"mode" description:"Permissions in octal"`
+}
+
+// ArchiveSkipped describes an archive entry that was not extracted
+type ArchiveSkipped struct {
+	Path   string `json:"path" description:"Entry path as stored in the archive"`
+	Reason string `json:"reason" description:"Why the entry was skipped"`
+}
+
+// ArchiveResult is the manifest of an archive create or extract call
+type ArchiveResult struct {
+	Path    string           `json:"path" description:"Archive path"`
+	Format  string           `json:"format" description:"Archive format"`
+	Dest    string           `json:"dest,omitempty" description:"Extraction directory"`
+	Entries []ArchiveEntry   `json:"entries" description:"Entries written"`
+	Skipped []ArchiveSkipped `json:"skipped,omitempty" description:"Entries left out, such as links"`
+	Bytes   int64            `json:"bytes" description:"Total uncompressed bytes of the entries"`
+	SHA256  string           `json:"sha256,omitempty" description:"SHA256 of the created archive"`
+}
+
 // CreateSessionArgs defines parameters for creating a new session
 type CreateSessionArgs struct {
-	ID string `json:"id,omitempty" description:"Optional session id"`
+	ID           string   `json:"id,omitempty" description:"Optional session id"`
+	Capabilities []string `json:"capabilities,omitempty" description:"Capabilities granted to the session: read, write, delete" jsonschema:"enum=read,enum=write,enum=delete"`
+	Sandbox      bool     `json:"sandbox,omitempty" description:"Keep all changes in a copy-on-write overlay until fs_overlay_commit"`
+	Archive      string   `json:"archive,omitempty" description:"Zip or tar file in the current session to serve read-only as the new root"`
+	ReadOnly     bool     `json:"read_only,omitempty" description:"Refuse every change in the new session"`
+	Rules        []string `json:"rules,omitempty" description:"Deny rules checked before the current session's path rules, as deny:read|write|any:pattern; allow rules are refused since they could widen access"`
 }
 
 // CreateSessionResult contains the created session id
 type CreateSessionResult struct {
-	ID string `json:"id" description:"Created session id"`
+	ID           string   `json:"id" description:"Created session id"`
+	Capabilities []string `json:"capabilities" description:"Capabilities granted to the session"`
+	Sandbox      bool     `json:"sandbox" description:"Whether changes go to a copy-on-write overlay"`
+	Archive      string   `json:"archive,omitempty" description:"Archive served as the session root"`
 }
 
 // SwitchSessionArgs defines parameters for switching active session
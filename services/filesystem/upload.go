@@ -0,0 +1,426 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/mark3labs/mcp-go/mcp"
+)
+
+// upload is a chunked write staged in a hidden file next to its target
+type upload struct {
+	ID        string
+	Path      string      // path as requested by the client
+	Name      string      // resolved target
+	Staging   string      // resolved staging file
+	Size      int64       // declared total size; 0 when unknown
+	Mode      fs.FileMode // requested mode; 0 keeps or defaults the target's
+	Overwrite bool
+	Received  int64
+	Touched   time.Time // last begin or chunk
+	busy      bool      // a commit is hashing or moving the staged data
+}
+
+// uploadTTL is how long an upload may sit idle before its staged data is
+// removed
+var uploadTTL = 24 * time.Hour
+
+// uploadStore holds a session's open uploads
+type uploadStore struct {
+	mu      sync.Mutex
+	uploads map[string]*upload
+}
+
+var uploadInitMu sync.Mutex
+
+// uploads returns the session's open uploads, creating the store on first use
+func (s *SessionState) uploads() *uploadStore {
+	uploadInitMu.Lock()
+	defer uploadInitMu.Unlock()
+	if s.Uploads == nil {
+		s.Uploads = &uploadStore{uploads: map[string]*upload{}}
+	}
+	return s.Uploads
+}
+
+func (u *uploadStore) get(id string) (*upload, error) {
+	up, ok := u.uploads[id]
+	if !ok {
+		return nil, newOpError("write_upload", id, ErrPathNotFound, "unknown upload_id; call fs_write_begin with it to resume")
+	}
+	if up.busy {
+		return nil, newOpError("write_upload", up.Path, ErrFileChanged, "a commit of this upload is in progress")
+	}
+	return up, nil
+}
+
+// expire drops uploads idle for longer than uploadTTL along with their
+// staged data
+func (u *uploadStore) expire(b Backend, now time.Time) {
+	for id, up := range u.uploads {
+		if up.busy || now.Sub(up.Touched) < uploadTTL {
+			continue
+		}
+		if err := b.Remove(up.Staging); err != nil && !errors.Is(err, os.ErrNotExist) {
+			dprintf("upload expire %s: %v", up.Staging, err)
+			continue
+		}
+		delete(u.uploads, id)
+	}
+}
+
+// sweepStaging removes staging files next to name that no open upload owns
+// and that have not grown for uploadTTL, left by clients that never
+// committed or aborted, or by an earlier server process
+func (u *uploadStore) sweepStaging(b Backend, name string, now time.Time) {
+	dir := path.Dir(name)
+	if dir == "." {
+		dir = ""
+	}
+	entries, err := b.ReadDir(dir)
+	if err != nil {
+		return
+	}
+	for _, e := range entries {
+		i := strings.LastIndex(e.Name(), ".upload-")
+		if !strings.HasPrefix(e.Name(), ".") || i < 0 || !e.Type().IsRegular() {
+			continue
+		}
+		_, id, ok := strings.Cut(e.Name()[i+len(".upload-"):], "-")
+		if !ok {
+			continue
+		}
+		if _, err := uuid.Parse(id); err != nil {
+			continue
+		}
+		if _, open := u.uploads[id]; open {
+			continue
+		}
+		fi, err := e.Info()
+		if err != nil || now.Sub(fi.ModTime()) < uploadTTL {
+			continue
+		}
+		if err := b.Remove(path.Join(dir, e.Name())); err != nil && !errors.Is(err, os.ErrNotExist) {
+			dprintf("upload sweep %s: %v", e.Name(), err)
+		}
+	}
+}
+
+// stagingName places the staging file for target name in the same directory
+// so that the final rename never crosses filesystems. The name carries a tag
+// of the session that began the upload, so only that session can recover
+// the staged data after a restart.
+func stagingName(name, sessionID, id string) string {
+	sum := sha256.Sum256([]byte(sessionID))
+	dir, base := path.Split(name)
+	return dir + "." + base + ".upload-" + hex.EncodeToString(sum[:16]) + "-" + id
+}
+
+// hashWholeFile computes the SHA256 of all of name; uploads may exceed the
+// maxHashBytes cap used elsewhere
+func hashWholeFile(b Backend, name string) (string, error) {
+	f, err := b.Open(name)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%x", h.Sum(nil)), nil
+}
+
+func formatUploadResult(r UploadResult) string {
+	return fmt.Sprintf("upload_id=%s path=%s offset=%d size=%d aborted=%v", r.UploadID, r.Path, r.Offset, r.Size, r.Aborted)
+}
+
+func uploadResultOf(up *upload) UploadResult {
+	return UploadResult{UploadID: up.ID, Path: up.Path, Offset: up.Received, Size: up.Size, MaxSize: currentConfig().MaxFileSize}
+}
+
+func handleWriteBegin(sessions map[string]*SessionState, mu *sync.RWMutex) mcp.StructuredToolHandlerFunc[WriteBeginArgs, UploadResult] {
+	return func(ctx context.Context, req mcp.CallToolRequest, args WriteBeginArgs) (UploadResult, error) {
+		state, err := getSessionState(ctx, sessions, mu)
+		if err != nil {
+			return UploadResult{}, err
+		}
+		start := time.Now()
+		dprintf("%s -> fs_write_begin path=%q size=%d upload_id=%q", sessionContext(ctx), args.Path, args.Size, args.UploadID)
+		var res UploadResult
+		name, err := state.FS.Resolve(args.Path, false)
+		if err != nil {
+			dprintf("fs_write_begin error: %v", err)
+			return res, err
+		}
+		if err := checkAccess(ctx, state, "write_begin", capWrite, args.Path, name); err != nil {
+			dprintf("fs_write_begin error: %v", err)
+			return res, err
+		}
+		if args.Size < 0 {
+			return res, &ValidationError{Field: "size", Value: args.Size, Message: "must not be negative"}
+		}
+		if args.Size > currentConfig().MaxFileSize {
+			return res, newOpError("write_begin", args.Path, ErrFileTooLarge, fmt.Sprintf("%d bytes exceeds --max-size %d", args.Size, currentConfig().MaxFileSize))
+		}
+		mode, err := parseMode(args.Mode)
+		if err != nil {
+			return res, fmt.Errorf("invalid mode: %w", err)
+		}
+		if args.Mode == "" {
+			mode = 0
+		}
+		if fi, err := state.FS.Lstat(name); err == nil {
+			if !fi.Mode().IsRegular() {
+				return res, newOpError("write_begin", args.Path, ErrPathNotRegular)
+			}
+			if !args.Overwrite {
+				return res, newOpError("write_begin", args.Path, ErrFileExists, "set overwrite to replace it")
+			}
+		}
+
+		store := state.uploads()
+		store.mu.Lock()
+		defer store.mu.Unlock()
+		now := time.Now()
+		store.expire(state.FS, now)
+		if args.UploadID != "" {
+			// Resume an upload, rebuilding it from its staging file if the
+			// server restarted in between
+			if up, ok := store.uploads[args.UploadID]; ok {
+				if up.Name != name {
+					return res, &ValidationError{Field: "upload_id", Value: args.UploadID, Message: "belongs to " + up.Path}
+				}
+				up.Touched = now
+				dprintf("<- fs_write_begin resumed offset=%d dur=%s", up.Received, time.Since(start))
+				return uploadResultOf(up), nil
+			}
+			if _, err := uuid.Parse(args.UploadID); err != nil {
+				return res, &ValidationError{Field: "upload_id", Value: args.UploadID, Message: "not an upload id"}
+			}
+			staging := stagingName(name, getSessionID(ctx), args.UploadID)
+			fi, err := state.FS.Lstat(staging)
+			if err != nil {
+				return res, newOpError("write_begin", args.Path, ErrPathNotFound, "no staged data for upload_id")
+			}
+			up := &upload{ID: args.UploadID, Path: args.Path, Name: name, Staging: staging, Size: args.Size, Mode: mode, Overwrite: args.Overwrite, Received: fi.Size(), Touched: now}
+			store.uploads[up.ID] = up
+			dprintf("<- fs_write_begin recovered offset=%d dur=%s", up.Received, time.Since(start))
+			return uploadResultOf(up), nil
+		}
+
+		if err := ensureParentDir(state.FS, name); err != nil {
+			dprintf("fs_write_begin error: %v", err)
+			return res, err
+		}
+		store.sweepStaging(state.FS, name, now)
+		id := uuid.NewString()
+		up := &upload{ID: id, Path: args.Path, Name: name, Staging: stagingName(name, getSessionID(ctx), id), Size: args.Size, Mode: mode, Overwrite: args.Overwrite, Touched: now}
+		if err := state.FS.WriteFile(up.Staging, nil, 0o600); err != nil {
+			dprintf("fs_write_begin error: %v", err)
+			return res, err
+		}
+		store.uploads[id] = up
+		dprintf("<- fs_write_begin ok upload_id=%s dur=%s", id, time.Since(start))
+		return uploadResultOf(up), nil
+	}
+}
+
+func handleWriteChunk(sessions map[string]*SessionState, mu *sync.RWMutex) mcp.StructuredToolHandlerFunc[WriteChunkArgs, UploadResult] {
+	return func(ctx context.Context, req mcp.CallToolRequest, args WriteChunkArgs) (UploadResult, error) {
+		state, err := getSessionState(ctx, sessions, mu)
+		if err != nil {
+			return UploadResult{}, err
+		}
+		start := time.Now()
+		dprintf("%s -> fs_write_chunk upload_id=%q offset=%d bytes=%d", sessionContext(ctx), args.UploadID, args.Offset, len(args.Data))
+		var res UploadResult
+		data, err := decodeContent(args.Encoding, args.Data)
+		if err != nil {
+			return res, err
+		}
+		if args.SHA256 != "" && !strings.EqualFold(args.SHA256, sha256sum(data)) {
+			return res, &ValidationError{Field: "sha256", Value: args.SHA256, Message: "does not match the chunk data"}
+		}
+		store := state.uploads()
+		store.mu.Lock()
+		defer store.mu.Unlock()
+		up, err := store.get(args.UploadID)
+		if err != nil {
+			return res, err
+		}
+		if args.Offset != up.Received {
+			return uploadResultOf(up), newOpError("write_chunk", up.Path, ErrFileChanged, fmt.Sprintf("expected offset %d", up.Received))
+		}
+		total := up.Received + int64(len(data))
+		if total > currentConfig().MaxFileSize || (up.Size > 0 && total > up.Size) {
+			return uploadResultOf(up), newOpError("write_chunk", up.Path, ErrFileTooLarge, fmt.Sprintf("upload would reach %d bytes", total))
+		}
+		if err := state.FS.AppendFile(up.Staging, data, 0o600); err != nil {
+			dprintf("fs_write_chunk error: %v", err)
+			return res, err
+		}
+		up.Received = total
+		up.Touched = time.Now()
+		dprintf("<- fs_write_chunk ok offset=%d dur=%s", up.Received, time.Since(start))
+		return uploadResultOf(up), nil
+	}
+}
+
+func handleWriteCommit(sessions map[string]*SessionState, mu *sync.RWMutex) mcp.StructuredToolHandlerFunc[WriteCommitArgs, WriteResult] {
+	return func(ctx context.Context, req mcp.CallToolRequest, args WriteCommitArgs) (WriteResult, error) {
+		state, err := getSessionState(ctx, sessions, mu)
+		if err != nil {
+			return WriteResult{}, err
+		}
+		start := time.Now()
+		dprintf("%s -> fs_write_commit upload_id=%q path=%q", sessionContext(ctx), args.UploadID, args.Path)
+		var res WriteResult
+		store := state.uploads()
+		up, err := store.claim(ctx, state, args)
+		if err != nil {
+			return res, err
+		}
+		committed := false
+		defer func() {
+			store.mu.Lock()
+			if committed {
+				delete(store.uploads, up.ID)
+			} else {
+				up.busy = false
+				up.Touched = time.Now()
+			}
+			store.mu.Unlock()
+		}()
+
+		// Hashing and waiting for the file lock happen outside store.mu so
+		// the session's other uploads carry on meanwhile
+		sha, err := hashWholeFile(state.FS, up.Staging)
+		if err != nil {
+			return res, err
+		}
+		if !strings.EqualFold(sha, args.SHA256) {
+			return res, &ValidationError{Field: "sha256", Value: args.SHA256, Message: "does not match the uploaded data " + sha}
+		}
+
+		release, err := state.FS.Lock(up.Name, lockExclusive, currentConfig().lockTimeout())
+		if err != nil {
+			return res, err
+		}
+		defer release()
+		if err := checkLease(ctx, state, "write_commit", up.Path, up.Name); err != nil {
+			return res, err
+		}
+		defer snapshotHistory(ctx)()
+		defer digestAudit(ctx)()
+		mode := up.Mode
+		preFi, preErr := state.FS.Lstat(up.Name)
+		switch {
+		case preErr == nil && !up.Overwrite:
+			return res, newOpError("write_commit", up.Path, ErrFileExists, "created while uploading; begin with overwrite to replace it")
+		case preErr == nil && !preFi.Mode().IsRegular():
+			return res, newOpError("write_commit", up.Path, ErrPathNotRegular)
+		case preErr != nil && !errors.Is(preErr, os.ErrNotExist):
+			return res, preErr
+		}
+		if mode == 0 {
+			mode = 0o644
+			if preErr == nil {
+				if pm := preFi.Mode() & os.ModePerm; pm != 0 {
+					mode = pm
+				}
+			}
+		}
+		if err := state.FS.Chmod(up.Staging, mode); err != nil {
+			return res, err
+		}
+		if err := state.FS.Rename(up.Staging, up.Name); err != nil {
+			dprintf("fs_write_commit rename error: %v", err)
+			return res, err
+		}
+		committed = true
+		if up.Received <= maxHashBytes {
+			rememberHash(state.FS, up.Name, sha)
+		}
+
+		var sample []byte
+		if f, err := state.FS.Open(up.Name); err == nil {
+			sample = make([]byte, 512)
+			n, _ := f.Read(sample)
+			sample = sample[:n]
+			f.Close()
+		}
+		res = WriteResult{
+			Path:     up.Path,
+			Action:   "upload",
+			Bytes:    int(up.Received),
+			Created:  preErr != nil,
+			MIMEType: detectMIME(up.Name, sample),
+			SHA256:   sha,
+			MetaFields: MetaFields{
+				Mode:       fmt.Sprintf("%#o", mode),
+				ModifiedAt: time.Now().UTC().Format(time.RFC3339),
+			},
+		}
+		dprintf("<- fs_write_commit ok bytes=%d dur=%s", up.Received, time.Since(start))
+		return res, nil
+	}
+}
+
+// claim checks that the upload named by a commit is complete and marks it
+// busy, so chunks and aborts are refused until the commit finishes
+func (u *uploadStore) claim(ctx context.Context, state *SessionState, args WriteCommitArgs) (*upload, error) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	up, err := u.get(args.UploadID)
+	if err != nil {
+		return nil, err
+	}
+	if name, err := state.FS.Resolve(args.Path, false); err != nil || name != up.Name {
+		return nil, &ValidationError{Field: "path", Value: args.Path, Message: "upload was started for " + up.Path}
+	}
+	if err := checkAccess(ctx, state, "write_commit", capWrite, up.Path, up.Name); err != nil {
+		return nil, err
+	}
+	if up.Size > 0 && up.Received != up.Size {
+		return nil, newOpError("write_commit", up.Path, ErrFileChanged, fmt.Sprintf("received %d of %d bytes", up.Received, up.Size))
+	}
+	up.busy = true
+	return up, nil
+}
+
+func handleWriteAbort(sessions map[string]*SessionState, mu *sync.RWMutex) mcp.StructuredToolHandlerFunc[WriteAbortArgs, UploadResult] {
+	return func(ctx context.Context, req mcp.CallToolRequest, args WriteAbortArgs) (UploadResult, error) {
+		state, err := getSessionState(ctx, sessions, mu)
+		if err != nil {
+			return UploadResult{}, err
+		}
+		dprintf("%s -> fs_write_abort upload_id=%q", sessionContext(ctx), args.UploadID)
+		store := state.uploads()
+		store.mu.Lock()
+		defer store.mu.Unlock()
+		up, err := store.get(args.UploadID)
+		if err != nil {
+			return UploadResult{}, err
+		}
+		if err := state.FS.Remove(up.Staging); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return UploadResult{}, err
+		}
+		delete(store.uploads, up.ID)
+		res := uploadResultOf(up)
+		res.Aborted = true
+		dprintf("<- fs_write_abort ok")
+		return res, nil
+	}
+}
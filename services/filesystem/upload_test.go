@@ -0,0 +1,240 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/mark3labs/mcp-go/mcp"
+)
+
+func TestChunkedUpload(t *testing.T) {
+	ctx, sessions, mu, mem := memSession()
+	req := mcp.CallToolRequest{}
+	blob := binaryBlob()
+
+	up, err := handleWriteBegin(sessions, mu)(ctx, req, WriteBeginArgs{Path: "dir/blob.bin", Size: int64(len(blob)), Mode: "0600"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	chunk := func(off int, b []byte) (UploadResult, error) {
+		return handleWriteChunk(sessions, mu)(ctx, req, WriteChunkArgs{
+			UploadID: up.UploadID, Offset: int64(off), Encoding: encodingBase64,
+			Data: base64.StdEncoding.EncodeToString(b), SHA256: sha256sum(b),
+		})
+	}
+	if res, err := chunk(0, blob[:100]); err != nil || res.Offset != 100 {
+		t.Fatalf("chunk 1 = %+v %v", res, err)
+	}
+	if _, err := chunk(0, blob[:100]); !errors.Is(err, ErrFileChanged) {
+		t.Fatalf("replayed chunk err = %v", err)
+	}
+	bad := WriteChunkArgs{UploadID: up.UploadID, Offset: 100, Data: "x", SHA256: sha256sum([]byte("y"))}
+	if _, err := handleWriteChunk(sessions, mu)(ctx, req, bad); err == nil {
+		t.Fatal("chunk with wrong checksum accepted")
+	}
+	if _, err := mem.Stat("dir/blob.bin"); !os.IsNotExist(err) {
+		t.Fatal("target visible before commit")
+	}
+
+	// A dropped connection resumes from the server's offset
+	res, err := handleWriteBegin(sessions, mu)(ctx, req, WriteBeginArgs{Path: "dir/blob.bin", UploadID: up.UploadID})
+	if err != nil || res.Offset != 100 {
+		t.Fatalf("resume = %+v %v", res, err)
+	}
+	if _, err := chunk(100, blob[100:]); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := handleWriteCommit(sessions, mu)(ctx, req, WriteCommitArgs{UploadID: up.UploadID, Path: "dir/blob.bin", SHA256: sha256sum(blob[1:])}); err == nil {
+		t.Fatal("commit with wrong checksum accepted")
+	}
+	wr, err := handleWriteCommit(sessions, mu)(ctx, req, WriteCommitArgs{UploadID: up.UploadID, Path: "dir/blob.bin", SHA256: sha256sum(blob)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !wr.Created || wr.Bytes != len(blob) || wr.SHA256 != sha256sum(blob) || wr.Mode != "0600" {
+		t.Fatalf("commit = %+v", wr)
+	}
+	if memRead(t, mem, "dir/blob.bin") != string(blob) {
+		t.Fatal("committed content differs")
+	}
+	entries, _ := mem.ReadDir("dir")
+	if len(entries) != 1 {
+		t.Fatalf("staging file left behind: %v", entries)
+	}
+	if _, err := chunk(len(blob), []byte("more")); err == nil {
+		t.Fatal("chunk accepted after commit")
+	}
+}
+
+func TestUploadRecoversStagedData(t *testing.T) {
+	root := t.TempDir()
+	ctx, sessions, mu := testSession(root)
+	req := mcp.CallToolRequest{}
+	up, err := handleWriteBegin(sessions, mu)(ctx, req, WriteBeginArgs{Path: "big.txt"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := handleWriteChunk(sessions, mu)(ctx, req, WriteChunkArgs{UploadID: up.UploadID, Data: "hello "}); err != nil {
+		t.Fatal(err)
+	}
+
+	// A restarted server only has the staging file to go on
+	ctx, sessions, mu = testSession(root)
+	if _, err := handleWriteChunk(sessions, mu)(ctx, req, WriteChunkArgs{UploadID: up.UploadID, Offset: 6, Data: "world"}); err == nil {
+		t.Fatal("chunk accepted for unknown upload")
+	}
+	res, err := handleWriteBegin(sessions, mu)(ctx, req, WriteBeginArgs{Path: "big.txt", UploadID: up.UploadID})
+	if err != nil || res.Offset != 6 {
+		t.Fatalf("recover = %+v %v", res, err)
+	}
+	if _, err := handleWriteChunk(sessions, mu)(ctx, req, WriteChunkArgs{UploadID: up.UploadID, Offset: 6, Data: "world"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := handleWriteCommit(sessions, mu)(ctx, req, WriteCommitArgs{UploadID: up.UploadID, Path: "big.txt", SHA256: sha256sum([]byte("hello world"))}); err != nil {
+		t.Fatal(err)
+	}
+	if mustRead(t, filepath.Join(root, "big.txt")) != "hello world" {
+		t.Fatal("content mismatch")
+	}
+}
+
+func TestWriteMaxSizeCountsReplacedSpan(t *testing.T) {
+	withConfig(t, func(c *ServerConfig) { c.MaxFileSize = 10 })
+	ctx, sessions, mu, mem := memSession()
+	memWrite(t, mem, "full.txt", []byte("1234\n6789\n"), 0o644)
+	write := func(args WriteArgs) error {
+		args.Path = "full.txt"
+		_, err := handleWrite(sessions, mu)(ctx, mcp.CallToolRequest{}, args)
+		return err
+	}
+	start, end, line := 0, 4, 1
+	// Edits that shrink a file at the limit are allowed
+	if err := write(WriteArgs{Content: "ab", Strategy: strategyReplaceRange, Start: &start, End: &end}); err != nil {
+		t.Fatalf("shrinking replace_range: %v", err)
+	}
+	if err := write(WriteArgs{Content: "x", Strategy: strategyReplaceLines, Line: &line}); err != nil {
+		t.Fatalf("shrinking replace_lines: %v", err)
+	}
+	if got := memRead(t, mem, "full.txt"); got != "x\n6789\n" {
+		t.Fatalf("content = %q", got)
+	}
+	// Growing past it is not
+	end = 1
+	if err := write(WriteArgs{Content: "abcdef", Strategy: strategyReplaceRange, Start: &start, End: &end}); !errors.Is(err, ErrFileTooLarge) {
+		t.Fatalf("growing replace_range: %v", err)
+	}
+	if err := write(WriteArgs{Content: "abcdef", Strategy: strategyInsertAtLine, Line: &line}); !errors.Is(err, ErrFileTooLarge) {
+		t.Fatalf("growing insert_at_line: %v", err)
+	}
+}
+
+func TestUploadLimitsAndAbort(t *testing.T) {
+	withConfig(t, func(c *ServerConfig) { c.MaxFileSize = 10 })
+
+	ctx, sessions, mu, mem := memSession()
+	memWrite(t, mem, "keep.txt", []byte("keep"), 0o644)
+	req := mcp.CallToolRequest{}
+
+	if _, err := handleWriteBegin(sessions, mu)(ctx, req, WriteBeginArgs{Path: "a.bin", Size: 11}); !errors.Is(err, ErrFileTooLarge) {
+		t.Fatalf("declared size err = %v", err)
+	}
+	if _, err := handleWriteBegin(sessions, mu)(ctx, req, WriteBeginArgs{Path: "keep.txt"}); !errors.Is(err, ErrFileExists) {
+		t.Fatalf("existing target err = %v", err)
+	}
+	up, err := handleWriteBegin(sessions, mu)(ctx, req, WriteBeginArgs{Path: "a.bin"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := handleWriteChunk(sessions, mu)(ctx, req, WriteChunkArgs{UploadID: up.UploadID, Data: strings.Repeat("x", 11)}); !errors.Is(err, ErrFileTooLarge) {
+		t.Fatalf("oversized chunk err = %v", err)
+	}
+	if _, err := handleWrite(sessions, mu)(ctx, req, WriteArgs{Path: "keep.txt", Content: strings.Repeat("y", 7), Strategy: strategyAppend}); !errors.Is(err, ErrFileTooLarge) {
+		t.Fatalf("fs_write over --max-size err = %v", err)
+	}
+
+	res, err := handleWriteAbort(sessions, mu)(ctx, req, WriteAbortArgs{UploadID: up.UploadID})
+	if err != nil || !res.Aborted {
+		t.Fatalf("abort = %+v %v", res, err)
+	}
+	entries, _ := mem.ReadDir("")
+	if len(entries) != 1 {
+		t.Fatalf("staging file left after abort: %v", entries)
+	}
+}
+
+func TestUploadExpiresIdleStaging(t *testing.T) {
+	ctx, sessions, mu, mem := memSession()
+	req := mcp.CallToolRequest{}
+	up, err := handleWriteBegin(sessions, mu)(ctx, req, WriteBeginArgs{Path: "dir/a.bin"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := handleWriteChunk(sessions, mu)(ctx, req, WriteChunkArgs{UploadID: up.UploadID, Data: "x"}); err != nil {
+		t.Fatal(err)
+	}
+	// Left by a server that has since exited
+	orphan := stagingName("dir/old.bin", "s1", uuid.NewString())
+	memWrite(t, mem, orphan, []byte("stale"), 0o600)
+
+	orig := uploadTTL
+	uploadTTL = time.Millisecond
+	t.Cleanup(func() { uploadTTL = orig })
+	time.Sleep(5 * time.Millisecond)
+
+	next, err := handleWriteBegin(sessions, mu)(ctx, req, WriteBeginArgs{Path: "dir/b.bin"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := handleWriteChunk(sessions, mu)(ctx, req, WriteChunkArgs{UploadID: up.UploadID, Offset: 1, Data: "y"}); !errors.Is(err, ErrPathNotFound) {
+		t.Fatalf("chunk for expired upload err = %v", err)
+	}
+	entries, _ := mem.ReadDir("dir")
+	if len(entries) != 1 || entries[0].Name() != path.Base(stagingName("dir/b.bin", "s1", next.UploadID)) {
+		t.Fatalf("staging files after expiry = %v", entries)
+	}
+}
+
+func TestUploadStagingHiddenAndOwned(t *testing.T) {
+	ctx, sessions, mu, _ := memSession()
+	req := mcp.CallToolRequest{}
+	up, err := handleWriteBegin(sessions, mu)(ctx, req, WriteBeginArgs{Path: "dir/a.txt"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := handleWriteChunk(sessions, mu)(ctx, req, WriteChunkArgs{UploadID: up.UploadID, Data: "needle"}); err != nil {
+		t.Fatal(err)
+	}
+
+	list, err := handleList(sessions, mu)(ctx, req, ListArgs{Path: "dir"})
+	if err != nil || len(list.Entries) != 0 {
+		t.Fatalf("list shows staging files: %+v %v", list.Entries, err)
+	}
+	glob, err := handleGlob(sessions, mu)(ctx, req, GlobArgs{Pattern: "**"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, m := range glob.Matches {
+		if strings.Contains(m, up.UploadID) {
+			t.Fatalf("glob shows staging file %s", m)
+		}
+	}
+	search, err := handleSearch(sessions, mu)(ctx, req, SearchArgs{Pattern: "needle", Path: "dir"})
+	if err != nil || len(search.Matches) != 0 {
+		t.Fatalf("search looks into staging files: %+v %v", search.Matches, err)
+	}
+
+	// Another session cannot take the upload over from its staged data
+	sessions["s2"] = &SessionState{FS: sessions["s1"].FS}
+	other := withSessionManager(context.Background(), &sessionManager{id: "s2"})
+	if _, err := handleWriteBegin(sessions, mu)(other, req, WriteBeginArgs{Path: "dir/a.txt", UploadID: up.UploadID}); !errors.Is(err, ErrPathNotFound) {
+		t.Fatalf("foreign session recovered the upload: %v", err)
+	}
+}
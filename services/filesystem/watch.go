@@ -0,0 +1,518 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"path"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bmatcuk/doublestar/v4"
+	"github.com/google/uuid"
+	"github.com/mark3labs/mcp-go/mcp"
+	"github.com/mark3labs/mcp-go/server"
+)
+
+const (
+	changeCreate = "create"
+	changeWrite  = "write"
+	changeRemove = "remove"
+	changeRename = "rename"
+	changeChmod  = "chmod"
+
+	watchModeNotify = "notify"
+	watchModePoll   = "poll"
+
+	// fsChangedMethod is the notification sent to clients of watches with notify set
+	fsChangedMethod = "notifications/fs/changed"
+)
+
+// watch is one fs_watch registration
+type watch struct {
+	id     string
+	paths  []string // backend names; a directory covers everything below it
+	globs  []string
+	ignore []string
+	notify func(params map[string]any) // nil when the client is not notified
+	client string                      // MCP client session that made it; ending it removes the watch
+	ended  func()                      // called when the watch goes because its client ended
+}
+
+func (w *watch) matches(name string) bool {
+	for _, g := range w.ignore {
+		if ok, _ := doublestar.Match(g, name); ok {
+			return false
+		}
+	}
+	for _, p := range w.paths {
+		if p == "" || name == p || strings.HasPrefix(name, p+"/") {
+			return true
+		}
+	}
+	for _, g := range w.globs {
+		if ok, _ := doublestar.Match(g, name); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// internalArtifact reports names the server itself creates in passing:
+// atomic write temporaries and upload staging files
+func internalArtifact(name string) bool {
+	base := path.Base(name)
+	return strings.HasPrefix(base, ".mcpfs-") ||
+		(strings.HasPrefix(base, ".") && strings.Contains(base, ".upload-"))
+}
+
+// watchStore holds a session's watches and the log of changes they matched.
+// Events for a path arriving within watchCoalesceMs are merged into one.
+type watchStore struct {
+	state *SessionState
+
+	mu      sync.Mutex
+	watches map[string]*watch
+	roots   map[string]bool // directories handed to the source, and whether recursively
+	src     watchSource
+	mode    string
+	seq     int64
+	log     []ChangeEvent // oldest first, at most watchLogEntries
+	pending map[string]*ChangeEvent
+	flushAt *time.Timer
+	wake    chan struct{} // closed whenever the log grows
+}
+
+var watchInitMu sync.Mutex
+
+// watches returns the session's watch store, creating it on first use
+func (s *SessionState) watches() *watchStore {
+	watchInitMu.Lock()
+	defer watchInitMu.Unlock()
+	if s.Watches == nil {
+		s.Watches = &watchStore{state: s, watches: map[string]*watch{}, roots: map[string]bool{}, pending: map[string]*ChangeEvent{}, wake: make(chan struct{})}
+	}
+	return s.Watches
+}
+
+// startLocked picks a source for the session's backend: OS notifications
+// for local folders unless --watch-poll is set, scanning otherwise
+func (ws *watchStore) startLocked() {
+	if ws.src != nil {
+		return
+	}
+	if lb, ok := ws.state.FS.(*localBackend); ok && !currentConfig().WatchPoll {
+		src, err := newNotifySource(lb.root, ws)
+		if err == nil {
+			ws.src, ws.mode = src, watchModeNotify
+			return
+		}
+		dprintf("fs_watch: notifications unavailable, polling: %v", err)
+	}
+	ws.src, ws.mode = newPollSource(ws.state.FS, ws), watchModePoll
+}
+
+// addRootLocked starts watching dir. When the OS refuses more
+// notifications, e.g. because the inotify watch limit is reached, every
+// root moves to a polling source.
+func (ws *watchStore) addRootLocked(dir string, recursive bool) error {
+	ws.startLocked()
+	if rec, ok := ws.roots[dir]; ok && (rec || !recursive) {
+		return nil
+	}
+	err := ws.src.watchDir(dir, recursive)
+	if err != nil && ws.mode == watchModeNotify {
+		dprintf("fs_watch: notify %q failed, polling: %v", dir, err)
+		ws.src.close()
+		ws.src, ws.mode = newPollSource(ws.state.FS, ws), watchModePoll
+		for d, r := range ws.roots {
+			ws.src.watchDir(d, r)
+		}
+		err = ws.src.watchDir(dir, recursive)
+	}
+	if err != nil {
+		return err
+	}
+	ws.roots[dir] = ws.roots[dir] || recursive
+	return nil
+}
+
+// record notes a change reported by the source
+func (ws *watchStore) record(name, op string) {
+	if internalArtifact(name) || !ws.state.readable(name) {
+		return
+	}
+	ws.mu.Lock()
+	defer ws.mu.Unlock()
+	var ids []string
+	for id, w := range ws.watches {
+		if w.matches(name) {
+			ids = append(ids, id)
+		}
+	}
+	if len(ids) == 0 {
+		return
+	}
+	ev, ok := ws.pending[name]
+	if !ok {
+		ev = &ChangeEvent{Path: name}
+		ws.pending[name] = ev
+	}
+	if !slices.Contains(ev.Ops, op) {
+		ev.Ops = append(ev.Ops, op)
+	}
+	for _, id := range ids {
+		if !slices.Contains(ev.WatchIDs, id) {
+			ev.WatchIDs = append(ev.WatchIDs, id)
+		}
+	}
+	if ws.flushAt == nil {
+		ws.flushAt = time.AfterFunc(watchCoalesceMs*time.Millisecond, ws.flush)
+	}
+}
+
+// flush moves coalesced events into the log and notifies clients
+func (ws *watchStore) flush() {
+	ws.mu.Lock()
+	names := make([]string, 0, len(ws.pending))
+	for name := range ws.pending {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	now := time.Now().UTC().Format(time.RFC3339Nano)
+	perWatch := map[string][]ChangeEvent{}
+	for _, name := range names {
+		ev := ws.pending[name]
+		ws.seq++
+		ev.Seq, ev.Time = ws.seq, now
+		sort.Strings(ev.WatchIDs)
+		ws.log = append(ws.log, *ev)
+		for _, id := range ev.WatchIDs {
+			if w, ok := ws.watches[id]; ok && w.notify != nil {
+				perWatch[id] = append(perWatch[id], *ev)
+			}
+		}
+	}
+	if over := len(ws.log) - watchLogEntries; over > 0 {
+		ws.log = append(ws.log[:0:0], ws.log[over:]...)
+	}
+	ws.pending = map[string]*ChangeEvent{}
+	ws.flushAt = nil
+	close(ws.wake)
+	ws.wake = make(chan struct{})
+	cursor := ws.seq
+	notify := map[string]func(map[string]any){}
+	for id := range perWatch {
+		notify[id] = ws.watches[id].notify
+	}
+	ws.mu.Unlock()
+
+	for id, events := range perWatch {
+		notify[id](map[string]any{"watch_id": id, "cursor": cursor, "changes": events})
+	}
+}
+
+// since returns logged changes after cursor, optionally only those matched
+// by one watch. overflow reports that changes after cursor were dropped.
+func (ws *watchStore) since(cursor int64, watchID string, max int) ([]ChangeEvent, int64, bool, <-chan struct{}) {
+	ws.mu.Lock()
+	defer ws.mu.Unlock()
+	overflow := cursor > ws.seq || (len(ws.log) > 0 && ws.log[0].Seq > cursor+1)
+	if cursor > ws.seq {
+		cursor = 0
+	}
+	i := sort.Search(len(ws.log), func(i int) bool { return ws.log[i].Seq > cursor })
+	out := []ChangeEvent{}
+	next := cursor
+	for ; i < len(ws.log) && len(out) < max; i++ {
+		ev := ws.log[i]
+		next = ev.Seq
+		if watchID == "" || slices.Contains(ev.WatchIDs, watchID) {
+			out = append(out, ev)
+		}
+	}
+	if i == len(ws.log) {
+		next = ws.seq
+	}
+	return out, next, overflow, ws.wake
+}
+
+// clientNotifier returns a function sending change notifications to the
+// client that made the request, or nil outside an MCP session
+func clientNotifier(ctx context.Context) func(map[string]any) {
+	return sessionNotifier(server.ServerFromContext(ctx), server.ClientSessionFromContext(ctx), fsChangedMethod)
+}
+
+// sessionNotifier returns a function sending method notifications to one
+// client session, or nil when there is no session to notify
+func sessionNotifier(srv *server.MCPServer, cs server.ClientSession, method string) func(map[string]any) {
+	if srv == nil || cs == nil {
+		return nil
+	}
+	id := cs.SessionID()
+	return func(params map[string]any) {
+		if err := srv.SendNotificationToSpecificClient(id, method, params); err != nil {
+			dprintf("notify %s session=%s: %v", method, id, err)
+		}
+	}
+}
+
+func formatWatchResult(r WatchResult) string {
+	return fmt.Sprintf("watch_id=%s cursor=%d mode=%s", r.WatchID, r.Cursor, r.Mode)
+}
+
+func formatUnwatchResult(r UnwatchResult) string {
+	return fmt.Sprintf("watch_id=%s removed=%v", r.WatchID, r.Removed)
+}
+
+func formatChangesSinceResult(r ChangesSinceResult) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "cursor=%d overflow=%v", r.Cursor, r.Overflow)
+	for _, c := range r.Changes {
+		fmt.Fprintf(&b, "\n%d %s %s", c.Seq, strings.Join(c.Ops, ","), c.Path)
+	}
+	return b.String()
+}
+
+func handleWatch(sessions map[string]*SessionState, mu *sync.RWMutex) mcp.StructuredToolHandlerFunc[WatchArgs, WatchResult] {
+	return func(ctx context.Context, req mcp.CallToolRequest, args WatchArgs) (WatchResult, error) {
+		state, err := getSessionState(ctx, sessions, mu)
+		if err != nil {
+			return WatchResult{}, err
+		}
+		start := time.Now()
+		dprintf("%s -> fs_watch paths=%v globs=%v ignore=%v", sessionContext(ctx), args.Paths, args.Globs, args.Ignore)
+		var res WatchResult
+		if len(args.Paths) == 0 && len(args.Globs) == 0 {
+			return res, &ValidationError{Field: "paths", Message: "paths or globs required"}
+		}
+		w := &watch{id: uuid.NewString()}
+		if cs := server.ClientSessionFromContext(ctx); cs != nil {
+			w.client = cs.SessionID()
+		}
+		if args.Notify == nil || *args.Notify {
+			w.notify = clientNotifier(ctx)
+		}
+		var roots []watchRoot
+		for _, p := range args.Paths {
+			name, err := state.FS.Resolve(p, false)
+			if err != nil {
+				dprintf("fs_watch error: %v", err)
+				return res, err
+			}
+			if err := checkAccess(ctx, state, "watch", capRead, p, name); err != nil {
+				dprintf("fs_watch error: %v", err)
+				return res, err
+			}
+			w.paths = append(w.paths, name)
+			roots = append(roots, watchRootOf(state.FS, name))
+		}
+		for _, g := range append(args.Globs, args.Ignore...) {
+			if strings.Contains(g, "../") || strings.HasPrefix(g, "/") {
+				return res, fmt.Errorf("pattern cannot escape base folder: %s", g)
+			}
+			if !doublestar.ValidatePattern(g) {
+				return res, &ValidationError{Field: "globs", Value: g, Message: "invalid glob pattern"}
+			}
+		}
+		for _, g := range args.Globs {
+			if err := checkAccess(ctx, state, "watch", capRead, g, ""); err != nil {
+				dprintf("fs_watch error: %v", err)
+				return res, err
+			}
+			pat := path.Clean(g)
+			base, rest := doublestar.SplitPattern(pat)
+			if base == "." {
+				base = ""
+			}
+			w.globs = append(w.globs, pat)
+			roots = append(roots, watchRoot{base, strings.Contains(rest, "/") || strings.Contains(rest, "**")})
+		}
+		w.ignore = args.Ignore
+
+		ws := state.watches()
+		cursor, mode, err := ws.add(w, roots)
+		if err != nil {
+			dprintf("fs_watch error: %v", err)
+			return res, err
+		}
+		res = WatchResult{WatchID: w.id, Cursor: cursor, Mode: mode}
+		dprintf("<- fs_watch ok id=%s mode=%s roots=%d dur=%s", w.id, mode, len(roots), time.Since(start))
+		return res, nil
+	}
+}
+
+// watchRoot is a directory a watch needs its source to cover
+type watchRoot struct {
+	dir       string
+	recursive bool
+}
+
+// watchRootOf returns the root covering name: the whole tree below a
+// directory, or the parent of anything else so creation is seen too
+func watchRootOf(b Backend, name string) watchRoot {
+	if fi, err := b.Stat(name); err == nil && fi.IsDir() {
+		return watchRoot{name, true}
+	}
+	return watchRoot{parentName(name), false}
+}
+
+// add registers w once its roots are being watched, returning the current
+// cursor and the detection mode
+func (ws *watchStore) add(w *watch, roots []watchRoot) (int64, string, error) {
+	ws.mu.Lock()
+	defer ws.mu.Unlock()
+	for _, r := range roots {
+		if err := ws.addRootLocked(r.dir, r.recursive); err != nil {
+			if len(ws.watches) == 0 {
+				ws.stopLocked()
+			}
+			return 0, "", newOpError("watch", r.dir, err)
+		}
+	}
+	ws.watches[w.id] = w
+	clientWatches.add(w.client, w.id, ws)
+	return ws.seq, ws.mode, nil
+}
+
+// remove drops a watch, stopping the source after the last one
+func (ws *watchStore) remove(id string) bool {
+	return ws.take(id) != nil
+}
+
+// take removes a watch and returns it, or nil if there was none
+func (ws *watchStore) take(id string) *watch {
+	ws.mu.Lock()
+	defer ws.mu.Unlock()
+	w, ok := ws.watches[id]
+	if !ok {
+		return nil
+	}
+	delete(ws.watches, id)
+	clientWatches.forget(w.client, id)
+	if len(ws.watches) == 0 {
+		ws.stopLocked()
+	}
+	return w
+}
+
+// watchIndex finds the watches made through each MCP client session, so
+// they can be removed when it ends whichever session's store holds them
+type watchIndex struct {
+	mu       sync.Mutex
+	byClient map[string]map[string]*watchStore // client -> watch id -> store
+}
+
+var clientWatches = &watchIndex{byClient: map[string]map[string]*watchStore{}}
+
+func (x *watchIndex) add(client, id string, ws *watchStore) {
+	if client == "" {
+		return
+	}
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	if x.byClient[client] == nil {
+		x.byClient[client] = map[string]*watchStore{}
+	}
+	x.byClient[client][id] = ws
+}
+
+func (x *watchIndex) forget(client, id string) {
+	if client == "" {
+		return
+	}
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	delete(x.byClient[client], id)
+	if len(x.byClient[client]) == 0 {
+		delete(x.byClient, client)
+	}
+}
+
+// releaseClient removes the watches made through an MCP client session
+// that has ended
+func (x *watchIndex) releaseClient(client string) {
+	if client == "" {
+		return
+	}
+	x.mu.Lock()
+	owned := x.byClient[client]
+	delete(x.byClient, client)
+	x.mu.Unlock()
+	for id, ws := range owned {
+		if w := ws.take(id); w != nil && w.ended != nil {
+			w.ended()
+		}
+	}
+}
+
+// stopLocked releases the source once no watch needs it; the change log
+// stays so cursors remain valid
+func (ws *watchStore) stopLocked() {
+	if ws.src != nil {
+		ws.src.close()
+	}
+	ws.src, ws.mode = nil, ""
+	ws.roots = map[string]bool{}
+}
+
+func handleUnwatch(sessions map[string]*SessionState, mu *sync.RWMutex) mcp.StructuredToolHandlerFunc[UnwatchArgs, UnwatchResult] {
+	return func(ctx context.Context, req mcp.CallToolRequest, args UnwatchArgs) (UnwatchResult, error) {
+		state, err := getSessionState(ctx, sessions, mu)
+		if err != nil {
+			return UnwatchResult{}, err
+		}
+		dprintf("%s -> fs_unwatch watch_id=%s", sessionContext(ctx), args.WatchID)
+		if args.WatchID == "" {
+			return UnwatchResult{}, &ValidationError{Field: "watch_id", Message: "watch_id required"}
+		}
+		ok := state.watches().remove(args.WatchID)
+		dprintf("<- fs_unwatch ok removed=%v", ok)
+		return UnwatchResult{WatchID: args.WatchID, Removed: ok}, nil
+	}
+}
+
+func handleChangesSince(sessions map[string]*SessionState, mu *sync.RWMutex) mcp.StructuredToolHandlerFunc[ChangesSinceArgs, ChangesSinceResult] {
+	return func(ctx context.Context, req mcp.CallToolRequest, args ChangesSinceArgs) (ChangesSinceResult, error) {
+		state, err := getSessionState(ctx, sessions, mu)
+		if err != nil {
+			return ChangesSinceResult{}, err
+		}
+		start := time.Now()
+		dprintf("%s -> fs_changes_since cursor=%d watch_id=%q timeout_ms=%d", sessionContext(ctx), args.Cursor, args.WatchID, args.TimeoutMs)
+		limit := currentConfig().toolLimit("fs_changes_since", args.MaxResults)
+		ws := state.watches()
+		if args.WatchID != "" {
+			ws.mu.Lock()
+			_, ok := ws.watches[args.WatchID]
+			ws.mu.Unlock()
+			if !ok {
+				return ChangesSinceResult{}, newOpError("changes_since", args.WatchID, ErrPathNotFound, "unknown watch_id")
+			}
+		}
+		deadline := time.NewTimer(time.Duration(min(max(args.TimeoutMs, 0), maxChangesWaitMs)) * time.Millisecond)
+		defer deadline.Stop()
+		cursor := args.Cursor
+		overflowed := false
+		for {
+			changes, next, overflow, wake := ws.since(cursor, args.WatchID, limit)
+			overflowed = overflowed || overflow
+			if len(changes) > 0 || args.TimeoutMs <= 0 {
+				dprintf("<- fs_changes_since ok changes=%d cursor=%d dur=%s", len(changes), next, time.Since(start))
+				return ChangesSinceResult{Changes: changes, Cursor: next, Overflow: overflowed}, nil
+			}
+			// Changes for other watches still move the cursor along
+			cursor = next
+			select {
+			case <-wake:
+			case <-deadline.C:
+				dprintf("<- fs_changes_since ok changes=0 dur=%s", time.Since(start))
+				return ChangesSinceResult{Changes: changes, Cursor: cursor, Overflow: overflowed}, nil
+			case <-ctx.Done():
+				return ChangesSinceResult{}, ctx.Err()
+			}
+		}
+	}
+}
@@ -0,0 +1,253 @@
+package main
+
+import (
+	"io/fs"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// watchSource reports changes below the directories it is asked to watch
+// to a watchStore. Sources never filter; the store decides what matters.
+type watchSource interface {
+	watchDir(dir string, recursive bool) error
+	close()
+}
+
+// notifySource uses OS notifications (inotify on Linux) for local backends.
+// Notifications are per directory, so recursive roots add every directory
+// below them, including ones created later.
+type notifySource struct {
+	w     *fsnotify.Watcher
+	root  string
+	store *watchStore
+
+	mu        sync.Mutex
+	recursive map[string]bool // watched host directories that cover their subdirectories
+}
+
+func newNotifySource(root string, store *watchStore) (*notifySource, error) {
+	w, err := fsnotify.NewWatcher()
+	if err != nil {
+		return nil, err
+	}
+	s := &notifySource{w: w, root: root, store: store, recursive: map[string]bool{}}
+	go s.loop()
+	return s, nil
+}
+
+func (s *notifySource) watchDir(dir string, recursive bool) error {
+	host := filepath.Join(s.root, filepath.FromSlash(dir))
+	if !recursive {
+		return s.add(host, false)
+	}
+	return filepath.WalkDir(host, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if p == host {
+				return err
+			}
+			return nil
+		}
+		if !d.IsDir() {
+			return nil
+		}
+		if name := s.name(p); name != "" && !s.store.state.readable(name) {
+			return fs.SkipDir
+		}
+		return s.add(p, true)
+	})
+}
+
+func (s *notifySource) add(host string, recursive bool) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if rec, ok := s.recursive[host]; ok && (rec || !recursive) {
+		return nil
+	}
+	if err := s.w.Add(host); err != nil {
+		return err
+	}
+	s.recursive[host] = recursive
+	return nil
+}
+
+// name maps a host path back to a backend name
+func (s *notifySource) name(host string) string {
+	rel, err := filepath.Rel(s.root, host)
+	if err != nil || rel == "." {
+		return ""
+	}
+	return filepath.ToSlash(rel)
+}
+
+func (s *notifySource) loop() {
+	for {
+		select {
+		case ev, ok := <-s.w.Events:
+			if !ok {
+				return
+			}
+			s.handle(ev)
+		case err, ok := <-s.w.Errors:
+			if !ok {
+				return
+			}
+			dprintf("fs_watch notify error: %v", err)
+		}
+	}
+}
+
+func (s *notifySource) handle(ev fsnotify.Event) {
+	name := s.name(ev.Name)
+	if name == "" {
+		return
+	}
+	if ev.Has(fsnotify.Create) {
+		s.mu.Lock()
+		rec := s.recursive[filepath.Dir(ev.Name)]
+		s.mu.Unlock()
+		if rec {
+			if fi, err := s.store.state.FS.Lstat(name); err == nil && fi.IsDir() {
+				if err := s.watchDir(name, true); err != nil {
+					dprintf("fs_watch add %s: %v", name, err)
+				}
+			}
+		}
+		s.store.record(name, changeCreate)
+	}
+	if ev.Has(fsnotify.Write) {
+		s.store.record(name, changeWrite)
+	}
+	if ev.Has(fsnotify.Remove) {
+		s.store.record(name, changeRemove)
+	}
+	if ev.Has(fsnotify.Rename) {
+		s.store.record(name, changeRename)
+	}
+	if ev.Has(fsnotify.Chmod) {
+		s.store.record(name, changeChmod)
+	}
+}
+
+func (s *notifySource) close() {
+	s.w.Close()
+}
+
+// pollEntry is what a scan remembers about a path
+type pollEntry struct {
+	size  int64
+	mtime int64
+	inode uint64
+	mode  fs.FileMode
+}
+
+// pollSource finds changes by scanning its directories every watchPollMs.
+// It serves backends without OS notifications and hosts where they fail.
+type pollSource struct {
+	b     Backend
+	store *watchStore
+	stop  chan struct{}
+
+	mu    sync.Mutex
+	roots map[string]bool
+	snap  map[string]pollEntry
+}
+
+func newPollSource(b Backend, store *watchStore) *pollSource {
+	s := &pollSource{b: b, store: store, stop: make(chan struct{}), roots: map[string]bool{}, snap: map[string]pollEntry{}}
+	go s.loop()
+	return s
+}
+
+func (s *pollSource) watchDir(dir string, recursive bool) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if rec, ok := s.roots[dir]; ok && (rec || !recursive) {
+		return nil
+	}
+	s.roots[dir] = recursive
+	// The first scan of a root is the baseline, not a change
+	for name, e := range s.scanRoot(dir, recursive) {
+		s.snap[name] = e
+	}
+	return nil
+}
+
+func (s *pollSource) scanRoot(dir string, recursive bool) map[string]pollEntry {
+	out := map[string]pollEntry{}
+	_ = walkBackend(s.b, dir, func(name string, d fs.DirEntry, err error) error {
+		if err != nil || name == dir {
+			return nil
+		}
+		if !s.store.state.readable(name) {
+			if d.IsDir() {
+				return fs.SkipDir
+			}
+			return nil
+		}
+		if fi, err := d.Info(); err == nil {
+			out[name] = pollEntry{size: fi.Size(), mtime: fi.ModTime().UnixNano(), inode: inodeOf(fi), mode: fi.Mode()}
+		}
+		if d.IsDir() && !recursive {
+			return fs.SkipDir
+		}
+		return nil
+	})
+	return out
+}
+
+func (s *pollSource) scan() {
+	s.mu.Lock()
+	cur := map[string]pollEntry{}
+	for dir, rec := range s.roots {
+		for name, e := range s.scanRoot(dir, rec) {
+			cur[name] = e
+		}
+	}
+	type change struct{ name, op string }
+	var changes []change
+	for name, e := range cur {
+		old, ok := s.snap[name]
+		switch {
+		case !ok:
+			changes = append(changes, change{name, changeCreate})
+		case old.inode != e.inode && !e.mode.IsDir():
+			changes = append(changes, change{name, changeRemove}, change{name, changeCreate})
+		case old.size != e.size || old.mtime != e.mtime:
+			if !e.mode.IsDir() {
+				changes = append(changes, change{name, changeWrite})
+			}
+		case old.mode != e.mode:
+			changes = append(changes, change{name, changeChmod})
+		}
+	}
+	for name := range s.snap {
+		if _, ok := cur[name]; !ok {
+			changes = append(changes, change{name, changeRemove})
+		}
+	}
+	s.snap = cur
+	s.mu.Unlock()
+	for _, c := range changes {
+		s.store.record(c.name, c.op)
+	}
+}
+
+func (s *pollSource) loop() {
+	t := time.NewTicker(watchPollMs * time.Millisecond)
+	defer t.Stop()
+	for {
+		select {
+		case <-s.stop:
+			return
+		case <-t.C:
+			s.scan()
+		}
+	}
+}
+
+func (s *pollSource) close() {
+	close(s.stop)
+}
@@ -0,0 +1,171 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/mark3labs/mcp-go/mcp"
+	"github.com/mark3labs/mcp-go/server"
+)
+
+// awaitChange polls fs_changes_since until path shows up, returning the
+// change and the cursor after it
+func awaitChange(t *testing.T, ctx context.Context, h mcp.StructuredToolHandlerFunc[ChangesSinceArgs, ChangesSinceResult], cursor int64, path string) (ChangeEvent, int64) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		res, err := h(ctx, mcp.CallToolRequest{}, ChangesSinceArgs{Cursor: cursor, TimeoutMs: 1000})
+		if err != nil {
+			t.Fatal(err)
+		}
+		cursor = res.Cursor
+		for _, c := range res.Changes {
+			if c.Path == path {
+				return c, cursor
+			}
+		}
+	}
+	t.Fatalf("no change for %s", path)
+	return ChangeEvent{}, 0
+}
+
+func TestWatchNotify(t *testing.T) {
+	root := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(root, "src"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	ctx, sessions, mu := testSession(root)
+	req := mcp.CallToolRequest{}
+	changes := handleChangesSince(sessions, mu)
+
+	w, err := handleWatch(sessions, mu)(ctx, req, WatchArgs{Paths: []string{"src"}, Ignore: []string{"**/*.tmp"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if w.Mode != watchModeNotify {
+		t.Skipf("OS notifications unavailable: mode %s", w.Mode)
+	}
+	mustWrite(t, filepath.Join(root, "src", "skip.tmp"), []byte("x"), 0o644)
+	mustWrite(t, filepath.Join(root, "other.txt"), []byte("x"), 0o644)
+	if _, err := handleWrite(sessions, mu)(ctx, req, WriteArgs{Path: "src/a.go", Content: "package a\n"}); err != nil {
+		t.Fatal(err)
+	}
+	c, cursor := awaitChange(t, ctx, changes, w.Cursor, "src/a.go")
+	if !slices.Contains(c.Ops, changeCreate) || !slices.Equal(c.WatchIDs, []string{w.WatchID}) {
+		t.Fatalf("change = %+v", c)
+	}
+	res, _ := changes(ctx, req, ChangesSinceArgs{Cursor: w.Cursor})
+	for _, c := range res.Changes {
+		if c.Path != "src/a.go" {
+			t.Fatalf("unexpected change %+v", c)
+		}
+	}
+
+	// Directories created later are watched too
+	if err := os.Mkdir(filepath.Join(root, "src", "sub"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	_, cursor = awaitChange(t, ctx, changes, cursor, "src/sub")
+	mustWrite(t, filepath.Join(root, "src", "sub", "b.go"), []byte("package b\n"), 0o644)
+	awaitChange(t, ctx, changes, cursor, "src/sub/b.go")
+
+	if res, err := handleUnwatch(sessions, mu)(ctx, req, UnwatchArgs{WatchID: w.WatchID}); err != nil || !res.Removed {
+		t.Fatalf("unwatch = %+v %v", res, err)
+	}
+	if sessions["s1"].Watches.src != nil {
+		t.Fatal("source still running without watches")
+	}
+}
+
+func TestWatchPoll(t *testing.T) {
+	sessions := map[string]*SessionState{"s1": {FS: newMemBackend()}}
+	var mu sync.RWMutex
+	ctx := withSessionManager(context.Background(), &sessionManager{id: "s1"})
+	req := mcp.CallToolRequest{}
+	changes := handleChangesSince(sessions, &mu)
+	if _, err := handleWrite(sessions, &mu)(ctx, req, WriteArgs{Path: "logs/old.log", Content: "old\n"}); err != nil {
+		t.Fatal(err)
+	}
+
+	w, err := handleWatch(sessions, &mu)(ctx, req, WatchArgs{Globs: []string{"logs/*.log"}})
+	if err != nil || w.Mode != watchModePoll {
+		t.Fatalf("watch = %+v %v", w, err)
+	}
+	if _, err := handleWrite(sessions, &mu)(ctx, req, WriteArgs{Path: "logs/new.log", Content: "new\n"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := handleWrite(sessions, &mu)(ctx, req, WriteArgs{Path: "logs/old.log", Content: "more\n", Strategy: strategyAppend}); err != nil {
+		t.Fatal(err)
+	}
+	c, cursor := awaitChange(t, ctx, changes, w.Cursor, "logs/new.log")
+	if !slices.Contains(c.Ops, changeCreate) {
+		t.Fatalf("new file = %+v", c)
+	}
+	awaitChange(t, ctx, changes, w.Cursor, "logs/old.log")
+
+	if err := sessions["s1"].FS.Remove("logs/new.log"); err != nil {
+		t.Fatal(err)
+	}
+	c, _ = awaitChange(t, ctx, changes, cursor, "logs/new.log")
+	if !slices.Equal(c.Ops, []string{changeRemove}) {
+		t.Fatalf("removal = %+v", c)
+	}
+
+	// A cursor from the future means the log was lost
+	res, err := changes(ctx, req, ChangesSinceArgs{Cursor: 1 << 40})
+	if err != nil || !res.Overflow {
+		t.Fatalf("stale cursor = %+v %v", res, err)
+	}
+	if _, err := changes(ctx, req, ChangesSinceArgs{WatchID: "nope"}); err == nil {
+		t.Fatal("unknown watch accepted")
+	}
+}
+
+// notifySession is a client session that keeps the notifications it is sent
+type notifySession struct{ ch chan mcp.JSONRPCNotification }
+
+func (s *notifySession) Initialize()                                         {}
+func (s *notifySession) Initialized() bool                                   { return true }
+func (s *notifySession) NotificationChannel() chan<- mcp.JSONRPCNotification { return s.ch }
+func (s *notifySession) SessionID() string                                   { return "client-1" }
+
+func TestWatchNotification(t *testing.T) {
+	root := t.TempDir()
+	sessions := map[string]*SessionState{"s1": {FS: newLocalBackend(root)}}
+	var mu sync.RWMutex
+	srv := server.NewMCPServer("test", "1.0.0")
+	srv.AddTool(mcp.NewTool("fs_watch"), wrapStructuredHandler(handleWatch(sessions, &mu)))
+	client := &notifySession{ch: make(chan mcp.JSONRPCNotification, 8)}
+	ctx := withSessionManager(context.Background(), &sessionManager{id: "s1"})
+	if err := srv.RegisterSession(ctx, client); err != nil {
+		t.Fatal(err)
+	}
+	ctx = srv.WithContext(ctx, client)
+
+	msg := srv.HandleMessage(ctx, []byte(`{"jsonrpc":"2.0","id":1,"method":"tools/call","params":{"name":"fs_watch","arguments":{"globs":["*.txt"]}}}`))
+	if resp, ok := msg.(mcp.JSONRPCResponse); !ok || resp.Result.(mcp.CallToolResult).IsError {
+		t.Fatalf("fs_watch = %+v", msg)
+	}
+	mustWrite(t, filepath.Join(root, "hello.txt"), []byte("hi"), 0o644)
+	select {
+	case n := <-client.ch:
+		changes, _ := n.Params.AdditionalFields["changes"].([]ChangeEvent)
+		if n.Method != fsChangedMethod || len(changes) != 1 || changes[0].Path != "hello.txt" {
+			t.Fatalf("notification = %+v", n)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("no notification")
+	}
+
+	// The watch goes with the client session that made it
+	var b sessionBindings
+	b.drop(client.SessionID())
+	if ws := sessions["s1"].Watches; len(ws.watches) != 0 || ws.src != nil {
+		t.Fatal("watch survived its client session")
+	}
+}
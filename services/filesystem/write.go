@@ -1,6 +1,7 @@
 package main
 
 import (
+	"bytes"
 	"context"
 	"errors"
 	"fmt"
@@ -21,16 +22,20 @@ func handleWrite(sessions map[string]*SessionState, mu *sync.RWMutex) mcp.Struct
 		if err != nil {
 			return WriteResult{}, err
 		}
-		root := state.Root
 		start := time.Now()
-		dprintf("%s -> fs_write path=%q strategy=%q bytes=%d", sessionContext(ctx), args.Path, args.Strategy, len(args.Content))
+		dprintf("%s -> fs_write path=%q strategy=%q encoding=%q bytes=%d", sessionContext(ctx), args.Path, args.Strategy, args.Encoding, len(args.Content))
 		var res WriteResult
-		full, err := safeJoin(root, args.Path)
+		data, err := decodeContent(args.Encoding, args.Content)
 		if err != nil {
 			dprintf("fs_write error: %v", err)
 			return res, err
 		}
-		if err := ensureParent(full); err != nil {
+		name, err := state.FS.Resolve(args.Path, false)
+		if err != nil {
+			dprintf("fs_write error: %v", err)
+			return res, err
+		}
+		if err := checkAccess(ctx, state, "write", capWrite, args.Path, name); err != nil {
 			dprintf("fs_write error: %v", err)
 			return res, err
 		}
@@ -40,19 +45,46 @@ func handleWrite(sessions map[string]*SessionState, mu *sync.RWMutex) mcp.Struct
 			return res, fmt.Errorf("invalid mode: %w", err)
 		}
 		modeProvided := args.Mode != ""
-		data := []byte(args.Content)
 		st := args.Strategy
 		if st == "" {
 			st = strategyOverwrite
 		}
 
-		preFi, preErr := os.Lstat(full)
-		if preErr == nil && (preFi.Mode()&os.ModeSymlink) != 0 {
-			dprintf("fs_write error: target is symlink")
-			return res, fmt.Errorf("refusing to write to symlink: %s", args.Path)
+		var preFi os.FileInfo
+		var preErr error
+		// inspect looks at the target and its leases as they are now; it runs
+		// again once the lock is held since another writer may have changed
+		// them meanwhile
+		inspect := func() error {
+			if err := checkLease(ctx, state, "write", args.Path, name); err != nil {
+				return err
+			}
+			preFi, preErr = state.FS.Lstat(name)
+			if preErr == nil && (preFi.Mode()&os.ModeSymlink) != 0 {
+				dprintf("fs_write error: target is symlink")
+				return fmt.Errorf("refusing to write to symlink: %s", args.Path)
+			}
+			if preErr == nil && preFi.IsDir() && (st == strategyOverwrite || st == strategyNoClobber) {
+				return fmt.Errorf("target is a directory: %s", args.Path)
+			}
+			if preErr == nil && st == strategyNoClobber {
+				dprintf("fs_write noclobber exists")
+				return fmt.Errorf("exists: %s", args.Path)
+			}
+			return nil
+		}
+		if err := inspect(); err != nil {
+			return res, err
+		}
+
+		release, err := state.FS.Lock(name, lockExclusive, currentConfig().lockTimeout())
+		if err != nil {
+			dprintf("fs_write lock error: %v", err)
+			return res, err
 		}
-		if preErr == nil && preFi.IsDir() && (st == strategyOverwrite || st == strategyNoClobber) {
-			return res, fmt.Errorf("target is a directory: %s", args.Path)
+		defer release()
+		if err := inspect(); err != nil {
+			return res, err
 		}
 		if preErr == nil && !modeProvided {
 			if pm := preFi.Mode() & os.ModePerm; pm != 0 {
@@ -61,24 +93,67 @@ func handleWrite(sessions map[string]*SessionState, mu *sync.RWMutex) mcp.Struct
 				mode = 0o644
 			}
 		}
+		defer snapshotHistory(ctx)()
+		defer digestAudit(ctx)()
+		if err := ensureParentDir(state.FS, name); err != nil {
+			dprintf("fs_write error: %v", err)
+			return res, err
+		}
 
-		release, err := acquireLock(full, 3*time.Second)
-		if err != nil {
-			dprintf("fs_write lock error: %v", err)
+		// Text content follows the existing file's encoding, BOM and line
+		// endings; base64 and hex content is written byte for byte
+		var tf textFormat
+		textMode := resultEncoding(args.Encoding) == ""
+		content := string(data)
+		if textMode {
+			existing, found := textFormat{}, false
+			if preErr == nil && preFi.Mode().IsRegular() {
+				existing, found = sniffTextFormat(state.FS, name)
+			}
+			tf, err = targetTextFormat(existing, found, args.Charset, args.BOM, args.Newline)
+			if err != nil {
+				dprintf("fs_write error: %v", err)
+				return res, err
+			}
+			if args.EnsureFinalNewline && st != strategyReplaceRange {
+				content = withFinalNewline(content, tf.LineEndings)
+			}
+			data = []byte(content)
+			if !tf.verbatim() {
+				// Only whole-file writes carry the BOM
+				frag := tf
+				frag.BOM = tf.BOM && (st == strategyOverwrite || st == strategyNoClobber || st == strategyPrepend)
+				if data, err = encodeText(content, frag); err != nil {
+					dprintf("fs_write error: %v", err)
+					return res, err
+				}
+			}
+		}
+
+		// fits refuses results beyond --max-size. The new content alone is
+		// checked here; strategies that keep part of the file check the whole
+		// result once it is built, since they may also shrink it.
+		fits := func(size int64) error {
+			if size > currentConfig().MaxFileSize {
+				return newOpError("write", args.Path, ErrFileTooLarge, fmt.Sprintf("result would exceed --max-size %d; use fs_write_begin for large files", currentConfig().MaxFileSize))
+			}
+			return nil
+		}
+		projected := int64(len(data))
+		if preErr == nil && st == strategyAppend {
+			projected += preFi.Size()
+		}
+		if err := fits(projected); err != nil {
 			return res, err
 		}
-		defer release()
 
 		created := false
 		action := string(st)
+		var span lineSpan
 
 		switch st {
 		case strategyNoClobber:
-			if preErr == nil {
-				dprintf("fs_write noclobber exists")
-				return res, fmt.Errorf("exists: %s", args.Path)
-			}
-			if err := atomicWrite(full, data, mode); err != nil {
+			if err := state.FS.WriteFile(name, data, mode); err != nil {
 				dprintf("fs_write error: %v", err)
 				return res, err
 			}
@@ -88,7 +163,7 @@ func handleWrite(sessions map[string]*SessionState, mu *sync.RWMutex) mcp.Struct
 			if errors.Is(preErr, os.ErrNotExist) {
 				created = true
 			}
-			if err := atomicWrite(full, data, mode); err != nil {
+			if err := state.FS.WriteFile(name, data, mode); err != nil {
 				dprintf("fs_write error: %v", err)
 				return res, err
 			}
@@ -100,18 +175,10 @@ func handleWrite(sessions map[string]*SessionState, mu *sync.RWMutex) mcp.Struct
 			if errors.Is(preErr, os.ErrNotExist) {
 				created = true
 			}
-			f, err := os.OpenFile(full, os.O_CREATE|os.O_WRONLY|os.O_APPEND, mode)
-			if err != nil {
-				dprintf("fs_write error: %v", err)
-				return res, err
-			}
-			defer f.Close()
-			n, err := f.Write(data)
-			if err != nil {
+			if err := state.FS.AppendFile(name, data, mode); err != nil {
 				dprintf("fs_write error: %v", err)
 				return res, err
 			}
-			data = data[:n]
 
 		case strategyPrepend:
 			if preErr == nil && !preFi.Mode().IsRegular() {
@@ -119,16 +186,22 @@ func handleWrite(sessions map[string]*SessionState, mu *sync.RWMutex) mcp.Struct
 			}
 			var old []byte
 			if preErr == nil {
-				old, err = os.ReadFile(full)
+				old, err = readBackendFile(state.FS, name)
 				if err != nil {
 					return res, err
 				}
+				if textMode && tf.BOM {
+					old = bytes.TrimPrefix(old, charsetBOM(tf.Encoding))
+				}
 			} else if errors.Is(preErr, os.ErrNotExist) {
 				created = true
 			}
 			buf := append([]byte{}, data...)
 			buf = append(buf, old...)
-			if err := atomicWrite(full, buf, mode); err != nil {
+			if err := fits(int64(len(buf))); err != nil {
+				return res, err
+			}
+			if err := state.FS.WriteFile(name, buf, mode); err != nil {
 				dprintf("fs_write error: %v", err)
 				return res, err
 			}
@@ -142,7 +215,7 @@ func handleWrite(sessions map[string]*SessionState, mu *sync.RWMutex) mcp.Struct
 			if !preFi.Mode().IsRegular() {
 				return res, fmt.Errorf("replace_range target not a regular file: %s", args.Path)
 			}
-			old, err := os.ReadFile(full)
+			old, err := readBackendFile(state.FS, name)
 			if err != nil {
 				dprintf("fs_write error: %v", err)
 				return res, err
@@ -157,7 +230,56 @@ func handleWrite(sessions map[string]*SessionState, mu *sync.RWMutex) mcp.Struct
 			buf := append([]byte{}, old[:s]...)
 			buf = append(buf, data...)
 			buf = append(buf, old[e:]...)
-			if err := atomicWrite(full, buf, mode); err != nil {
+			if err := fits(int64(len(buf))); err != nil {
+				return res, err
+			}
+			if err := state.FS.WriteFile(name, buf, mode); err != nil {
+				dprintf("fs_write error: %v", err)
+				return res, err
+			}
+			data = buf
+
+		case strategyInsertAtLine, strategyInsertAfterLine, strategyReplaceLines, strategyDeleteLines, strategyInsertAfterMatch:
+			if preErr != nil {
+				dprintf("fs_write error: %v", preErr)
+				return res, fmt.Errorf("%s requires existing file: %w", st, preErr)
+			}
+			if !preFi.Mode().IsRegular() {
+				return res, fmt.Errorf("%s target not a regular file: %s", st, args.Path)
+			}
+			old, err := readBackendFile(state.FS, name)
+			if err != nil {
+				dprintf("fs_write error: %v", err)
+				return res, err
+			}
+			text := string(old)
+			if textMode {
+				if existing, ok := detectTextFormat(old); ok && !existing.plain() {
+					if text, err = decodeText(old, existing); err != nil {
+						return res, err
+					}
+				}
+				// The whole file is rewritten, so its line endings are taken
+				// from all of it rather than the sniffed start
+				if tf.LineEndings, err = lineEndingsFor(args.Newline, lineEndingsOf(text)); err != nil {
+					return res, err
+				}
+			}
+			text, span, err = applyLineStrategy(text, content, st, args, tf.LineEndings)
+			if err != nil {
+				dprintf("fs_write error: %v", err)
+				return res, err
+			}
+			buf := []byte(text)
+			if textMode && !tf.verbatim() {
+				if buf, err = encodeText(text, tf); err != nil {
+					return res, err
+				}
+			}
+			if err := fits(int64(len(buf))); err != nil {
+				return res, err
+			}
+			if err := state.FS.WriteFile(name, buf, mode); err != nil {
 				dprintf("fs_write error: %v", err)
 				return res, err
 			}
@@ -168,11 +290,11 @@ func handleWrite(sessions map[string]*SessionState, mu *sync.RWMutex) mcp.Struct
 		}
 
 		final := data
-		if b, err := os.ReadFile(full); err == nil {
+		if b, err := readBackendFile(state.FS, name); err == nil {
 			final = b
 		}
-		mt := detectMIME(full, final)
-		fi, statErr := os.Lstat(full)
+		mt := detectMIME(name, final)
+		fi, statErr := state.FS.Lstat(name)
 		modAt := time.Now().UTC().Format(time.RFC3339)
 		modeStr := ""
 		if fi != nil && statErr == nil {
@@ -185,13 +307,18 @@ func handleWrite(sessions map[string]*SessionState, mu *sync.RWMutex) mcp.Struct
 		} else {
 			dprintf("fs_write: skip sha256 (size %d > cap %d)", len(final), maxHashBytes)
 		}
+		rememberHash(state.FS, name, sha)
 		res = WriteResult{
-			Path:     args.Path,
-			Action:   action,
-			Bytes:    len(final),
-			Created:  created,
-			MIMEType: mt,
-			SHA256:   sha,
+			Path:        args.Path,
+			Action:      action,
+			Bytes:       len(final),
+			Created:     created,
+			MIMEType:    mt,
+			SHA256:      sha,
+			Charset:     tf.Encoding,
+			LineEndings: storedLineEndings(final),
+			LineStart:   span.Start,
+			LineEnd:     span.End,
 			MetaFields: MetaFields{
 				Mode:       modeStr,
 				ModifiedAt: modAt,
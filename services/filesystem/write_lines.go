@@ -0,0 +1,118 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// splitLines breaks text into lines that keep their terminators, so joining
+// them gives back the original text
+func splitLines(text string) []string {
+	var lines []string
+	for text != "" {
+		i := strings.IndexByte(text, '\n')
+		if i < 0 {
+			lines = append(lines, text)
+			break
+		}
+		lines = append(lines, text[:i+1])
+		text = text[i+1:]
+	}
+	return lines
+}
+
+// lineSpan is the 1-based inclusive range of lines touched by a line strategy
+type lineSpan struct {
+	Start, End int
+}
+
+// applyLineStrategy edits text line-wise. Inserted content always ends with
+// a line break in the given convention so it never merges into the
+// following line. The returned span covers the new lines in the result, or
+// the removed lines of the original for delete_lines. Empty content gives
+// Start == End at the position where it would have gone.
+func applyLineStrategy(text, content string, st writeStrategy, args WriteArgs, endings string) (string, lineSpan, error) {
+	nl := "\n"
+	if endings == lineEndingsCRLF {
+		nl = "\r\n"
+	}
+	lines := splitLines(text)
+	n := len(lines)
+	var ins []string
+	if content != "" {
+		ins = splitLines(withFinalNewline(content, endings))
+	}
+
+	var at, del int // insert position and number of lines removed there
+	line, end := 0, 0
+	if args.Line != nil {
+		line = *args.Line
+	}
+	if args.EndLine != nil {
+		end = *args.EndLine
+	}
+	switch st {
+	case strategyInsertAtLine:
+		if args.Line == nil || line < 1 || line > n+1 {
+			return "", lineSpan{}, fmt.Errorf("line must be between 1 and %d", n+1)
+		}
+		at = line - 1
+	case strategyInsertAfterLine:
+		if args.Line == nil || line < 0 || line > n {
+			return "", lineSpan{}, fmt.Errorf("line must be between 0 and %d", n)
+		}
+		at = line
+	case strategyReplaceLines, strategyDeleteLines:
+		if args.EndLine == nil {
+			end = line
+		}
+		if args.Line == nil || line < 1 || end < line || end > n {
+			return "", lineSpan{}, fmt.Errorf("invalid line range [%d,%d] for %d lines", line, end, n)
+		}
+		at, del = line-1, end-line+1
+		if st == strategyDeleteLines {
+			ins = nil
+		}
+	case strategyInsertAfterMatch:
+		if args.Match == "" {
+			return "", lineSpan{}, errors.New("match required for insert_after_match")
+		}
+		re, err := regexp.Compile(args.Match)
+		if err != nil {
+			return "", lineSpan{}, fmt.Errorf("invalid regex: %w", err)
+		}
+		at = -1
+		for i, l := range lines {
+			if re.MatchString(strings.TrimRight(l, "\r\n")) {
+				at = i + 1
+				break
+			}
+		}
+		if at < 0 {
+			return "", lineSpan{}, fmt.Errorf("no line matches %q", args.Match)
+		}
+	default:
+		return "", lineSpan{}, fmt.Errorf("unknown strategy: %s", st)
+	}
+
+	// Lines before the insertion point must be terminated
+	if at > 0 && len(ins) > 0 && !strings.HasSuffix(lines[at-1], "\n") {
+		lines[at-1] += nl
+	}
+	out := make([]string, 0, n-del+len(ins))
+	out = append(out, lines[:at]...)
+	out = append(out, ins...)
+	out = append(out, lines[at+del:]...)
+
+	span := lineSpan{Start: at + 1, End: at + len(ins)}
+	switch {
+	case st == strategyDeleteLines:
+		span = lineSpan{Start: line, End: end}
+	case len(ins) == 0:
+		// Nothing was inserted; report the position rather than a negative range
+		span.End = span.Start
+	}
+	return strings.Join(out, ""), span, nil
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/mark3labs/mcp-go/mcp"
+)
+
+func TestLineStrategies(t *testing.T) {
+	ip := func(n int) *int { return &n }
+	cases := []struct {
+		name    string
+		args    WriteArgs
+		want    string
+		span    [2]int
+		initial string
+	}{
+		{"insert at first", WriteArgs{Strategy: strategyInsertAtLine, Line: ip(1), Content: "zero"}, "zero\none\ntwo\nthree", [2]int{1, 1}, ""},
+		{"insert at end", WriteArgs{Strategy: strategyInsertAtLine, Line: ip(4), Content: "four\nfive\n"}, "one\ntwo\nthree\nfour\nfive\n", [2]int{4, 5}, ""},
+		{"insert after", WriteArgs{Strategy: strategyInsertAfterLine, Line: ip(2), Content: "x"}, "one\ntwo\nx\nthree", [2]int{3, 3}, ""},
+		{"insert after zero", WriteArgs{Strategy: strategyInsertAfterLine, Line: ip(0), Content: "x"}, "x\none\ntwo\nthree", [2]int{1, 1}, ""},
+		{"replace", WriteArgs{Strategy: strategyReplaceLines, Line: ip(1), EndLine: ip(2), Content: "a\nb\nc"}, "a\nb\nc\nthree", [2]int{1, 3}, ""},
+		{"replace one", WriteArgs{Strategy: strategyReplaceLines, Line: ip(3), Content: "3"}, "one\ntwo\n3\n", [2]int{3, 3}, ""},
+		{"replace with nothing", WriteArgs{Strategy: strategyReplaceLines, Line: ip(2)}, "one\nthree", [2]int{2, 2}, ""},
+		{"delete", WriteArgs{Strategy: strategyDeleteLines, Line: ip(2), EndLine: ip(3)}, "one\n", [2]int{2, 3}, ""},
+		{"after match", WriteArgs{Strategy: strategyInsertAfterMatch, Match: `^t\w+$`, Content: "after"}, "one\ntwo\nafter\nthree", [2]int{3, 3}, ""},
+		{"crlf", WriteArgs{Strategy: strategyInsertAfterLine, Line: ip(1), Content: "b\nc"}, "a\r\nb\r\nc\r\nd\r\n", [2]int{2, 3}, "a\r\nd\r\n"},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			ctx, sessions, mu, mem := memSession()
+			initial := c.initial
+			if initial == "" {
+				initial = "one\ntwo\nthree"
+			}
+			memWrite(t, mem, "f.txt", []byte(initial), 0o644)
+			c.args.Path = "f.txt"
+			res, err := handleWrite(sessions, mu)(ctx, mcp.CallToolRequest{}, c.args)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := memRead(t, mem, "f.txt"); got != c.want {
+				t.Fatalf("content = %q; want %q", got, c.want)
+			}
+			if res.LineStart != c.span[0] || res.LineEnd != c.span[1] {
+				t.Fatalf("span = %d-%d; want %d-%d", res.LineStart, res.LineEnd, c.span[0], c.span[1])
+			}
+		})
+	}
+}
+
+func TestLineStrategyErrors(t *testing.T) {
+	ip := func(n int) *int { return &n }
+	ctx, sessions, mu, mem := memSession()
+	memWrite(t, mem, "f.txt", []byte("one\ntwo\n"), 0o644)
+	bad := []WriteArgs{
+		{Path: "f.txt", Strategy: strategyInsertAtLine, Line: ip(4), Content: "x"},
+		{Path: "f.txt", Strategy: strategyInsertAtLine, Content: "x"},
+		{Path: "f.txt", Strategy: strategyReplaceLines, Line: ip(2), EndLine: ip(1), Content: "x"},
+		{Path: "f.txt", Strategy: strategyDeleteLines, Line: ip(1), EndLine: ip(3)},
+		{Path: "f.txt", Strategy: strategyInsertAfterMatch, Match: "nope", Content: "x"},
+		{Path: "f.txt", Strategy: strategyInsertAfterMatch, Match: "(", Content: "x"},
+		{Path: "missing.txt", Strategy: strategyInsertAtLine, Line: ip(1), Content: "x"},
+	}
+	for _, args := range bad {
+		if _, err := handleWrite(sessions, mu)(ctx, mcp.CallToolRequest{}, args); err == nil {
+			t.Errorf("%+v: expected error", args)
+		}
+	}
+	if got := memRead(t, mem, "f.txt"); got != "one\ntwo\n" {
+		t.Fatalf("file modified by failed writes: %q", got)
+	}
+}
+
+func TestLineStrategyKeepsEncoding(t *testing.T) {
+	ctx, sessions, mu, mem := memSession()
+	p := "u16.txt"
+	memWrite(t, mem, p, []byte("\xff\xfea\x00\r\x00\n\x00"), 0o644)
+	line := 1
+	if _, err := handleWrite(sessions, mu)(ctx, mcp.CallToolRequest{}, WriteArgs{Path: "u16.txt", Strategy: strategyInsertAfterLine, Line: &line, Content: "é"}); err != nil {
+		t.Fatal(err)
+	}
+	if got := memRead(t, mem, p); got != "\xff\xfea\x00\r\x00\n\x00\xe9\x00\r\x00\n\x00" {
+		t.Fatalf("content = %q", got)
+	}
+}
+
+func TestLineStrategyKeepsLineEndingsBeyondSniff(t *testing.T) {
+	ctx, sessions, mu, mem := memSession()
+	head := strings.Repeat("lf line\n", textSniffBytes/8+1)
+	tail := "crlf line\r\ncrlf line\r\n"
+	memWrite(t, mem, "big.txt", []byte(head+tail), 0o644)
+	line := 1
+	if _, err := handleWrite(sessions, mu)(ctx, mcp.CallToolRequest{}, WriteArgs{Path: "big.txt", Strategy: strategyInsertAtLine, Line: &line, Content: "new"}); err != nil {
+		t.Fatal(err)
+	}
+	if got := memRead(t, mem, "big.txt"); got != "new\n"+head+tail {
+		t.Fatalf("line endings past the sniffed start changed: tail %q", got[len(got)-len(tail):])
+	}
+}